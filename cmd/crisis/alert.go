@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	alertPort    int
+	alertWebPort int
+	alertFields  store.Alert
+	alertTTL     time.Duration
+)
+
+var alertCmd = &cobra.Command{
+	Use:   "alert",
+	Short: "List the official alerts in force",
+	Long: "Official alerts are signed by a node whose role allows them (see `crisis role`)\n" +
+		"and interrupt every TUI and web UI until acknowledged, then stay pinned until\n" +
+		"they expire or are cancelled.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openNodeDB(alertPort)
+		alerts, err := store.ActiveAlerts(db, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "ID\tSEVERITY\tURGENCY\tAREA\tUNTIL\tFROM\tHEADLINE")
+		for _, a := range alerts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", short(a.ID), a.Severity, a.Urgency, a.Area,
+				time.Unix(a.Expires, 0).Format("2006-01-02 15:04"), a.Issuer, a.Headline)
+		}
+	},
+}
+
+var alertSendCmd = &cobra.Command{
+	Use:   "send <headline>",
+	Short: "Issue an official alert through the running node",
+	Example: `  crisis alert send "Evacuate sector B now" --severity extreme --urgency immediate \
+    --area "Sector B" --instructions "Leave by the north road. Do not use the bridge." --ttl 2h`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		a := alertFields
+		a.Headline = args[0]
+		a.Expires = time.Now().Add(alertTTL).Unix()
+		data, err := callNode(http.MethodPost, alertPort, alertWebPort, "/api/alerts", a)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var resp struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(data, &resp)
+		fmt.Printf("Alert %s issued until %s\n", short(resp.ID), time.Unix(a.Expires, 0).Format("15:04"))
+	},
+}
+
+var alertCancelCmd = &cobra.Command{
+	Use:   "cancel <alert-id>",
+	Short: "Withdraw an alert before it expires",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := resolveAlert(args[0])
+		if _, err := callNode(http.MethodDelete, alertPort, alertWebPort, "/api/alerts?id="+url.QueryEscape(id), nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Alert %s cancelled\n", short(id))
+	},
+}
+
+// resolveAlert finds an active alert by ID or ID prefix.
+func resolveAlert(ref string) string {
+	alerts, _ := store.ActiveAlerts(openNodeDB(alertPort), time.Now())
+	var ids []string
+	for _, a := range alerts {
+		if strings.HasPrefix(a.ID, ref) {
+			ids = append(ids, a.ID)
+		}
+	}
+	if len(ids) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: %q matches %d active alerts\n", ref, len(ids))
+		os.Exit(1)
+	}
+	return ids[0]
+}
+
+func init() {
+	rootCmd.AddCommand(alertCmd)
+	alertCmd.AddCommand(alertSendCmd, alertCancelCmd)
+	alertCmd.PersistentFlags().IntVarP(&alertPort, "port", "p", 9000, "Port of the node to use")
+	alertCmd.PersistentFlags().IntVar(&alertWebPort, "web-port", 0, "Web port of the running node (default: derived from --port as `start` does)")
+	f := alertSendCmd.Flags()
+	f.StringVar(&alertFields.Severity, "severity", "severe", "One of "+strings.Join(store.Severities, ", "))
+	f.StringVar(&alertFields.Urgency, "urgency", "immediate", "One of "+strings.Join(store.Urgencies, ", "))
+	f.StringVar(&alertFields.Area, "area", "", "Area the alert applies to")
+	f.StringVar(&alertFields.Instructions, "instructions", "", "What people should do")
+	f.DurationVar(&alertTTL, "ttl", time.Hour, "How long the alert stays pinned")
+	alertSendCmd.MarkFlagRequired("area")
+}
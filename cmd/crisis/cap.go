@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/cap"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	capPort    int
+	capWebPort int
+	capOut     string
+	capSince   time.Duration
+)
+
+var capCmd = &cobra.Command{
+	Use:   "cap",
+	Short: "Exchange alerts with other systems as CAP 1.2 XML",
+	Long: "The Common Alerting Protocol is what public alerting systems and aggregators\n" +
+		"speak. Official alerts and SOS messages export as CAP; CAP alerts import as\n" +
+		"official alerts, which needs a role that allows alerts.",
+}
+
+var capExportCmd = &cobra.Command{
+	Use:   "export [message-id...]",
+	Short: "Write alerts and SOS messages as CAP files",
+	Long: "Writes each message to <out>/<id>.xml. Without IDs, exports every alert and\n" +
+		"SOS message from the --since window. A single ID without --out prints to stdout.",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openNodeDB(capPort)
+		ids := args
+		if len(ids) == 0 {
+			msgs, err := store.EmergencyMessages(db, time.Now().Add(-capSince))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			for _, m := range msgs {
+				ids = append(ids, m.ID)
+			}
+		}
+		if len(ids) == 1 && capOut == "" {
+			data, err := cap.ExportMessage(db, ids[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Stdout.Write(data)
+			return
+		}
+		if capOut == "" {
+			capOut = "."
+		}
+		if err := os.MkdirAll(capOut, 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, id := range ids {
+			data, err := cap.ExportMessage(db, id)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", short(id), err)
+				continue
+			}
+			path := filepath.Join(capOut, id+".xml")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(path)
+		}
+	},
+}
+
+var capImportCmd = &cobra.Command{
+	Use:   "import <file>...",
+	Short: "Issue CAP files as official alerts through the running node",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, path := range args {
+			data, err := os.ReadFile(path)
+			if err == nil {
+				data, err = callNode(http.MethodPost, capPort, capWebPort, "/api/cap", data)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				failed = true
+				continue
+			}
+			var resp struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(data, &resp)
+			if resp.ID == "" {
+				fmt.Printf("%s: cancellation applied\n", path)
+			} else {
+				fmt.Printf("%s: alert %s\n", path, short(resp.ID))
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(capCmd)
+	capCmd.AddCommand(capExportCmd, capImportCmd)
+	capCmd.PersistentFlags().IntVarP(&capPort, "port", "p", 9000, "Port of the node to use")
+	capImportCmd.Flags().IntVar(&capWebPort, "web-port", 0, "Web port of the running node (default: derived from --port as `start` does)")
+	capExportCmd.Flags().StringVarP(&capOut, "out", "o", "", "Directory to write CAP files to")
+	capExportCmd.Flags().DurationVar(&capSince, "since", 24*time.Hour, "How far back to export when no IDs are given")
+}
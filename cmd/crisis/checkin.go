@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkInPort    int
+	checkInWebPort int
+)
+
+var checkInCmd = &cobra.Command{
+	Use:   "checkin",
+	Short: "Check in with the running node's dead-man's switch",
+	Long: "With check-ins on, a node that is not checked in by the deadline sends SOS from\n" +
+		"its last known position. Checking in restarts the countdown and cancels an SOS\n" +
+		"the switch already sent.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := callNode(http.MethodPost, checkInPort, checkInWebPort, "/api/checkins", nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Checked in")
+	},
+}
+
+var checkInSetCmd = &cobra.Command{
+	Use:     "set <interval>",
+	Short:   "Turn the switch on, or change how often to check in",
+	Example: "  crisis checkin set 45m",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		interval, err := time.ParseDuration(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		body := map[string]string{"interval": interval.String()}
+		if _, err := callNode(http.MethodPut, checkInPort, checkInWebPort, "/api/checkins", body); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Check in every %s; next due %s\n", interval, time.Now().Add(interval).Format("15:04"))
+	},
+}
+
+var checkInOffCmd = &cobra.Command{
+	Use:   "off",
+	Short: "Turn the switch off",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := callNode(http.MethodDelete, checkInPort, checkInWebPort, "/api/checkins", nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Check-ins turned off")
+	},
+}
+
+var checkInBoardCmd = &cobra.Command{
+	Use:   "board",
+	Short: "List every node with check-ins on, overdue ones first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := store.CheckIns(openNodeDB(checkInPort), time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "NODE\tNICK\tEVERY\tLAST\tDUE\tSTATUS")
+		for _, c := range list {
+			status := "ok"
+			switch {
+			case c.Missed:
+				status = "MISSED, SOS SENT"
+			case c.Overdue:
+				status = "OVERDUE"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", short(c.NodeID), c.Nick, time.Duration(c.Interval)*time.Second,
+				c.Last.Format("15:04"), c.Due.Format("15:04"), status)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkInCmd)
+	checkInCmd.AddCommand(checkInSetCmd, checkInOffCmd, checkInBoardCmd)
+	checkInCmd.PersistentFlags().IntVarP(&checkInPort, "port", "p", 9000, "Port of the node to use")
+	checkInCmd.PersistentFlags().IntVar(&checkInWebPort, "web-port", 0, "Web port of the running node (default: derived from --port as `start` does)")
+}
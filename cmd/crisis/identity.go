@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+)
+
+var identityPort int
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Show or rotate this node's keys",
+}
+
+var identityShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the node ID and public keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		id := loadIdentity(identityPort)
+		fmt.Printf("Node ID:     %s\n", id.NodeID)
+		fmt.Printf("Box key:     %s\n", id.PubKey)
+		fmt.Printf("Signing key: %s\n", id.SignPub)
+		if id.Rotation != nil {
+			fmt.Printf("Rotated from %s\n", id.Rotation.OldSignPub)
+		}
+		if c := id.RoleCert; c != nil {
+			fmt.Printf("Role:        %s until %s\n", c.Role, time.Unix(c.Expires, 0).Format("2006-01-02 15:04"))
+		}
+		fmt.Printf("Fingerprint: %s\n\n", core.Fingerprint(id.SignPub, id.PubKey))
+		if qr, err := qrcode.New(core.FingerprintURI(id.NodeID, id.SignPub, id.PubKey), qrcode.Low); err == nil {
+			fmt.Print(qr.ToSmallString(false))
+		}
+	},
+}
+
+var identityRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace both key pairs, keeping the node ID",
+	Long: "Generates new box and signing keys and a rotation proof signed by the old\n" +
+		"signing key. Peers that know the old key accept the new one from the proof\n" +
+		"carried in heartbeats. Restart the node for the new keys to take effect.",
+	Run: func(cmd *cobra.Command, args []string) {
+		id := loadIdentity(identityPort)
+		if err := id.Rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := core.SaveIdentity(identityFile(identityPort), id); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Rotated keys for %s\nNew signing key: %s\n", id.NodeID, id.SignPub)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(identityCmd)
+	identityCmd.AddCommand(identityShowCmd, identityRotateCmd)
+	identityCmd.PersistentFlags().IntVarP(&identityPort, "port", "p", 9000, "Port of the node whose identity to use")
+}
+
+func identityFile(port int) string {
+	return fmt.Sprintf("identity_%d.json", port)
+}
+
+func loadIdentity(port int) *core.Identity {
+	path := identityFile(port)
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: no identity for port %d (%s)\n", port, path)
+		os.Exit(1)
+	}
+	id, err := core.LoadOrGenerateIdentity(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return id
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	moderatePort    int
+	moderateWebPort int
+	moderateLocal   bool
+	moderateReason  string
+)
+
+var moderateCmd = &cobra.Command{
+	Use:   "moderate",
+	Short: "Show the moderation audit trail",
+	Long: "Lists every moderation action this node has issued or received, newest first,\n" +
+		"and whether it is in force here. Records from the mesh apply only when signed by\n" +
+		"a node whose certificate from the command key grants a role that may moderate.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openNodeDB(moderatePort)
+		mods, err := store.GetModerations(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "AT\tACTION\tTARGET\tISSUER\tSCOPE\tAPPLIED\tREASON")
+		for _, m := range mods {
+			scope, applied := "mesh", "yes"
+			if m.Local {
+				scope = "local"
+			}
+			if !m.Applied {
+				applied = "no: " + m.Note
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.At.Format("2006-01-02 15:04"), m.Action,
+				m.Target, short(m.Issuer), scope, applied, m.Reason)
+		}
+	},
+}
+
+func moderateAction(action, what string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action + " <" + what + ">",
+		Short: strings.ToUpper(action[:1]) + action[1:] + " a " + what,
+		Long: "With --local, applies on this node only. Otherwise the running node signs the\n" +
+			"action and gossips it to the mesh, which needs a commander certificate.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			act := store.ModerationAction{Action: action, Target: args[0], Reason: moderateReason}
+			if err := protocol.ValidateModeration(act); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if moderateLocal {
+				db := openWritableNodeDB(moderatePort)
+				if err := store.AddLocalModeration(db, act, localNodeID(moderatePort), time.Now()); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Printf("%s %s applied on this node\n", action, args[0])
+				return
+			}
+			if _, err := callNode(http.MethodPost, moderatePort, moderateWebPort, "/api/moderation", act); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s %s sent to the mesh\n", action, args[0])
+		},
+	}
+}
+
+// localNodeID is the ID of the node started on port, if it has an identity.
+func localNodeID(port int) string {
+	path := fmt.Sprintf("identity_%d.json", port)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	id, err := core.LoadOrGenerateIdentity(path)
+	if err != nil {
+		return ""
+	}
+	return id.NodeID
+}
+
+func init() {
+	rootCmd.AddCommand(moderateCmd)
+	moderateCmd.AddCommand(
+		moderateAction(store.ModHide, "message"),
+		moderateAction(store.ModUnhide, "message"),
+		moderateAction(store.ModBlock, "node"),
+		moderateAction(store.ModUnblock, "node"),
+	)
+	moderateCmd.PersistentFlags().IntVarP(&moderatePort, "port", "p", 9000, "Port of the node to moderate")
+	moderateCmd.PersistentFlags().IntVar(&moderateWebPort, "web-port", 0, "Web port of the running node (default: derived from --port as `start` does)")
+	moderateCmd.PersistentFlags().BoolVar(&moderateLocal, "local", false, "Apply on this node only instead of gossiping")
+	moderateCmd.PersistentFlags().StringVar(&moderateReason, "reason", "", "Why, for the audit trail")
+}
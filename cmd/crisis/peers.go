@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var peersPort int
+
+var peersCmd = &cobra.Command{
+	Use:   "peers [peer]",
+	Short: "Show peer uptime and contact history",
+	Long: "Lists every peer this node has sighted with first-seen time and total uptime.\n" +
+		"Given a peer ID (or prefix) or nick, prints each contact window instead.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openNodeDB(peersPort)
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		defer w.Flush()
+
+		if len(args) == 0 {
+			all, err := store.GetAllPeerStats(db, now)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintln(w, "PEER\tNICK\tFIRST SEEN\tUPTIME\tWINDOWS\tSTATUS")
+			for _, st := range all {
+				status := "away"
+				if n := len(st.Windows); n > 0 && st.Windows[n-1].Open {
+					status = "in contact"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", short(st.PeerID), st.Nick,
+					st.FirstSeen.Format(time.DateTime), uptime(st.UptimeSeconds), len(st.Windows), status)
+			}
+			return
+		}
+
+		peerID, err := resolvePeer(db, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		st, err := store.GetPeerStats(db, peerID, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(w, "Peer:\t%s (%s)\n", st.PeerID, st.Nick)
+		fmt.Fprintf(w, "First seen:\t%s\n", st.FirstSeen.Format(time.DateTime))
+		fmt.Fprintf(w, "Uptime:\t%s\n\n", uptime(st.UptimeSeconds))
+		fmt.Fprintln(w, "FROM\tTO\tDURATION\tADDR\tIFACE")
+		for _, win := range st.Windows {
+			end := win.End.Format(time.DateTime)
+			if win.Open {
+				end = "now"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", win.Start.Format(time.DateTime), end,
+				uptime(win.End.Sub(win.Start).Seconds()), win.Addr, win.Iface)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(peersCmd)
+	peersCmd.Flags().IntVarP(&peersPort, "port", "p", 9000, "Port of the node whose database to read")
+}
+
+// openNodeDB opens the database of the node started on port read-only. It
+// is safe to use while that node is running.
+func openNodeDB(port int) *gorm.DB {
+	return openNodeDBMode(port, true)
+}
+
+// openWritableNodeDB is openNodeDB for the commands that change the
+// node's local state, e.g. marking a peer verified.
+func openWritableNodeDB(port int) *gorm.DB {
+	return openNodeDBMode(port, false)
+}
+
+func openNodeDBMode(port int, readOnly bool) *gorm.DB {
+	dbPath := fmt.Sprintf("crisis_%d.db", port)
+	if _, err := os.Stat(dbPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: no database for port %d (%s)\n", port, dbPath)
+		os.Exit(1)
+	}
+	db, err := store.Open(dbPath, readOnly)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return db
+}
+
+// callNode makes a request to the web API of the node started on port, for
+// actions only the running node can take (e.g. signing with its role).
+// webPort 0 derives it from port as `start` does.
+func callNode(method string, port, webPort int, path string, body any) ([]byte, error) {
+	if webPort == 0 {
+		webPort = 8080 + port - 9000
+	}
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		// Raw documents, e.g. CAP XML, are sent as they are.
+		reader = bytes.NewReader(b)
+		contentType = "application/xml"
+	default:
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://127.0.0.1:%d%s", webPort, path), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the node on web port %d: %w", webPort, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("node refused: %s", strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// resolvePeer finds a peer by exact nick, or by ID or ID prefix.
+func resolvePeer(db *gorm.DB, ref string) (string, error) {
+	var peers []store.Peer
+	db.Where("nick = ? OR id LIKE ?", ref, ref+"%").Find(&peers)
+	switch len(peers) {
+	case 0:
+		return "", fmt.Errorf("no peer matches %q", ref)
+	case 1:
+		return peers[0].ID, nil
+	}
+	var ids []string
+	for _, p := range peers {
+		ids = append(ids, short(p.ID)+" ("+p.Nick+")")
+	}
+	return "", fmt.Errorf("%q is ambiguous: %s", ref, strings.Join(ids, ", "))
+}
+
+func short(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func uptime(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
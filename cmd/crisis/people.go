@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/pfif"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	peoplePort    int
+	peopleWebPort int
+	peopleOut     string
+	personReport  store.PersonReport
+	personPhoto   string
+)
+
+var peopleCmd = &cobra.Command{
+	Use:   "people [query]",
+	Short: "Search the person-finder registry",
+	Long: "The person finder collects reports of who has been seen, and how they are,\n" +
+		"from every node. Searches match name, description and last seen location.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		people, err := store.People(openNodeDB(peoplePort), strings.Join(args, ""))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(people) == 0 {
+			fmt.Println("Nobody found")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "ID\tNAME\tSTATUS\tLAST SEEN\tUPDATED\tREPORTED BY")
+		for _, p := range people {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.Name, p.Status, p.Location,
+				p.Updated.Format("Jan 2 15:04"), p.Reporter)
+		}
+	},
+}
+
+var peopleExportCmd = &cobra.Command{
+	Use:   "export [query]",
+	Short: "Write the registry as a PFIF 1.4 file for agencies",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		people, err := store.People(openNodeDB(peoplePort), strings.Join(args, ""))
+		if err == nil {
+			var data []byte
+			if data, err = pfif.Encode(pfif.FromPeople(people, time.Now())); err == nil {
+				if peopleOut == "" {
+					_, err = os.Stdout.Write(data)
+				} else {
+					err = os.WriteFile(peopleOut, data, 0o644)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if peopleOut != "" {
+			fmt.Printf("Wrote %d people to %s\n", len(people), peopleOut)
+		}
+	},
+}
+
+var peopleReportCmd = &cobra.Command{
+	Use:   "report <name>",
+	Short: "Report a person through the running node",
+	Long: "Opens a registry entry, or with --id updates one. Fields left out are not\n" +
+		"changed. A photo is only hashed; the hash is what is shared.",
+	Example: `  crisis people report "Maria Lopez" --status missing --location "Elm St school" --description "70, grey coat"
+  crisis people report "Maria Lopez" --id <person-id> --status safe --note "At shelter 2"`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		personReport.Name = args[0]
+		if personPhoto != "" {
+			data, err := os.ReadFile(personPhoto)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			sum := sha256.Sum256(data)
+			personReport.Photo = hex.EncodeToString(sum[:])
+		}
+		data, err := callNode(http.MethodPost, peoplePort, peopleWebPort, "/api/people", personReport)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var resp struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(data, &resp)
+		fmt.Printf("Reported %s %s (person %s)\n", personReport.Name, personReport.Status, resp.ID)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(peopleCmd)
+	peopleCmd.AddCommand(peopleExportCmd, peopleReportCmd)
+	peopleCmd.PersistentFlags().IntVarP(&peoplePort, "port", "p", 9000, "Port of the node to use")
+	peopleExportCmd.Flags().StringVarP(&peopleOut, "out", "o", "", "File to write the PFIF document to (default: stdout)")
+	f := peopleReportCmd.Flags()
+	f.IntVar(&peopleWebPort, "web-port", 0, "Web port of the running node (default: derived from --port as `start` does)")
+	f.StringVar(&personReport.Person, "id", "", "Person ID to update instead of opening a new entry")
+	f.StringVar(&personReport.Status, "status", store.PersonMissing, "safe, injured, missing or deceased")
+	f.StringVar(&personReport.Description, "description", "", "Age, clothing, distinguishing marks")
+	f.StringVar(&personReport.Location, "location", "", "Where the person was last seen")
+	f.StringVar(&personReport.Note, "note", "", "Anything else about this report")
+	f.StringVar(&personReport.Reporter, "reporter", "", "Who is reporting, if not this node's operator")
+	f.StringVar(&personPhoto, "photo", "", "Photo file to identify the person by hash")
+}
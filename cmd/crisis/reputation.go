@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var reputationPort int
+
+var reputationCmd = &cobra.Command{
+	Use:   "reputation",
+	Short: "Inspect and override peer reputation scores",
+	Long: "Lists every peer's reputation, worst first. Scores fall with invalid packets,\n" +
+		"bad signatures and flooding, and rise with useful relays and uptime. Peers below\n" +
+		fmt.Sprintf("%.0f get fewer sync slots and are forwarded to last; below %.0f they are quarantined.", store.LowScore, store.QuarantineScore),
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openNodeDB(reputationPort)
+		reps, err := store.GetReputations(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var peers []store.Peer
+		db.Find(&peers)
+		nicks := make(map[string]string, len(peers))
+		for _, p := range peers {
+			nicks[p.ID] = p.Nick
+		}
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "PEER\tNICK\tSCORE\tRELAYS\tINVALID\tBAD SIG\tFLOODS\tSTATUS")
+		for _, r := range reps {
+			status := "ok"
+			switch {
+			case now.Before(r.QuarantinedUntil):
+				status = "quarantined until " + r.QuarantinedUntil.Format(time.TimeOnly)
+			case r.Override != nil:
+				status = fmt.Sprintf("override (observed %.1f)", r.Score)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.1f\t%d\t%d\t%d\t%d\t%s\n", short(r.PeerID), nicks[r.PeerID],
+				r.Effective(), r.Relays, r.Invalid, r.BadSigs, r.Floods, status)
+		}
+	},
+}
+
+var reputationSetCmd = &cobra.Command{
+	Use:   "set <peer> <score>",
+	Short: "Pin a peer's score, overriding what has been observed",
+	Long: fmt.Sprintf("Sets the score (%.0f-%.0f) the node acts on until cleared. Use %.0f to quarantine a\n"+
+		"peer now, or a high score to release one.", store.MinScore, store.MaxScore, store.MinScore),
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		score, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || score < store.MinScore || score > store.MaxScore {
+			fmt.Fprintf(os.Stderr, "Error: score must be a number from %.0f to %.0f\n", store.MinScore, store.MaxScore)
+			os.Exit(1)
+		}
+		setOverride(args[0], &score)
+	},
+}
+
+var reputationClearCmd = &cobra.Command{
+	Use:   "clear <peer>",
+	Short: "Return a peer to its observed score",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setOverride(args[0], nil)
+	},
+}
+
+func setOverride(ref string, score *float64) {
+	db := openWritableNodeDB(reputationPort)
+	peerID := resolveScoredPeer(db, ref)
+	if err := store.SetReputationOverride(db, peerID, score); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if score == nil {
+		fmt.Printf("%s is back to its observed score %.1f\n", short(peerID), store.GetReputation(db, peerID).Score)
+		return
+	}
+	fmt.Printf("%s is pinned at %.1f\n", short(peerID), *score)
+}
+
+// resolveScoredPeer is resolvePeer, also accepting the full ID of a node
+// that has a score but was never stored as a peer (e.g. a relayed origin).
+func resolveScoredPeer(db *gorm.DB, ref string) string {
+	peerID, err := resolvePeer(db, ref)
+	if err == nil {
+		return peerID
+	}
+	var n int64
+	db.Model(&store.PeerReputation{}).Where("peer_id = ?", ref).Count(&n)
+	if n > 0 {
+		return ref
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+	return ""
+}
+
+func init() {
+	rootCmd.AddCommand(reputationCmd)
+	reputationCmd.AddCommand(reputationSetCmd, reputationClearCmd)
+	reputationCmd.PersistentFlags().IntVarP(&reputationPort, "port", "p", 9000, "Port of the node whose database to use")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rolePort       int
+	roleCommandKey string
+	roleTTL        time.Duration
+)
+
+var roleCmd = &cobra.Command{
+	Use:   "role",
+	Short: "Show this node's role certificate",
+	Long: "Roles are granted by certificates signed by the incident-command key, which\n" +
+		"every node is started with (--command-key). Commanders may send official alerts,\n" +
+		"moderate and start roll calls; responders may start roll calls; everyone else is\n" +
+		"a civilian. The node holding the command key is a commander without a certificate.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		id := loadIdentity(rolePort)
+		c := id.RoleCert
+		if c == nil {
+			if id.SignPub == roleCommandKey {
+				fmt.Println("commander (holds the command key)")
+				return
+			}
+			fmt.Println("civilian (no certificate installed)")
+			return
+		}
+		status := "not checked; pass --command-key"
+		if roleCommandKey != "" {
+			status = "valid"
+			if err := c.Verify(roleCommandKey, time.Now()); err != nil {
+				status = "INVALID: " + err.Error()
+			}
+		}
+		fmt.Printf("%s until %s [%s]\n", c.Role, time.Unix(c.Expires, 0).Format("2006-01-02 15:04"), status)
+	},
+}
+
+var roleIssueCmd = &cobra.Command{
+	Use:   "issue <peer> <role>",
+	Short: "Sign a role certificate for a peer with this node's key",
+	Long: "Run on the node whose signing key is the command key. Prints a certificate for\n" +
+		"the peer's current signing key, to be installed with `crisis role install` on\n" +
+		"the peer. Roles: " + strings.Join(core.Roles, ", ") + ".",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := loadIdentity(rolePort)
+		db := openNodeDB(rolePort)
+		peerID, err := resolvePeer(db, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var peer store.Peer
+		db.First(&peer, "id = ?", peerID)
+		if peer.SignKey == "" || peer.KeyStatus == store.KeyChanged {
+			fmt.Fprintf(os.Stderr, "Error: no settled signing key for %s\n", peer.Nick)
+			os.Exit(1)
+		}
+		now := time.Now()
+		cert, err := core.IssueRoleCert(id.SignPriv, peer.ID, peer.SignKey, args[1], now, now.Add(roleTTL))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !peer.Verified {
+			fmt.Fprintf(os.Stderr, "Warning: %s's key has not been verified (see `crisis verify`)\n", peer.Nick)
+		}
+		fmt.Printf("%s for %s (%s) until %s:\n%s\n", cert.Role, peer.Nick, short(peer.ID),
+			time.Unix(cert.Expires, 0).Format("2006-01-02 15:04"), cert.Encode())
+	},
+}
+
+var roleInstallCmd = &cobra.Command{
+	Use:   "install <certificate>",
+	Short: "Install a role certificate issued for this node",
+	Long:  "Restart the node for the role to take effect.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := loadIdentity(rolePort)
+		cert, err := core.DecodeRoleCert(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if cert.NodeID != id.NodeID || cert.SignPub != id.SignPub {
+			fmt.Fprintf(os.Stderr, "Error: certificate is for %s and another key, not this node\n", short(cert.NodeID))
+			os.Exit(1)
+		}
+		if roleCommandKey != "" {
+			if err := cert.Verify(roleCommandKey, time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		id.RoleCert = cert
+		if err := core.SaveIdentity(identityFile(rolePort), id); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Installed %s certificate; restart the node to use it\n", cert.Role)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(roleCmd)
+	roleCmd.AddCommand(roleIssueCmd, roleInstallCmd)
+	roleCmd.PersistentFlags().IntVarP(&rolePort, "port", "p", 9000, "Port of the node whose identity to use")
+	roleCmd.PersistentFlags().StringVar(&roleCommandKey, "command-key", "", "Incident-command signing key to check certificates against")
+	roleIssueCmd.Flags().DurationVar(&roleTTL, "ttl", 7*24*time.Hour, "How long the certificate lasts")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rollCallPort    int
+	rollCallWebPort int
+)
+
+var rollCallCmd = &cobra.Command{
+	Use:   "rollcall",
+	Short: "Show who is accounted for in the latest roll call",
+	Long: "A roll call asks every node to report whether its operator and the web users\n" +
+		"registered with it are safe. Starting one needs a role that allows it (see\n" +
+		"`crisis role`).",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openNodeDB(rollCallPort)
+		call, ok := store.LatestRollCall(db)
+		if !ok {
+			fmt.Println("No roll call yet")
+			return
+		}
+		board, err := store.RollCallBoard(db, call.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Roll call %s by %s at %s: %d safe, %d need help, %d no response\n",
+			short(board.ID), board.Caller, board.Started.Format("15:04"), board.Safe, board.Help, board.Missing)
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "NAME\tSTATUS\tNODE")
+		for _, e := range board.Entries {
+			status := e.Status
+			if !e.Accounted() {
+				status = "no response"
+			}
+			if e.Auto {
+				status += " (auto)"
+			}
+			if e.HelpEarlier {
+				status += " (needed help earlier)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", e.Name, status, short(e.NodeID))
+		}
+	},
+}
+
+var rollCallStartCmd = &cobra.Command{
+	Use:     "start [note]",
+	Short:   "Start a roll call through the running node",
+	Example: `  crisis rollcall start "Aftershock 14:02, report in"`,
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := callNode(http.MethodPost, rollCallPort, rollCallWebPort, "/api/rollcall", map[string]string{"note": strings.Join(args, "")})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var resp struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(data, &resp)
+		fmt.Printf("Roll call %s started\n", short(resp.ID))
+	},
+}
+
+var rollCallAnswerCmd = &cobra.Command{
+	Use:   "answer <safe|help>",
+	Short: "Answer the latest roll call for this node's operator",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		call, ok := store.LatestRollCall(openNodeDB(rollCallPort))
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Error: no roll call to answer")
+			os.Exit(1)
+		}
+		body := map[string]string{"id": call.ID, "status": args[0]}
+		if _, err := callNode(http.MethodPost, rollCallPort, rollCallWebPort, "/api/rollcall/answer", body); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Answered roll call %s: %s\n", short(call.ID), args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollCallCmd)
+	rollCallCmd.AddCommand(rollCallStartCmd, rollCallAnswerCmd)
+	rollCallCmd.PersistentFlags().IntVarP(&rollCallPort, "port", "p", 9000, "Port of the node to use")
+	rollCallCmd.PersistentFlags().IntVar(&rollCallWebPort, "web-port", 0, "Web port of the running node (default: derived from --port as `start` does)")
+}
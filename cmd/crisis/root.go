@@ -6,10 +6,15 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"slices"
+	"strconv"
+	"time"
 
+	"github.com/bit2swaz/crisismesh/internal/cap"
 	"github.com/bit2swaz/crisismesh/internal/config"
 	"github.com/bit2swaz/crisismesh/internal/core"
 	"github.com/bit2swaz/crisismesh/internal/engine"
+	"github.com/bit2swaz/crisismesh/internal/policy"
 	"github.com/bit2swaz/crisismesh/internal/store"
 	"github.com/bit2swaz/crisismesh/internal/transport"
 	"github.com/bit2swaz/crisismesh/internal/tui"
@@ -49,7 +54,13 @@ var startCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		slog.Info("Starting CrisisMesh", "port", cfg.Port, "nick", cfg.Nick)
+		networks, err := store.ParseNetworks(cfg.Networks)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		slog.Info("Starting CrisisMesh", "port", cfg.Port, "nick", cfg.Nick, "networks", networks)
 		dbPath := fmt.Sprintf("crisis_%d.db", cfg.Port)
 		db, err := store.Init(dbPath)
 		if err != nil {
@@ -65,14 +76,51 @@ var startCmd = &cobra.Command{
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 		tm := transport.NewManager()
-		eng := engine.NewGossipEngine(db, tm, id.NodeID, cfg.Nick, cfg.Port, id.PubKey, id.PrivKey)
+		eng := engine.NewGossipEngine(db, tm, id, cfg.Nick, cfg.Port)
+		eng.MDNS = cfg.MDNS
+		eng.Role = cfg.Role
+		eng.Networks = networks
+		eng.TimeSource = cfg.TimeSource
+		eng.TimeSources = cfg.TimeSources
+		eng.TrustModeration = cfg.TrustModeration
+		eng.CommandKey = cfg.CommandKey
+		eng.RollCallAuto = cfg.RollCallAuto
+		eng.IdentityFile = identityPath
+		eng.SetPosition(cfg.Lat, cfg.Long)
+		if cfg.PolicyFile != "" {
+			pol, err := policy.Load(cfg.PolicyFile)
+			if err != nil {
+				slog.Error("Failed to load link policy", "error", err)
+				os.Exit(1)
+			}
+			eng.Policy = pol
+		}
 
 		// Uplink Service Integration
+		var uplinks []func(<-chan store.Message)
 		if discordWebhook != "" {
 			slog.Info("Initializing Uplink Service", "webhook", "REDACTED")
-			upService := uplink.NewService(discordWebhook)
+			uplinks = append(uplinks, uplink.NewService(discordWebhook).Start)
+		}
+		if cfg.CAPUplink != "" {
+			slog.Info("Initializing CAP uplink", "url", cfg.CAPUplink)
+			uplinks = append(uplinks, uplink.NewCAPService(cfg.CAPUplink, db).Start)
+		}
+		if len(uplinks) > 0 {
 			eng.UplinkChan = make(chan store.Message, 100)
-			upService.Start(eng.UplinkChan)
+			var in <-chan store.Message = eng.UplinkChan
+			if len(cfg.UplinkSOSTypes) > 0 {
+				for _, t := range cfg.UplinkSOSTypes {
+					if !slices.Contains(store.EmergencyTypes, t) {
+						slog.Error("Unknown SOS type for uplink filter", "type", t, "types", store.EmergencyTypes)
+						os.Exit(1)
+					}
+				}
+				in = uplink.Filter(in, uplink.SOSTypes(cfg.UplinkSOSTypes))
+			}
+			for i, out := range uplink.Fanout(in, len(uplinks)) {
+				uplinks[i](out)
+			}
 		}
 
 		if err := eng.Start(ctx); err != nil {
@@ -80,6 +128,17 @@ var startCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		if cfg.CAPInbox != "" {
+			err := cap.WatchInbox(ctx, cfg.CAPInbox, 5*time.Second, func(data []byte) error {
+				_, err := eng.ImportCAP(data)
+				return err
+			})
+			if err != nil {
+				slog.Error("Failed to watch CAP inbox", "error", err)
+				os.Exit(1)
+			}
+		}
+
 		// Start Web Server
 		webSrv := web.NewServer(db, eng, cfg.WebPort)
 		go func() {
@@ -91,7 +150,7 @@ var startCmd = &cobra.Command{
 
 		// Generate QR Code
 		ip, _ := utils.GetOutboundIP()
-		url := fmt.Sprintf("http://%s:%d", ip, cfg.WebPort)
+		url := fmt.Sprintf("http://%s", net.JoinHostPort(ip, strconv.Itoa(cfg.WebPort)))
 		qr, _ := qrcode.New(url, qrcode.Medium)
 		qrAscii := qr.ToString(false)
 
@@ -109,7 +168,7 @@ var startCmd = &cobra.Command{
 			case <-ctx.Done():
 			}
 		} else {
-			if err := tui.StartTUI(db, id.NodeID, eng.MsgUpdates, eng.PeerUpdates, eng, qrAscii); err != nil {
+			if err := tui.StartTUI(db, id.NodeID, eng.MsgUpdates, eng.PeerUpdates, eng.PeerEvents, eng, qrAscii); err != nil {
 				slog.Error("TUI failed", "error", err)
 				// Don't exit on TUI failure in some cases, but here we probably should
 				// os.Exit(1)
@@ -123,6 +182,20 @@ func init() {
 	startCmd.Flags().IntVarP(&cfg.Port, "port", "p", 9000, "Port to listen on")
 	startCmd.Flags().IntVarP(&cfg.WebPort, "web-port", "w", 8080, "Web interface port")
 	startCmd.Flags().StringVarP(&cfg.Nick, "nick", "n", "Anonymous", "Nickname")
+	startCmd.Flags().BoolVar(&cfg.MDNS, "mdns", true, "Advertise and browse for peers over mDNS/DNS-SD")
+	startCmd.Flags().StringVar(&cfg.PolicyFile, "policy", "", "Link policy file (JSON allow/deny rules)")
+	startCmd.Flags().StringVar(&cfg.Role, "role", "", "Self-declared role shown to peers (e.g. medic, relay); not a certified role")
+	startCmd.Flags().Float64Var(&cfg.Lat, "lat", 0, "Latitude of this node, if fixed")
+	startCmd.Flags().Float64Var(&cfg.Long, "long", 0, "Longitude of this node, if fixed")
+	startCmd.Flags().StringSliceVar(&cfg.Networks, "network", nil, "Mesh network to join; repeat to join several (first is the default for sending)")
+	startCmd.Flags().BoolVar(&cfg.TimeSource, "time-source", false, "Offer this node's clock (e.g. GPS-disciplined) as trusted mesh time")
+	startCmd.Flags().StringSliceVar(&cfg.TimeSources, "time-peer", nil, "Node ID of a peer trusted as a time source; repeat for several")
+	startCmd.Flags().BoolVar(&cfg.TrustModeration, "trust-moderation", true, "Apply moderation records signed by verified moderator peers")
+	startCmd.Flags().StringVar(&cfg.CommandKey, "command-key", "", "Incident-command signing key that role certificates must be signed by")
+	startCmd.Flags().BoolVar(&cfg.RollCallAuto, "rollcall-auto", false, "Answer roll calls safe for this node's operator without asking")
+	startCmd.Flags().StringVar(&cfg.CAPInbox, "cap-inbox", "", "Directory to watch for CAP 1.2 files to issue as official alerts")
+	startCmd.Flags().StringVar(&cfg.CAPUplink, "cap-uplink", "", "URL to post alerts and SOS messages to as CAP 1.2 XML")
+	startCmd.Flags().StringSliceVar(&cfg.UplinkSOSTypes, "uplink-sos-types", nil, "Only relay SOS messages of these types to uplinks (medical, fire, trapped, flood, other)")
 	startCmd.Flags().StringVar(&discordWebhook, "discord-webhook", "", "Discord Webhook URL for Uplink Service")
 }
 func Execute() {
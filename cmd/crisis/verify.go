@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyPort int
+	verifyUndo bool
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <peer> [fingerprint]",
+	Short: "Compare a peer's key fingerprint and mark it verified",
+	Long: "Without a fingerprint, prints the fingerprint of the key pinned for the peer so\n" +
+		"it can be compared with what the peer reads out from `crisis identity show`.\n" +
+		"With one (digits, or the text of a scanned fingerprint QR code), checks it and\n" +
+		"marks the peer verified if it matches.",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openWritableNodeDB(verifyPort)
+		peerID, err := resolvePeer(db, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var peer store.Peer
+		db.First(&peer, "id = ?", peerID)
+		if peer.SignKey == "" {
+			fmt.Fprintf(os.Stderr, "Error: no signing key pinned for %s yet\n", peer.Nick)
+			os.Exit(1)
+		}
+		if peer.KeyStatus == store.KeyChanged {
+			fmt.Fprintf(os.Stderr, "Error: key for %s has changed; accept or reject it first\n", peer.Nick)
+			os.Exit(1)
+		}
+		want := core.Fingerprint(peer.SignKey, peer.PubKey)
+
+		if verifyUndo {
+			if err := store.SetPeerVerified(db, peerID, false); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s is no longer marked verified\n", peer.Nick)
+			return
+		}
+		if len(args) == 1 {
+			status := "not verified"
+			if peer.Verified {
+				status = "verified " + peer.VerifiedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%s (%s): %s [%s]\n", peer.Nick, short(peerID), want, status)
+			return
+		}
+
+		scannedID, got, err := core.ParseFingerprint(strings.Join(args[1:], " "))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if (scannedID != "" && scannedID != peerID) || got != want {
+			fmt.Fprintf(os.Stderr, "MISMATCH: pinned key for %s is %s\n", peer.Nick, want)
+			os.Exit(1)
+		}
+		if err := store.SetPeerVerified(db, peerID, true); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Fingerprint matches; %s is now verified\n", peer.Nick)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().IntVarP(&verifyPort, "port", "p", 9000, "Port of the node whose database to use")
+	verifyCmd.Flags().BoolVar(&verifyUndo, "unverify", false, "Clear the peer's verified mark")
+}
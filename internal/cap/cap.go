@@ -0,0 +1,314 @@
+// Package cap encodes mesh alerts and SOS messages as OASIS Common Alerting
+// Protocol 1.2 documents, and decodes CAP documents into mesh alerts.
+package cap
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// Namespace is the CAP 1.2 XML namespace.
+const Namespace = "urn:oasis:names:tc:emergency:cap:1.2"
+
+// MaxDocumentSize bounds an imported document.
+const MaxDocumentSize = 64 * 1024
+
+// TimeLayout is CAP's date-time format, which always carries an offset.
+const TimeLayout = "2006-01-02T15:04:05-07:00"
+
+// Message types and the only status imported.
+const (
+	MsgAlert     = "Alert"
+	MsgUpdate    = "Update"
+	MsgCancel    = "Cancel"
+	StatusActual = "Actual"
+)
+
+// Alert is a CAP <alert>. Only the elements the mesh uses are modelled;
+// others are ignored on import.
+type Alert struct {
+	XMLName    xml.Name `xml:"urn:oasis:names:tc:emergency:cap:1.2 alert"`
+	Identifier string   `xml:"identifier"`
+	Sender     string   `xml:"sender"`
+	Sent       string   `xml:"sent"`
+	Status     string   `xml:"status"`
+	MsgType    string   `xml:"msgType"`
+	Scope      string   `xml:"scope"`
+	References string   `xml:"references,omitempty"`
+	Info       []Info   `xml:"info"`
+}
+
+// Info is a CAP <info> block.
+type Info struct {
+	Language    string   `xml:"language,omitempty"`
+	Category    []string `xml:"category"`
+	Event       string   `xml:"event"`
+	Urgency     string   `xml:"urgency"`
+	Severity    string   `xml:"severity"`
+	Certainty   string   `xml:"certainty"`
+	Expires     string   `xml:"expires,omitempty"`
+	SenderName  string   `xml:"senderName,omitempty"`
+	Headline    string   `xml:"headline,omitempty"`
+	Description string   `xml:"description,omitempty"`
+	Instruction string   `xml:"instruction,omitempty"`
+	Area        []Area   `xml:"area"`
+}
+
+// Area is a CAP <area>. Circles are "lat,long radius-km".
+type Area struct {
+	AreaDesc string   `xml:"areaDesc"`
+	Circle   []string `xml:"circle,omitempty"`
+}
+
+// Encode renders a document with the XML declaration.
+func Encode(a *Alert) ([]byte, error) {
+	data, err := xml.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode CAP: %w", err)
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// Decode parses a CAP 1.2 document and checks its required elements.
+func Decode(data []byte) (*Alert, error) {
+	if len(data) > MaxDocumentSize {
+		return nil, fmt.Errorf("CAP document of %d bytes, at most %d allowed", len(data), MaxDocumentSize)
+	}
+	var a Alert
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&a); err != nil {
+		return nil, fmt.Errorf("invalid CAP document: %w", err)
+	}
+	for name, v := range map[string]string{"identifier": a.Identifier, "sender": a.Sender, "sent": a.Sent,
+		"status": a.Status, "msgType": a.MsgType, "scope": a.Scope} {
+		if strings.TrimSpace(v) == "" {
+			return nil, fmt.Errorf("CAP document without <%s>", name)
+		}
+	}
+	if _, err := time.Parse(TimeLayout, a.Sent); err != nil {
+		return nil, fmt.Errorf("invalid <sent>: %w", err)
+	}
+	return &a, nil
+}
+
+// Severity and urgency map one to one onto the CAP values, capitalised.
+// CAP's Unknown (and urgency Past) have no mesh equivalent and are imported
+// as the middle value.
+func toCAP(v string) string {
+	if v == "" {
+		return "Unknown"
+	}
+	return strings.ToUpper(v[:1]) + v[1:]
+}
+
+func fromCAP(v string, allowed []string, fallback string) string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	return fallback
+}
+
+func sender(nodeID string) string {
+	return nodeID + "@crisismesh"
+}
+
+func capTime(unix int64) string {
+	return time.Unix(unix, 0).Format(TimeLayout)
+}
+
+// FromMessage exports a stored alert, alert cancellation or SOS message.
+// For a cancellation, ref is the alert it withdraws, if known.
+func FromMessage(msg store.Message, ref *store.Message) (*Alert, error) {
+	doc := &Alert{
+		Identifier: msg.ID,
+		Sender:     sender(msg.SenderID),
+		Sent:       capTime(msg.Timestamp),
+		Status:     StatusActual,
+		MsgType:    MsgAlert,
+		Scope:      "Public",
+	}
+	switch {
+	case msg.Kind == store.KindAlert:
+		var a store.Alert
+		if err := json.Unmarshal([]byte(msg.Payload), &a); err != nil {
+			return nil, fmt.Errorf("invalid alert payload: %w", err)
+		}
+		if a.CapID != "" {
+			doc.Identifier = a.CapID
+		}
+		if a.Cancels != "" {
+			doc.MsgType = MsgCancel
+			refID, refSender, refSent := a.Cancels, doc.Sender, doc.Sent
+			if ref != nil {
+				refSender, refSent = sender(ref.SenderID), capTime(ref.Timestamp)
+				var r store.Alert
+				if json.Unmarshal([]byte(ref.Payload), &r) == nil && r.CapID != "" {
+					refID = r.CapID
+				}
+			}
+			doc.References = strings.Join([]string{refSender, refID, refSent}, ",")
+			return doc, nil
+		}
+		doc.Info = []Info{{
+			Category:    []string{"Safety"},
+			Event:       a.Headline,
+			Urgency:     toCAP(a.Urgency),
+			Severity:    toCAP(a.Severity),
+			Certainty:   "Observed",
+			Expires:     capTime(a.Expires),
+			SenderName:  msg.Author,
+			Headline:    a.Headline,
+			Instruction: a.Instructions,
+			Area:        []Area{{AreaDesc: a.Area}},
+		}}
+	case msg.Kind == "" && msg.Priority == 2:
+		sos, structured := store.ParseSOS(msg)
+		radius := 0.1
+		if sos.Accuracy > 0 {
+			radius = sos.Accuracy / 1000
+		}
+		area := Area{AreaDesc: "Unknown location"}
+		if msg.Lat != 0 || msg.Long != 0 {
+			area = Area{
+				AreaDesc: fmt.Sprintf("%.5f, %.5f", msg.Lat, msg.Long),
+				Circle:   []string{fmt.Sprintf("%.5f,%.5f %g", msg.Lat, msg.Long, radius)},
+			}
+		}
+		info := Info{
+			Category:   []string{"Rescue"},
+			Event:      "SOS",
+			Urgency:    "Immediate",
+			Severity:   "Unknown",
+			Certainty:  "Observed",
+			SenderName: msg.Author,
+			Headline:   msg.Content,
+			Area:       []Area{area},
+		}
+		if structured {
+			info.Category = []string{sosCategories[sos.Type]}
+			info.Event = "SOS: " + sos.Type
+			if sos.Injured > 0 || sos.Mobility == "immobile" {
+				info.Severity = "Severe"
+			}
+			info.Description = sosDescription(sos)
+		}
+		doc.Info = []Info{info}
+	default:
+		return nil, errors.New("only alerts and SOS messages can be exported as CAP")
+	}
+	return doc, nil
+}
+
+// sosCategories maps emergency types onto CAP event categories.
+var sosCategories = map[string]string{
+	store.EmergencyMedical: "Health",
+	store.EmergencyFire:    "Fire",
+	store.EmergencyTrapped: "Rescue",
+	store.EmergencyFlood:   "Met",
+	store.EmergencyOther:   "Rescue",
+}
+
+func sosDescription(sos store.SOS) string {
+	var parts []string
+	if sos.People > 0 {
+		parts = append(parts, fmt.Sprintf("People: %d", sos.People))
+	}
+	if sos.Injured > 0 {
+		parts = append(parts, fmt.Sprintf("Injured: %d", sos.Injured))
+	}
+	if sos.Mobility != "" {
+		parts = append(parts, "Mobility: "+sos.Mobility)
+	}
+	if sos.Accuracy > 0 {
+		parts = append(parts, fmt.Sprintf("GPS accuracy: %.0f m", sos.Accuracy))
+	}
+	if sos.Notes != "" {
+		parts = append(parts, "Notes: "+sos.Notes)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// ToMeshAlert maps the first <info> of a CAP alert or update onto a mesh
+// alert. Documents without an expiry get defaultTTL from now.
+func ToMeshAlert(doc *Alert, now time.Time, defaultTTL time.Duration) (store.Alert, error) {
+	if doc.Status != StatusActual {
+		return store.Alert{}, fmt.Errorf("not importing a %q alert", doc.Status)
+	}
+	if len(doc.Info) == 0 {
+		return store.Alert{}, errors.New("CAP alert without <info>")
+	}
+	info := doc.Info[0]
+	a := store.Alert{
+		Headline:     strings.TrimSpace(info.Headline),
+		Severity:     fromCAP(info.Severity, store.Severities, "moderate"),
+		Urgency:      fromCAP(info.Urgency, store.Urgencies, "expected"),
+		Instructions: truncate(strings.TrimSpace(info.Instruction), protocol.MaxInstructionsLen),
+		CapID:        doc.Identifier,
+		Expires:      now.Add(defaultTTL).Unix(),
+	}
+	if a.Headline == "" {
+		a.Headline = strings.TrimSpace(info.Event)
+	}
+	a.Headline = truncate(a.Headline, protocol.MaxHeadlineLen)
+	var areas []string
+	for _, ar := range info.Area {
+		if d := strings.TrimSpace(ar.AreaDesc); d != "" {
+			areas = append(areas, d)
+		}
+	}
+	a.Area = truncate(strings.Join(areas, "; "), protocol.MaxAreaLen)
+	if a.Area == "" {
+		a.Area = unknownArea
+	}
+	if info.Expires != "" {
+		t, err := time.Parse(TimeLayout, info.Expires)
+		if err != nil {
+			return store.Alert{}, fmt.Errorf("invalid <expires>: %w", err)
+		}
+		if !t.After(now) {
+			return store.Alert{}, errors.New("CAP alert has already expired")
+		}
+		a.Expires = t.Unix()
+	}
+	return a, nil
+}
+
+// unknownArea stands in for a missing <areaDesc>, which mesh alerts require.
+const unknownArea = "Area not given"
+
+// truncate cuts s to at most n bytes on a rune boundary, marking the cut
+// with an ellipsis.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	const ellipsis = "…"
+	cut := n - len(ellipsis)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + ellipsis
+}
+
+// ReferencedIDs returns the identifiers in a <references> list of
+// "sender,identifier,sent" triples.
+func ReferencedIDs(doc *Alert) []string {
+	var ids []string
+	for _, ref := range strings.Fields(doc.References) {
+		if parts := strings.Split(ref, ","); len(parts) == 3 {
+			ids = append(ids, parts[1])
+		}
+	}
+	return ids
+}
@@ -0,0 +1,108 @@
+package cap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+func TestSOSExport(t *testing.T) {
+	sos := store.Message{ID: "sos-1", SenderID: "node-a", Author: "alice", Content: "Trapped on roof",
+		Priority: 2, Lat: 51.5, Long: -0.12, Timestamp: 1700000000}
+	doc, err := FromMessage(sos, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Exported SOS does not decode: %v\n%s", err, data)
+	}
+	info := out.Info[0]
+	if out.Identifier != "sos-1" || out.Sender != "node-a@crisismesh" || info.Event != "SOS" ||
+		info.Headline != "Trapped on roof" || info.Area[0].Circle[0] != "51.50000,-0.12000 0.1" {
+		t.Errorf("Unexpected SOS export: %+v", out)
+	}
+
+	if _, err := FromMessage(store.Message{ID: "chat", Priority: 0}, nil); err == nil {
+		t.Error("Expected ordinary chat to be refused")
+	}
+}
+
+func TestToMeshAlert(t *testing.T) {
+	now := time.Now()
+	doc := &Alert{Identifier: "x", Status: StatusActual, Info: []Info{{
+		Event: "Gas leak", Severity: "Unknown", Urgency: "Past",
+		Area: []Area{{AreaDesc: "Mill Street"}},
+	}}}
+	a, err := ToMeshAlert(doc, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Headline != "Gas leak" || a.Severity != "moderate" || a.Urgency != "expected" ||
+		a.Area != "Mill Street" || a.Expires != now.Add(time.Hour).Unix() {
+		t.Errorf("Unexpected mapping: %+v", a)
+	}
+
+	doc.Info[0].Headline = strings.Repeat("é", 100)
+	doc.Info[0].Instruction = strings.Repeat("x", 2000)
+	doc.Info[0].Area = nil
+	a, err = ToMeshAlert(doc, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protocol.ValidateAlert(a); err != nil || !utf8.ValidString(a.Headline) || !strings.HasSuffix(a.Instructions, "…") {
+		t.Errorf("Expected long fields cut and a missing area filled in, got %+v (%v)", a, err)
+	}
+	doc.Info[0].Headline, doc.Info[0].Instruction = "", ""
+	doc.Info[0].Area = []Area{{AreaDesc: "Mill Street"}}
+
+	doc.Status = "Exercise"
+	if _, err := ToMeshAlert(doc, now, time.Hour); err == nil {
+		t.Error("Expected an exercise alert to be refused")
+	}
+	doc.Status = StatusActual
+	doc.Info[0].Expires = now.Add(-time.Minute).Format(TimeLayout)
+	if _, err := ToMeshAlert(doc, now, time.Hour); err == nil {
+		t.Error("Expected an expired alert to be refused")
+	}
+
+	if _, err := Decode([]byte(`<alert xmlns="urn:oasis:names:tc:emergency:cap:1.1"><identifier>x</identifier></alert>`)); err == nil {
+		t.Error("Expected a CAP 1.1 document to be refused")
+	}
+	if ids := ReferencedIDs(&Alert{References: "a@x,ID-1,2024-01-01T00:00:00+00:00 b@y,ID-2,2024-01-01T00:00:00+00:00"}); strings.Join(ids, " ") != "ID-1 ID-2" {
+		t.Errorf("Unexpected references %v", ids)
+	}
+}
+
+func TestScanInbox(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{DoneDir, FailedDir} {
+		os.Mkdir(filepath.Join(dir, sub), 0o755)
+	}
+	path := filepath.Join(dir, "flood.xml")
+	os.WriteFile(path, []byte("<alert/>"), 0o644)
+	var handled int
+	handle := func([]byte) error { handled++; return nil }
+	stuck := make(map[string]time.Time)
+
+	scanInbox(dir, handle, stuck)
+	if handled != 0 {
+		t.Fatal("Expected a file still being written to be left alone")
+	}
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(path, old, old)
+	scanInbox(dir, handle, stuck)
+	if _, err := os.Stat(filepath.Join(dir, DoneDir, "flood.xml")); handled != 1 || err != nil {
+		t.Errorf("Expected a settled file to be imported and moved to done, handled %d times (%v)", handled, err)
+	}
+}
@@ -0,0 +1,40 @@
+package cap
+
+import (
+	"encoding/json"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"gorm.io/gorm"
+)
+
+// ExportMessage renders the stored alert or SOS message id as CAP XML,
+// resolving the alert a cancellation refers to.
+func ExportMessage(db *gorm.DB, id string) ([]byte, error) {
+	msg, err := store.GetMessage(db, id)
+	if err != nil {
+		return nil, err
+	}
+	return export(db, msg)
+}
+
+func export(db *gorm.DB, msg store.Message) ([]byte, error) {
+	var ref *store.Message
+	if msg.Kind == store.KindAlert {
+		var a store.Alert
+		if json.Unmarshal([]byte(msg.Payload), &a) == nil && a.Cancels != "" {
+			if m, err := store.GetMessage(db, a.Cancels); err == nil {
+				ref = &m
+			}
+		}
+	}
+	doc, err := FromMessage(msg, ref)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(doc)
+}
+
+// Exportable reports whether msg is an alert or SOS message.
+func Exportable(msg store.Message) bool {
+	return msg.Kind == store.KindAlert || (msg.Kind == "" && msg.Priority == 2)
+}
@@ -0,0 +1,83 @@
+package cap
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Inbox subdirectories that handled documents are moved into.
+const (
+	DoneDir   = "done"
+	FailedDir = "failed"
+)
+
+// inboxSettle is how long a file must go unmodified before it is imported,
+// so one still being written is not read half-way.
+const inboxSettle = 2 * time.Second
+
+// WatchInbox polls dir for *.xml files and hands each to handle. Files are
+// moved to dir/done or, with a .err note beside them, to dir/failed, so
+// nothing is imported twice. Files modified in the last few seconds are
+// left for a later poll.
+func WatchInbox(ctx context.Context, dir string, interval time.Duration, handle func(data []byte) error) error {
+	for _, sub := range []string{DoneDir, FailedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		stuck := make(map[string]time.Time)
+		for {
+			scanInbox(dir, handle, stuck)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// scanInbox handles the settled files in dir. A file that was handled but
+// could not be moved is never deleted; it is recorded in stuck with its
+// modification time and skipped until it changes.
+func scanInbox(dir string, handle func(data []byte) error, stuck map[string]time.Time) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return
+	}
+	for _, path := range files {
+		name := filepath.Base(path)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < inboxSettle {
+			continue
+		}
+		if mod, ok := stuck[path]; ok && mod.Equal(info.ModTime()) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = handle(data)
+		}
+		dest := filepath.Join(dir, DoneDir, name)
+		if err != nil {
+			slog.Warn("Failed to import CAP file", "file", name, "error", err)
+			dest = filepath.Join(dir, FailedDir, name)
+			os.WriteFile(dest+".err", []byte(err.Error()+"\n"), 0o644)
+		} else {
+			slog.Info("Imported CAP file", "file", name)
+		}
+		if err := os.Rename(path, dest); err != nil {
+			slog.Error("Failed to move CAP file out of inbox; leaving it in place", "file", name, "error", err)
+			stuck[path] = info.ModTime()
+			continue
+		}
+		delete(stuck, path)
+	}
+}
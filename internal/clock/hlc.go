@@ -0,0 +1,82 @@
+// Package clock implements hybrid logical clocks (Kulkarni et al., 2014),
+// which order events across nodes whose wall clocks disagree.
+//
+// A timestamp packs milliseconds since the Unix epoch into the high 48 bits
+// and a logical counter into the low 16, so timestamps compare as plain
+// integers and sort correctly in the store.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+const logicalBits = 16
+
+// MaxSkew is how far a remote clock may run ahead of ours before we stop
+// following it and treat it as wrong.
+const MaxSkew = 5 * time.Minute
+
+// Pack builds a timestamp from a physical time and logical counter.
+func Pack(t time.Time, logical uint16) int64 {
+	return t.UnixMilli()<<logicalBits | int64(logical)
+}
+
+// FromUnix is the timestamp for a wall-clock time in seconds with no logical
+// part, for messages that predate HLCs.
+func FromUnix(sec int64) int64 {
+	return Pack(time.Unix(sec, 0), 0)
+}
+
+// Physical is the wall-clock part of ts.
+func Physical(ts int64) time.Time {
+	return time.UnixMilli(ts >> logicalBits)
+}
+
+// Clock is a hybrid logical clock. The zero value is not usable; use New.
+type Clock struct {
+	mu   sync.Mutex
+	last int64
+	now  func() time.Time
+}
+
+// New returns a clock that reads physical time from now, or from the local
+// clock if now is nil.
+func New(now func() time.Time) *Clock {
+	if now == nil {
+		now = time.Now
+	}
+	return &Clock{now: now}
+}
+
+// Now returns a timestamp for a local event such as sending a message. It is
+// always greater than every timestamp returned or observed before.
+func (c *Clock) Now() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = c.next(c.last)
+	return c.last
+}
+
+// Update merges a timestamp received from a peer, so that anything we send
+// afterwards orders after it. Remote timestamps more than MaxSkew ahead of
+// our wall clock are not followed, and Update reports false for them.
+func (c *Clock) Update(remote int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if Physical(remote).After(c.now().Add(MaxSkew)) {
+		c.last = c.next(c.last)
+		return false
+	}
+	c.last = c.next(max(c.last, remote))
+	return true
+}
+
+// next is the smallest timestamp after both prev and the current wall clock.
+func (c *Clock) next(prev int64) int64 {
+	wall := Pack(c.now(), 0)
+	if wall > prev {
+		return wall
+	}
+	return prev + 1
+}
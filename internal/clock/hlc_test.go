@@ -0,0 +1,46 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockMonotonic(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	wall := base
+	c := &Clock{now: func() time.Time { return wall }}
+
+	a := c.Now()
+	b := c.Now()
+	if b <= a || !Physical(b).Equal(base) {
+		t.Fatalf("Expected logical tick at same wall time, got %d then %d", a, b)
+	}
+
+	// A peer slightly ahead pulls us forward; our next event orders after it.
+	remote := Pack(base.Add(2*time.Second), 7)
+	if !c.Update(remote) {
+		t.Fatal("Expected small skew to be accepted")
+	}
+	if next := c.Now(); next <= remote {
+		t.Errorf("Expected %d to order after remote %d", next, remote)
+	}
+
+	// Our wall clock going backwards must not make timestamps go backwards.
+	before := c.Now()
+	wall = base.Add(-time.Hour)
+	if after := c.Now(); after <= before {
+		t.Errorf("Clock went backwards: %d then %d", before, after)
+	}
+}
+
+func TestClockIgnoresFarFuture(t *testing.T) {
+	wall := time.Unix(1700000000, 0)
+	c := &Clock{now: func() time.Time { return wall }}
+	far := Pack(wall.Add(MaxSkew+time.Minute), 0)
+	if c.Update(far) {
+		t.Error("Expected far-future timestamp to be flagged")
+	}
+	if c.Now() >= far {
+		t.Error("Clock followed a far-future timestamp")
+	}
+}
@@ -0,0 +1,162 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Strata follow NTP: a trusted source (GPS, an operator-set clock) is 0 and
+// each node synced from it adds one. StratumUnsynced means no path to a
+// source, in which case nodes converge on the median of their peers instead.
+const (
+	StratumSource   = 0
+	StratumUnsynced = 16
+)
+
+const (
+	// filterSize is how many recent exchanges are kept per peer; the one with
+	// the lowest round-trip delay is used, as in NTP's clock filter.
+	filterSize = 8
+	// maxDelay discards exchanges whose round trip is too slow to trust.
+	maxDelay = 2 * time.Second
+	// sampleTTL drops peers we have not exchanged with recently.
+	sampleTTL = 5 * time.Minute
+	// MaxOffset bounds how far one exchange may move us from the local
+	// clock; exchanges implying more are ignored, whatever the stratum.
+	MaxOffset = 24 * time.Hour
+)
+
+type sample struct {
+	offset  time.Duration
+	delay   time.Duration
+	stratum int
+	at      time.Time
+}
+
+// MeshTime estimates the offset between the local clock and mesh time from
+// PING/PONG exchanges with linked peers.
+type MeshTime struct {
+	mu      sync.Mutex
+	source  bool
+	samples map[string][]sample
+	offset  time.Duration
+	stratum int
+	now     func() time.Time
+}
+
+// NewMeshTime returns an estimator. If source is set the local clock is
+// trusted as-is and offered to peers at stratum 0.
+func NewMeshTime(source bool) *MeshTime {
+	m := &MeshTime{samples: make(map[string][]sample), now: time.Now}
+	m.SetSource(source)
+	return m
+}
+
+// SetSource marks the local clock as trusted (or no longer trusted).
+func (m *MeshTime) SetSource(source bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.source = source
+	if source {
+		m.offset, m.stratum = 0, StratumSource
+		return
+	}
+	m.stratum = StratumUnsynced
+	m.recompute()
+}
+
+// Now is the local clock corrected by the current offset.
+func (m *MeshTime) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now().Add(m.offset)
+}
+
+// Offset is how far mesh time is ahead of the local clock.
+func (m *MeshTime) Offset() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.offset
+}
+
+func (m *MeshTime) Stratum() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stratum
+}
+
+// Add records one exchange with peer: t1 and t4 are our local clock when the
+// PING left and the PONG arrived, t2 and t3 the peer's mesh time when it
+// received the PING and sent the PONG, and stratum the peer's stratum. The
+// stratum is taken as given, so callers pass StratumSource only for peers
+// they trust as a source.
+func (m *MeshTime) Add(peer string, t1, t2, t3, t4 time.Time, stratum int) {
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	if delay < 0 || delay > maxDelay {
+		return
+	}
+	s := sample{
+		offset:  (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		delay:   delay,
+		stratum: stratum,
+		at:      m.now(),
+	}
+	if s.offset > MaxOffset || s.offset < -MaxOffset {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	samples := append(m.samples[peer], s)
+	if len(samples) > filterSize {
+		samples = samples[len(samples)-filterSize:]
+	}
+	m.samples[peer] = samples
+	m.recompute()
+}
+
+// recompute picks the offset from the peers closest to a time source, or the
+// median of everyone if none has one.
+func (m *MeshTime) recompute() {
+	if m.source {
+		return
+	}
+	cutoff := m.now().Add(-sampleTTL)
+	best := StratumUnsynced
+	var chosen []sample
+	for peer, samples := range m.samples {
+		if samples[len(samples)-1].at.Before(cutoff) {
+			delete(m.samples, peer)
+			continue
+		}
+		s := slices.MinFunc(samples, func(a, b sample) int { return int(a.delay - b.delay) })
+		switch {
+		case s.stratum < best:
+			best, chosen = s.stratum, []sample{s}
+		case s.stratum == best:
+			chosen = append(chosen, s)
+		}
+	}
+	offsets := make([]time.Duration, 0, len(chosen)+1)
+	for _, s := range chosen {
+		offsets = append(offsets, s.offset)
+	}
+	if best >= StratumUnsynced {
+		// Free-running mesh: count our own clock as one vote.
+		m.stratum = StratumUnsynced
+		offsets = append(offsets, m.offset)
+	} else {
+		m.stratum = best + 1
+	}
+	if len(offsets) == 0 {
+		return
+	}
+	slices.Sort(offsets)
+	mid := len(offsets) / 2
+	if len(offsets)%2 == 0 && best >= StratumUnsynced && (m.offset-offsets[mid-1]).Abs() < (offsets[mid]-m.offset).Abs() {
+		// Break a tie towards where we are, so it takes a majority of
+		// peers to move us and one lone peer cannot pull us to its clock.
+		mid--
+	}
+	m.offset = offsets[mid]
+}
@@ -0,0 +1,76 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// exchange simulates a PING/PONG with a peer whose mesh time is ahead of our
+// local clock by skew, over a link with the given one-way latency.
+func exchange(m *MeshTime, peer string, local time.Time, skew, latency time.Duration, stratum int) {
+	t1 := local
+	t2 := local.Add(latency + skew)
+	t3 := t2.Add(time.Millisecond)
+	t4 := local.Add(2*latency + time.Millisecond)
+	m.Add(peer, t1, t2, t3, t4, stratum)
+}
+
+func TestMeshTimePrefersSource(t *testing.T) {
+	local := time.Unix(1700000000, 0)
+	m := NewMeshTime(false)
+	m.now = func() time.Time { return local }
+
+	exchange(m, "a", local, 30*time.Second, 5*time.Millisecond, StratumUnsynced)
+	exchange(m, "b", local, 40*time.Second, 5*time.Millisecond, StratumUnsynced)
+	if m.Stratum() != StratumUnsynced {
+		t.Errorf("Expected unsynced stratum without a source, got %d", m.Stratum())
+	}
+
+	exchange(m, "gps", local, -10*time.Second, 20*time.Millisecond, StratumSource)
+	if m.Stratum() != 1 {
+		t.Errorf("Expected stratum 1 next to a source, got %d", m.Stratum())
+	}
+	if off := m.Offset(); off < -10*time.Second-time.Millisecond || off > -10*time.Second+time.Millisecond {
+		t.Errorf("Expected offset of about -10s from the source, got %v", off)
+	}
+}
+
+func TestMeshTimeMedian(t *testing.T) {
+	local := time.Unix(1700000000, 0)
+	m := NewMeshTime(false)
+	m.now = func() time.Time { return local }
+	exchange(m, "a", local, 10*time.Second, time.Millisecond, StratumUnsynced)
+	exchange(m, "b", local, 20*time.Second, time.Millisecond, StratumUnsynced)
+	exchange(m, "c", local, time.Hour, time.Millisecond, StratumUnsynced)
+	// Votes: a, b, c and our own clock; the outlier does not win.
+	if off := m.Offset(); off > 21*time.Second {
+		t.Errorf("Expected median offset to ignore the outlier, got %v", off)
+	}
+
+	// One peer against our own clock is a tie, and ties do not move us.
+	lone := NewMeshTime(false)
+	lone.now = func() time.Time { return local }
+	exchange(lone, "a", local, time.Hour, time.Millisecond, StratumUnsynced)
+	if off := lone.Offset(); off != 0 {
+		t.Errorf("Expected a lone peer not to move the clock, got %v", off)
+	}
+
+	// A slow exchange is discarded rather than skewing the estimate.
+	exchange(m, "d", local, time.Hour, 3*time.Second, StratumSource)
+	if m.Stratum() != StratumUnsynced {
+		t.Error("Expected exchange over a slow link to be ignored")
+	}
+	// So is one implying an offset beyond MaxOffset, even from a source.
+	exchange(m, "e", local, 10*365*24*time.Hour, time.Millisecond, StratumSource)
+	if m.Stratum() != StratumUnsynced {
+		t.Error("Expected exchange beyond MaxOffset to be ignored")
+	}
+}
+
+func TestMeshTimeSource(t *testing.T) {
+	m := NewMeshTime(true)
+	exchange(m, "a", time.Now(), time.Hour, time.Millisecond, StratumSource)
+	if m.Offset() != 0 || m.Stratum() != StratumSource {
+		t.Error("A time source must not follow its peers")
+	}
+}
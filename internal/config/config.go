@@ -4,4 +4,30 @@ type Config struct {
 	Port    int
 	WebPort int
 	Nick    string
+	MDNS    bool
+	// PolicyFile holds the link allow/deny rules; see internal/policy.
+	PolicyFile string
+	// Role and Lat/Long are reported in heartbeats.
+	Role string
+	Lat  float64
+	Long float64
+	// Networks are the meshes to join; the first is the default for sending.
+	Networks []string
+	// TimeSource offers this node's clock to the mesh as trusted time.
+	TimeSource bool
+	// TimeSources are the peers whose claimed stratum is trusted.
+	TimeSources []string
+	// TrustModeration applies moderation records from verified moderators.
+	TrustModeration bool
+	// CommandKey is the incident-command signing key for role certificates.
+	CommandKey string
+	// CAPInbox is a directory polled for CAP files to issue as alerts, and
+	// CAPUplink a URL alerts and SOS messages are posted to as CAP.
+	CAPInbox  string
+	CAPUplink string
+	// UplinkSOSTypes limits the SOS messages uplinks relay to these
+	// emergency types; empty relays all.
+	UplinkSOSTypes []string
+	// RollCallAuto answers roll calls safe for the operator automatically.
+	RollCallAuto bool
 }
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// fingerprintGroups is how many five-digit groups a fingerprint has; 30
+// digits is about 100 bits, the same strength as a Signal safety number.
+const fingerprintGroups = 6
+
+// FingerprintScheme prefixes the text encoded in fingerprint QR codes.
+const FingerprintScheme = "crisismesh-fp:"
+
+// Fingerprint turns a node's signing and box keys into groups of digits
+// that two operators can read aloud and compare, e.g. "04182 99310 ...".
+func Fingerprint(signPubHex, pubKeyHex string) string {
+	sum := sha256.Sum256([]byte("crisismesh-fingerprint:" + signPubHex + ":" + pubKeyHex))
+	groups := make([]string, fingerprintGroups)
+	for i := range groups {
+		// Five bytes per group leaves negligible modulo bias.
+		var chunk [8]byte
+		copy(chunk[3:], sum[i*5:i*5+5])
+		groups[i] = fmt.Sprintf("%05d", binary.BigEndian.Uint64(chunk[:])%100000)
+	}
+	return strings.Join(groups, " ")
+}
+
+// FingerprintURI is what a node's fingerprint QR code encodes.
+func FingerprintURI(nodeID, signPubHex, pubKeyHex string) string {
+	return FingerprintScheme + nodeID + ":" + Fingerprint(signPubHex, pubKeyHex)
+}
+
+// ParseFingerprint accepts a typed fingerprint (any spacing) or a scanned
+// FingerprintURI, returning the node ID (empty when typed) and the
+// normalised fingerprint.
+func ParseFingerprint(s string) (nodeID, fingerprint string, err error) {
+	s = strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(s, FingerprintScheme); ok {
+		nodeID, s, ok = strings.Cut(rest, ":")
+		if !ok {
+			return "", "", fmt.Errorf("malformed fingerprint code")
+		}
+	}
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-':
+		default:
+			return "", "", fmt.Errorf("fingerprint may only contain digits")
+		}
+	}
+	d := digits.String()
+	if len(d) != fingerprintGroups*5 {
+		return "", "", fmt.Errorf("fingerprint must have %d digits, got %d", fingerprintGroups*5, len(d))
+	}
+	groups := make([]string, fingerprintGroups)
+	for i := range groups {
+		groups[i] = d[i*5 : i*5+5]
+	}
+	return nodeID, strings.Join(groups, " "), nil
+}
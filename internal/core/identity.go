@@ -1,6 +1,7 @@
 package core
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -12,10 +13,31 @@ import (
 	"golang.org/x/crypto/nacl/box"
 )
 
+// Identity holds a node's Curve25519 box keys (for DMs) and Ed25519 signing
+// keys (for heartbeats and signed records).
 type Identity struct {
-	NodeID  string `json:"node_id"`
-	PubKey  string `json:"pub_key"`
-	PrivKey string `json:"priv_key"`
+	NodeID   string `json:"node_id"`
+	PubKey   string `json:"pub_key"`
+	PrivKey  string `json:"priv_key"`
+	SignPub  string `json:"sign_pub"`
+	SignPriv string `json:"sign_priv"`
+	// Rotation is set after the keys have been rotated, and is carried in
+	// heartbeats so peers that pinned the old key accept the new one.
+	Rotation *KeyRotation `json:"rotation,omitempty"`
+	// RoleCert is the role certificate installed for this node, if any.
+	RoleCert *RoleCert `json:"role_cert,omitempty"`
+	// HeartbeatCounter is the highest heartbeat counter reserved so far, so
+	// counters keep rising across restarts even if the clock goes back.
+	HeartbeatCounter uint64 `json:"heartbeat_counter,omitempty"`
+}
+
+// KeyRotation is signed by the previous signing key and vouches for the
+// node's new key pair.
+type KeyRotation struct {
+	OldSignPub string `json:"old_sign_pub"`
+	NewSignPub string `json:"new_sign_pub"`
+	NewPubKey  string `json:"new_pub_key"`
+	Sig        string `json:"sig"`
 }
 
 func GenerateIdentity() (*Identity, error) {
@@ -23,11 +45,17 @@ func GenerateIdentity() (*Identity, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate keys: %w", err)
 	}
+	signPub, signPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing keys: %w", err)
+	}
 
 	return &Identity{
-		NodeID:  uuid.New().String(),
-		PubKey:  hex.EncodeToString(pub[:]),
-		PrivKey: hex.EncodeToString(priv[:]),
+		NodeID:   uuid.New().String(),
+		PubKey:   hex.EncodeToString(pub[:]),
+		PrivKey:  hex.EncodeToString(priv[:]),
+		SignPub:  hex.EncodeToString(signPub),
+		SignPriv: hex.EncodeToString(signPriv),
 	}, nil
 }
 
@@ -42,6 +70,20 @@ func LoadOrGenerateIdentity(filename string) (*Identity, error) {
 			return nil, fmt.Errorf("failed to parse identity file: %w", err)
 		}
 		if id.NodeID != "" && id.PubKey != "" && id.PrivKey != "" {
+			if id.SignPub != "" && id.SignPriv != "" {
+				return &id, nil
+			}
+			// Identities from before signed heartbeats keep their node ID
+			// and box keys and just gain a signing key.
+			signPub, signPriv, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate signing keys: %w", err)
+			}
+			id.SignPub = hex.EncodeToString(signPub)
+			id.SignPriv = hex.EncodeToString(signPriv)
+			if err := SaveIdentity(filename, &id); err != nil {
+				return nil, err
+			}
 			return &id, nil
 		}
 	}
@@ -50,18 +92,94 @@ func LoadOrGenerateIdentity(filename string) (*Identity, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := SaveIdentity(filename, id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
 
+func SaveIdentity(filename string, id *Identity) error {
 	data, err := json.MarshalIndent(id, "", "  ")
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal identity: %w", err)
+		return fmt.Errorf("failed to marshal identity: %w", err)
 	}
 
-	if err := os.WriteFile(filename, data, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write identity file: %w", err)
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		return fmt.Errorf("failed to write identity file: %w", err)
 	}
+	return nil
+}
 
-	return id, nil
+// SaveHeartbeatCounter records counter in the identity file, leaving the
+// rest of the file as it is on disk.
+func SaveHeartbeatCounter(filename string, counter uint64) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read identity file: %w", err)
+	}
+	var id Identity
+	if err := json.Unmarshal(data, &id); err != nil {
+		return fmt.Errorf("failed to parse identity file: %w", err)
+	}
+	id.HeartbeatCounter = counter
+	return SaveIdentity(filename, &id)
 }
+
+// Rotate replaces both key pairs, keeping the node ID, and records a
+// rotation proof signed by the outgoing signing key.
+func (id *Identity) Rotate() error {
+	next, err := GenerateIdentity()
+	if err != nil {
+		return err
+	}
+	rot := &KeyRotation{
+		OldSignPub: id.SignPub,
+		NewSignPub: next.SignPub,
+		NewPubKey:  next.PubKey,
+	}
+	rot.Sig, err = Sign(id.SignPriv, rot.signingBytes(id.NodeID))
+	if err != nil {
+		return err
+	}
+	id.PubKey, id.PrivKey = next.PubKey, next.PrivKey
+	id.SignPub, id.SignPriv = next.SignPub, next.SignPriv
+	id.Rotation = rot
+	// A role certificate names the old signing key; a new one is needed.
+	id.RoleCert = nil
+	return nil
+}
+
+// Verify checks the proof was signed by OldSignPub for this node.
+func (r *KeyRotation) Verify(nodeID string) bool {
+	return Verify(r.OldSignPub, r.signingBytes(nodeID), r.Sig)
+}
+
+func (r *KeyRotation) signingBytes(nodeID string) []byte {
+	return []byte(fmt.Sprintf("crisismesh-rotate:%s:%s:%s", nodeID, r.NewSignPub, r.NewPubKey))
+}
+
+// Sign returns the hex Ed25519 signature of data.
+func Sign(signPrivHex string, data []byte) (string, error) {
+	priv, err := hex.DecodeString(signPrivHex)
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		return "", fmt.Errorf("invalid signing key")
+	}
+	return hex.EncodeToString(ed25519.Sign(ed25519.PrivateKey(priv), data)), nil
+}
+
+// Verify reports whether sigHex is a valid signature of data by signPubHex.
+func Verify(signPubHex string, data []byte, sigHex string) bool {
+	pub, err := hex.DecodeString(signPubHex)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), data, sig)
+}
+
 func GenerateMessageID(senderID, content string, ts int64) string {
 	input := fmt.Sprintf("%s:%s:%d", senderID, content, ts)
 	hash := sha256.Sum256([]byte(input))
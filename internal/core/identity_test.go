@@ -3,7 +3,10 @@ package core
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/nacl/box"
 )
@@ -116,3 +119,98 @@ func TestDecryptionFailure(t *testing.T) {
 		t.Error("Charlie successfully decrypted Bob's message!")
 	}
 }
+
+func TestRotateKeepsNodeID(t *testing.T) {
+	id, _ := GenerateIdentity()
+	nodeID, oldSign, oldBox := id.NodeID, id.SignPub, id.PubKey
+	if err := id.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if id.NodeID != nodeID {
+		t.Error("Rotate changed the node ID")
+	}
+	if id.SignPub == oldSign || id.PubKey == oldBox {
+		t.Error("Rotate did not replace the keys")
+	}
+	r := id.Rotation
+	if r == nil || r.OldSignPub != oldSign || !r.Verify(nodeID) {
+		t.Fatalf("Expected a valid rotation proof from the old key, got %+v", r)
+	}
+	if r.Verify("some-other-node") {
+		t.Error("Rotation proof verified for a different node ID")
+	}
+}
+
+func TestSaveHeartbeatCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+	id, err := LoadOrGenerateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveHeartbeatCounter(path, 12345); err != nil {
+		t.Fatalf("SaveHeartbeatCounter failed: %v", err)
+	}
+	loaded, err := LoadOrGenerateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.HeartbeatCounter != 12345 || loaded.NodeID != id.NodeID || loaded.SignPriv != id.SignPriv {
+		t.Errorf("Expected the counter saved alongside the same keys, got %+v", loaded)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	id, _ := GenerateIdentity()
+	fp := Fingerprint(id.SignPub, id.PubKey)
+	if len(fp) != 6*5+5 {
+		t.Fatalf("Unexpected fingerprint format %q", fp)
+	}
+	if Fingerprint(id.SignPub, id.PubKey) != fp {
+		t.Error("Fingerprint is not deterministic")
+	}
+	other, _ := GenerateIdentity()
+	if Fingerprint(other.SignPub, id.PubKey) == fp {
+		t.Error("Different signing key gave the same fingerprint")
+	}
+
+	nodeID, parsed, err := ParseFingerprint(FingerprintURI(id.NodeID, id.SignPub, id.PubKey))
+	if err != nil || nodeID != id.NodeID || parsed != fp {
+		t.Errorf("Failed to round-trip QR code: %q %q %v", nodeID, parsed, err)
+	}
+	if _, parsed, err := ParseFingerprint(strings.ReplaceAll(fp, " ", "-")); err != nil || parsed != fp {
+		t.Errorf("Failed to parse typed fingerprint: %q %v", parsed, err)
+	}
+	if _, _, err := ParseFingerprint("12345 678"); err == nil {
+		t.Error("Expected short fingerprint to be rejected")
+	}
+}
+
+func TestRoleCert(t *testing.T) {
+	command, _ := GenerateIdentity()
+	node, _ := GenerateIdentity()
+	now := time.Now()
+	cert, err := IssueRoleCert(command.SignPriv, node.NodeID, node.SignPub, RoleResponder, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeRoleCert(cert.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(command.SignPub, now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected certificate to verify: %v", err)
+	}
+	if err := decoded.Verify(command.SignPub, now.Add(2*time.Hour)); err == nil {
+		t.Error("Expected expired certificate to fail")
+	}
+	if err := decoded.Verify(node.SignPub, now); err == nil {
+		t.Error("Expected certificate to fail against another key")
+	}
+	decoded.Role = RoleCommander
+	if err := decoded.Verify(command.SignPub, now); err == nil {
+		t.Error("Expected promoted certificate to fail")
+	}
+	if !RoleAllows(RoleCommander, PermAlert) || RoleAllows(RoleResponder, PermModerate) || RoleAllows(RoleCivilian, PermRollCall) {
+		t.Error("Unexpected role permissions")
+	}
+}
@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Peer liveness states, in the order a silent peer moves through them.
+const (
+	StateAlive     = "alive"
+	StateSuspect   = "suspect"
+	StateDead      = "dead"
+	StateForgotten = "forgotten"
+)
+
+// PeerEvent records a liveness transition for the UIs.
+type PeerEvent struct {
+	PeerID string    `json:"peer_id"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Phi    float64   `json:"phi"`
+	At     time.Time `json:"at"`
+}
+
+const (
+	windowSize = 100
+	// Copies of one beat arrive via broadcast, loopback and multicast within
+	// a few ms of each other; only the first counts as an arrival.
+	minArrivalGap = 200 * time.Millisecond
+	// Seed for a peer with no history, matching the 1s heartbeat ticker.
+	firstIntervalEstimate = 1000.0
+	minStdDevMs           = 100.0
+	// Tolerated pause on top of the observed mean, so one lost beat on a
+	// clean link does not immediately raise suspicion.
+	acceptablePauseMs = 2000.0
+	// Long silences underflow to +Inf, which JSON cannot carry.
+	maxPhi = 100.0
+)
+
+// FailureDetector is a phi-accrual failure detector: instead of a fixed
+// timeout it tracks each peer's heartbeat inter-arrival times and reports
+// how unlikely the current silence is (phi = -log10 P(still alive)). Peers
+// on lossy links build up a wider distribution and so are suspected later.
+type FailureDetector struct {
+	mu          sync.Mutex
+	peers       map[string]*arrivalWindow
+	SuspectPhi  float64
+	DeadPhi     float64
+	ForgetAfter time.Duration
+}
+
+type arrivalWindow struct {
+	last      time.Time
+	intervals []float64
+	state     string
+	deadSince time.Time
+}
+
+func NewFailureDetector() *FailureDetector {
+	return &FailureDetector{
+		peers:       make(map[string]*arrivalWindow),
+		SuspectPhi:  3,
+		DeadPhi:     8,
+		ForgetAfter: 10 * time.Minute,
+	}
+}
+
+// Track seeds a peer known from a previous run so it can time out even if
+// it never beats again.
+func (d *FailureDetector) Track(id string, lastSeen time.Time, state string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.peers[id]; ok {
+		return
+	}
+	w := &arrivalWindow{last: lastSeen, state: state}
+	if state == StateDead {
+		w.deadSince = lastSeen
+	}
+	d.peers[id] = w
+}
+
+// Heartbeat records an arrival from id and returns the transition, if the
+// peer was not already alive.
+func (d *FailureDetector) Heartbeat(id string, now time.Time) (PeerEvent, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.peers[id]
+	if !ok {
+		d.peers[id] = &arrivalWindow{last: now, state: StateAlive}
+		return PeerEvent{PeerID: id, To: StateAlive, At: now}, true
+	}
+	if gap := now.Sub(w.last); gap >= minArrivalGap {
+		// A gap spanning a dead period says nothing about the link's
+		// normal rhythm, so only intervals observed while alive count.
+		if w.state == StateAlive || w.state == StateSuspect {
+			w.intervals = append(w.intervals, float64(gap.Milliseconds()))
+			if len(w.intervals) > windowSize {
+				w.intervals = w.intervals[1:]
+			}
+		}
+		w.last = now
+	}
+	if w.state == StateAlive {
+		return PeerEvent{}, false
+	}
+	ev := PeerEvent{PeerID: id, From: w.state, To: StateAlive, At: now}
+	w.state = StateAlive
+	return ev, true
+}
+
+func (d *FailureDetector) Phi(id string, now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.peers[id]
+	if !ok {
+		return 0
+	}
+	return w.phi(now)
+}
+
+func (d *FailureDetector) State(id string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if w, ok := d.peers[id]; ok {
+		return w.state
+	}
+	return StateForgotten
+}
+
+// Sweep re-evaluates every peer and returns the transitions that occurred.
+// Forgotten peers are dropped from the detector.
+func (d *FailureDetector) Sweep(now time.Time) []PeerEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var events []PeerEvent
+	for id, w := range d.peers {
+		phi := w.phi(now)
+		next := w.state
+		switch w.state {
+		case StateAlive, StateSuspect:
+			if phi >= d.DeadPhi {
+				next = StateDead
+			} else if phi >= d.SuspectPhi {
+				next = StateSuspect
+			}
+		case StateDead:
+			if now.Sub(w.deadSince) >= d.ForgetAfter {
+				next = StateForgotten
+			}
+		}
+		if next == w.state {
+			continue
+		}
+		events = append(events, PeerEvent{PeerID: id, From: w.state, To: next, Phi: phi, At: now})
+		w.state = next
+		switch next {
+		case StateDead:
+			w.deadSince = now
+		case StateForgotten:
+			delete(d.peers, id)
+		}
+	}
+	return events
+}
+
+func (w *arrivalWindow) phi(now time.Time) float64 {
+	mean, std := firstIntervalEstimate, firstIntervalEstimate/4
+	if n := len(w.intervals); n > 0 {
+		var sum, sq float64
+		for _, v := range w.intervals {
+			sum += v
+		}
+		mean = sum / float64(n)
+		for _, v := range w.intervals {
+			sq += (v - mean) * (v - mean)
+		}
+		std = math.Sqrt(sq / float64(n))
+	}
+	if std < minStdDevMs {
+		std = minStdDevMs
+	}
+	elapsed := float64(now.Sub(w.last).Milliseconds())
+	return phi(elapsed, mean+acceptablePauseMs, std)
+}
+
+// phi uses the logistic approximation of the normal CDF (as in Akka's
+// detector) to get -log10 of the probability of a gap at least this long.
+func phi(elapsed, mean, std float64) float64 {
+	y := (elapsed - mean) / std
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	var p float64
+	if elapsed > mean {
+		p = -math.Log10(e / (1.0 + e))
+	} else {
+		p = -math.Log10(1.0 - 1.0/(1.0+e))
+	}
+	if math.IsNaN(p) || p > maxPhi {
+		return maxPhi
+	}
+	return p
+}
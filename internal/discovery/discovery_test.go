@@ -1,11 +1,31 @@
 package discovery
+
 import (
 	"context"
 	"encoding/json"
 	"net"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/hashicorp/mdns"
 )
+
+// signedBeat returns a heartbeat for id signed with a fresh identity key.
+func signedBeat(t *testing.T, id, nick string, port int) []byte {
+	t.Helper()
+	key, err := core.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := HeartbeatPacket{Type: "beat", ID: id, Nick: nick, Port: port, TS: time.Now().Unix(), SignKey: key.SignPub, Counter: 1}
+	if err := p.Sign(key.SignPriv); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(p)
+	return data
+}
 func TestHeartbeatListener(t *testing.T) {
 	port := 9999
 	peerChan := make(chan PeerInfo, 1)
@@ -26,14 +46,7 @@ func TestHeartbeatListener(t *testing.T) {
 		t.Fatalf("Failed to dial UDP: %v", err)
 	}
 	defer conn.Close()
-	packet := HeartbeatPacket{
-		Type: "beat",
-		ID:   "peer-node-id",
-		Nick: "PeerNick",
-		Port: 12345,
-		TS:   time.Now().Unix(),
-	}
-	data, _ := json.Marshal(packet)
+	data := signedBeat(t, "peer-node-id", "PeerNick", 12345)
 	if _, err := conn.Write(data); err != nil {
 		t.Fatalf("Failed to write packet: %v", err)
 	}
@@ -55,8 +68,7 @@ func TestHeartbeatListener(t *testing.T) {
 	if _, err := conn.Write([]byte("{invalid-json")); err != nil {
 		t.Fatalf("Failed to write malformed packet: %v", err)
 	}
-	packet.ID = "peer-node-id-2"
-	data, _ = json.Marshal(packet)
+	data = signedBeat(t, "peer-node-id-2", "PeerNick", 12345)
 	if _, err := conn.Write(data); err != nil {
 		t.Fatalf("Failed to write second packet: %v", err)
 	}
@@ -69,3 +81,220 @@ func TestHeartbeatListener(t *testing.T) {
 		t.Fatal("Timed out waiting for second peer info (listener might have crashed)")
 	}
 }
+func TestHeartbeatListenerIPv6(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp6", "[::1]:9998")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	peerChan := make(chan PeerInfo, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := StartListener(ctx, 9998, "my-node-id", peerChan); err != nil {
+			t.Errorf("StartListener failed: %v", err)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	conn, err := net.DialUDP("udp6", nil, addr)
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer conn.Close()
+	data := signedBeat(t, "v6-peer", "Six", 12345)
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("Failed to write packet: %v", err)
+	}
+	select {
+	case info := <-peerChan:
+		if info.Addr != "[::1]:12345" {
+			t.Errorf("Expected bracketed IPv6 addr '[::1]:12345', got %q", info.Addr)
+		}
+		if _, _, err := net.SplitHostPort(info.Addr); err != nil {
+			t.Errorf("Addr %q is not dialable: %v", info.Addr, err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out waiting for IPv6 peer info")
+	}
+}
+func TestParseServiceEntry(t *testing.T) {
+	entry := &mdns.ServiceEntry{
+		AddrV6IPAddr: &net.IPAddr{IP: net.ParseIP("fe80::1"), Zone: "wlan0"},
+		Port:         9001,
+	}
+	key, _ := core.GenerateIdentity()
+	sig, _ := core.Sign(key.SignPriv, txtSigningBytes("peer-1", "Bravo", "abcd", key.SignPub, "", 9001))
+	entry.InfoFields = []string{"id=peer-1", "nick=Bravo", "pk=abcd", "sk=" + key.SignPub, "sig=" + sig}
+	info, ok := parseServiceEntry(entry, "my-node-id")
+	if !ok {
+		t.Fatal("Expected entry to parse")
+	}
+	if info.ID != "peer-1" || info.Nick != "Bravo" || info.PubKey != "abcd" || info.Source != SourceMDNS {
+		t.Errorf("Unexpected peer info: %+v", info)
+	}
+	if info.Addr != "[fe80::1%wlan0]:9001" {
+		t.Errorf("Expected zoned link-local addr, got %q", info.Addr)
+	}
+	entry.AddrV4 = net.ParseIP("10.0.0.7")
+	if info, _ := parseServiceEntry(entry, "my-node-id"); info.Addr != "10.0.0.7:9001" {
+		t.Errorf("Expected IPv4 to be preferred, got %q", info.Addr)
+	}
+	if _, ok := parseServiceEntry(entry, "peer-1"); ok {
+		t.Error("Expected own advertisement to be ignored")
+	}
+	entry.InfoFields[1] = "nick=Mallory"
+	if _, ok := parseServiceEntry(entry, "my-node-id"); ok {
+		t.Error("Expected tampered TXT record to be rejected")
+	}
+}
+func TestHeartbeatPacketVerify(t *testing.T) {
+	key, _ := core.GenerateIdentity()
+	p := HeartbeatPacket{Type: "beat", ID: key.NodeID, Nick: "Alpha", Port: 9000, PubKey: key.PubKey, SignKey: key.SignPub, Counter: 7}
+	if err := p.Sign(key.SignPriv); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Verify() {
+		t.Fatal("Expected signed packet to verify")
+	}
+	tampered := p
+	tampered.Port = 9005
+	if tampered.Verify() {
+		t.Error("Expected tampered packet to fail verification")
+	}
+	unsigned := p
+	unsigned.Sig = ""
+	if unsigned.Verify() {
+		t.Error("Expected unsigned packet to fail verification")
+	}
+
+	old := *key
+	if err := key.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	p = HeartbeatPacket{Type: "beat", ID: key.NodeID, PubKey: key.PubKey, SignKey: key.SignPub, Rotation: key.Rotation}
+	_ = p.Sign(key.SignPriv)
+	if !p.Verify() {
+		t.Error("Expected rotated packet to verify")
+	}
+	// A stolen rotation proof cannot vouch for someone else's key.
+	forged := HeartbeatPacket{Type: "beat", ID: key.NodeID, PubKey: key.PubKey, SignKey: old.SignPub, Rotation: key.Rotation}
+	_ = forged.Sign(old.SignPriv)
+	if forged.Verify() {
+		t.Error("Expected rotation proof for a different key to be rejected")
+	}
+}
+func TestFailureDetectorTransitions(t *testing.T) {
+	fd := NewFailureDetector()
+	start := time.Now()
+	if ev, changed := fd.Heartbeat("p", start); !changed || ev.To != StateAlive {
+		t.Fatalf("Expected first heartbeat to mark peer alive, got %+v", ev)
+	}
+	now := start
+	for i := 0; i < 30; i++ {
+		now = now.Add(time.Second)
+		if _, changed := fd.Heartbeat("p", now); changed {
+			t.Fatalf("Unexpected transition on steady heartbeat %d", i)
+		}
+	}
+	// Duplicate copies of the same beat must not skew the window.
+	fd.Heartbeat("p", now.Add(5*time.Millisecond))
+
+	if evs := fd.Sweep(now.Add(2500 * time.Millisecond)); len(evs) != 0 {
+		t.Errorf("Expected no transition after one missed beat, got %+v", evs)
+	}
+	evs := fd.Sweep(now.Add(3500 * time.Millisecond))
+	if len(evs) != 1 || evs[0].To != StateSuspect {
+		t.Fatalf("Expected suspect, got %+v", evs)
+	}
+	evs = fd.Sweep(now.Add(10 * time.Second))
+	if len(evs) != 1 || evs[0].From != StateSuspect || evs[0].To != StateDead {
+		t.Fatalf("Expected suspect -> dead, got %+v", evs)
+	}
+	evs = fd.Sweep(now.Add(10*time.Second + fd.ForgetAfter))
+	if len(evs) != 1 || evs[0].To != StateForgotten {
+		t.Fatalf("Expected forgotten, got %+v", evs)
+	}
+	if fd.State("p") != StateForgotten {
+		t.Errorf("Expected detector to drop forgotten peer")
+	}
+}
+
+func TestFailureDetectorAdaptsToLossyLink(t *testing.T) {
+	clean, lossy := NewFailureDetector(), NewFailureDetector()
+	now := time.Now()
+	clean.Heartbeat("p", now)
+	lossy.Heartbeat("p", now)
+	c, l := now, now
+	for i := 0; i < 40; i++ {
+		c = c.Add(time.Second)
+		clean.Heartbeat("p", c)
+		// Every other beat is lost on the lossy link.
+		if i%2 == 0 {
+			l = l.Add(time.Second)
+		} else {
+			l = l.Add(3 * time.Second)
+		}
+		lossy.Heartbeat("p", l)
+	}
+	gap := 4500 * time.Millisecond
+	if phi := clean.Phi("p", c.Add(gap)); phi < clean.DeadPhi {
+		t.Errorf("Expected clean link to be dead after %v silence, phi=%.2f", gap, phi)
+	}
+	if phi := lossy.Phi("p", l.Add(gap)); phi >= lossy.SuspectPhi {
+		t.Errorf("Expected lossy link to tolerate %v silence, phi=%.2f", gap, phi)
+	}
+}
+func TestHeartbeatMetadata(t *testing.T) {
+	peerChan := make(chan PeerInfo, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := StartListener(ctx, 9997, "my-node-id", peerChan); err != nil {
+			t.Errorf("StartListener failed: %v", err)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	conn, err := net.Dial("udp", "127.0.0.1:9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	key, _ := core.GenerateIdentity()
+	level := 15
+	p := HeartbeatPacket{Type: "beat", ID: "meta-peer", Port: 9000, SignKey: key.SignPub, Counter: 1,
+		Metadata: Metadata{Battery: &level, Lat: 35.68, Long: 139.69, Role: "medic", Queue: 3}}
+	_ = p.Sign(key.SignPriv)
+	data, _ := json.Marshal(p)
+	conn.Write(data)
+	select {
+	case info := <-peerChan:
+		m := info.Meta
+		if m.Battery == nil || *m.Battery != 15 || m.Role != "medic" || m.Queue != 3 || m.Lat != 35.68 {
+			t.Errorf("Metadata not carried through: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for heartbeat")
+	}
+
+	// Oversized beats are dropped even when correctly signed.
+	p.ID, p.Nick = "big-peer", strings.Repeat("x", MaxHeartbeatSize)
+	_ = p.Sign(key.SignPriv)
+	data, _ = json.Marshal(p)
+	conn.Write(data)
+	select {
+	case info := <-peerChan:
+		t.Errorf("Expected oversized heartbeat to be dropped, got %s", info.ID)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+func TestMetadataSanitize(t *testing.T) {
+	bad := 250
+	m := Metadata{Battery: &bad, Lat: 123, Long: 10, Role: strings.Repeat("r", 100), Queue: -4}.Sanitize()
+	if m.Battery != nil || m.Lat != 0 || m.Long != 0 || len(m.Role) != MaxRoleLen || m.Queue != 0 {
+		t.Errorf("Unexpected sanitized metadata: %+v", m)
+	}
+	zero := 0
+	if m := (Metadata{Battery: &zero}).Sanitize(); m.Battery == nil {
+		t.Error("Expected 0% battery to be kept")
+	}
+}
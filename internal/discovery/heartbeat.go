@@ -6,33 +6,146 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"strconv"
 	"time"
 
+	"github.com/bit2swaz/crisismesh/internal/core"
 	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/bit2swaz/crisismesh/internal/utils"
 	"gorm.io/gorm"
 )
 
+// IPv6AllNodes is the link-local all-nodes multicast group. Every IPv6 host
+// is already a member, so a plain dual-stack listener receives these beats
+// without joining anything.
+const IPv6AllNodes = "ff02::1"
+
+// MaxHeartbeatSize keeps a beat in one unfragmented datagram even on the
+// IPv6 minimum MTU; larger packets are dropped by the listener.
+const MaxHeartbeatSize = 1200
+
+// MaxRoleLen bounds the declared role carried in heartbeats.
+const MaxRoleLen = 24
+
+// Metadata is the optional node status carried in heartbeats. Zero values
+// mean "not reported"; Battery is a pointer since 0% is worth reporting.
+type Metadata struct {
+	Battery *int    `json:"bat,omitempty"`
+	Lat     float64 `json:"lat,omitempty"`
+	Long    float64 `json:"lon,omitempty"`
+	Role    string  `json:"role,omitempty"`
+	// Queue is how many messages the node holds for delivery.
+	Queue int `json:"q,omitempty"`
+}
+
+// Sanitize drops out-of-range values and truncates the role.
+func (m Metadata) Sanitize() Metadata {
+	if m.Battery != nil && (*m.Battery < 0 || *m.Battery > 100) {
+		m.Battery = nil
+	}
+	if m.Lat < -90 || m.Lat > 90 || m.Long < -180 || m.Long > 180 {
+		m.Lat, m.Long = 0, 0
+	}
+	if r := []rune(m.Role); len(r) > MaxRoleLen {
+		m.Role = string(r[:MaxRoleLen])
+	}
+	if m.Queue < 0 {
+		m.Queue = 0
+	}
+	return m
+}
+
+// HeartbeatPacket is signed with the sender's Ed25519 identity key. Counter
+// only ever increases (it starts from the microsecond clock at boot, or past
+// the last value reserved in the identity file if that is higher), so
+// receivers can reject replays.
 type HeartbeatPacket struct {
-	Type   string `json:"type"`
-	ID     string `json:"id"`
-	Nick   string `json:"nick"`
-	Port   int    `json:"port"`
-	TS     int64  `json:"ts"`
-	PubKey string `json:"pub_key"`
+	Type     string            `json:"type"`
+	ID       string            `json:"id"`
+	Nick     string            `json:"nick"`
+	Port     int               `json:"port"`
+	TS       int64             `json:"ts"`
+	PubKey   string            `json:"pub_key"`
+	SignKey  string            `json:"sign_key"`
+	Counter  uint64            `json:"ctr"`
+	Rotation *core.KeyRotation `json:"rot,omitempty"`
+	// Networks are the meshes the sender has joined; none means the
+	// default mesh.
+	Networks []string `json:"nets,omitempty"`
+	Metadata
+	Sig string `json:"sig"`
+}
+
+// Sign fills in Sig over every other field.
+func (p *HeartbeatPacket) Sign(signPriv string) error {
+	sig, err := core.Sign(signPriv, p.signingBytes())
+	if err != nil {
+		return err
+	}
+	p.Sig = sig
+	return nil
+}
+
+// Verify checks the signature against the packet's own SignKey, and that
+// any rotation proof vouches for exactly the keys being announced. Whether
+// SignKey is the key we expect for this node is the caller's decision.
+func (p *HeartbeatPacket) Verify() bool {
+	if !core.Verify(p.SignKey, p.signingBytes(), p.Sig) {
+		return false
+	}
+	if r := p.Rotation; r != nil {
+		return r.NewSignPub == p.SignKey && r.NewPubKey == p.PubKey && r.Verify(p.ID)
+	}
+	return true
 }
+
+func (p *HeartbeatPacket) signingBytes() []byte {
+	unsigned := *p
+	unsigned.Sig = ""
+	data, _ := json.Marshal(unsigned)
+	return data
+}
+
 type PeerInfo struct {
 	ID     string
 	Nick   string
 	Addr   string
 	PubKey string
+	Source string
+	// Iface is the local interface the peer was heard on.
+	Iface string
+	// SignKey has been checked against the announcement's signature.
+	SignKey string
+	// Counter is the heartbeat counter, or 0 for sources without one.
+	Counter  uint64
+	Rotation *core.KeyRotation
+	Networks []string
+	// Meta is only reported by heartbeats.
+	Meta Metadata
 }
 
-func StartHeartbeat(ctx context.Context, servicePort int, nodeID, nick, pubKey string) error {
-	targets := []string{"255.255.255.255", "127.0.0.1"}
+// Where a PeerInfo was learned from.
+const (
+	SourceHeartbeat = "beat"
+	SourceMDNS      = "mdns"
+)
+
+// counterBlock is how many counter values are reserved in the identity file
+// at a time, so it is not rewritten on every beat.
+const counterBlock = 3600
+
+// StartHeartbeat beats once a second, announcing the given networks. meta,
+// if non-nil, is polled on each beat for the node's current status. If
+// identityFile is set, the counter is reserved there ahead of use.
+func StartHeartbeat(ctx context.Context, servicePort int, id *core.Identity, identityFile string, nick string, networks []string, meta func() Metadata) error {
+	targets := []string{"255.255.255.255", "127.0.0.1", "::1"}
+	for _, ifi := range utils.MulticastInterfaces() {
+		targets = append(targets, IPv6AllNodes+"%"+ifi.Name)
+	}
 	var conns []*net.UDPConn
 	for _, host := range targets {
 		for p := 9000; p <= 9005; p++ {
-			addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", host, p))
+			addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(p)))
 			if err != nil {
 				continue
 			}
@@ -45,12 +158,14 @@ func StartHeartbeat(ctx context.Context, servicePort int, nodeID, nick, pubKey s
 	if len(conns) == 0 {
 		return fmt.Errorf("failed to dial any UDP broadcast addresses")
 	}
-	slog.Info("Heartbeat started", "targets", len(conns), "nodeID", nodeID)
+	slog.Info("Heartbeat started", "targets", len(conns), "nodeID", id.NodeID)
 	defer func() {
 		for _, c := range conns {
 			c.Close()
 		}
 	}()
+	counter := max(id.HeartbeatCounter+1, uint64(time.Now().UnixMicro()))
+	var reserved uint64
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 	for {
@@ -58,18 +173,43 @@ func StartHeartbeat(ctx context.Context, servicePort int, nodeID, nick, pubKey s
 		case <-ctx.Done():
 			return nil
 		case t := <-ticker.C:
+			if identityFile != "" && counter > reserved {
+				reserved = counter + counterBlock
+				if err := core.SaveHeartbeatCounter(identityFile, reserved); err != nil {
+					slog.Warn("Failed to reserve heartbeat counter", "error", err)
+				}
+			}
 			packet := HeartbeatPacket{
-				Type:   "beat",
-				ID:     nodeID,
-				Nick:   nick,
-				Port:   servicePort,
-				TS:     t.Unix(),
-				PubKey: pubKey,
+				Type:     "beat",
+				ID:       id.NodeID,
+				Nick:     nick,
+				Port:     servicePort,
+				TS:       t.Unix(),
+				PubKey:   id.PubKey,
+				SignKey:  id.SignPub,
+				Counter:  counter,
+				Rotation: id.Rotation,
+				Networks: networks,
+			}
+			counter++
+			if meta != nil {
+				packet.Metadata = meta().Sanitize()
+			}
+			if err := packet.Sign(id.SignPriv); err != nil {
+				return fmt.Errorf("failed to sign heartbeat: %w", err)
 			}
 			data, err := json.Marshal(packet)
 			if err != nil {
 				continue
 			}
+			if len(data) > MaxHeartbeatSize {
+				// Status is optional; never let it cost us the beat.
+				packet.Metadata = Metadata{}
+				if err := packet.Sign(id.SignPriv); err != nil {
+					return fmt.Errorf("failed to sign heartbeat: %w", err)
+				}
+				data, _ = json.Marshal(packet)
+			}
 			for _, c := range conns {
 				_, _ = c.Write(data)
 			}
@@ -77,7 +217,9 @@ func StartHeartbeat(ctx context.Context, servicePort int, nodeID, nick, pubKey s
 	}
 }
 func StartListener(ctx context.Context, port int, nodeID string, peerChan chan<- PeerInfo) error {
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
+	// "udp" with an empty host binds dual-stack, so IPv4 broadcasts and
+	// IPv6 multicast beats arrive on the same socket.
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort("", strconv.Itoa(port)))
 	if err != nil {
 		return fmt.Errorf("failed to resolve listen address: %w", err)
 	}
@@ -100,6 +242,10 @@ func StartListener(ctx context.Context, port int, nodeID string, peerChan chan<-
 				return fmt.Errorf("read error: %w", err)
 			}
 		}
+		if n > MaxHeartbeatSize {
+			slog.Warn("Dropping oversized heartbeat", "from", remoteAddr, "size", n)
+			continue
+		}
 		var packet HeartbeatPacket
 		if err := json.Unmarshal(buf[:n], &packet); err != nil {
 			slog.Warn("Failed to unmarshal heartbeat", "error", err)
@@ -111,33 +257,64 @@ func StartListener(ctx context.Context, port int, nodeID string, peerChan chan<-
 		if packet.ID == nodeID {
 			continue
 		}
-		remoteIP := remoteAddr.IP.String()
-		peerAddr := fmt.Sprintf("%s:%d", remoteIP, packet.Port)
+		peerAddr := utils.HostPort(remoteAddr.IP, remoteAddr.Zone, packet.Port)
+		if !packet.Verify() {
+			// Not held against anyone: UDP source addresses are as easy to
+			// forge as the ID.
+			slog.Warn("Dropping heartbeat with bad signature", "id", packet.ID, "from", remoteAddr)
+			continue
+		}
 		slog.Info("Received heartbeat", "from", packet.Nick, "addr", peerAddr)
 		select {
 		case peerChan <- PeerInfo{
-			ID:     packet.ID,
-			Nick:   packet.Nick,
-			Addr:   peerAddr,
-			PubKey: packet.PubKey,
+			ID:       packet.ID,
+			Nick:     packet.Nick,
+			Addr:     peerAddr,
+			PubKey:   packet.PubKey,
+			Source:   SourceHeartbeat,
+			Iface:    utils.InterfaceFor(remoteAddr.IP, remoteAddr.Zone),
+			SignKey:  packet.SignKey,
+			Counter:  packet.Counter,
+			Rotation: packet.Rotation,
+			Networks: packet.Networks,
+			Meta:     packet.Metadata.Sanitize(),
 		}:
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
-func StartReaper(ctx context.Context, db *gorm.DB) {
-	ticker := time.NewTicker(2 * time.Second)
+
+// StartReaper sweeps the failure detector, persisting each peer's liveness
+// state and publishing transitions on events. Peers from a previous run are
+// seeded from the store so they age out even if they never return.
+func StartReaper(ctx context.Context, db *gorm.DB, fd *FailureDetector, events chan<- PeerEvent) {
+	var known []store.Peer
+	db.Where("state <> ?", StateForgotten).Find(&known)
+	for _, p := range known {
+		state := p.State
+		if state == "" {
+			state = StateAlive
+		}
+		fd.Track(p.ID, p.LastSeen, state)
+	}
+	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			threshold := time.Now().Add(-5 * time.Second)
-			db.Model(&store.Peer{}).
-				Where("is_active = ? AND last_seen < ?", true, threshold).
-				Update("is_active", false)
+		case now := <-ticker.C:
+			for _, ev := range fd.Sweep(now) {
+				active := ev.To == StateAlive || ev.To == StateSuspect
+				db.Model(&store.Peer{}).Where("id = ?", ev.PeerID).
+					Updates(map[string]interface{}{"state": ev.To, "is_active": active})
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
 		}
 	}
 }
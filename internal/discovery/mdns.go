@@ -0,0 +1,158 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/utils"
+	"github.com/hashicorp/mdns"
+)
+
+// MDNSService is the DNS-SD service type every node advertises, so
+// `avahi-browse -r _crisismesh._tcp` lists the mesh.
+const MDNSService = "_crisismesh._tcp"
+
+const mdnsBrowseInterval = 10 * time.Second
+
+// StartMDNS advertises this node over mDNS and periodically browses for other
+// nodes, feeding what it finds into peerChan alongside the UDP heartbeats.
+func StartMDNS(ctx context.Context, servicePort int, id *core.Identity, nick string, networks []string, peerChan chan<- PeerInfo) error {
+	nodeID := id.NodeID
+	// The library logs through the standard logger, which would scribble
+	// over the TUI; route it into debug.log instead.
+	logger := slog.NewLogLogger(slog.Default().Handler(), slog.LevelDebug)
+	short := nodeID
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	// TXT carries no counter, so it is signed as a static statement; it can
+	// introduce a node but never change the keys we have pinned for one.
+	nets := strings.Join(networks, ",")
+	sig, err := core.Sign(id.SignPriv, txtSigningBytes(nodeID, nick, id.PubKey, id.SignPub, nets, servicePort))
+	if err != nil {
+		return fmt.Errorf("failed to sign mDNS TXT record: %w", err)
+	}
+	txt := []string{"id=" + nodeID, "nick=" + nick, "pk=" + id.PubKey, "sk=" + id.SignPub, "net=" + nets, "sig=" + sig}
+	svc, err := mdns.NewMDNSService(nodeID, MDNSService, "", "crisis-"+short+".local.", servicePort, localIPs(), txt)
+	if err != nil {
+		return fmt.Errorf("failed to build mDNS service: %w", err)
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: svc, Logger: logger})
+	if err != nil {
+		return fmt.Errorf("failed to start mDNS responder: %w", err)
+	}
+	defer server.Shutdown()
+	slog.Info("mDNS advertising", "service", MDNSService, "nodeID", nodeID)
+
+	ticker := time.NewTicker(mdnsBrowseInterval)
+	defer ticker.Stop()
+	for {
+		browseMDNS(ctx, nodeID, peerChan, logger)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func browseMDNS(ctx context.Context, nodeID string, peerChan chan<- PeerInfo, logger *log.Logger) {
+	entries := make(chan *mdns.ServiceEntry, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range entries {
+			info, ok := parseServiceEntry(e, nodeID)
+			if !ok {
+				continue
+			}
+			select {
+			case peerChan <- info:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	params := mdns.DefaultParams(MDNSService)
+	params.Entries = entries
+	params.Timeout = 2 * time.Second
+	params.Logger = logger
+	if err := mdns.QueryContext(ctx, params); err != nil {
+		slog.Debug("mDNS browse failed", "error", err)
+	}
+	close(entries)
+	<-done
+}
+
+// parseServiceEntry turns a browsed DNS-SD instance into a PeerInfo, reading
+// identity from the TXT record. IPv4 is preferred when both are offered.
+func parseServiceEntry(e *mdns.ServiceEntry, nodeID string) (PeerInfo, bool) {
+	fields := make(map[string]string)
+	for _, kv := range e.InfoFields {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			fields[k] = v
+		}
+	}
+	id := fields["id"]
+	if id == "" || id == nodeID || e.Port == 0 {
+		return PeerInfo{}, false
+	}
+	if !core.Verify(fields["sk"], txtSigningBytes(id, fields["nick"], fields["pk"], fields["sk"], fields["net"], e.Port), fields["sig"]) {
+		slog.Warn("Dropping mDNS advertisement with bad signature", "id", id, "name", e.Name)
+		return PeerInfo{}, false
+	}
+	var networks []string
+	if fields["net"] != "" {
+		networks = strings.Split(fields["net"], ",")
+	}
+	var ip net.IP
+	var zone string
+	switch {
+	case e.AddrV4 != nil:
+		ip = e.AddrV4
+	case e.AddrV6IPAddr != nil:
+		ip, zone = e.AddrV6IPAddr.IP, e.AddrV6IPAddr.Zone
+	case e.AddrV6 != nil:
+		ip = e.AddrV6
+	default:
+		return PeerInfo{}, false
+	}
+	return PeerInfo{
+		ID:       id,
+		Nick:     fields["nick"],
+		Addr:     utils.HostPort(ip, zone, e.Port),
+		PubKey:   fields["pk"],
+		Source:   SourceMDNS,
+		Iface:    utils.InterfaceFor(ip, zone),
+		SignKey:  fields["sk"],
+		Networks: networks,
+	}, true
+}
+
+func txtSigningBytes(id, nick, pubKey, signKey, networks string, port int) []byte {
+	return []byte(fmt.Sprintf("crisismesh-mdns:%s:%s:%s:%s:%s:%d", id, nick, pubKey, signKey, networks, port))
+}
+
+func localIPs() []net.IP {
+	var ips []net.IP
+	for _, ifi := range utils.MulticastInterfaces() {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		ips = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	return ips
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// maxAlertLifetime bounds how long an alert may stay pinned; longer-lived
+// instructions should be reissued.
+const maxAlertLifetime = 7 * 24 * time.Hour
+
+// IssueAlert signs an official alert and sends it to the default network,
+// returning its ID. This node's role must allow alerts.
+func (g *GossipEngine) IssueAlert(a store.Alert) (string, error) {
+	if err := g.requirePermission(core.PermAlert); err != nil {
+		return "", err
+	}
+	a.Cancels = ""
+	if err := protocol.ValidateAlert(a); err != nil {
+		return "", err
+	}
+	now := g.Now()
+	expires := time.Unix(a.Expires, 0)
+	if !expires.After(now) || expires.Sub(now) > maxAlertLifetime {
+		return "", fmt.Errorf("expiry must be within %s from now", maxAlertLifetime)
+	}
+	return g.sendAlert(a, "OFFICIAL ALERT: "+a.Headline)
+}
+
+// CancelAlert withdraws an active alert before it expires.
+func (g *GossipEngine) CancelAlert(id string) error {
+	if err := g.requirePermission(core.PermAlert); err != nil {
+		return err
+	}
+	alerts, err := store.ActiveAlerts(g.db, g.Now())
+	if err != nil {
+		return err
+	}
+	for _, a := range alerts {
+		if a.ID == id {
+			_, err := g.sendAlert(store.Alert{Cancels: id, Expires: a.Expires}, "ALERT CANCELLED: "+a.Headline)
+			return err
+		}
+	}
+	return fmt.Errorf("no active alert %s", id)
+}
+
+// ActiveAlerts returns the alerts currently in force.
+func (g *GossipEngine) ActiveAlerts() ([]store.ActiveAlert, error) {
+	return store.ActiveAlerts(g.db, g.Now())
+}
+
+func (g *GossipEngine) sendAlert(a store.Alert, content string) (string, error) {
+	msg, err := g.sendRecord(store.KindAlert, a, content, 2)
+	if err != nil {
+		return "", err
+	}
+	slog.Info("Issued alert", "id", msg.ID, "content", content)
+	if g.UplinkChan != nil {
+		select {
+		case g.UplinkChan <- msg:
+		default:
+		}
+	}
+	return msg.ID, nil
+}
+
+// checkAlert parses a received alert and checks its sender may issue one.
+func (g *GossipEngine) checkAlert(msg store.Message) error {
+	var a store.Alert
+	if err := json.Unmarshal([]byte(msg.Payload), &a); err != nil {
+		return err
+	}
+	if err := protocol.ValidateAlert(a); err != nil {
+		return err
+	}
+	if !core.RoleAllows(msg.Role, core.PermAlert) {
+		return errors.New("sender's role may not issue alerts")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/cap"
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// capDefaultLifetime is how long an imported alert without an expiry stays
+// in force.
+const capDefaultLifetime = time.Hour
+
+// ImportCAP issues a CAP 1.2 document as an official alert, returning the
+// mesh alert ID. Cancel documents withdraw the alerts they reference and
+// return ""; updates replace them. This node's role must allow alerts.
+func (g *GossipEngine) ImportCAP(data []byte) (string, error) {
+	doc, err := cap.Decode(data)
+	if err != nil {
+		return "", err
+	}
+	if doc.MsgType != cap.MsgAlert && doc.MsgType != cap.MsgUpdate && doc.MsgType != cap.MsgCancel {
+		return "", fmt.Errorf("unsupported CAP message type %q", doc.MsgType)
+	}
+	if err := g.requirePermission(core.PermAlert); err != nil {
+		return "", err
+	}
+	// An update's replacement is built and checked before anything is
+	// cancelled, so one that cannot be issued leaves the old alerts in force.
+	var a store.Alert
+	if doc.MsgType != cap.MsgCancel {
+		if a, err = cap.ToMeshAlert(doc, g.Now(), capDefaultLifetime); err != nil {
+			return "", err
+		}
+		if limit := g.Now().Add(maxAlertLifetime).Unix(); a.Expires > limit {
+			a.Expires = limit
+		}
+		if err := protocol.ValidateAlert(a); err != nil {
+			return "", err
+		}
+	}
+	active, err := g.ActiveAlerts()
+	if err != nil {
+		return "", err
+	}
+	if doc.MsgType != cap.MsgAlert {
+		for _, ref := range cap.ReferencedIDs(doc) {
+			for _, old := range active {
+				if old.ID != ref && old.CapID != ref {
+					continue
+				}
+				if err := g.CancelAlert(old.ID); err != nil {
+					return "", err
+				}
+				slog.Info("CAP document cancelled alert", "cap", doc.Identifier, "alert", old.ID)
+			}
+		}
+		if doc.MsgType == cap.MsgCancel {
+			return "", nil
+		}
+	}
+	for _, old := range active {
+		if old.CapID == doc.Identifier {
+			return old.ID, nil
+		}
+	}
+	return g.IssueAlert(a)
+}
+
+// ExportCAP renders a stored alert or SOS message as CAP XML.
+func (g *GossipEngine) ExportCAP(id string) ([]byte, error) {
+	return cap.ExportMessage(g.db, id)
+}
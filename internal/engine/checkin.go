@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// checkInCheck is how often the dead-man's switch looks at the clock.
+const checkInCheck = 5 * time.Second
+
+// SetCheckIn turns on this node's dead-man's switch: unless the operator
+// checks in every interval, the node sends SOS from its last known
+// position. Zero turns it off. The switch is kept as a record, so the
+// countdown carries on across restarts and coordinators can watch it.
+func (g *GossipEngine) SetCheckIn(interval time.Duration) error {
+	c := store.CheckIn{Interval: int64(interval / time.Second)}
+	if c.Armed() {
+		c.Due = g.Now().Add(interval).Unix()
+	}
+	if err := protocol.ValidateCheckIn(c); err != nil {
+		return err
+	}
+	return g.sendCheckIn(c)
+}
+
+// CheckIn confirms the operator is fine and restarts the countdown. An SOS
+// the switch already sent is cancelled.
+func (g *GossipEngine) CheckIn() error {
+	c, _, ok := g.ownCheckIn()
+	if !ok || !c.Armed() {
+		return errors.New("check-ins are not turned on")
+	}
+	if c.Missed && c.SOS != "" {
+		if err := g.CancelSOS(c.SOS); err != nil {
+			slog.Warn("Failed to cancel missed check-in SOS", "sos", c.SOS, "error", err)
+		}
+	}
+	return g.sendCheckIn(store.CheckIn{Interval: c.Interval, Due: g.Now().Add(time.Duration(c.Interval) * time.Second).Unix()})
+}
+
+// OwnCheckIn returns this node's switch, read only from records it signed.
+func (g *GossipEngine) OwnCheckIn() store.CheckIn {
+	c, _, _ := g.ownCheckIn()
+	return c
+}
+
+func (g *GossipEngine) ownCheckIn() (store.CheckIn, store.Message, bool) {
+	return store.LatestCheckIn(g.db, g.nodeID, g.identity.SignPub)
+}
+
+// CheckIns returns the switches of every node that has one on, overdue
+// ones first.
+func (g *GossipEngine) CheckIns() ([]store.CheckInStatus, error) {
+	list, err := store.CheckIns(g.db, g.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if list[i].NodeID == g.nodeID {
+			g.posMu.Lock()
+			list[i].Lat, list[i].Long = g.lat, g.long
+			g.posMu.Unlock()
+		}
+	}
+	return list, nil
+}
+
+func (g *GossipEngine) sendCheckIn(c store.CheckIn) error {
+	due := time.Unix(c.Due, 0).Format("15:04")
+	content := "CHECK-IN OFF"
+	switch {
+	case c.Missed:
+		content = fmt.Sprintf("CHECK-IN MISSED (due %s): SOS sent", due)
+	case c.Armed():
+		content = fmt.Sprintf("CHECK-IN every %s, next due %s", time.Duration(c.Interval)*time.Second, due)
+	}
+	msg, err := g.sendRecord(store.KindCheckIn, c, content, 0)
+	if err != nil {
+		return err
+	}
+	slog.Info("Check-in", "interval", c.Interval, "due", due, "missed", c.Missed, "record", msg.ID)
+	return nil
+}
+
+func (g *GossipEngine) watchCheckIn(ctx context.Context) {
+	ticker := time.NewTicker(checkInCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.checkDeadline(g.Now())
+		}
+	}
+}
+
+// checkDeadline sends SOS once if the operator has not checked in by the
+// time the switch was due.
+func (g *GossipEngine) checkDeadline(now time.Time) {
+	c, _, ok := g.ownCheckIn()
+	if !ok || !c.Armed() || c.Missed || now.Before(time.Unix(c.Due, 0)) {
+		return
+	}
+	due := time.Unix(c.Due, 0)
+	slog.Warn("Missed check-in, sending SOS", "due", due)
+	id, err := g.PublishSOS(store.SOS{Type: store.EmergencyOther, Notes: "Missed check-in due " + due.Format("15:04")}, "", 0, 0)
+	if err != nil {
+		slog.Error("Failed to send missed check-in SOS", "error", err)
+		return
+	}
+	c.Missed, c.SOS = true, id
+	if err := g.sendCheckIn(c); err != nil {
+		slog.Error("Failed to record missed check-in", "error", err)
+	}
+}
+
+// checkCheckIn parses a received check-in record.
+func (g *GossipEngine) checkCheckIn(msg store.Message) error {
+	var c store.CheckIn
+	if err := json.Unmarshal([]byte(msg.Payload), &c); err != nil {
+		return err
+	}
+	return protocol.ValidateCheckIn(c)
+}
@@ -3,12 +3,19 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/bit2swaz/crisismesh/internal/cap"
+	"github.com/bit2swaz/crisismesh/internal/clock"
 	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/discovery"
+	"github.com/bit2swaz/crisismesh/internal/policy"
 	"github.com/bit2swaz/crisismesh/internal/protocol"
 	"github.com/bit2swaz/crisismesh/internal/store"
 	"github.com/bit2swaz/crisismesh/internal/transport"
@@ -28,7 +35,8 @@ func CreateTestNode(t *testing.T, nick string, port int) (*GossipEngine, string,
 
 	tm := transport.NewManager()
 	nodeID := fmt.Sprintf("node-%s", nick)
-	eng := NewGossipEngine(db, tm, nodeID, nick, port, id.PubKey, id.PrivKey)
+	id.NodeID = nodeID
+	eng := NewGossipEngine(db, tm, id, nick, port)
 	ctx, cancel := context.WithCancel(context.Background())
 	if err := tm.Listen(fmt.Sprintf("%d", port), eng.handleConnection); err != nil {
 		t.Fatalf("Failed to listen for %s: %v", nick, err)
@@ -102,3 +110,1269 @@ func TestGossipPropagation(t *testing.T) {
 		t.Errorf("Node C received wrong content: %s", retrievedMsg.Content)
 	}
 }
+
+func TestCheckPeerKeys(t *testing.T) {
+	g := &GossipEngine{}
+	peerID, _ := core.GenerateIdentity()
+	known := store.Peer{ID: peerID.NodeID, Addr: "10.0.0.1:9000", PubKey: peerID.PubKey, SignKey: peerID.SignPub, LastCounter: 100}
+	info := discovery.PeerInfo{ID: peerID.NodeID, Addr: "10.0.0.1:9000", PubKey: peerID.PubKey, SignKey: peerID.SignPub, Counter: 101}
+
+	check := func(name string, info discovery.PeerInfo, known store.Peer, found bool, want keyVerdict) {
+		t.Helper()
+		if got := g.checkPeerKeys(info, known, found); got != want {
+			t.Errorf("%s: got verdict %d, want %d", name, got, want)
+		}
+	}
+	check("first contact", info, store.Peer{}, false, keyPin)
+	check("newer counter", info, known, true, keyKnown)
+	info.Counter = 100
+	check("duplicate copy of latest beat", info, known, true, keyKnown)
+	moved := info
+	moved.Addr = "10.6.6.6:9000"
+	check("duplicate copy from another address", moved, known, true, keyReject)
+	moved.Counter = 0
+	check("counterless record from another address", moved, known, true, keyReject)
+	moved.Counter = 101
+	check("newer beat from another address", moved, known, true, keyKnown)
+	info.Counter = 50
+	check("replay", info, known, true, keyReject)
+
+	impostor, _ := core.GenerateIdentity()
+	check("changed key without proof", discovery.PeerInfo{ID: peerID.NodeID, PubKey: impostor.PubKey, SignKey: impostor.SignPub, Counter: 200}, known, true, keyChanged)
+	check("changed box key only", discovery.PeerInfo{ID: peerID.NodeID, PubKey: impostor.PubKey, SignKey: peerID.SignPub, Counter: 200}, known, true, keyChanged)
+
+	if err := peerID.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	info = discovery.PeerInfo{ID: peerID.NodeID, PubKey: peerID.PubKey, SignKey: peerID.SignPub, Counter: 1, Rotation: peerID.Rotation}
+	check("rotation with proof", info, known, true, keyRotate)
+	info.SignKey = impostor.SignPub
+	check("rotation proof for another key", info, known, true, keyChanged)
+}
+
+func TestKeyChangeReview(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Review", 9400)
+	defer cleanup()
+	alice, _ := core.GenerateIdentity()
+	mallory, _ := core.GenerateIdentity()
+
+	store.UpsertPeer(eng.db, store.Peer{ID: alice.NodeID, Nick: "alice", PubKey: alice.PubKey, SignKey: alice.SignPub, KeyStatus: store.KeyPinned})
+	forged := discovery.PeerInfo{ID: alice.NodeID, Nick: "alice", Addr: "127.0.0.1:1", PubKey: mallory.PubKey, SignKey: mallory.SignPub}
+	eng.handlePeerDiscovery(forged)
+
+	var peer store.Peer
+	eng.db.First(&peer, "id = ?", alice.NodeID)
+	if peer.KeyStatus != store.KeyChanged || peer.PubKey != alice.PubKey || peer.PendingPubKey != mallory.PubKey {
+		t.Fatalf("Expected pinned key kept and change pending, got %+v", peer)
+	}
+	if err := eng.PublishText("/dm alice hello", "", 0, 0); err == nil {
+		t.Error("Expected DM to be blocked while key change is pending")
+	}
+
+	if err := eng.RejectPeerKey(alice.NodeID); err != nil {
+		t.Fatal(err)
+	}
+	eng.handlePeerDiscovery(forged)
+	eng.db.First(&peer, "id = ?", alice.NodeID)
+	if peer.KeyStatus != store.KeyPinned {
+		t.Errorf("Expected rejected key not to be raised again, got %q", peer.KeyStatus)
+	}
+	if err := eng.PublishText("/dm alice hello", "", 0, 0); err != nil {
+		t.Errorf("Expected DM to be allowed after review: %v", err)
+	}
+
+	history, _ := store.GetKeyHistory(eng.db, alice.NodeID)
+	var events []string
+	for _, h := range history {
+		events = append(events, h.Event)
+	}
+	if fmt.Sprint(events) != "[changed rejected]" {
+		t.Errorf("Unexpected key history: %v", events)
+	}
+}
+
+func TestVerifyPeer(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Verify", 9401)
+	defer cleanup()
+	alice, _ := core.GenerateIdentity()
+	store.UpsertPeer(eng.db, store.Peer{ID: alice.NodeID, Nick: "alice", PubKey: alice.PubKey, SignKey: alice.SignPub, KeyStatus: store.KeyPinned})
+
+	if err := eng.VerifyPeer(alice.NodeID, "00000 00000 00000 00000 00000 00000"); err == nil {
+		t.Error("Expected wrong fingerprint to be refused")
+	}
+	if err := eng.VerifyPeer(alice.NodeID, core.FingerprintURI(alice.NodeID, alice.SignPub, alice.PubKey)); err != nil {
+		t.Fatalf("Expected scanned code to verify: %v", err)
+	}
+	var peer store.Peer
+	eng.db.First(&peer, "id = ?", alice.NodeID)
+	if !peer.Verified || peer.VerifiedAt.IsZero() {
+		t.Fatalf("Expected peer to be marked verified, got %+v", peer)
+	}
+
+	// Accepting an unproven key change must drop the verified mark.
+	mallory, _ := core.GenerateIdentity()
+	store.FlagPeerKeyChange(eng.db, alice.NodeID, mallory.PubKey, mallory.SignPub, "", time.Now().Add(-time.Hour))
+	if err := eng.AcceptPeerKey(alice.NodeID); err != nil {
+		t.Fatal(err)
+	}
+	eng.db.First(&peer, "id = ?", alice.NodeID)
+	if peer.Verified {
+		t.Error("Expected verified mark to be cleared after key change")
+	}
+}
+
+func TestNetworkIsolation(t *testing.T) {
+	engA, _, cleanupA := CreateTestNode(t, "NetA", 9402)
+	defer cleanupA()
+	engB, _, cleanupB := CreateTestNode(t, "NetB", 9403)
+	defer cleanupB()
+	engA.Networks = []string{"ops", "shared"}
+	engB.Networks = []string{"shared"}
+
+	store.SaveMessage(engA.db, &store.Message{ID: "msg-ops", SenderID: engA.nodeID, Content: "ops only", Timestamp: time.Now().Unix(), Network: "ops"})
+	store.SaveMessage(engA.db, &store.Message{ID: "msg-shared", SenderID: engA.nodeID, Content: "for all", Timestamp: time.Now().Unix(), Network: "shared"})
+
+	conn, err := engA.transport.Dial("127.0.0.1:9403")
+	if err != nil {
+		t.Fatalf("Failed to dial A->B: %v", err)
+	}
+	go engA.handleConnection(conn)
+	time.Sleep(time.Second)
+
+	var msg store.Message
+	if err := engB.db.First(&msg, "id = ?", "msg-shared").Error; err != nil {
+		t.Errorf("Expected message on the shared network to sync: %v", err)
+	}
+	if err := engB.db.First(&msg, "id = ?", "msg-ops").Error; err == nil {
+		t.Error("Message on a network B has not joined leaked to B")
+	}
+	if s := engA.session(conn.RemoteAddr().String()); s == nil || fmt.Sprint(s.networks) != "[shared]" {
+		t.Errorf("Expected session scoped to the shared network, got %+v", s)
+	}
+
+	// A node on no common network is disconnected after the handshake.
+	engC, _, cleanupC := CreateTestNode(t, "NetC", 9404)
+	defer cleanupC()
+	engC.Networks = []string{"elsewhere"}
+	conn, err = engA.transport.Dial("127.0.0.1:9404")
+	if err != nil {
+		t.Fatalf("Failed to dial A->C: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		engA.handleConnection(conn)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected connection to a foreign network to be closed")
+	}
+}
+
+func TestClockSkewFlagged(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Skew", 9405)
+	defer cleanup()
+	relay := receiver(eng, &session{networks: []string{store.DefaultNetwork}})
+	receive := func(msg store.Message) store.Message {
+		t.Helper()
+		relay(msg)
+		var got store.Message
+		if err := eng.db.First(&got, "id = ?", msg.ID).Error; err != nil {
+			t.Fatalf("Message %s not saved: %v", msg.ID, err)
+		}
+		return got
+	}
+
+	now := time.Now()
+	ok := receive(store.Message{ID: "on-time", Timestamp: now.Unix(), HLC: clock.Pack(now, 0)})
+	if ok.ClockSkew {
+		t.Error("Message with a sane clock was flagged")
+	}
+
+	future := now.Add(time.Hour)
+	fast := receive(store.Message{ID: "fast", Timestamp: future.Unix(), HLC: clock.Pack(future, 0)})
+	if !fast.ClockSkew {
+		t.Error("Expected message from a clock an hour fast to be flagged")
+	}
+	if fast.HLC >= clock.Pack(future, 0) {
+		t.Error("Expected future message to be re-stamped with our clock")
+	}
+
+	past := now.Add(-time.Hour)
+	slow := receive(store.Message{ID: "slow", Timestamp: past.Unix(), HLC: clock.Pack(now, 1)})
+	if !slow.ClockSkew {
+		t.Error("Expected message from a clock an hour slow to be flagged")
+	}
+
+	msgs, _ := store.GetMessages(eng.db, 10)
+	if len(msgs) != 3 || msgs[0].ID != "fast" || msgs[2].ID != "on-time" {
+		t.Errorf("Expected messages in HLC order, got %v", msgs)
+	}
+
+	// A restart carries on after the latest stored HLC even if the wall
+	// clock is now behind it.
+	ahead := clock.Pack(now.Add(time.Minute), 0)
+	store.SaveMessage(eng.db, &store.Message{ID: "ahead", SenderID: eng.nodeID, Timestamp: now.Unix(), HLC: ahead})
+	restarted := NewGossipEngine(eng.db, transport.NewManager(), eng.identity, "Skew", 9405)
+	if hlc := restarted.clock.Now(); hlc <= ahead {
+		t.Errorf("Expected the clock to resume after stored HLC %d, got %d", ahead, hlc)
+	}
+}
+
+func TestMeshTimeSync(t *testing.T) {
+	engA, _, cleanupA := CreateTestNode(t, "TimeA", 9406)
+	defer cleanupA()
+	engB, _, cleanupB := CreateTestNode(t, "TimeB", 9407)
+	defer cleanupB()
+	engB.meshTime.SetSource(true)
+	engA.TimeSources = []string{engB.nodeID}
+
+	conn, err := engA.transport.Dial("127.0.0.1:9407")
+	if err != nil {
+		t.Fatalf("Failed to dial A->B: %v", err)
+	}
+	go engA.handleConnection(conn)
+	time.Sleep(500 * time.Millisecond)
+
+	if engA.TimeStratum() != 1 {
+		t.Errorf("Expected A to sync to B at stratum 1, got %d", engA.TimeStratum())
+	}
+	if off := engA.ClockOffset(); off < -50*time.Millisecond || off > 50*time.Millisecond {
+		t.Errorf("Expected near-zero offset between clocks on one host, got %v", off)
+	}
+	if engB.TimeStratum() != clock.StratumSource || engB.ClockOffset() != 0 {
+		t.Error("Time source must not follow its peers")
+	}
+
+	// No stratum from a peer not configured as a source is believed, so a
+	// lone peer claiming one cannot drag our clock to its own.
+	engC, _, cleanupC := CreateTestNode(t, "TimeC", 9425)
+	defer cleanupC()
+	for _, stratum := range []int{clock.StratumSource, 1} {
+		now := time.Now()
+		skewed := now.Add(time.Hour).UnixNano()
+		pong, _ := json.Marshal(protocol.PongPayload{Sent: now.UnixNano(), Received: skewed, Replied: skewed, Stratum: stratum})
+		engC.handlePong(&session{peerID: "node-claims-gps", link: "10.0.0.2", verified: true}, pong)
+		if engC.TimeStratum() != clock.StratumUnsynced {
+			t.Errorf("Expected unconfigured stratum %d claim to be ignored, got stratum %d", stratum, engC.TimeStratum())
+		}
+		if off := engC.ClockOffset(); off > time.Second {
+			t.Errorf("Expected one unsynced peer not to move the clock, got offset %v", off)
+		}
+	}
+}
+
+func TestFloodLimits(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Flood", 9408)
+	defer cleanup()
+	receive := receiver(eng, &session{link: "10.0.0.1", networks: []string{store.DefaultNetwork}})
+	signers := make(map[string]*core.Identity)
+	send := func(id, origin string, priority int) {
+		msg := store.Message{ID: id, SenderID: origin, Priority: priority, Network: store.DefaultNetwork, Timestamp: time.Now().Unix()}
+		if signers[origin] == nil {
+			signers[origin], _ = core.GenerateIdentity()
+		}
+		msg.SignerKey = signers[origin].SignPub
+		msg.Signature, _ = core.Sign(signers[origin].SignPriv, msg.SigningBytes())
+		receive(msg)
+	}
+
+	for i := 0; i < 100; i++ {
+		send(fmt.Sprintf("flood-%d", i), "node-flooder", 0)
+	}
+	var stored int64
+	eng.db.Model(&store.Message{}).Where("sender_id = ?", "node-flooder").Count(&stored)
+	if stored < int64(originLimit.Burst) || stored > int64(originLimit.Burst)+2 {
+		t.Errorf("Expected about %d messages from the flooder, got %d", originLimit.Burst, stored)
+	}
+	send("other-1", "node-quiet", 0)
+	if !store.HasMessage(eng.db, "other-1") {
+		t.Error("Flooding by one origin throttled another")
+	}
+
+	for i := 0; i < 5; i++ {
+		send(fmt.Sprintf("sos-%d", i), "node-panic", 2)
+	}
+	var alarms int64
+	eng.db.Model(&store.Message{}).Where("sender_id = ? AND priority = 2", "node-panic").Count(&alarms)
+	if alarms != int64(sosLimit.Burst) {
+		t.Errorf("Expected SOS quota of %d, got %d alarms", sosLimit.Burst, alarms)
+	}
+	for i := 0; i < 5; i++ {
+		receive(store.Message{ID: fmt.Sprintf("fake-sos-%d", i), SenderID: "node-calm", Priority: 2, Timestamp: time.Now().Unix()})
+	}
+	send("sos-calm", "node-calm", 2)
+	var calm store.Message
+	if err := eng.db.First(&calm, "id = ?", "sos-calm").Error; err != nil || calm.Priority != 2 {
+		t.Errorf("Forged SOS spent the quota of the node they named: %v %d", err, calm.Priority)
+	}
+	if eng.db.First(&calm, "id = ?", "fake-sos-4").Error == nil {
+		t.Error("Expected unsigned SOS over the link's quota to be dropped, not demoted")
+	}
+	if stats := eng.RateLimits()["origin"]; len(stats) == 0 || stats[0].Key != "node-flooder" || stats[0].Dropped == 0 {
+		t.Errorf("Expected flooder at the top of the counters, got %+v", stats)
+	}
+	if rep := store.GetReputation(eng.db, "node-flooder"); rep.Score >= store.NeutralScore {
+		t.Errorf("Expected signed flooder to lose reputation, got %v", rep.Score)
+	}
+
+	// Unsigned messages count against the link that delivered them, so a
+	// forged flood under another node's ID neither throttles nor blames it.
+	for i := 0; i < 100; i++ {
+		receive(store.Message{ID: fmt.Sprintf("forged-%d", i), SenderID: "node-quiet", Timestamp: time.Now().Unix()})
+	}
+	send("other-2", "node-quiet", 0)
+	if !store.HasMessage(eng.db, "other-2") {
+		t.Error("Forged unsigned flood throttled the node it named")
+	}
+	if rep := store.GetReputation(eng.db, "node-quiet"); rep.Score != store.NeutralScore {
+		t.Errorf("Forged flood was charged to the node it named: %v", rep.Score)
+	}
+}
+
+func TestInvalidPacketsDisconnect(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Strict", 9409)
+	defer cleanup()
+	conn, err := net.Dial("tcp", "127.0.0.1:9409")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Out-of-bounds messages may have been relayed, so they are dropped
+	// without counting against the link.
+	forged, _ := json.Marshal(protocol.MsgPayload{Message: store.Message{ID: "forged", SenderID: "node-x", Priority: 9, Timestamp: time.Now().Unix()}})
+	content, _ := json.Marshal(protocol.Packet{Type: protocol.TypeMsg, Payload: forged})
+	for i := 0; i < maxViolations; i++ {
+		transport.WriteFrame(conn, content)
+	}
+	bad := []byte(`{"type":"MSG","payload":"e30=","extra":1}`)
+	for i := 0; i < maxViolations; i++ {
+		transport.WriteFrame(conn, bad)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, err := transport.ReadFrame(conn); err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				t.Fatal("Expected connection to be closed after repeated violations")
+			}
+			break
+		}
+	}
+	stats := eng.Violations()
+	if len(stats) != 1 || stats[0].Total != maxViolations || stats[0].Link != "127.0.0.1" {
+		t.Errorf("Unexpected violation counters %+v", stats)
+	}
+	if !eng.limits.link.Quarantined("127.0.0.1") {
+		t.Error("Expected offending link to be quarantined")
+	}
+	if store.HasMessage(eng.db, "forged") {
+		t.Error("Invalid message was stored")
+	}
+}
+
+func TestReputationQuarantine(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Rep", 9410)
+	defer cleanup()
+	for i := 0; i < 9; i++ {
+		eng.adjustReputation("node-bad", repInvalid, store.RepInvalid)
+	}
+	if !eng.peerQuarantined("node-bad") {
+		t.Fatal("Expected peer with repeated invalid packets to be quarantined")
+	}
+	hello, _ := json.Marshal(protocol.HelloPayload{NodeID: "node-bad"})
+	data, _ := json.Marshal(protocol.Packet{Type: protocol.TypeHello, Payload: hello})
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	if _, err := eng.handleHello(conn, data); err == nil {
+		t.Error("Expected HELLO from quarantined peer to be refused")
+	}
+
+	peers := []store.Peer{{ID: "node-bad"}, {ID: "node-good"}}
+	for i := 0; i < 20; i++ {
+		if p, ok := eng.pickSyncPeer(peers); !ok || p.ID != "node-good" {
+			t.Fatalf("Quarantined peer was given a sync slot: %v %v", p.ID, ok)
+		}
+	}
+
+	release := 80.0
+	if err := eng.SetReputationOverride("node-bad", &release); err != nil {
+		t.Fatal(err)
+	}
+	if eng.peerQuarantined("node-bad") {
+		t.Error("Expected operator override to lift the quarantine")
+	}
+}
+
+// relaySession is a link to peer "node-relay" on the default network, over
+// which tests hand the engine messages from other nodes.
+func relaySession() *session {
+	return &session{peerID: "node-relay", networks: []string{store.DefaultNetwork}}
+}
+
+// receiver returns a func that hands eng each message as received over sess.
+func receiver(eng *GossipEngine, sess *session) func(store.Message) {
+	return func(msg store.Message) {
+		payload, _ := json.Marshal(protocol.MsgPayload{Message: msg})
+		eng.handleMsg(sess, payload)
+	}
+}
+
+// signedRecord is a record of kind from a new node "node-<id>", signed and,
+// unless role is empty, certified by command.
+func signedRecord(command *core.Identity, id, role, kind string, body any) store.Message {
+	signer, _ := core.GenerateIdentity()
+	payload, _ := json.Marshal(body)
+	now := time.Now()
+	msg := store.Message{ID: id, SenderID: "node-" + id, Timestamp: now.Unix(), Network: store.DefaultNetwork,
+		Kind: kind, Payload: string(payload), SignerKey: signer.SignPub}
+	if role != "" {
+		msg.Cert, _ = core.IssueRoleCert(command.SignPriv, msg.SenderID, signer.SignPub, role, now.Add(-time.Hour), now.Add(time.Hour))
+	}
+	msg.Signature, _ = core.Sign(signer.SignPriv, msg.SigningBytes())
+	return msg
+}
+
+func TestModerationRecords(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Mod", 9411)
+	defer cleanup()
+	command, _ := core.GenerateIdentity()
+	eng.CommandKey = command.SignPub
+	receive := receiver(eng, relaySession())
+	hidden := func(id string) bool {
+		var m store.Message
+		eng.db.First(&m, "id = ?", id)
+		return m.Hidden
+	}
+	now := time.Now()
+	receive(store.Message{ID: "spam-1", SenderID: "node-spammer", Content: "spam", Timestamp: now.Unix()})
+
+	record := func(id, role string, act store.ModerationAction) store.Message {
+		return signedRecord(command, id, role, store.KindModeration, act)
+	}
+	block := store.ModerationAction{Action: store.ModBlock, Target: "node-spammer"}
+
+	receive(record("uncertified", "", block))
+	receive(record("responder", core.RoleResponder, block))
+	if hidden("spam-1") {
+		t.Fatal("Record from a node whose role may not moderate was applied")
+	}
+	receive(record("commander", core.RoleCommander, block))
+	if !hidden("spam-1") {
+		t.Fatal("Expected block from a certified commander to apply")
+	}
+	var rec store.Message
+	eng.db.First(&rec, "id = ?", "commander")
+	if rec.Role != core.RoleCommander {
+		t.Errorf("Expected record to be marked with its sender's role, got %q", rec.Role)
+	}
+	receive(store.Message{ID: "spam-2", SenderID: "node-spammer", Content: "more", Timestamp: now.Unix()})
+	if !hidden("spam-2") {
+		t.Error("Expected later messages from a blocked sender to arrive hidden")
+	}
+	msgs, _ := store.GetChatMessages(eng.db, 10)
+	if len(msgs) != 0 {
+		t.Errorf("Expected hidden messages and records to be left out of chat, got %d", len(msgs))
+	}
+
+	// A commander's certificate is public; replayed on an unsigned record
+	// it must not carry the commander's authority.
+	receive(store.Message{ID: "innocent-1", SenderID: "node-innocent", Content: "hi", Timestamp: now.Unix()})
+	unsigned := record("replayed-cert", core.RoleCommander, store.ModerationAction{Action: store.ModBlock, Target: "node-innocent"})
+	unsigned.Signature = ""
+	receive(unsigned)
+	if store.HasMessage(eng.db, "replayed-cert") || hidden("innocent-1") {
+		t.Error("Unsigned record carrying a copied certificate was accepted")
+	}
+	if applied, _ := eng.trustModerator(unsigned, now); applied {
+		t.Error("Expected an unsigned record never to be trusted to moderate")
+	}
+
+	forged := record("forged", core.RoleCommander, store.ModerationAction{Action: store.ModUnblock, Target: "node-spammer"})
+	forged.Payload = `{"action":"unblock","target":"node-other"}`
+	receive(forged)
+	if store.HasMessage(eng.db, "forged") || !hidden("spam-1") {
+		t.Error("Record with a bad signature was accepted")
+	}
+
+	if err := eng.Moderate(store.ModUnblock, "node-spammer", "", true); err != nil {
+		t.Fatal(err)
+	}
+	if hidden("spam-1") {
+		t.Error("Expected a local unblock to override the mesh block")
+	}
+	if err := eng.Moderate(store.ModHide, "spam-1", "", false); err == nil {
+		t.Error("Expected a civilian node to be refused mesh moderation")
+	}
+}
+
+func TestRoleCertificates(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Role", 9412)
+	defer cleanup()
+	command, _ := core.GenerateIdentity()
+	eng.CommandKey = command.SignPub
+	now := time.Now()
+	eng.identity.RoleCert, _ = core.IssueRoleCert(command.SignPriv, eng.nodeID, eng.identity.SignPub, core.RoleResponder, now.Add(-time.Hour), now.Add(time.Hour))
+	if err := eng.initRole(); err != nil {
+		t.Fatal(err)
+	}
+	if eng.CertifiedRole() != core.RoleResponder {
+		t.Fatalf("Expected installed certificate to grant responder, got %s", eng.CertifiedRole())
+	}
+	if err := eng.requirePermission(core.PermModerate); err == nil {
+		t.Error("Expected responder to be refused moderation")
+	}
+	if err := eng.BroadcastSafe(); err != nil {
+		t.Fatal(err)
+	}
+	var sent store.Message
+	eng.db.First(&sent, "sender_id = ?", eng.nodeID)
+	if sent.Cert == nil || !core.Verify(sent.SignerKey, sent.SigningBytes(), sent.Signature) {
+		t.Fatal("Expected operator message to be signed with the certificate attached")
+	}
+	if role, err := eng.senderRole(sent, time.Now()); err != nil || role != core.RoleResponder {
+		t.Errorf("Expected stored certificate to check out as responder, got %q %v", role, err)
+	}
+	// Validity is judged when the message arrives, within clock skew,
+	// whatever timestamp the sender gave it.
+	if _, err := eng.senderRole(sent, now.Add(time.Hour+time.Minute)); err != nil {
+		t.Errorf("Expected certificate just past expiry to pass within skew: %v", err)
+	}
+	if _, err := eng.senderRole(sent, now.Add(2*time.Hour)); err == nil {
+		t.Error("Expected certificate expired on receipt to be rejected")
+	}
+
+	other, _ := core.GenerateIdentity()
+	eng.CommandKey = other.SignPub
+	if _, err := eng.senderRole(sent, time.Now()); err == nil {
+		t.Error("Expected certificate from another command key to be rejected")
+	}
+
+	eng.CommandKey = eng.identity.SignPub
+	eng.identity.RoleCert = nil
+	if err := eng.initRole(); err != nil || eng.CertifiedRole() != core.RoleCommander {
+		t.Errorf("Expected the command key holder to be a commander, got %s %v", eng.CertifiedRole(), err)
+	}
+}
+
+func TestOfficialAlerts(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Alert", 9413)
+	defer cleanup()
+	command, _ := core.GenerateIdentity()
+	eng.CommandKey = command.SignPub
+	receive := receiver(eng, relaySession())
+	active := func() []store.ActiveAlert {
+		alerts, err := eng.ActiveAlerts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return alerts
+	}
+	evacuate := store.Alert{Headline: "Evacuate sector B now", Severity: "extreme", Urgency: "immediate",
+		Area: "Sector B", Expires: time.Now().Add(time.Hour).Unix()}
+
+	if _, err := eng.IssueAlert(evacuate); err == nil {
+		t.Error("Expected a civilian node to be refused alerts")
+	}
+	receive(signedRecord(command, "from-responder", core.RoleResponder, store.KindAlert, evacuate))
+	if store.HasMessage(eng.db, "from-responder") {
+		t.Error("Alert from a role that may not issue alerts was stored")
+	}
+	expired := evacuate
+	expired.Expires = time.Now().Add(-time.Minute).Unix()
+	receive(signedRecord(command, "expired", core.RoleCommander, store.KindAlert, expired))
+	receive(signedRecord(command, "official", core.RoleCommander, store.KindAlert, evacuate))
+	alerts := active()
+	if len(alerts) != 1 || alerts[0].ID != "official" || alerts[0].Role != core.RoleCommander {
+		t.Fatalf("Expected only the unexpired commander alert to be active, got %+v", alerts)
+	}
+	receive(signedRecord(command, "cancel", core.RoleCommander, store.KindAlert, store.Alert{Cancels: "official", Expires: evacuate.Expires}))
+	if alerts := active(); len(alerts) != 0 {
+		t.Errorf("Expected cancelled alert to be withdrawn, got %+v", alerts)
+	}
+
+	// The node holding the command key issues and cancels its own.
+	eng.CommandKey = eng.identity.SignPub
+	if err := eng.initRole(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := eng.IssueAlert(evacuate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alerts := active(); len(alerts) != 1 || alerts[0].ID != id {
+		t.Fatalf("Expected own alert to be active, got %+v", alerts)
+	}
+	if err := eng.CancelAlert(id); err != nil {
+		t.Fatal(err)
+	}
+	if alerts := active(); len(alerts) != 0 {
+		t.Errorf("Expected own alert to be cancelled, got %+v", alerts)
+	}
+}
+
+const capFlood = `<?xml version="1.0" encoding="UTF-8"?>
+<alert xmlns="urn:oasis:names:tc:emergency:cap:1.2">
+  <identifier>NWS-FLOOD-0042</identifier>
+  <sender>w-nws.webmaster@noaa.gov</sender>
+  <sent>%s</sent>
+  <status>Actual</status>
+  <msgType>%s</msgType>
+  <scope>Public</scope>
+  <references>%s</references>
+  <info>
+    <category>Met</category>
+    <event>Flash Flood Warning</event>
+    <urgency>Immediate</urgency>
+    <severity>Severe</severity>
+    <certainty>Likely</certainty>
+    <expires>%s</expires>
+    <headline>Flash flood warning for the river valley</headline>
+    <instruction>Move to higher ground now.</instruction>
+    <area><areaDesc>River valley</areaDesc></area>
+    <area><areaDesc>Lower town</areaDesc></area>
+  </info>
+</alert>`
+
+func TestCAPImport(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "CAP", 9414)
+	defer cleanup()
+	eng.CommandKey = eng.identity.SignPub
+	if err := eng.initRole(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	doc := func(msgType, refs string) []byte {
+		return []byte(fmt.Sprintf(capFlood, now.Format(cap.TimeLayout), msgType, refs, now.Add(2*time.Hour).Format(cap.TimeLayout)))
+	}
+
+	id, err := eng.ImportCAP(doc(cap.MsgAlert, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts, _ := eng.ActiveAlerts()
+	if len(alerts) != 1 || alerts[0].ID != id {
+		t.Fatalf("Expected the imported alert to be active, got %+v", alerts)
+	}
+	a := alerts[0]
+	if a.Severity != "severe" || a.Urgency != "immediate" || a.Area != "River valley; Lower town" ||
+		a.Instructions != "Move to higher ground now." || a.CapID != "NWS-FLOOD-0042" {
+		t.Errorf("CAP fields not carried over: %+v", a.Alert)
+	}
+	if again, err := eng.ImportCAP(doc(cap.MsgAlert, "")); err != nil || again != id {
+		t.Errorf("Expected re-import to return %s, got %s, %v", id, again, err)
+	}
+
+	data, err := eng.ExportCAP(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cap.Decode(data)
+	if err != nil {
+		t.Fatalf("Exported CAP does not decode: %v\n%s", err, data)
+	}
+	if out.Identifier != "NWS-FLOOD-0042" || out.Info[0].Severity != "Severe" || out.Info[0].Area[0].AreaDesc != a.Area {
+		t.Errorf("Unexpected export: %+v", out)
+	}
+
+	ref := "w-nws.webmaster@noaa.gov,NWS-FLOOD-0042," + now.Format(cap.TimeLayout)
+	expired := fmt.Sprintf(capFlood, now.Format(cap.TimeLayout), cap.MsgUpdate, ref, now.Add(-time.Hour).Format(cap.TimeLayout))
+	if _, err := eng.ImportCAP([]byte(expired)); err == nil {
+		t.Error("Expected an update that has already expired to be refused")
+	}
+	if alerts, _ := eng.ActiveAlerts(); len(alerts) != 1 {
+		t.Errorf("Expected a refused update to leave the alert it references in force, got %+v", alerts)
+	}
+
+	if _, err := eng.ImportCAP(doc(cap.MsgCancel, ref)); err != nil {
+		t.Fatal(err)
+	}
+	if alerts, _ := eng.ActiveAlerts(); len(alerts) != 0 {
+		t.Errorf("Expected CAP cancel to withdraw the alert, got %+v", alerts)
+	}
+}
+
+func TestStructuredSOS(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "SOS", 9415)
+	defer cleanup()
+	eng.SetPosition(35.68, 139.69)
+
+	id, err := eng.PublishSOS(store.SOS{Type: store.EmergencyTrapped, People: 3, Injured: 1, Mobility: "immobile", Notes: "Second floor", Accuracy: 12}, "alice", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := store.GetMessage(eng.db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sos, ok := store.ParseSOS(msg)
+	if !ok || sos.People != 3 || sos.Mobility != "immobile" || msg.Priority != 2 || msg.Lat != 35.68 {
+		t.Errorf("Unexpected SOS message %+v", msg)
+	}
+	if msg.Content != "PRIORITY ALERT: SOS TRAPPED, 3 people, 1 injured, immobile: Second floor" {
+		t.Errorf("Unexpected summary %q", msg.Content)
+	}
+	if msg.Cert != nil || !core.Verify(msg.SignerKey, msg.SigningBytes(), msg.Signature) {
+		t.Error("Expected SOS to be signed by the node without its certificate")
+	}
+	moved := msg
+	moved.Lat, moved.Long = 50, 60
+	if core.Verify(moved.SignerKey, moved.SigningBytes(), moved.Signature) {
+		t.Error("Expected the signature to cover the SOS position")
+	}
+
+	if _, err := eng.PublishSOS(store.SOS{Type: "zombies"}, "", 0, 0); err == nil {
+		t.Error("Expected unknown emergency type to be refused")
+	}
+	if _, err := eng.PublishSOS(store.SOS{Type: store.EmergencyFire, People: 1, Injured: 2}, "", 0, 0); err == nil {
+		t.Error("Expected more injured than people to be refused")
+	}
+
+	if err := eng.PublishText("sos", "bob", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	var bare store.Message
+	eng.db.Where("author = ?", "bob").First(&bare)
+	if sos, ok := store.ParseSOS(bare); !ok || sos.Type != store.EmergencyOther || bare.Priority != 2 {
+		t.Errorf("Expected a bare SOS to be sent as type other, got %+v", bare)
+	}
+	if err := eng.PublishText("on my way", "carol", 10, 20); err != nil {
+		t.Fatal(err)
+	}
+	if eng.lat != 35.68 || eng.long != 139.69 {
+		t.Errorf("A web client's fix must not move the node, got %v,%v", eng.lat, eng.long)
+	}
+}
+
+func TestIncidentLifecycle(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Incident", 9416)
+	defer cleanup()
+	command, _ := core.GenerateIdentity()
+	eng.CommandKey = command.SignPub
+	receive := receiver(eng, relaySession())
+	incident := func() store.Incident {
+		incidents, err := eng.Incidents()
+		if err != nil || len(incidents) != 1 {
+			t.Fatalf("Expected one incident, got %+v, %v", incidents, err)
+		}
+		return incidents[0]
+	}
+	receive(store.Message{ID: "sos-1", SenderID: "node-victim", Author: "carol", Priority: 2,
+		Content: "PRIORITY ALERT: SOS TRAPPED", Payload: `{"type":"trapped","people":2}`, Timestamp: time.Now().Unix()})
+	if inc := incident(); inc.State != store.IncidentOpen || inc.SOS == nil || inc.SOS.People != 2 {
+		t.Fatalf("Expected an open incident for the SOS, got %+v", inc)
+	}
+	// Only the victim's own signature lets a record cancel their SOS.
+	cancel, _ := json.Marshal(store.IncidentUpdate{Incident: "sos-1", State: store.IncidentResolved})
+	receive(store.Message{ID: "claimed-cancel", SenderID: "node-victim", Timestamp: time.Now().Unix(), Network: store.DefaultNetwork,
+		Kind: store.KindIncident, Payload: string(cancel)})
+	if inc := incident(); inc.State != store.IncidentOpen || inc.Cancelled {
+		t.Fatalf("Unsigned record claiming the reporter's ID cancelled the SOS: %+v", inc)
+	}
+
+	if err := eng.UpdateIncident("sos-1", store.IncidentAcknowledged, "", ""); err == nil {
+		t.Error("Expected a civilian node to be refused")
+	}
+	receive(signedRecord(command, "by-civilian", core.RoleCivilian, store.KindIncident,
+		store.IncidentUpdate{Incident: "sos-1", State: store.IncidentResolved}))
+	if store.HasMessage(eng.db, "by-civilian") {
+		t.Error("Incident update from a civilian was stored")
+	}
+	receive(signedRecord(command, "en-route", core.RoleResponder, store.KindIncident,
+		store.IncidentUpdate{Incident: "sos-1", State: store.IncidentEnRoute, Team: "Team 3"}))
+	receive(signedRecord(command, "late-ack", core.RoleResponder, store.KindIncident,
+		store.IncidentUpdate{Incident: "sos-1", State: store.IncidentAcknowledged, Team: "Team 9"}))
+	if inc := incident(); inc.State != store.IncidentEnRoute || inc.StatusLine() != "Team 3 en route" {
+		t.Errorf("Expected the incident to stay en route with Team 3, got %+v", inc)
+	}
+
+	// The node holding the command key responds itself.
+	eng.CommandKey = eng.identity.SignPub
+	if err := eng.initRole(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.UpdateIncident("sos-1", "teleported", "", ""); err == nil {
+		t.Error("Expected an unknown state to be refused")
+	}
+	if err := eng.UpdateIncident("sos-1", store.IncidentResolved, "Team 3", "Both out safe"); err != nil {
+		t.Fatal(err)
+	}
+	if inc := incident(); inc.State != store.IncidentResolved || inc.Note != "Both out safe" {
+		t.Errorf("Expected the incident to be resolved, got %+v", inc)
+	}
+}
+
+func TestSOSRepeat(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Repeat", 9417)
+	defer cleanup()
+	id, err := eng.PublishSOS(store.SOS{Type: store.EmergencyTrapped, People: 1}, "", 51.5, -0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lat, long := eng.lat, eng.long; lat != 0 || long != 0 {
+		t.Errorf("A sender's fix must not move the node, got %v,%v", lat, long)
+	}
+	sent := time.Now()
+	repeats := func() int64 {
+		var n int64
+		eng.db.Model(&store.Message{}).Where("kind = ?", store.KindSOSRepeat).Count(&n)
+		return n
+	}
+	incident := func(id string) store.Incident {
+		incidents, _ := eng.Incidents()
+		for _, inc := range incidents {
+			if inc.ID == id {
+				return inc
+			}
+		}
+		t.Fatalf("No incident for %s in %+v", id, incidents)
+		return store.Incident{}
+	}
+
+	eng.repeatDue(sent.Add(10 * time.Second))
+	if n := repeats(); n != 0 {
+		t.Fatalf("Expected no repeat before the back-off ran out, got %d", n)
+	}
+	level := 40
+	if err := eng.UpdateSOSFix(id, 51.6, -0.2, 15, &level); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.UpdateSOSFix("sos-elsewhere", 51.6, -0.2, 15, nil); err == nil {
+		t.Error("Expected a fix for an unknown SOS to be refused")
+	}
+	eng.repeatDue(sent.Add(sosRepeatFirst + time.Second))
+	eng.repeatDue(sent.Add(sosRepeatFirst + 2*time.Second))
+	if n := repeats(); n != 1 {
+		t.Fatalf("Expected one repeat with the back-off doubled, got %d", n)
+	}
+	inc := incident(id)
+	if inc.Repeats != 1 || inc.Lat != 51.6 || inc.Battery == nil || *inc.Battery != 40 || inc.SOS.Accuracy != 15 {
+		t.Errorf("Expected the repeat folded into the incident with the new fix, got %+v", inc)
+	}
+
+	if err := eng.CancelSOS(id); err != nil {
+		t.Fatal(err)
+	}
+	if inc := incident(id); inc.State != store.IncidentResolved || inc.StatusLine() != "Cancelled by the sender" {
+		t.Errorf("Expected the SOS cancelled, got %+v", inc)
+	}
+	eng.repeatDue(sent.Add(time.Hour))
+	if n := repeats(); n != 1 {
+		t.Errorf("Expected repeats to stop once cancelled, got %d", n)
+	}
+
+	// Received repeats collapse into the incident; only its sender may
+	// repeat an SOS.
+	receive := receiver(eng, relaySession())
+	receive(store.Message{ID: "sos-far", SenderID: "node-victim", Priority: 2, Network: store.DefaultNetwork,
+		Content: "PRIORITY ALERT: SOS FLOOD", Payload: `{"type":"flood"}`, Timestamp: time.Now().Unix()})
+	receive(signedRecord(nil, "victim", "", store.KindSOSRepeat, store.SOSRepeat{SOS: "sos-far", Count: 2, Lat: 10, Long: 20}))
+	receive(signedRecord(nil, "imposter", "", store.KindSOSRepeat, store.SOSRepeat{SOS: "sos-far", Count: 9, Lat: 1, Long: 2}))
+	if store.HasMessage(eng.db, "imposter") {
+		t.Error("Repeat from someone other than the SOS sender was stored")
+	}
+	moved, _ := json.Marshal(store.SOSRepeat{SOS: "sos-far", Count: 7, Lat: 50, Long: 60})
+	receive(store.Message{ID: "unsigned-repeat", SenderID: "node-victim", Timestamp: time.Now().Unix(), Network: store.DefaultNetwork,
+		Kind: store.KindSOSRepeat, Payload: string(moved)})
+	if store.HasMessage(eng.db, "unsigned-repeat") {
+		t.Error("Unsigned repeat claiming the SOS sender's ID was stored")
+	}
+	if inc := incident("sos-far"); inc.Repeats != 2 || inc.Lat != 10 || inc.State != store.IncidentOpen {
+		t.Errorf("Expected the repeat folded into the open incident, got %+v", inc)
+	}
+}
+
+func TestCheckIn(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "CheckIn", 9418)
+	defer cleanup()
+	eng.SetPosition(48.2, 16.4)
+	sosCount := func() int {
+		n := 0
+		incidents, _ := eng.Incidents()
+		for _, inc := range incidents {
+			if inc.State != store.IncidentResolved {
+				n++
+			}
+		}
+		return n
+	}
+
+	if err := eng.CheckIn(); err == nil {
+		t.Error("Expected checking in with the switch off to fail")
+	}
+	if err := eng.SetCheckIn(30 * time.Second); err == nil {
+		t.Error("Expected an interval under the minimum to be refused")
+	}
+	if err := eng.SetCheckIn(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	eng.checkDeadline(start.Add(30 * time.Minute))
+	if n := sosCount(); n != 0 {
+		t.Fatalf("Expected no SOS before the deadline, got %d", n)
+	}
+	eng.checkDeadline(start.Add(61 * time.Minute))
+	eng.checkDeadline(start.Add(62 * time.Minute))
+	if n := sosCount(); n != 1 {
+		t.Fatalf("Expected one SOS for the missed check-in, got %d", n)
+	}
+	c, _, _ := eng.ownCheckIn()
+	sos, err := store.GetMessage(eng.db, c.SOS)
+	if !c.Missed || err != nil || sos.Lat != 48.2 {
+		t.Errorf("Expected the missed check-in recorded with an SOS from the last position, got %+v, %+v", c, sos)
+	}
+	board, _ := eng.CheckIns()
+	if len(board) != 1 || !board[0].Missed || board[0].NodeID != eng.nodeID {
+		t.Errorf("Expected this node on the board as missed, got %+v", board)
+	}
+
+	if err := eng.CheckIn(); err != nil {
+		t.Fatal(err)
+	}
+	if n := sosCount(); n != 0 {
+		t.Errorf("Expected checking in to cancel the SOS, got %d open", n)
+	}
+	if c, _, _ := eng.ownCheckIn(); c.Missed || c.Interval != 3600 {
+		t.Errorf("Expected the countdown restarted, got %+v", c)
+	}
+	// A record claiming this node's ID cannot turn the switch off.
+	receive := receiver(eng, relaySession())
+	forged := signedRecord(nil, "disarm", "", store.KindCheckIn, store.CheckIn{})
+	forged.SenderID = eng.nodeID
+	receive(forged)
+	if !eng.OwnCheckIn().Armed() {
+		t.Fatal("Expected a forged check-in to leave the switch on")
+	}
+	// Nor can stored records that were never verified, or were signed by a
+	// key other than the one bound to their sender, put a node on the board.
+	armed := store.CheckIn{Interval: 3600, Due: time.Now().Unix()}
+	unverified := signedRecord(nil, "unverified", "", store.KindCheckIn, armed)
+	rebound := signedRecord(nil, "rebound", "", store.KindCheckIn, armed)
+	rebound.Verified = true
+	other, _ := core.GenerateIdentity()
+	store.BindSigner(eng.db, rebound.SenderID, other.SignPub, time.Now())
+	store.SaveMessage(eng.db, &unverified)
+	store.SaveMessage(eng.db, &rebound)
+	if board, _ := eng.CheckIns(); len(board) != 1 {
+		t.Errorf("Expected only this node on the board, got %+v", board)
+	}
+	if err := eng.SetCheckIn(0); err != nil {
+		t.Fatal(err)
+	}
+	if board, _ := eng.CheckIns(); len(board) != 0 {
+		t.Errorf("Expected no switches on, got %+v", board)
+	}
+
+	receive(signedRecord(nil, "too-often", "", store.KindCheckIn, store.CheckIn{Interval: 5, Due: time.Now().Unix()}))
+	if store.HasMessage(eng.db, "too-often") {
+		t.Error("Check-in with an invalid interval was stored")
+	}
+}
+
+func TestRollCall(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Roll", 9419)
+	defer cleanup()
+	command, _ := core.GenerateIdentity()
+	eng.CommandKey = command.SignPub
+	receive := receiver(eng, relaySession())
+	entry := func(name string) store.RollCallEntry {
+		board, ok, err := eng.RollCall()
+		if err != nil || !ok {
+			t.Fatalf("Expected a roll call board, got %v", err)
+		}
+		for _, e := range board.Entries {
+			if e.NodeID == eng.nodeID && e.Name == name {
+				return e
+			}
+		}
+		t.Fatalf("Expected %q on the board, got %+v", name, board.Entries)
+		return store.RollCallEntry{}
+	}
+
+	if _, err := eng.StartRollCall(""); err == nil {
+		t.Error("Expected a civilian node to be refused roll calls")
+	}
+	receive(signedRecord(nil, "unsigned-call", "", store.KindRollCall, store.RollCall{}))
+	if store.HasMessage(eng.db, "unsigned-call") {
+		t.Error("Roll call without a role was stored")
+	}
+
+	token, err := eng.RegisterWebUser("Ana", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eng.RegisterWebUser("Ana", ""); err == nil {
+		t.Error("Expected another client to be refused a name already in use")
+	}
+	if again, err := eng.RegisterWebUser("Ana", token); err != nil || again != token || !eng.AuthWebUser("Ana", token) {
+		t.Errorf("Expected the registering client to keep its name, got %q, %v", again, err)
+	}
+	receive(signedRecord(command, "call", core.RoleResponder, store.KindRollCall, store.RollCall{Note: "Aftershock"}))
+	if !store.HasMessage(eng.db, "call") {
+		t.Fatal("Roll call from a responder was not stored")
+	}
+	if e := entry("Ana"); e.Status != store.RollCallPending {
+		t.Errorf("Expected the web user pending, got %+v", e)
+	}
+	if e := entry("Roll"); e.Status != store.RollCallPending || !e.Operator {
+		t.Errorf("Expected the operator pending, got %+v", e)
+	}
+
+	if err := eng.AnswerRollCall("call", "Ana", store.RollCallHelp); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.AnswerRollCall("call", "Ana", store.RollCallPending); err == nil {
+		t.Error("Expected answering pending to be refused")
+	}
+	if err := eng.AnswerRollCall("call", "Bob", store.RollCallSafe); err == nil {
+		t.Error("Expected answering for an unregistered name to be refused")
+	}
+	call, _ := store.GetMessage(eng.db, "call")
+	eng.answerRollCall(call)
+	if e := entry("Ana"); e.Status != store.RollCallHelp {
+		t.Errorf("Expected the help answer to stand, got %+v", e)
+	}
+	eng.BroadcastSafe()
+	if e := entry("Roll"); e.Status != store.RollCallSafe {
+		t.Errorf("Expected the safe broadcast to answer the roll call, got %+v", e)
+	}
+	board, _, _ := eng.RollCall()
+	if board.Help != 1 || board.Safe != 1 || board.Entries[0].Name != "Ana" {
+		t.Errorf("Expected those needing help first, got %+v", board)
+	}
+	if err := eng.AnswerRollCall("call", "Ana", store.RollCallSafe); err != nil {
+		t.Fatal(err)
+	}
+	if e := entry("Ana"); e.Status != store.RollCallSafe || !e.HelpEarlier {
+		t.Errorf("Expected a later safe to show the earlier call for help, got %+v", e)
+	}
+
+	receive(signedRecord(nil, "orphan", "", store.KindRollCallReply,
+		store.RollCallReply{RollCall: "unknown", Answers: []store.RollCallAnswer{{Status: store.RollCallSafe}}}))
+	if store.HasMessage(eng.db, "orphan") {
+		t.Error("Reply to an unknown roll call was stored")
+	}
+
+	// Replies count for the node that signed them, and only with the key
+	// bound to it.
+	answer := store.RollCallReply{RollCall: "call", Answers: []store.RollCallAnswer{{Status: store.RollCallHelp}}}
+	receive(signedRecord(nil, "peer-reply", "", store.KindRollCallReply, answer))
+	unverified := signedRecord(nil, "unverified-reply", "", store.KindRollCallReply, answer)
+	if err := eng.checkRollCallReply(unverified); err == nil {
+		t.Error("Expected an unverified reply to be refused")
+	}
+	rebound := signedRecord(nil, "rebound-reply", "", store.KindRollCallReply, answer)
+	rebound.Verified = true
+	other, _ := core.GenerateIdentity()
+	store.BindSigner(eng.db, rebound.SenderID, other.SignPub, time.Now())
+	store.SaveMessage(eng.db, &unverified)
+	store.SaveMessage(eng.db, &rebound)
+	board, _, _ = eng.RollCall()
+	var from []string
+	for _, e := range board.Entries {
+		if e.Status == store.RollCallHelp {
+			from = append(from, e.NodeID)
+		}
+	}
+	if len(from) != 1 || from[0] != "node-peer-reply" {
+		t.Errorf("Expected help only from the signed reply, got %v", from)
+	}
+}
+
+func TestPersonFinder(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Finder", 9420)
+	defer cleanup()
+	relay := receiver(eng, relaySession())
+	receive := func(msg store.Message, at time.Time) {
+		msg.HLC = clock.Pack(at, 0)
+		relay(msg)
+	}
+	find := func(query string) []store.Person {
+		people, err := eng.People(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return people
+	}
+
+	id, err := eng.ReportPerson(store.PersonReport{Name: "Maria Lopez", Status: store.PersonMissing, Location: "Elm St school"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eng.ReportPerson(store.PersonReport{Person: "nobody", Name: "X", Status: store.PersonSafe}); err == nil {
+		t.Error("Expected an update to an unknown person to be refused")
+	}
+	// Reports are ordered by the second they were signed in; make sure the
+	// update is signed after the first report.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	if _, err := eng.ReportPerson(store.PersonReport{Person: id, Name: "Maria Lopez", Status: store.PersonSafe, Reporter: "Ana"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A report made an hour ago elsewhere arrives late, its HLC rewritten
+	// by a relay: its status is older than ours, but nobody has set a
+	// description since.
+	signer, _ := core.GenerateIdentity()
+	payload, _ := json.Marshal(store.PersonReport{Person: id, Name: "Maria Lopez", Status: store.PersonMissing, Description: "Grey coat"})
+	late := store.Message{ID: "late", SenderID: "node-late", Timestamp: time.Now().Add(-time.Hour).Unix(), Network: store.DefaultNetwork,
+		Kind: store.KindPerson, Payload: string(payload), SignerKey: signer.SignPub}
+	late.Signature, _ = core.Sign(signer.SignPriv, late.SigningBytes())
+	receive(late, time.Now().Add(time.Minute))
+	people := find("grey")
+	if len(people) != 1 {
+		t.Fatalf("Expected the search to find her by description, got %+v", people)
+	}
+	p := people[0]
+	if p.ID != id || p.Status != store.PersonSafe || p.Reporter != "Ana" || p.Location != "Elm St school" ||
+		p.Description != "Grey coat" || len(p.Reports) != 3 {
+		t.Errorf("Expected the latest status kept with the late description merged in, got %+v", p)
+	}
+
+	receive(signedRecord(nil, "bad-photo", "", store.KindPerson, store.PersonReport{Name: "Y", Status: store.PersonSafe, Photo: "cat.jpg"}), time.Now())
+	if store.HasMessage(eng.db, "bad-photo") {
+		t.Error("Report with an invalid photo hash was stored")
+	}
+	if people := find("nobody here"); len(people) != 0 {
+		t.Errorf("Expected no match, got %+v", people)
+	}
+	data, err := eng.ExportPFIF("")
+	if err != nil || !strings.Contains(string(data), "<full_name>Maria Lopez</full_name>") {
+		t.Errorf("Expected her in the PFIF export, got %v\n%s", err, data)
+	}
+}
+
+func TestSignerBinding(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Binding", 9421)
+	defer cleanup()
+	receive := receiver(eng, relaySession())
+	signer, _ := core.GenerateIdentity()
+	record := func(id, sender string, key *core.Identity) store.Message {
+		payload, _ := json.Marshal(store.PersonReport{Name: "Sam", Status: store.PersonSafe})
+		msg := store.Message{ID: id, SenderID: sender, Timestamp: time.Now().Unix(), Network: store.DefaultNetwork,
+			Kind: store.KindPerson, Payload: string(payload), SignerKey: key.SignPub}
+		msg.Signature, _ = core.Sign(key.SignPriv, msg.SigningBytes())
+		receive(msg)
+		return msg
+	}
+
+	record("first", "node-origin", signer)
+	if !store.HasMessage(eng.db, "first") {
+		t.Fatal("First record from an unknown origin was not stored")
+	}
+	impostor, _ := core.GenerateIdentity()
+	record("forged", "node-origin", impostor)
+	if store.HasMessage(eng.db, "forged") {
+		t.Error("Record signed with a key other than the one pinned for its sender was stored")
+	}
+	record("second", "node-origin", signer)
+	if !store.HasMessage(eng.db, "second") {
+		t.Error("Record signed with the pinned key was not stored")
+	}
+	record("spoofed-self", eng.nodeID, impostor)
+	if store.HasMessage(eng.db, "spoofed-self") {
+		t.Error("Record claiming this node's ID was stored")
+	}
+}
+
+func TestHelloAuth(t *testing.T) {
+	engA, _, cleanupA := CreateTestNode(t, "AuthA", 9422)
+	defer cleanupA()
+	engB, _, cleanupB := CreateTestNode(t, "AuthB", 9423)
+	defer cleanupB()
+
+	conn, err := engA.transport.Dial("127.0.0.1:9423")
+	if err != nil {
+		t.Fatalf("Failed to dial A->B: %v", err)
+	}
+	go engA.handleConnection(conn)
+	time.Sleep(500 * time.Millisecond)
+	if s := engA.session(conn.RemoteAddr().String()); s == nil || !s.verified || s.identity() != engB.nodeID {
+		t.Errorf("Expected B to be verified by A, got %+v", s)
+	}
+
+	// An impostor claiming A's ID cannot answer B's nonce with A's key.
+	impostor, _ := core.GenerateIdentity()
+	raw, err := net.Dial("tcp", "127.0.0.1:9423")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	frame, err := transport.ReadFrame(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packet protocol.Packet
+	var hello protocol.HelloPayload
+	json.Unmarshal(frame, &packet)
+	json.Unmarshal(packet.Payload, &hello)
+	mine, _ := json.Marshal(protocol.HelloPayload{NodeID: engA.nodeID, Networks: engA.Networks, SignKey: impostor.SignPub, Nonce: newNonce()})
+	data, _ := json.Marshal(protocol.Packet{Type: protocol.TypeHello, Payload: mine})
+	transport.WriteFrame(raw, data)
+	sig, _ := core.Sign(impostor.SignPriv, protocol.AuthBytes(engA.nodeID, engB.nodeID, hello.Nonce))
+	auth, _ := json.Marshal(protocol.AuthPayload{Sig: sig})
+	data, _ = json.Marshal(protocol.Packet{Type: protocol.TypeAuth, Payload: auth})
+	transport.WriteFrame(raw, data)
+	raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, err := transport.ReadFrame(raw); err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				t.Fatal("Expected impostor to be disconnected")
+			}
+			break
+		}
+	}
+
+	// A legacy peer that starts with a SYNC gets an unverified session on
+	// the default network.
+	legacy, err := net.Dial("tcp", "127.0.0.1:9423")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+	sync, _ := json.Marshal(protocol.SyncPayload{MessageIDs: []string{"legacy-1"}})
+	data, _ = json.Marshal(protocol.Packet{Type: protocol.TypeSync, Payload: sync})
+	transport.WriteFrame(legacy, data)
+	time.Sleep(300 * time.Millisecond)
+	s := engB.session(legacy.LocalAddr().String())
+	if s == nil || s.verified || !s.shares(store.DefaultNetwork) {
+		t.Errorf("Expected unverified legacy session on the default network, got %+v", s)
+	}
+}
+
+func TestLinkPolicyByID(t *testing.T) {
+	eng, _, cleanup := CreateTestNode(t, "Policy", 9424)
+	defer cleanup()
+	eng.Policy.Add(policy.Rule{Action: policy.ActionDeny, Match: policy.MatchID, Value: "node-banned"}, -1)
+	hello, _ := json.Marshal(protocol.HelloPayload{NodeID: "node-banned"})
+	data, _ := json.Marshal(protocol.Packet{Type: protocol.TypeHello, Payload: hello})
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	if _, err := eng.handleHello(conn, data); err == nil {
+		t.Error("Expected HELLO from a denied ID to be refused")
+	}
+
+	raw, err := net.Dial("tcp", "127.0.0.1:9424")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	hello, _ = json.Marshal(protocol.HelloPayload{NodeID: "node-later"})
+	data, _ = json.Marshal(protocol.Packet{Type: protocol.TypeHello, Payload: hello})
+	transport.WriteFrame(raw, data)
+	time.Sleep(200 * time.Millisecond)
+	eng.Policy.Add(policy.Rule{Action: policy.ActionDeny, Match: policy.MatchID, Value: "node-later"}, -1)
+	eng.enforcePolicy()
+	raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, err := transport.ReadFrame(raw); err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				t.Fatal("Expected link denied by ID to be dropped")
+			}
+			break
+		}
+	}
+}
@@ -5,16 +5,22 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	mathrand "math/rand"
+	"maps"
 	"net"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/bit2swaz/crisismesh/internal/clock"
 	"github.com/bit2swaz/crisismesh/internal/core"
 	"github.com/bit2swaz/crisismesh/internal/discovery"
+	"github.com/bit2swaz/crisismesh/internal/policy"
 	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/ratelimit"
 	"github.com/bit2swaz/crisismesh/internal/store"
 	"github.com/bit2swaz/crisismesh/internal/transport"
 	"golang.org/x/crypto/nacl/box"
@@ -29,35 +35,124 @@ type GossipEngine struct {
 	port        int
 	pubKey      string
 	privKey     string
+	identity    *core.Identity
+	clock       *clock.Clock
+	meshTime    *clock.MeshTime
 	peerChan    chan discovery.PeerInfo
 	MsgUpdates  chan store.Message
 	PeerUpdates chan []store.Peer
-	UplinkChan  chan store.Message
+	// PeerEvents carries liveness transitions (alive, suspect, dead,
+	// forgotten) for the TUI.
+	PeerEvents chan discovery.PeerEvent
+	UplinkChan chan store.Message
+	// MDNS enables DNS-SD advertisement and browsing; set by the caller
+	// before Start.
+	MDNS bool
+	// Policy decides which peers we link with. Defaults to allow-all.
+	Policy *policy.Policy
+	// Networks are the meshes this node joins; peers and messages from
+	// others are ignored. Defaults to the default network.
+	Networks []string
+	// TimeSource marks this node's clock as trusted (e.g. GPS-disciplined)
+	// for the rest of the mesh to sync to.
+	TimeSource bool
+	// TimeSources are the node IDs whose claimed stratum is trusted.
+	TimeSources []string
+	// Role is the operator-declared role (e.g. "medic", "relay") reported
+	// in heartbeats.
+	Role string
+	// TrustModeration applies moderation records from verified moderator
+	// peers. When false, only local moderation actions are applied.
+	TrustModeration bool
+	// CommandKey is the incident-command signing key that role
+	// certificates must be signed by. Without one every node is a civilian.
+	CommandKey string
+	// RollCallAuto answers roll calls safe for the operator without
+	// waiting for them to.
+	RollCallAuto bool
+	// IdentityFile is where the identity was loaded from; the heartbeat
+	// counter is persisted there. Empty disables persistence.
+	IdentityFile string
+
+	detector   *discovery.FailureDetector
+	reaped     chan discovery.PeerEvent
+	eventsMu   sync.Mutex
+	peerEvents []discovery.PeerEvent
+	posMu      sync.Mutex
+	lat, long  float64
+	sessionsMu sync.Mutex
+	sessions   map[string]*session
+	limits     limiters
+	dups       *ratelimit.Limiter
+	violations violations
+	cert       *core.RoleCert
+	fixMu      sync.Mutex
+	sosFixes   map[string]sosFix
 }
 
-func NewGossipEngine(db *gorm.DB, tm *transport.Manager, nodeID, nick string, port int, pubKey, privKey string) *GossipEngine {
-	return &GossipEngine{
-		db:          db,
-		transport:   tm,
-		nodeID:      nodeID,
-		nick:        nick,
-		port:        port,
-		pubKey:      pubKey,
-		privKey:     privKey,
-		peerChan:    make(chan discovery.PeerInfo, 10),
-		MsgUpdates:  make(chan store.Message, 100),
-		PeerUpdates: make(chan []store.Peer, 10),
+// maxPeerEvents bounds the liveness history kept for the web UI.
+const maxPeerEvents = 100
+
+func NewGossipEngine(db *gorm.DB, tm *transport.Manager, id *core.Identity, nick string, port int) *GossipEngine {
+	g := &GossipEngine{
+		db:              db,
+		transport:       tm,
+		nodeID:          id.NodeID,
+		nick:            nick,
+		port:            port,
+		pubKey:          id.PubKey,
+		privKey:         id.PrivKey,
+		identity:        id,
+		meshTime:        clock.NewMeshTime(false),
+		peerChan:        make(chan discovery.PeerInfo, 10),
+		MsgUpdates:      make(chan store.Message, 100),
+		PeerUpdates:     make(chan []store.Peer, 10),
+		PeerEvents:      make(chan discovery.PeerEvent, 20),
+		Policy:          policy.New(),
+		Networks:        []string{store.DefaultNetwork},
+		TrustModeration: true,
+		sessions:        make(map[string]*session),
+		limits:          newLimiters(),
+		dups:            ratelimit.New(dupLimit),
+		violations:      violations{byLink: make(map[string]*violation)},
+		detector:        discovery.NewFailureDetector(),
+		reaped:          make(chan discovery.PeerEvent, 20),
+		sosFixes:        make(map[string]sosFix),
 		// UplinkChan is initialized by the caller if needed
 	}
+	g.clock = clock.New(g.Now)
+	// Carry on from the messages already stored, so a restart with the
+	// clock set back still stamps new messages after them.
+	if hlc, err := store.MaxHLC(db); err != nil {
+		slog.Error("Failed to read latest HLC", "error", err)
+	} else {
+		g.clock.Update(hlc)
+	}
+	return g
 }
 
 func (g *GossipEngine) GetNodeID() string {
 	return g.nodeID
 }
 
+func (g *GossipEngine) LinkPolicy() *policy.Policy {
+	return g.Policy
+}
+
 func (g *GossipEngine) Start(ctx context.Context) error {
+	nets, err := store.ParseNetworks(g.Networks)
+	if err != nil {
+		return err
+	}
+	g.Networks = nets
+	g.meshTime.SetSource(g.TimeSource)
+	if err := g.initRole(); err != nil {
+		return err
+	}
+	g.reapplyModeration()
+	g.Policy.OnChange(g.enforcePolicy)
 	go func() {
-		if err := discovery.StartHeartbeat(ctx, g.port, g.nodeID, g.nick, g.pubKey); err != nil {
+		if err := discovery.StartHeartbeat(ctx, g.port, g.identity, g.IdentityFile, g.nick, g.Networks, g.heartbeatMeta); err != nil {
 			slog.Error("Heartbeat failed", "error", err)
 		}
 	}()
@@ -66,12 +161,27 @@ func (g *GossipEngine) Start(ctx context.Context) error {
 			slog.Error("Listener failed", "error", err)
 		}
 	}()
+	if g.MDNS {
+		go func() {
+			if err := discovery.StartMDNS(ctx, g.port, g.identity, g.nick, g.Networks, g.peerChan); err != nil {
+				slog.Error("mDNS failed", "error", err)
+			}
+		}()
+	}
 	if err := g.transport.Listen(fmt.Sprintf("%d", g.port), g.handleConnection); err != nil {
 		return fmt.Errorf("failed to start TCP listener: %w", err)
 	}
-	go discovery.StartReaper(ctx, g.db)
+	if err := store.CloseOpenSightings(g.db, discovery.StateDead); err != nil {
+		slog.Error("Failed to close stale contact windows", "error", err)
+	}
+	go discovery.StartReaper(ctx, g.db, g.detector, g.reaped)
+	go g.processPeerEvents(ctx)
 	go g.startSyncer(ctx)
+	go g.startTimeSync(ctx)
+	go g.rewardUptime(ctx)
 	go g.processPeers(ctx)
+	go g.repeatSOS(ctx)
+	go g.watchCheckIn(ctx)
 	return nil
 }
 func (g *GossipEngine) startSyncer(ctx context.Context) {
@@ -86,31 +196,22 @@ func (g *GossipEngine) startSyncer(ctx context.Context) {
 			if err != nil || len(peers) == 0 {
 				continue
 			}
-			target := peers[mathrand.Intn(len(peers))]
-			msgs, err := store.GetMessages(g.db, 50)
-			if err != nil {
-				slog.Error("Failed to get messages for sync", "error", err)
+			target, ok := g.pickSyncPeer(peers)
+			if !ok {
 				continue
 			}
-			var ids []string
-			for _, m := range msgs {
-				ids = append(ids, m.ID)
-			}
-			if len(ids) == 0 {
+			sess := g.session(target.Addr)
+			if sess == nil {
 				continue
 			}
-			syncPayload := protocol.SyncPayload{MessageIDs: ids}
-			pBytes, err := json.Marshal(syncPayload)
-			if err != nil {
-				continue
-			}
-			packet := protocol.Packet{Type: protocol.TypeSync, Payload: pBytes}
-			data, err := json.Marshal(packet)
-			if err != nil {
-				continue
-			}
-			if err := g.transport.SendPacket(target.Addr, data); err != nil {
-				slog.Debug("Failed to gossip sync", "peer", target.Addr, "error", err)
+			for _, network := range sess.networks {
+				data := g.syncPacket(network)
+				if data == nil {
+					continue
+				}
+				if err := g.transport.SendPacket(target.Addr, data); err != nil {
+					slog.Debug("Failed to gossip sync", "peer", target.Addr, "error", err)
+				}
 			}
 		}
 	}
@@ -125,82 +226,304 @@ func (g *GossipEngine) processPeers(ctx context.Context) {
 		}
 	}
 }
+func (g *GossipEngine) processPeerEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-g.reaped:
+			g.logSighting(ev, "", "")
+			g.publishPeerEvent(ev)
+		}
+	}
+}
+
+// publishPeerEvent records a liveness transition and fans it out to the TUI
+// and the web UI's history.
+func (g *GossipEngine) publishPeerEvent(ev discovery.PeerEvent) {
+	slog.Info("Peer state changed", "peer", ev.PeerID, "from", ev.From, "to", ev.To, "phi", ev.Phi)
+	g.eventsMu.Lock()
+	g.peerEvents = append(g.peerEvents, ev)
+	if len(g.peerEvents) > maxPeerEvents {
+		g.peerEvents = g.peerEvents[len(g.peerEvents)-maxPeerEvents:]
+	}
+	g.eventsMu.Unlock()
+	select {
+	case g.PeerEvents <- ev:
+	default:
+	}
+	g.pushPeers()
+}
+
+// logSighting appends to the peer's contact log when a transition starts or
+// ends a contact window. Suspect is still "in contact", so only coming back
+// from dead (or first contact) and going dead are logged.
+func (g *GossipEngine) logSighting(ev discovery.PeerEvent, addr, iface string) {
+	sighting := store.PeerSighting{PeerID: ev.PeerID, Addr: addr, Iface: iface, At: ev.At}
+	switch {
+	case ev.To == discovery.StateAlive && (ev.From == "" || ev.From == discovery.StateDead || ev.From == discovery.StateForgotten):
+		sighting.Event = store.SightingAppear
+	case ev.To == discovery.StateDead:
+		sighting.Event = store.SightingDisappear
+		// The window ends at the last heartbeat, not when the detector
+		// finally gave up on the peer.
+		var peer store.Peer
+		if err := g.db.First(&peer, "id = ?", ev.PeerID).Error; err == nil {
+			sighting.Addr = peer.Addr
+			sighting.At = peer.LastSeen
+		}
+	default:
+		return
+	}
+	if err := store.RecordSighting(g.db, sighting); err != nil {
+		slog.Error("Failed to record peer sighting", "peer", ev.PeerID, "error", err)
+	}
+}
+
+// RecentPeerEvents returns the latest liveness transitions, oldest first.
+func (g *GossipEngine) RecentPeerEvents() []discovery.PeerEvent {
+	g.eventsMu.Lock()
+	defer g.eventsMu.Unlock()
+	return append([]discovery.PeerEvent{}, g.peerEvents...)
+}
+
+func (g *GossipEngine) pushPeers() {
+	var peers []store.Peer
+	g.db.Where("state <> ?", discovery.StateForgotten).Find(&peers)
+	select {
+	case g.PeerUpdates <- peers:
+	default:
+	}
+}
+
 func (g *GossipEngine) handlePeerDiscovery(info discovery.PeerInfo) {
-	if g.port == 9001 && strings.Contains(info.Addr, "9003") {
+	if !g.Policy.Allows(policy.Peer{ID: info.ID, Nick: info.Nick, Addr: info.Addr}) {
+		slog.Debug("Peer denied by link policy", "id", info.ID, "addr", info.Addr)
+		return
+	}
+	// A dual-stack peer is heard over both IPv4 and IPv6. Once one of those
+	// addresses carries a live link, keep it instead of flapping between them.
+	now := time.Now()
+	addr := info.Addr
+	var known store.Peer
+	found := g.db.First(&known, "id = ?", info.ID).Error == nil
+	if found && known.Addr != addr && g.transport.HasConnection(known.Addr) {
+		addr = known.Addr
+	}
+	if len(store.SharedNetworks(g.Networks, info.Networks)) == 0 {
+		slog.Debug("Ignoring peer from another network", "id", info.ID, "networks", info.Networks)
 		return
 	}
-	if g.port == 9003 && strings.Contains(info.Addr, "9001") {
+	verdict := g.checkPeerKeys(info, known, found)
+	switch verdict {
+	case keyReject:
 		return
+	case keyChanged:
+		g.flagKeyChange(info)
+		return
+	}
+	peer := known
+	peer.ID = info.ID
+	peer.Nick = info.Nick
+	peer.Addr = addr
+	if verdict != keyKnown {
+		peer.PubKey = info.PubKey
+		peer.SignKey = info.SignKey
+		peer.KeyStatus = store.KeyPinned
+		peer.LastCounter = 0
+	}
+	if info.Counter > peer.LastCounter {
+		peer.LastCounter = info.Counter
+	}
+	if info.Source == discovery.SourceHeartbeat {
+		peer.Battery = info.Meta.Battery
+		peer.Lat, peer.Long = info.Meta.Lat, info.Meta.Long
+		peer.Role = info.Meta.Role
+		peer.QueueDepth = info.Meta.Queue
 	}
-	peer := store.Peer{
-		ID:       info.ID,
-		Nick:     info.Nick,
-		Addr:     info.Addr,
-		PubKey:   info.PubKey,
-		LastSeen: time.Now(),
-		IsActive: true,
+	peer.Networks = store.DefaultNetwork
+	if len(info.Networks) > 0 {
+		peer.Networks = strings.Join(info.Networks, ",")
 	}
+	peer.LastSeen = now
+	peer.IsActive = true
+	peer.State = discovery.StateAlive
 	if err := store.UpsertPeer(g.db, peer); err != nil {
 		slog.Error("Failed to upsert peer", "error", err)
 	}
-	var peers []store.Peer
-	g.db.Find(&peers)
-	select {
-	case g.PeerUpdates <- peers:
-	default:
+	if verdict == keyPin || verdict == keyRotate {
+		event := store.KeyEventPinned
+		if verdict == keyRotate {
+			event = store.KeyEventRotated
+		}
+		if err := store.SetPeerKey(g.db, peer.ID, peer.PubKey, peer.SignKey, addr, event); err != nil {
+			slog.Error("Failed to pin peer key", "peer", peer.ID, "error", err)
+		}
+	}
+	if ev, changed := g.detector.Heartbeat(info.ID, now); changed {
+		g.logSighting(ev, addr, info.Iface)
+		g.publishPeerEvent(ev)
+	} else {
+		g.pushPeers()
 	}
-	if !g.transport.HasConnection(info.Addr) {
-		slog.Info("Dialing peer", "addr", info.Addr)
-		conn, err := g.transport.Dial(info.Addr)
+	if !g.transport.HasConnection(addr) && !g.limits.link.Quarantined(linkKey(addr)) && !g.peerQuarantined(peer.ID) {
+		slog.Info("Dialing peer", "addr", addr)
+		conn, err := g.transport.Dial(addr)
 		if err != nil {
-			slog.Error("Failed to dial peer", "addr", info.Addr, "error", err)
+			slog.Error("Failed to dial peer", "addr", addr, "error", err)
 			return
 		}
 		go g.handleConnection(conn)
 	}
 }
-func (g *GossipEngine) handleConnection(conn net.Conn) {
-	defer conn.Close()
-	msgs, err := store.GetMessages(g.db, 50)
-	if err == nil {
-		var ids []string
-		for _, m := range msgs {
-			ids = append(ids, m.ID)
+
+// enforcePolicy drops links the current policy denies, so a runtime rule
+// change takes effect without waiting for a reconnect. Links are judged by
+// their session, since a peer's connection need not come from the address
+// we know it by.
+func (g *GossipEngine) enforcePolicy() {
+	g.sessionsMu.Lock()
+	sessions := maps.Clone(g.sessions)
+	g.sessionsMu.Unlock()
+	for addr, s := range sessions {
+		if g.Policy.Allows(g.policyPeer(s.peerID, addr)) {
+			continue
 		}
-		if len(ids) > 0 {
-			slog.Info("Sending Initial SYNC", "count", len(ids), "remote", conn.RemoteAddr())
-			syncPayload := protocol.SyncPayload{MessageIDs: ids}
-			pBytes, _ := json.Marshal(syncPayload)
-			packet := protocol.Packet{Type: protocol.TypeSync, Payload: pBytes}
-			data, _ := json.Marshal(packet)
-			transport.WriteFrame(conn, data)
+		slog.Info("Dropping link denied by link policy", "id", s.peerID, "remote", addr)
+		g.transport.Disconnect(addr)
+		if s.peerID != "" {
+			g.db.Model(&store.Peer{}).Where("id = ?", s.peerID).Update("is_active", false)
 		}
 	}
+}
+
+// policyPeer is what the link policy judges a connection from addr by,
+// with the nick we know for peerID, if any.
+func (g *GossipEngine) policyPeer(peerID, addr string) policy.Peer {
+	peer := policy.Peer{ID: peerID, Addr: addr}
+	var known store.Peer
+	if peerID != "" && g.db.Limit(1).Find(&known, "id = ?", peerID).Error == nil {
+		peer.Nick = known.Nick
+	}
+	return peer
+}
+func (g *GossipEngine) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	if !g.Policy.Allows(policy.Peer{Addr: conn.RemoteAddr().String()}) {
+		slog.Info("Rejecting connection denied by link policy", "remote", conn.RemoteAddr())
+		return
+	}
+	addr := conn.RemoteAddr().String()
+	link := linkKey(addr)
+	if g.limits.link.Quarantined(link) {
+		slog.Info("Rejecting connection from quarantined link", "remote", addr)
+		return
+	}
+	nonce := newNonce()
+	if err := g.sendHello(conn, nonce); err != nil {
+		return
+	}
+	defer g.setSession(addr, nil)
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	var sess *session
 	for {
 		payload, err := transport.ReadFrame(conn)
+		var ne net.Error
+		if sess == nil && errors.As(err, &ne) && ne.Timeout() {
+			// Legacy peers without HELLO may have nothing to send first.
+			if sess, err = g.legacySession(link); err != nil {
+				slog.Info("Closing connection", "remote", addr, "reason", err)
+				return
+			}
+			g.startSession(conn, addr, sess)
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if !g.limits.link.Allow(link) {
+			if g.limits.link.Quarantined(link) {
+				slog.Warn("Dropping quarantined link", "remote", addr)
+				return
+			}
+			continue
+		}
+		packet, err := protocol.Validate(payload, g.Now())
+		if errors.Is(err, protocol.ErrContent) {
+			slog.Debug("Dropping invalid message", "remote", addr, "error", err)
+			continue
+		}
+		if err != nil {
+			if g.recordViolation(link, sess, err) {
+				slog.Warn("Disconnecting link after repeated violations", "remote", addr)
+				return
+			}
+			continue
+		}
+		if sess != nil && packet.Type == protocol.TypeAuth {
+			if sess, err = g.handleAuth(sess, nonce, packet.Payload); err != nil {
+				slog.Warn("Closing connection", "remote", addr, "reason", err)
+				return
+			}
+			g.setSession(addr, sess)
+			continue
+		}
+		if sess != nil {
+			g.handlePacket(conn, sess, payload)
+			continue
+		}
+		sess, err = g.handleHello(conn, payload)
 		if err != nil {
+			slog.Info("Closing connection", "remote", addr, "reason", err)
 			return
 		}
-		g.handlePacket(conn, payload)
+		g.startSession(conn, addr, sess)
+		if packet.Type != protocol.TypeHello {
+			g.handlePacket(conn, sess, payload)
+		}
 	}
 }
+
+// startSession registers a connection's session and opens the exchange
+// with a PING and our inventory.
+func (g *GossipEngine) startSession(conn net.Conn, addr string, sess *session) {
+	conn.SetReadDeadline(time.Time{})
+	g.setSession(addr, sess)
+	if err := transport.WriteFrame(conn, pingPacket()); err != nil {
+		slog.Debug("Failed to send PING", "remote", addr, "error", err)
+	}
+	for _, network := range sess.networks {
+		if data := g.syncPacket(network); data != nil {
+			slog.Info("Sending Initial SYNC", "network", network, "remote", addr)
+			if err := transport.WriteFrame(conn, data); err != nil {
+				slog.Debug("Failed to send SYNC", "remote", addr, "error", err)
+			}
+		}
+	}
+}
+
+// PublishText sends to this node's default (first) network.
 func (g *GossipEngine) PublishText(content string, author string, lat float64, long float64) error {
+	return g.PublishTextTo(g.Networks[0], content, author, lat, long)
+}
+
+func (g *GossipEngine) PublishTextTo(network, content, author string, lat, long float64) error {
+	if !slices.Contains(g.Networks, network) {
+		return fmt.Errorf("not a member of network %q", network)
+	}
 	recipientID := "BROADCAST"
 	isEncrypted := false
 	plainText := content
-	priority := 0
 
 	// Use provided author or fallback to local nick
 	if author == "" {
 		author = g.nick
 	}
-
-	// Auto-detect SOS for demo
-	// Check for exact "SOS" OR "PRIORITY ALERT: SOS" (which comes from the Web UI button)
+	// A bare "SOS" (or the old web button's text) is an SOS without details.
 	upperContent := strings.ToUpper(strings.TrimSpace(content))
 	if upperContent == "SOS" || upperContent == "PRIORITY ALERT: SOS" {
-		priority = 2
-		plainText = "PRIORITY ALERT: SOS"
+		_, err := g.PublishSOSTo(network, store.SOS{Type: store.EmergencyOther}, author, lat, long)
+		return err
 	}
 
 	cipherText := plainText
@@ -213,6 +536,9 @@ func (g *GossipEngine) PublishText(content string, author string, lat float64, l
 
 			var peer store.Peer
 			if err := g.db.Where("nick = ?", nick).First(&peer).Error; err == nil {
+				if peer.KeyStatus == store.KeyChanged {
+					return fmt.Errorf("key for %s has changed; accept or reject it before sending DMs", nick)
+				}
 				recipientID = peer.ID
 				plainText = text
 				cipherText = text
@@ -234,7 +560,11 @@ func (g *GossipEngine) PublishText(content string, author string, lat float64, l
 		}
 	}
 
-	ts := time.Now().Unix()
+	if len(cipherText) > protocol.MaxContentLen {
+		return fmt.Errorf("message too long: %d bytes on the wire, at most %d", len(cipherText), protocol.MaxContentLen)
+	}
+
+	ts := g.Now().Unix()
 	msgID := core.GenerateMessageID(g.nodeID, plainText, ts)
 
 	// 1. Save Plaintext Locally (so we can read our own sent messages)
@@ -244,15 +574,95 @@ func (g *GossipEngine) PublishText(content string, author string, lat float64, l
 		RecipientID: recipientID,
 		Content:     plainText,
 		Timestamp:   ts,
+		HLC:         g.clock.Now(),
 		TTL:         10,
 		HopCount:    0,
 		Status:      "sent",
 		IsEncrypted: false, // Stored as plaintext locally
-		Priority:    priority,
 		Author:      author,
 		Lat:         lat,
 		Long:        long,
+		Network:     network,
+	}
+	return g.send(msg, cipherText, isEncrypted)
+}
+
+// PublishSOS sends a distress message with structured details to the
+// default network, returning its ID. Without a position from the sender,
+// this node's own is used.
+func (g *GossipEngine) PublishSOS(sos store.SOS, author string, lat, long float64) (string, error) {
+	return g.PublishSOSTo(g.Networks[0], sos, author, lat, long)
+}
+
+func (g *GossipEngine) PublishSOSTo(network string, sos store.SOS, author string, lat, long float64) (string, error) {
+	if !slices.Contains(g.Networks, network) {
+		return "", fmt.Errorf("not a member of network %q", network)
+	}
+	if err := protocol.ValidateSOS(sos); err != nil {
+		return "", err
+	}
+	if author == "" {
+		author = g.nick
+	}
+	explicit := lat != 0 || long != 0
+	if !explicit {
+		g.posMu.Lock()
+		lat, long = g.lat, g.long
+		g.posMu.Unlock()
+	}
+	payload, _ := json.Marshal(sos)
+	content := sos.Summary()
+	hlc := g.clock.Now()
+	msg := store.Message{
+		// The HLC keeps two identical SOS sent in one second apart.
+		ID:          core.GenerateMessageID(g.nodeID, content, hlc),
+		SenderID:    g.nodeID,
+		RecipientID: "BROADCAST",
+		Content:     content,
+		Timestamp:   g.Now().Unix(),
+		HLC:         hlc,
+		TTL:         10,
+		Status:      "sent",
+		Priority:    2,
+		Author:      author,
+		Lat:         lat,
+		Long:        long,
+		Network:     network,
+		Payload:     string(payload),
+	}
+	// Signed so relays can hold the quota against this node. The sender
+	// may be a web user, so no role certificate goes with it; the
+	// signature does not cover it.
+	if err := g.sign(&msg); err != nil {
+		return "", err
+	}
+	msg.Cert, msg.Role = nil, ""
+	slog.Info("Sending SOS", "id", msg.ID, "type", sos.Type, "people", sos.People)
+	if err := g.send(msg, content, false); err != nil {
+		return "", err
+	}
+	if explicit {
+		// Repeats carry the sender's fix, not the node's.
+		g.fixMu.Lock()
+		g.sosFixes[msg.ID] = sosFix{lat: lat, long: long, accuracy: sos.Accuracy}
+		g.fixMu.Unlock()
 	}
+	return msg.ID, nil
+}
+
+// send stores msg, hands it to the UIs and uplink, and broadcasts it with
+// wireContent (the ciphertext, for DMs) as its content.
+func (g *GossipEngine) send(msg store.Message, wireContent string, encrypted bool) error {
+	// 1. Encode for the network first: a message too large to frame is
+	// refused rather than stored where no peer could receive it.
+	wireMsg := msg
+	wireMsg.Content = wireContent
+	wireMsg.IsEncrypted = encrypted
+	data, err := msgPacket(wireMsg)
+	if err != nil {
+		return err
+	}
+
 	if err := store.SaveMessage(g.db, &msg); err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
@@ -270,22 +680,25 @@ func (g *GossipEngine) PublishText(content string, author string, lat float64, l
 	}
 
 	// 2. Send Ciphertext to Network
-	wireMsg := msg
-	wireMsg.Content = cipherText
-	wireMsg.IsEncrypted = isEncrypted
+	g.broadcast(msg.Network, data)
+	return nil
+}
 
-	msgPayload := protocol.MsgPayload{Message: wireMsg}
-	pBytes, err := json.Marshal(msgPayload)
+// msgPacket encodes msg as a MSG packet, failing if it would not fit in a
+// frame.
+func msgPacket(msg store.Message) ([]byte, error) {
+	pBytes, err := json.Marshal(protocol.MsgPayload{Message: msg})
 	if err != nil {
-		return fmt.Errorf("failed to marshal msg payload: %w", err)
+		return nil, fmt.Errorf("failed to marshal msg payload: %w", err)
 	}
-	packet := protocol.Packet{Type: protocol.TypeMsg, Payload: pBytes}
-	data, err := json.Marshal(packet)
+	data, err := json.Marshal(protocol.Packet{Type: protocol.TypeMsg, Payload: pBytes})
 	if err != nil {
-		return fmt.Errorf("failed to marshal packet: %w", err)
+		return nil, fmt.Errorf("failed to marshal packet: %w", err)
 	}
-	g.transport.BroadcastPacket(data)
-	return nil
+	if len(data) > transport.MaxFrameSize {
+		return nil, fmt.Errorf("message too large: %d bytes encoded, at most %d", len(data), transport.MaxFrameSize)
+	}
+	return data, nil
 }
 func (g *GossipEngine) ManualConnect(addr string) error {
 	slog.Info("Manual connect initiated", "addr", addr)
@@ -296,20 +709,28 @@ func (g *GossipEngine) ManualConnect(addr string) error {
 	go g.handleConnection(conn)
 	return nil
 }
+
+// BroadcastSafe tells the mesh the operator is safe, answering the current
+// roll call too.
 func (g *GossipEngine) BroadcastSafe() error {
 	content := "SAFE ALERT: I am safe!"
-	ts := time.Now().Unix()
+	ts := g.Now().Unix()
 	msgID := core.GenerateMessageID(g.nodeID, content, ts)
 	msg := store.Message{
 		ID:        msgID,
 		SenderID:  g.nodeID,
 		Content:   content,
 		Timestamp: ts,
+		HLC:       g.clock.Now(),
 		TTL:       10,
 		HopCount:  0,
 		Status:    "sent",
 		Priority:  2,
 		Author:    g.nick,
+		Network:   g.Networks[0],
+	}
+	if err := g.sign(&msg); err != nil {
+		return err
 	}
 	if err := store.SaveMessage(g.db, &msg); err != nil {
 		return fmt.Errorf("failed to save safe message: %w", err)
@@ -328,6 +749,7 @@ func (g *GossipEngine) BroadcastSafe() error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal packet: %w", err)
 	}
-	g.transport.BroadcastPacket(data)
+	g.broadcast(msg.Network, data)
+	g.answerSafe()
 	return nil
 }
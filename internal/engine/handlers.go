@@ -5,14 +5,18 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net"
+	"time"
 
+	"github.com/bit2swaz/crisismesh/internal/clock"
+	"github.com/bit2swaz/crisismesh/internal/core"
 	"github.com/bit2swaz/crisismesh/internal/protocol"
 	"github.com/bit2swaz/crisismesh/internal/store"
 	"github.com/bit2swaz/crisismesh/internal/transport"
 	"golang.org/x/crypto/nacl/box"
 )
 
-func (g *GossipEngine) handlePacket(conn net.Conn, data []byte) {
+func (g *GossipEngine) handlePacket(conn net.Conn, sess *session, data []byte) {
+	received := g.Now()
 	var packet protocol.Packet
 	if err := json.Unmarshal(data, &packet); err != nil {
 		slog.Error("Failed to unmarshal packet", "error", err)
@@ -20,22 +24,151 @@ func (g *GossipEngine) handlePacket(conn net.Conn, data []byte) {
 	}
 	switch packet.Type {
 	case protocol.TypeMsg:
-		g.handleMsg(packet.Payload)
+		g.handleMsg(sess, packet.Payload)
 	case protocol.TypeSync:
-		g.handleSync(conn, packet.Payload)
+		g.handleSync(conn, sess, packet.Payload)
 	case protocol.TypeReq:
-		g.handleReq(conn, packet.Payload)
+		g.handleReq(conn, sess, packet.Payload)
+	case protocol.TypePing:
+		g.handlePing(conn, received, packet.Payload)
+	case protocol.TypePong:
+		g.handlePong(sess, packet.Payload)
+	case protocol.TypeHello:
+		// Repeated HELLOs after the handshake are ignored.
 	default:
 		slog.Warn("Unknown packet type", "type", packet.Type)
 	}
 }
-func (g *GossipEngine) handleMsg(payload []byte) {
+
+// handleMsg stores, shows and relays a message received from sess. A record
+// that fails its kind's check is dropped without being stored, so if it
+// failed only because what it refers to (an SOS, a roll call) has not
+// arrived yet, it is fetched again on a later sync.
+func (g *GossipEngine) handleMsg(sess *session, payload []byte) {
 	var msgPayload protocol.MsgPayload
 	if err := json.Unmarshal(payload, &msgPayload); err != nil {
 		slog.Error("Failed to unmarshal MSG payload", "error", err)
 		return
 	}
 	msg := msgPayload.Message
+	if msg.Network == "" {
+		msg.Network = store.DefaultNetwork
+	}
+	if !sess.shares(msg.Network) {
+		slog.Warn("Dropping message from a network not shared with this peer", "id", msg.ID, "network", msg.Network, "peer", sess.peerID)
+		return
+	}
+	if store.HasMessage(g.db, msg.ID) {
+		if !g.dups.Allow(sess.limitKey()) {
+			g.adjustReputation(sess.identity(), repFlood, store.RepFlood)
+		}
+		return
+	}
+	// Our own messages are already stored; a new one claiming our ID is
+	// forged.
+	if msg.SenderID == g.nodeID {
+		slog.Warn("Dropping message claiming this node's ID", "id", msg.ID, "peer", sess.peerID)
+		return
+	}
+	// Status is ours to set, whatever the sender claimed.
+	msg.Status = "received"
+	msg.Role = ""
+	msg.Verified = false
+	// signed is set once the signature proves the message is from its
+	// SenderID; only then is the origin held to account for it.
+	signed := false
+	if msg.Signature != "" {
+		if !core.Verify(msg.SignerKey, msg.SigningBytes(), msg.Signature) {
+			slog.Warn("Dropping message with bad signature", "id", msg.ID, "peer", sess.peerID)
+			g.adjustReputation(sess.identity(), repBadSig, store.RepBadSig)
+			return
+		}
+		// A valid signature only proves who sent the message if the key is
+		// the one pinned for its SenderID.
+		if ok, err := store.BindSigner(g.db, msg.SenderID, msg.SignerKey, g.Now()); !ok {
+			slog.Warn("Dropping message signed with a key not pinned for its sender", "id", msg.ID, "sender", msg.SenderID, "error", err)
+			return
+		}
+		signed = true
+		msg.Verified = true
+		if role, err := g.senderRole(msg, g.Now()); err == nil {
+			msg.Role = role
+		} else {
+			slog.Debug("Sender role not recognised", "id", msg.ID, "error", err)
+		}
+	}
+	// Records act on shared state, so only a sender that proved it made
+	// one is heard; a certificate on anything else proves nothing.
+	if !signed {
+		if msg.Kind != "" {
+			slog.Warn("Dropping unsigned record", "id", msg.ID, "kind", msg.Kind, "sender", msg.SenderID)
+			return
+		}
+		msg.Cert = nil
+	}
+	if g.peerQuarantined(msg.SenderID) {
+		slog.Debug("Dropping message from quarantined origin", "id", msg.ID, "origin", msg.SenderID)
+		return
+	}
+	// Dropped messages are not stored, so they are requested again on a
+	// later sync once the origin is back under its limit.
+	if !g.limits.origin.Allow(originKey(msg, signed, sess)) {
+		slog.Debug("Throttling origin", "id", msg.ID, "origin", msg.SenderID, "signed", signed)
+		if signed {
+			g.adjustReputation(msg.SenderID, repFlood, store.RepFlood)
+		}
+		return
+	}
+	// Over the SOS quota the message is dropped, not demoted: its priority
+	// is the sender's to set.
+	if msg.Priority == 2 && msg.Kind == "" && !g.limits.sos.Allow(originKey(msg, signed, sess)) {
+		slog.Warn("SOS quota exceeded, dropping message", "id", msg.ID, "origin", msg.SenderID, "signed", signed)
+		return
+	}
+	var act store.ModerationAction
+	switch msg.Kind {
+	case store.KindModeration:
+		var ok bool
+		if act, ok = g.checkModeration(msg); !ok {
+			return
+		}
+	case store.KindAlert:
+		// Alerts from anyone not authorised are neither stored nor relayed.
+		if err := g.checkAlert(msg); err != nil {
+			slog.Warn("Dropping alert", "id", msg.ID, "sender", msg.SenderID, "error", err)
+			return
+		}
+	case store.KindIncident:
+		if err := g.checkIncident(msg); err != nil {
+			slog.Warn("Dropping incident update", "id", msg.ID, "sender", msg.SenderID, "error", err)
+			return
+		}
+	case store.KindSOSRepeat:
+		if err := g.checkSOSRepeat(msg); err != nil {
+			slog.Warn("Dropping SOS repeat", "id", msg.ID, "sender", msg.SenderID, "error", err)
+			return
+		}
+	case store.KindCheckIn:
+		if err := g.checkCheckIn(msg); err != nil {
+			slog.Warn("Dropping check-in", "id", msg.ID, "sender", msg.SenderID, "error", err)
+			return
+		}
+	case store.KindRollCall:
+		if err := g.checkRollCall(msg); err != nil {
+			slog.Warn("Dropping roll call", "id", msg.ID, "sender", msg.SenderID, "error", err)
+			return
+		}
+	case store.KindRollCallReply:
+		if err := g.checkRollCallReply(msg); err != nil {
+			slog.Warn("Dropping roll call reply", "id", msg.ID, "sender", msg.SenderID, "error", err)
+			return
+		}
+	case store.KindPerson:
+		if err := g.checkPerson(msg); err != nil {
+			slog.Warn("Dropping person report", "id", msg.ID, "sender", msg.SenderID, "error", err)
+			return
+		}
+	}
 
 	if msg.IsEncrypted && msg.RecipientID == g.nodeID {
 		privKey, _ := hex.DecodeString(g.privKey)
@@ -54,14 +187,35 @@ func (g *GossipEngine) handleMsg(payload []byte) {
 		}
 	}
 
+	msg.ClockSkew = g.checkClock(&msg)
+	if msg.ClockSkew {
+		slog.Warn("Sender clock looks wrong", "id", msg.ID, "sender", msg.SenderID, "sent", time.Unix(msg.Timestamp, 0))
+	}
+	if msg.Kind == "" && store.Silenced(g.db, msg) {
+		// Kept so it is not fetched again, but not shown or relayed.
+		msg.Hidden = true
+	}
 	if err := store.SaveMessage(g.db, &msg); err != nil {
 		return
 	}
+	g.adjustReputation(sess.identity(), repRelay, store.RepRelay)
+	if msg.Kind == store.KindModeration {
+		if err := g.recordModeration(msg, act); err != nil {
+			slog.Error("Failed to record moderation", "id", msg.ID, "error", err)
+		}
+		return
+	}
+	if msg.Hidden {
+		return
+	}
 	slog.Info("New message received", "id", msg.ID, "content", msg.Content)
 	select {
 	case g.MsgUpdates <- msg:
 	default:
 	}
+	if msg.Kind == store.KindRollCall {
+		g.answerRollCall(msg)
+	}
 
 	// Send to Uplink if configured
 	if g.UplinkChan != nil {
@@ -71,25 +225,44 @@ func (g *GossipEngine) handleMsg(payload []byte) {
 		}
 	}
 }
-func (g *GossipEngine) handleSync(conn net.Conn, payload []byte) {
+
+// checkClock merges the message's HLC into ours and reports whether the
+// sender's clock looks badly wrong: its wall time is far ahead of ours, or far
+// behind events it had already seen when it sent. A message from the future
+// is re-stamped with our clock so it does not sort above everything else.
+func (g *GossipEngine) checkClock(msg *store.Message) bool {
+	sent := time.Unix(msg.Timestamp, 0)
+	ahead := sent.After(g.Now().Add(clock.MaxSkew))
+	behind := false
+	if msg.HLC != 0 {
+		if !g.clock.Update(msg.HLC) {
+			ahead = true
+		}
+		behind = clock.Physical(msg.HLC).Sub(sent) > clock.MaxSkew
+	}
+	if ahead {
+		msg.HLC = g.clock.Now()
+	}
+	return ahead || behind
+}
+
+func (g *GossipEngine) handleSync(conn net.Conn, sess *session, payload []byte) {
 	var sync protocol.SyncPayload
 	if err := json.Unmarshal(payload, &sync); err != nil {
 		slog.Error("Failed to unmarshal SYNC payload", "error", err)
 		return
 	}
-	slog.Info("Received SYNC", "count", len(sync.MessageIDs), "remote", conn.RemoteAddr())
-	msgs, err := store.GetMessages(g.db, 100)
-	if err != nil {
-		slog.Error("Failed to get local messages", "error", err)
-		return
+	if sync.Network == "" {
+		sync.Network = store.DefaultNetwork
 	}
-	myIDs := make(map[string]bool)
-	for _, m := range msgs {
-		myIDs[m.ID] = true
+	if !sess.shares(sync.Network) {
+		return
 	}
+	slog.Info("Received SYNC", "network", sync.Network, "count", len(sync.MessageIDs), "remote", conn.RemoteAddr())
 	var missingIDs []string
 	for _, id := range sync.MessageIDs {
-		if !myIDs[id] {
+		// Hidden messages count as held, so they are not fetched again.
+		if !store.HasMessage(g.db, id) {
 			missingIDs = append(missingIDs, id)
 		}
 	}
@@ -98,10 +271,12 @@ func (g *GossipEngine) handleSync(conn net.Conn, payload []byte) {
 		reqBytes, _ := json.Marshal(req)
 		packet := protocol.Packet{Type: protocol.TypeReq, Payload: reqBytes}
 		data, _ := json.Marshal(packet)
-		transport.WriteFrame(conn, data)
+		if err := transport.WriteFrame(conn, data); err != nil {
+			slog.Debug("Failed to send REQ", "remote", conn.RemoteAddr(), "error", err)
+		}
 	}
 }
-func (g *GossipEngine) handleReq(conn net.Conn, payload []byte) {
+func (g *GossipEngine) handleReq(conn net.Conn, sess *session, payload []byte) {
 	var req protocol.ReqPayload
 	if err := json.Unmarshal(payload, &req); err != nil {
 		slog.Error("Failed to unmarshal REQ payload", "error", err)
@@ -109,12 +284,14 @@ func (g *GossipEngine) handleReq(conn net.Conn, payload []byte) {
 	}
 	for _, id := range req.MessageIDs {
 		var msg store.Message
-		if err := g.db.First(&msg, "id = ?", id).Error; err == nil {
-			msgPayload := protocol.MsgPayload{Message: msg}
-			pBytes, _ := json.Marshal(msgPayload)
-			packet := protocol.Packet{Type: protocol.TypeMsg, Payload: pBytes}
-			data, _ := json.Marshal(packet)
-			transport.WriteFrame(conn, data)
+		if err := g.db.First(&msg, "id = ?", id).Error; err == nil && !msg.Hidden && sess.shares(msg.Network) {
+			data, err := msgPacket(msg)
+			if err == nil {
+				err = transport.WriteFrame(conn, data)
+			}
+			if err != nil {
+				slog.Debug("Failed to send requested message", "id", id, "remote", conn.RemoteAddr(), "error", err)
+			}
 		}
 	}
 }
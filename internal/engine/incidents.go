@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// incidentWindow is how far back SOS messages are tracked as incidents.
+const incidentWindow = 7 * 24 * time.Hour
+
+// UpdateIncident moves the incident opened by SOS message id to state,
+// gossiping a signed record. Responders and commanders may make any
+// change; whoever sent the SOS may resolve it. team defaults to this
+// node's nick.
+func (g *GossipEngine) UpdateIncident(id, state, team, note string) error {
+	u := store.IncidentUpdate{Incident: id, State: state, Team: team, Note: note}
+	if err := protocol.ValidateIncident(u); err != nil {
+		return err
+	}
+	sos, err := store.GetMessage(g.db, id)
+	if err != nil || !store.IsSOS(sos) {
+		return fmt.Errorf("no SOS %s", id)
+	}
+	if !store.MayUpdateIncident(g.CertifiedRole(), g.nodeID, sos.SenderID, state, true) {
+		return errors.New("this node's role may not respond to incidents")
+	}
+	if u.Team == "" {
+		u.Team = g.nick
+	}
+	content := fmt.Sprintf("INCIDENT %s %s: %s", id[:min(8, len(id))], strings.ToUpper(state), u.Team)
+	msg, err := g.sendRecord(store.KindIncident, u, content, 0)
+	if err != nil {
+		return err
+	}
+	slog.Info("Updated incident", "incident", id, "state", state, "team", u.Team, "record", msg.ID)
+	return nil
+}
+
+// Incidents returns the SOS incidents of the last week, open ones first.
+func (g *GossipEngine) Incidents() ([]store.Incident, error) {
+	return store.Incidents(g.db, g.Now().Add(-incidentWindow))
+}
+
+// checkIncident parses a received incident record and checks the SOS it
+// updates is held here and its sender may make the change.
+func (g *GossipEngine) checkIncident(msg store.Message) error {
+	var u store.IncidentUpdate
+	if err := json.Unmarshal([]byte(msg.Payload), &u); err != nil {
+		return err
+	}
+	if err := protocol.ValidateIncident(u); err != nil {
+		return err
+	}
+	sos, err := store.GetMessage(g.db, u.Incident)
+	if err != nil {
+		return err
+	}
+	if !store.MayUpdateIncident(msg.Role, msg.SenderID, sos.SenderID, u.State, msg.Verified) {
+		return errors.New("sender's role may not respond to incidents")
+	}
+	return nil
+}
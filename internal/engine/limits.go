@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/ratelimit"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// Flood limits. New messages count against their origin (see originKey),
+// SOS-priority ones also against a much smaller quota, and every frame
+// counts against the link it arrived on.
+var (
+	originLimit = ratelimit.Config{Rate: 2, Burst: 60, Strikes: 100, Window: time.Minute, Quarantine: 10 * time.Minute}
+	sosLimit    = ratelimit.Config{Rate: 3.0 / 600, Burst: 3, Strikes: 10, Window: 10 * time.Minute, Quarantine: 30 * time.Minute}
+	linkLimit   = ratelimit.Config{Rate: 50, Burst: 200, Strikes: 500, Window: time.Minute, Quarantine: 5 * time.Minute}
+)
+
+type limiters struct {
+	origin *ratelimit.Limiter
+	sos    *ratelimit.Limiter
+	link   *ratelimit.Limiter
+}
+
+func newLimiters() limiters {
+	l := limiters{
+		origin: ratelimit.New(originLimit),
+		sos:    ratelimit.New(sosLimit),
+		link:   ratelimit.New(linkLimit),
+	}
+	for name, lim := range map[string]*ratelimit.Limiter{"origin": l.origin, "sos": l.sos, "link": l.link} {
+		lim.OnQuarantine = func(key string, until time.Time) {
+			slog.Warn("Quarantined for flooding", "limit", name, "key", key, "until", until)
+		}
+	}
+	return l
+}
+
+// RateLimits returns the flood-protection counters by limit: origin, sos
+// and link.
+func (g *GossipEngine) RateLimits() map[string][]ratelimit.Stat {
+	return map[string][]ratelimit.Stat{
+		"origin": g.limits.origin.Stats(),
+		"sos":    g.limits.sos.Stats(),
+		"link":   g.limits.link.Stats(),
+	}
+}
+
+// linkKey identifies a link by remote host, so reconnecting from a new
+// source port does not reset its bucket.
+func linkKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// originKey is what a new message counts against: its sender wherever it
+// was relayed from when the signature proves who that is, otherwise the
+// link that delivered it, since an unsigned SenderID can be forged to spend
+// someone else's quota.
+func originKey(msg store.Message, signed bool, sess *session) string {
+	if signed {
+		return msg.SenderID
+	}
+	return sess.limitKey()
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// Moderate issues a moderation action. A local action applies on this node
+// only. Otherwise a signed record is gossiped to the default network, which
+// requires a role allowed to moderate; other nodes apply it if they trust
+// moderation and the record's certificate checks out against their command
+// key.
+func (g *GossipEngine) Moderate(action, target, reason string, local bool) error {
+	act := store.ModerationAction{Action: action, Target: target, Reason: reason}
+	if err := protocol.ValidateModeration(act); err != nil {
+		return err
+	}
+	now := g.Now()
+	if local {
+		return store.AddLocalModeration(g.db, act, g.nodeID, now)
+	}
+	if err := g.requirePermission(core.PermModerate); err != nil {
+		return fmt.Errorf("%w; use a local action instead", err)
+	}
+	payload, _ := json.Marshal(act)
+	msg := store.Message{
+		ID:        core.GenerateMessageID(g.nodeID, string(payload), now.Unix()),
+		SenderID:  g.nodeID,
+		Author:    g.nick,
+		Timestamp: now.Unix(),
+		HLC:       g.clock.Now(),
+		TTL:       10,
+		Status:    "sent",
+		Network:   g.Networks[0],
+		Kind:      store.KindModeration,
+		Payload:   string(payload),
+	}
+	if err := g.sign(&msg); err != nil {
+		return err
+	}
+	if err := store.SaveMessage(g.db, &msg); err != nil {
+		return fmt.Errorf("failed to save moderation record: %w", err)
+	}
+	if err := g.recordModeration(msg, act); err != nil {
+		return err
+	}
+	pBytes, _ := json.Marshal(protocol.MsgPayload{Message: msg})
+	data, _ := json.Marshal(protocol.Packet{Type: protocol.TypeMsg, Payload: pBytes})
+	g.broadcast(msg.Network, data)
+	return nil
+}
+
+// ModerationLog is the audit trail of moderation actions, newest first.
+func (g *GossipEngine) ModerationLog() ([]store.Moderation, error) {
+	return store.GetModerations(g.db)
+}
+
+// checkModeration parses and checks a received moderation record. handleMsg
+// has already verified its signature and bound the signer to its sender.
+func (g *GossipEngine) checkModeration(msg store.Message) (store.ModerationAction, bool) {
+	var act store.ModerationAction
+	if err := json.Unmarshal([]byte(msg.Payload), &act); err != nil {
+		return act, false
+	}
+	if err := protocol.ValidateModeration(act); err != nil {
+		slog.Warn("Dropping invalid moderation record", "id", msg.ID, "error", err)
+		return act, false
+	}
+	return act, true
+}
+
+// recordModeration adds a record to the audit trail, applied if its signer
+// is trusted now.
+func (g *GossipEngine) recordModeration(msg store.Message, act store.ModerationAction) error {
+	now := g.Now()
+	applied, note := g.trustModerator(msg, now)
+	slog.Info("Moderation record", "action", act.Action, "target", act.Target, "issuer", msg.SenderID, "applied", applied)
+	return store.AddModeration(g.db, store.Moderation{
+		ID:               msg.ID,
+		ModerationAction: act,
+		Issuer:           msg.SenderID,
+		IssuerKey:        msg.SignerKey,
+		Applied:          applied,
+		Note:             note,
+		At:               now,
+	})
+}
+
+// trustModerator decides whether a record received at at is applied:
+// moderation must be trusted here, the record signed, and its certificate
+// must grant a role allowed to moderate.
+func (g *GossipEngine) trustModerator(msg store.Message, at time.Time) (bool, string) {
+	if !g.TrustModeration {
+		return false, "mesh moderation is not trusted on this node"
+	}
+	if msg.Signature == "" {
+		return false, "record is not signed"
+	}
+	role, err := g.senderRole(msg, at)
+	if err != nil {
+		return false, err.Error()
+	}
+	if !core.RoleAllows(role, core.PermModerate) {
+		return false, fmt.Sprintf("the %s role may not moderate", role)
+	}
+	return true, ""
+}
+
+// reapplyModeration re-evaluates every mesh record against current trust,
+// e.g. after startup with a different command key.
+func (g *GossipEngine) reapplyModeration() {
+	mods, err := store.GetModerations(g.db)
+	if err != nil {
+		return
+	}
+	changed := false
+	for _, m := range mods {
+		if m.Local {
+			continue
+		}
+		var msg store.Message
+		if err := g.db.First(&msg, "id = ?", m.ID).Error; err != nil {
+			continue
+		}
+		applied, note := g.trustModerator(msg, m.At)
+		if applied != m.Applied || note != m.Note {
+			store.SetModerationApplied(g.db, m.ID, applied, note)
+			changed = true
+		}
+	}
+	if changed {
+		if err := store.RebuildModeration(g.db); err != nil {
+			slog.Error("Failed to reapply moderation", "error", err)
+		}
+	}
+}
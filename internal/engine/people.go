@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bit2swaz/crisismesh/internal/pfif"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// ReportPerson gossips a report to the person-finder registry and returns
+// the person's ID. A report without rep.Person opens a new entry.
+func (g *GossipEngine) ReportPerson(rep store.PersonReport) (string, error) {
+	if err := protocol.ValidatePersonReport(rep); err != nil {
+		return "", err
+	}
+	if rep.Person != "" {
+		if _, ok, err := store.GetPerson(g.db, rep.Person); err != nil || !ok {
+			return "", fmt.Errorf("no person %s", rep.Person)
+		}
+	}
+	content := fmt.Sprintf("PERSON %s: %s", strings.ToUpper(rep.Status), rep.Name)
+	if rep.Location != "" {
+		content += " at " + rep.Location
+	}
+	msg, err := g.sendRecord(store.KindPerson, rep, content, 0)
+	if err != nil {
+		return "", err
+	}
+	id := rep.Person
+	if id == "" {
+		id = msg.ID
+	}
+	slog.Info("Reported person", "person", id, "status", rep.Status, "record", msg.ID)
+	return id, nil
+}
+
+// People searches the person-finder registry by name, description or last
+// seen location.
+func (g *GossipEngine) People(query string) ([]store.Person, error) {
+	return store.People(g.db, query)
+}
+
+// ExportPFIF renders the registry entries matching query as a PFIF 1.4
+// document for handover to agencies.
+func (g *GossipEngine) ExportPFIF(query string) ([]byte, error) {
+	people, err := g.People(query)
+	if err != nil {
+		return nil, err
+	}
+	return pfif.Encode(pfif.FromPeople(people, g.Now()))
+}
+
+// checkPerson parses a received person report. Updates to an entry not
+// yet received are kept: entries are merged when read, so they fold in
+// once the first report arrives.
+func (g *GossipEngine) checkPerson(msg store.Message) error {
+	var rep store.PersonReport
+	if err := json.Unmarshal([]byte(msg.Payload), &rep); err != nil {
+		return err
+	}
+	return protocol.ValidatePersonReport(rep)
+}
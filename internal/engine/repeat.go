@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/bit2swaz/crisismesh/internal/utils"
+)
+
+// SOS back-off: an SOS nobody has acknowledged is repeated sosRepeatFirst
+// after it was sent, then after twice as long each time up to sosRepeatMax.
+const (
+	sosRepeatFirst = 30 * time.Second
+	sosRepeatMax   = 10 * time.Minute
+	sosRepeatCheck = 5 * time.Second
+)
+
+// sosCancelNote is the note on the update that resolves a cancelled SOS.
+const sosCancelNote = "Cancelled by sender"
+
+// sosFix is the latest position and battery a web client reported for its
+// SOS. SOS sent from the node itself use the node's.
+type sosFix struct {
+	lat, long, accuracy float64
+	battery             *int
+}
+
+// sosBackoff is how long after the last announcement an SOS repeated
+// repeats times is due again.
+func sosBackoff(repeats int) time.Duration {
+	return min(sosRepeatFirst<<min(repeats, 8), sosRepeatMax)
+}
+
+func (g *GossipEngine) repeatSOS(ctx context.Context) {
+	ticker := time.NewTicker(sosRepeatCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.repeatDue(g.Now())
+		}
+	}
+}
+
+// repeatDue re-announces every open SOS this node sent whose back-off has
+// run out. The schedule comes from the stored repeats, so it carries on
+// across restarts and stops once anyone acknowledges.
+func (g *GossipEngine) repeatDue(now time.Time) {
+	incidents, err := g.Incidents()
+	if err != nil {
+		slog.Error("Failed to load incidents", "error", err)
+		return
+	}
+	var open []string
+	for _, inc := range incidents {
+		if inc.ReporterID != g.nodeID || inc.State != store.IncidentOpen {
+			continue
+		}
+		open = append(open, inc.ID)
+		if now.Before(inc.LastHeard.Add(sosBackoff(inc.Repeats))) {
+			continue
+		}
+		if err := g.repeat(inc); err != nil {
+			slog.Error("Failed to repeat SOS", "id", inc.ID, "error", err)
+		}
+	}
+	g.fixMu.Lock()
+	for id := range g.sosFixes {
+		if !slices.Contains(open, id) {
+			delete(g.sosFixes, id)
+		}
+	}
+	g.fixMu.Unlock()
+}
+
+// repeat sends a repeat record for inc with the latest position and
+// battery.
+func (g *GossipEngine) repeat(inc store.Incident) error {
+	rep := store.SOSRepeat{SOS: inc.ID, Count: inc.Repeats + 1}
+	g.fixMu.Lock()
+	fix, ok := g.sosFixes[inc.ID]
+	g.fixMu.Unlock()
+	if ok {
+		rep.Lat, rep.Long, rep.Accuracy, rep.Battery = fix.lat, fix.long, fix.accuracy, fix.battery
+	} else {
+		g.posMu.Lock()
+		rep.Lat, rep.Long = g.lat, g.long
+		g.posMu.Unlock()
+		if level, ok := utils.BatteryLevel(); ok {
+			rep.Battery = &level
+		}
+	}
+	network := inc.Network
+	if !slices.Contains(g.Networks, network) {
+		network = g.Networks[0]
+	}
+	content := fmt.Sprintf("SOS %s REPEAT %d: no response yet", inc.ID[:min(8, len(inc.ID))], rep.Count)
+	msg, err := g.sendRecordTo(network, store.KindSOSRepeat, rep, content, 0)
+	if err != nil {
+		return err
+	}
+	slog.Info("Repeated SOS", "id", inc.ID, "count", rep.Count, "record", msg.ID)
+	return nil
+}
+
+// ownSOS returns the SOS message id if this node sent it.
+func (g *GossipEngine) ownSOS(id string) (store.Message, error) {
+	msg, err := store.GetMessage(g.db, id)
+	if err != nil || !store.IsSOS(msg) || msg.SenderID != g.nodeID {
+		return msg, fmt.Errorf("no SOS %s sent from this node", id)
+	}
+	return msg, nil
+}
+
+// UpdateSOSFix records the latest position and battery for SOS id, sent
+// from this node on behalf of a web client, to go out with its next repeat.
+func (g *GossipEngine) UpdateSOSFix(id string, lat, long, accuracy float64, battery *int) error {
+	if err := protocol.ValidateSOSRepeat(store.SOSRepeat{SOS: id, Count: 1, Lat: lat, Long: long, Accuracy: accuracy, Battery: battery}); err != nil {
+		return err
+	}
+	if _, err := g.ownSOS(id); err != nil {
+		return err
+	}
+	g.fixMu.Lock()
+	g.sosFixes[id] = sosFix{lat: lat, long: long, accuracy: accuracy, battery: battery}
+	g.fixMu.Unlock()
+	return nil
+}
+
+// CancelSOS calls off an SOS this node sent, resolving its incident so
+// repeats stop here and responders elsewhere stand down.
+func (g *GossipEngine) CancelSOS(id string) error {
+	if _, err := g.ownSOS(id); err != nil {
+		return err
+	}
+	if err := g.UpdateIncident(id, store.IncidentResolved, "", sosCancelNote); err != nil {
+		return err
+	}
+	g.fixMu.Lock()
+	delete(g.sosFixes, id)
+	g.fixMu.Unlock()
+	return nil
+}
+
+// SOSToken is the secret a web client is given with its SOS and must show
+// to update or cancel it. It is derived from the node's signing key, so it
+// still works after a restart.
+func (g *GossipEngine) SOSToken(id string) string {
+	mac := hmac.New(sha256.New, []byte(g.identity.SignPriv))
+	mac.Write([]byte("sos:" + id))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// checkSOSRepeat parses a received repeat record and checks it is signed by
+// the key bound to whoever sent the SOS it repeats.
+func (g *GossipEngine) checkSOSRepeat(msg store.Message) error {
+	if !msg.Verified {
+		return errors.New("repeat is not signed by its sender")
+	}
+	var rep store.SOSRepeat
+	if err := json.Unmarshal([]byte(msg.Payload), &rep); err != nil {
+		return err
+	}
+	if err := protocol.ValidateSOSRepeat(rep); err != nil {
+		return err
+	}
+	sos, err := store.GetMessage(g.db, rep.SOS)
+	if err != nil {
+		return err
+	}
+	if !store.IsSOS(sos) || sos.SenderID != msg.SenderID {
+		return errors.New("only the sender of an SOS may repeat it")
+	}
+	return nil
+}
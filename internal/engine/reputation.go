@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	mathrand "math/rand"
+	"slices"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/ratelimit"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// Reputation adjustments for what the engine observes. The thresholds are
+// store.LowScore and store.QuarantineScore.
+const (
+	repInvalid = -5.0
+	repBadSig  = -10.0
+	repFlood   = -2.0
+	repRelay   = 0.5
+	// repUptime is earned per reputationInterval in contact, which also lets
+	// a peer recover from old offences.
+	repUptime = 0.2
+
+	reputationQuarantine = 30 * time.Minute
+	reputationInterval   = time.Minute
+)
+
+// dupLimit is how many already-known messages a peer may push before it
+// counts as flooding. Some duplicates are normal when several peers relay
+// the same message.
+var dupLimit = ratelimit.Config{Rate: 1, Burst: 30}
+
+// adjustReputation applies delta to a peer's score and quarantines it if the
+// score has fallen too low.
+func (g *GossipEngine) adjustReputation(peerID string, delta float64, event string) {
+	if peerID == "" || peerID == g.nodeID {
+		return
+	}
+	rep, err := store.AdjustReputation(g.db, peerID, delta, event)
+	if err != nil {
+		slog.Error("Failed to update reputation", "peer", peerID, "error", err)
+		return
+	}
+	now := time.Now()
+	if rep.Effective() >= store.QuarantineScore || now.Before(rep.QuarantinedUntil) {
+		return
+	}
+	until := now.Add(reputationQuarantine)
+	if err := store.QuarantinePeer(g.db, peerID, until); err != nil {
+		slog.Error("Failed to quarantine peer", "peer", peerID, "error", err)
+		return
+	}
+	slog.Warn("Quarantining peer for low reputation", "peer", peerID, "score", rep.Effective(), "until", until)
+	g.dropPeer(peerID)
+}
+
+// peerQuarantined reports whether a peer is currently refused, either by a
+// quarantine or an operator override below the threshold.
+func (g *GossipEngine) peerQuarantined(peerID string) bool {
+	rep := store.GetReputation(g.db, peerID)
+	return time.Now().Before(rep.QuarantinedUntil) || (rep.Override != nil && *rep.Override < store.QuarantineScore)
+}
+
+// dropPeer closes every connection whose HELLO claimed peerID.
+func (g *GossipEngine) dropPeer(peerID string) {
+	g.sessionsMu.Lock()
+	var addrs []string
+	for addr, s := range g.sessions {
+		if s.peerID == peerID {
+			addrs = append(addrs, addr)
+		}
+	}
+	g.sessionsMu.Unlock()
+	for _, addr := range addrs {
+		g.transport.Disconnect(addr)
+	}
+}
+
+// scores returns every peer's effective score; peers never scored are
+// NeutralScore and quarantined ones 0.
+func (g *GossipEngine) scores() map[string]float64 {
+	reps, _ := store.GetReputations(g.db)
+	now := time.Now()
+	scores := make(map[string]float64, len(reps))
+	for _, r := range reps {
+		scores[r.PeerID] = r.Effective()
+		if now.Before(r.QuarantinedUntil) || r.Effective() < store.QuarantineScore {
+			scores[r.PeerID] = 0
+		}
+	}
+	return scores
+}
+
+func scoreOf(scores map[string]float64, peerID string) float64 {
+	if s, ok := scores[peerID]; ok {
+		return s
+	}
+	return store.NeutralScore
+}
+
+// pickSyncPeer chooses a peer to sync with, weighted by reputation so that
+// low scorers get fewer sync slots and quarantined peers none.
+func (g *GossipEngine) pickSyncPeer(peers []store.Peer) (store.Peer, bool) {
+	scores := g.scores()
+	total := 0.0
+	for _, p := range peers {
+		total += scoreOf(scores, p.ID)
+	}
+	if total <= 0 {
+		return store.Peer{}, false
+	}
+	r := mathrand.Float64() * total
+	for _, p := range peers {
+		if r -= scoreOf(scores, p.ID); r < 0 {
+			return p, true
+		}
+	}
+	return peers[len(peers)-1], true
+}
+
+// forwardOrder lists connected addresses sharing network, best reputation
+// first, so well-behaved peers get new messages before low scorers.
+// Quarantined peers are left out.
+func (g *GossipEngine) forwardOrder(network string) []string {
+	scores := g.scores()
+	type target struct {
+		addr  string
+		score float64
+	}
+	var targets []target
+	g.sessionsMu.Lock()
+	for addr, s := range g.sessions {
+		if !s.shares(network) {
+			continue
+		}
+		if score := scoreOf(scores, s.identity()); score > 0 {
+			targets = append(targets, target{addr, score})
+		}
+	}
+	g.sessionsMu.Unlock()
+	slices.SortFunc(targets, func(a, b target) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		return 0
+	})
+	addrs := make([]string, len(targets))
+	for i, t := range targets {
+		addrs[i] = t.addr
+	}
+	return addrs
+}
+
+// rewardUptime credits every verified peer we currently have a session with.
+func (g *GossipEngine) rewardUptime(ctx context.Context) {
+	ticker := time.NewTicker(reputationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			seen := make(map[string]bool)
+			g.sessionsMu.Lock()
+			for _, s := range g.sessions {
+				if s.verified {
+					seen[s.peerID] = true
+				}
+			}
+			g.sessionsMu.Unlock()
+			for peerID := range seen {
+				g.adjustReputation(peerID, repUptime, store.RepUptime)
+			}
+		}
+	}
+}
+
+// SetReputationOverride pins a peer's score, or with nil returns it to the
+// observed one. A score below the quarantine threshold drops the peer now.
+func (g *GossipEngine) SetReputationOverride(peerID string, score *float64) error {
+	if err := store.SetReputationOverride(g.db, peerID, score); err != nil {
+		return err
+	}
+	if score != nil && *score < store.QuarantineScore {
+		g.dropPeer(peerID)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// rollCallChunk is how many answers go in one reply record, safely under
+// the protocol's limit.
+const rollCallChunk = 30
+
+// StartRollCall signs a roll call and sends it to the default network,
+// returning its ID. This node's role must allow roll calls. Every node
+// that gets it replies for its operator and registered web users.
+func (g *GossipEngine) StartRollCall(note string) (string, error) {
+	if err := g.requirePermission(core.PermRollCall); err != nil {
+		return "", err
+	}
+	rc := store.RollCall{Note: note}
+	if err := protocol.ValidateRollCall(rc); err != nil {
+		return "", err
+	}
+	content := "ROLL CALL: report whether you are safe"
+	if note != "" {
+		content += ": " + note
+	}
+	msg, err := g.sendRecord(store.KindRollCall, rc, content, 1)
+	if err != nil {
+		return "", err
+	}
+	slog.Info("Started roll call", "id", msg.ID)
+	g.answerRollCall(msg)
+	return msg.ID, nil
+}
+
+// answerRollCall replies to a roll call as soon as it arrives, so the
+// caller knows this node was reached. Anyone with an open SOS from here
+// is answered as needing help; the operator is answered safe with
+// RollCallAuto; everyone else is pending until they answer.
+func (g *GossipEngine) answerRollCall(call store.Message) {
+	if g.Now().Sub(time.Unix(call.Timestamp, 0)) > store.RollCallWindow {
+		return
+	}
+	needHelp := make(map[string]bool)
+	if incidents, err := g.Incidents(); err == nil {
+		for _, inc := range incidents {
+			if inc.ReporterID == g.nodeID && inc.State != store.IncidentResolved {
+				needHelp[inc.Reporter] = true
+			}
+		}
+	}
+	operator := store.RollCallAnswer{Status: store.RollCallPending}
+	switch {
+	case needHelp[g.nick]:
+		operator = store.RollCallAnswer{Status: store.RollCallHelp, Auto: true}
+	case g.RollCallAuto:
+		operator = store.RollCallAnswer{Status: store.RollCallSafe, Auto: true}
+	}
+	answers := []store.RollCallAnswer{operator}
+	names, _ := store.WebUsers(g.db, g.Now().Add(-store.RollCallRoster))
+	for _, name := range names {
+		a := store.RollCallAnswer{Name: name, Status: store.RollCallPending}
+		if needHelp[name] {
+			a = store.RollCallAnswer{Name: name, Status: store.RollCallHelp, Auto: true}
+		}
+		answers = append(answers, a)
+	}
+	for len(answers) > 0 {
+		chunk := answers[:min(rollCallChunk, len(answers))]
+		answers = answers[len(chunk):]
+		reply := store.RollCallReply{RollCall: call.ID, Answers: chunk}
+		content := fmt.Sprintf("ROLL CALL REPLY from %s: %d people", g.nick, len(chunk))
+		if _, err := g.sendRecordTo(call.Network, store.KindRollCallReply, reply, content, 0); err != nil {
+			slog.Error("Failed to reply to roll call", "id", call.ID, "error", err)
+			return
+		}
+	}
+}
+
+// AnswerRollCall answers roll call id safe or help for name, a web user
+// registered here, or for the operator when name is empty.
+func (g *GossipEngine) AnswerRollCall(id, name, status string) error {
+	if status == store.RollCallPending {
+		return errors.New("answer safe or help")
+	}
+	if name != "" {
+		if _, ok := store.GetWebUser(g.db, name, g.Now().Add(-store.RollCallRoster)); !ok {
+			return fmt.Errorf("%s is not registered on this node", name)
+		}
+	}
+	reply := store.RollCallReply{RollCall: id, Answers: []store.RollCallAnswer{{Name: name, Status: status}}}
+	if err := protocol.ValidateRollCallReply(reply); err != nil {
+		return err
+	}
+	call, err := store.GetMessage(g.db, id)
+	if err != nil || call.Kind != store.KindRollCall {
+		return fmt.Errorf("no roll call %s", id)
+	}
+	who := name
+	if who == "" {
+		who = g.nick
+	}
+	content := fmt.Sprintf("ROLL CALL: %s %s", who, map[string]string{store.RollCallSafe: "SAFE", store.RollCallHelp: "NEEDS HELP"}[status])
+	msg, err := g.sendRecordTo(call.Network, store.KindRollCallReply, reply, content, 0)
+	if err != nil {
+		return err
+	}
+	slog.Info("Answered roll call", "id", id, "name", who, "status", status, "record", msg.ID)
+	return nil
+}
+
+// RollCall returns the board for the latest roll call, if there has been
+// one.
+func (g *GossipEngine) RollCall() (store.RollCallStatus, bool, error) {
+	call, ok := store.LatestRollCall(g.db)
+	if !ok {
+		return store.RollCallStatus{}, false, nil
+	}
+	board, err := store.RollCallBoard(g.db, call.ID)
+	return board, err == nil, err
+}
+
+// RegisterWebUser records the name a web client goes by, so roll calls
+// are answered for them, and returns the token the client must show to
+// answer for it. An empty token registers a new client; a name another
+// client registered within the roster window is refused.
+func (g *GossipEngine) RegisterWebUser(name, token string) (string, error) {
+	if err := protocol.ValidateWebUser(name); err != nil {
+		return "", err
+	}
+	now := g.Now()
+	if _, ok := store.GetWebUser(g.db, name, now.Add(-store.RollCallRoster)); ok && !g.AuthWebUser(name, token) {
+		return "", fmt.Errorf("%s is already in use on this node", name)
+	}
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		token = hex.EncodeToString(b)
+	}
+	return token, store.RegisterWebUser(g.db, name, token, now)
+}
+
+// AuthWebUser reports whether token is the one name registered with.
+func (g *GossipEngine) AuthWebUser(name, token string) bool {
+	u, ok := store.GetWebUser(g.db, name, g.Now().Add(-store.RollCallRoster))
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(u.Token), []byte(token)) == 1
+}
+
+// answerSafe answers the current roll call, if any, safe for the operator.
+// BroadcastSafe calls it, so the old SAFE ALERT also counts as an answer.
+func (g *GossipEngine) answerSafe() {
+	call, ok := store.LatestRollCall(g.db)
+	if !ok || g.Now().Sub(time.Unix(call.Timestamp, 0)) > store.RollCallWindow {
+		return
+	}
+	if err := g.AnswerRollCall(call.ID, "", store.RollCallSafe); err != nil {
+		slog.Error("Failed to answer roll call", "id", call.ID, "error", err)
+	}
+}
+
+// checkRollCall parses a received roll call and checks its sender may
+// start one.
+func (g *GossipEngine) checkRollCall(msg store.Message) error {
+	var rc store.RollCall
+	if err := json.Unmarshal([]byte(msg.Payload), &rc); err != nil {
+		return err
+	}
+	if err := protocol.ValidateRollCall(rc); err != nil {
+		return err
+	}
+	if !core.RoleAllows(msg.Role, core.PermRollCall) {
+		return errors.New("sender's role may not start roll calls")
+	}
+	return nil
+}
+
+// checkRollCallReply parses a received reply and checks it is signed by
+// its SenderID, since it answers for that node's people, and that the roll
+// call it answers is held here.
+func (g *GossipEngine) checkRollCallReply(msg store.Message) error {
+	if !msg.Verified {
+		return errors.New("reply is not signed by its sender")
+	}
+	var reply store.RollCallReply
+	if err := json.Unmarshal([]byte(msg.Payload), &reply); err != nil {
+		return err
+	}
+	if err := protocol.ValidateRollCallReply(reply); err != nil {
+		return err
+	}
+	call, err := store.GetMessage(g.db, reply.RollCall)
+	if err != nil || call.Kind != store.KindRollCall {
+		return fmt.Errorf("no roll call %s", reply.RollCall)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/bit2swaz/crisismesh/internal/transport"
+)
+
+// helloTimeout is how long a new connection has to send its HELLO. A peer
+// that sends nothing by then is taken to be a legacy one without HELLO.
+const helloTimeout = 10 * time.Second
+
+// session is what a connection's HELLO told us about the other side.
+type session struct {
+	peerID string
+	// link is the connection's linkKey.
+	link string
+	// signKey is the key the HELLO named. verified is set once the peer has
+	// signed our nonce with it and it is the key pinned for peerID; until
+	// then peerID is only a claim.
+	signKey  string
+	verified bool
+	// networks are the networks both sides have joined; only their
+	// messages cross this connection.
+	networks []string
+}
+
+// identity is the peer's node ID if it has been verified, else "", so that
+// reputation is never credited or charged to an ID the peer only claimed.
+func (s *session) identity() string {
+	if s.verified {
+		return s.peerID
+	}
+	return ""
+}
+
+// limitKey keys per-peer counters: the verified node ID, or else the link.
+func (s *session) limitKey() string {
+	if s.verified {
+		return s.peerID
+	}
+	return "link:" + s.link
+}
+
+func (s *session) shares(network string) bool {
+	return slices.Contains(s.networks, network)
+}
+
+func (g *GossipEngine) session(addr string) *session {
+	g.sessionsMu.Lock()
+	defer g.sessionsMu.Unlock()
+	return g.sessions[addr]
+}
+
+func (g *GossipEngine) setSession(addr string, s *session) {
+	g.sessionsMu.Lock()
+	defer g.sessionsMu.Unlock()
+	if s == nil {
+		delete(g.sessions, addr)
+		return
+	}
+	g.sessions[addr] = s
+}
+
+// JoinedNetworks lists the networks this node takes part in; the first is
+// where messages go by default.
+func (g *GossipEngine) JoinedNetworks() []string {
+	return slices.Clone(g.Networks)
+}
+
+// newNonce returns a fresh nonce for a HELLO.
+func newNonce() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (g *GossipEngine) sendHello(conn net.Conn, nonce string) error {
+	hello, _ := json.Marshal(protocol.HelloPayload{NodeID: g.nodeID, Networks: g.Networks, SignKey: g.identity.SignPub, Nonce: nonce})
+	data, _ := json.Marshal(protocol.Packet{Type: protocol.TypeHello, Payload: hello})
+	return transport.WriteFrame(conn, data)
+}
+
+// handleHello processes the first frame from a peer. It returns the new
+// session, or an error if the connection should be closed. A first frame
+// that is not a HELLO comes from a legacy peer, which is on DefaultNetwork;
+// the caller handles it once the session is set up. A HELLO carrying a
+// nonce is answered with an AUTH.
+func (g *GossipEngine) handleHello(conn net.Conn, data []byte) (*session, error) {
+	link := linkKey(conn.RemoteAddr().String())
+	var packet protocol.Packet
+	if err := json.Unmarshal(data, &packet); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal packet: %w", err)
+	}
+	if packet.Type != protocol.TypeHello {
+		return g.legacySession(link)
+	}
+	var hello protocol.HelloPayload
+	if err := json.Unmarshal(packet.Payload, &hello); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal HELLO: %w", err)
+	}
+	if hello.NodeID == g.nodeID {
+		return nil, errors.New("peer claims this node's ID")
+	}
+	if g.peerQuarantined(hello.NodeID) {
+		return nil, fmt.Errorf("peer %s is quarantined", hello.NodeID)
+	}
+	if !g.Policy.Allows(g.policyPeer(hello.NodeID, conn.RemoteAddr().String())) {
+		return nil, fmt.Errorf("peer %s is denied by link policy", hello.NodeID)
+	}
+	shared := store.SharedNetworks(g.Networks, hello.Networks)
+	if len(shared) == 0 {
+		return nil, fmt.Errorf("no network in common (they have %v)", hello.Networks)
+	}
+	if hello.Nonce != "" {
+		sig, err := core.Sign(g.identity.SignPriv, protocol.AuthBytes(g.nodeID, hello.NodeID, hello.Nonce))
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign AUTH: %w", err)
+		}
+		auth, _ := json.Marshal(protocol.AuthPayload{Sig: sig})
+		data, _ := json.Marshal(protocol.Packet{Type: protocol.TypeAuth, Payload: auth})
+		if err := transport.WriteFrame(conn, data); err != nil {
+			return nil, err
+		}
+	}
+	return &session{peerID: hello.NodeID, link: link, signKey: hello.SignKey, networks: shared}, nil
+}
+
+// legacySession is the session for a peer that sent no HELLO: it names no
+// node ID, so it is never verified, and is on DefaultNetwork.
+func (g *GossipEngine) legacySession(link string) (*session, error) {
+	shared := store.SharedNetworks(g.Networks, nil)
+	if len(shared) == 0 {
+		return nil, errors.New("legacy peer without HELLO is not on a network we joined")
+	}
+	return &session{link: link, networks: shared}, nil
+}
+
+// handleAuth checks the peer's answer to the nonce we sent in our HELLO and
+// returns the session verified. An answer that does not check out closes
+// the connection: the peer claimed an ID it cannot prove.
+func (g *GossipEngine) handleAuth(sess *session, nonce string, payload []byte) (*session, error) {
+	if sess.verified {
+		return sess, nil
+	}
+	var auth protocol.AuthPayload
+	if err := json.Unmarshal(payload, &auth); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal AUTH: %w", err)
+	}
+	if sess.peerID == "" || sess.signKey == "" || !core.Verify(sess.signKey, protocol.AuthBytes(sess.peerID, g.nodeID, nonce), auth.Sig) {
+		return nil, fmt.Errorf("bad AUTH signature for %s", sess.peerID)
+	}
+	if ok, err := store.BindSigner(g.db, sess.peerID, sess.signKey, g.Now()); !ok {
+		return nil, fmt.Errorf("key is not the one pinned for %s (%v)", sess.peerID, err)
+	}
+	verified := *sess
+	verified.verified = true
+	slog.Info("Peer verified", "peer", sess.peerID, "link", sess.link)
+	return &verified, nil
+}
+
+// syncPacket is a SYNC frame listing our latest messages in network, or
+// nil if we have none.
+func (g *GossipEngine) syncPacket(network string) []byte {
+	msgs, err := store.GetNetworkMessages(g.db, network, 50)
+	if err != nil {
+		slog.Error("Failed to get messages for sync", "error", err)
+		return nil
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	pBytes, _ := json.Marshal(protocol.SyncPayload{Network: network, MessageIDs: ids})
+	data, _ := json.Marshal(protocol.Packet{Type: protocol.TypeSync, Payload: pBytes})
+	return data
+}
+
+// broadcast sends a packet to every connected peer that shares network,
+// in order of reputation.
+func (g *GossipEngine) broadcast(network string, data []byte) {
+	for _, addr := range g.forwardOrder(network) {
+		if err := g.transport.SendPacket(addr, data); err != nil {
+			slog.Debug("Failed to forward packet", "peer", addr, "error", err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"github.com/bit2swaz/crisismesh/internal/discovery"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/bit2swaz/crisismesh/internal/utils"
+)
+
+// SetPosition records this node's last known coordinates for heartbeats.
+// Zero means unknown and is ignored.
+func (g *GossipEngine) SetPosition(lat, long float64) {
+	if lat == 0 && long == 0 {
+		return
+	}
+	g.posMu.Lock()
+	g.lat, g.long = lat, long
+	g.posMu.Unlock()
+}
+
+// heartbeatMeta is polled by the heartbeat for this node's status.
+func (g *GossipEngine) heartbeatMeta() discovery.Metadata {
+	meta := discovery.Metadata{Role: g.Role, Queue: g.queueDepth()}
+	if level, ok := utils.BatteryLevel(); ok {
+		meta.Battery = &level
+	}
+	g.posMu.Lock()
+	meta.Lat, meta.Long = g.lat, g.long
+	g.posMu.Unlock()
+	return meta
+}
+
+// queueDepth counts messages waiting on this node: direct messages for
+// peers that are out of contact, plus anything not yet sent to the uplink.
+func (g *GossipEngine) queueDepth() int {
+	var held int64
+	g.db.Model(&store.Message{}).
+		Where("recipient_id IN (?)", g.db.Model(&store.Peer{}).Select("id").Where("is_active = ?", false)).
+		Count(&held)
+	return int(held) + len(g.UplinkChan)
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/clock"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/transport"
+)
+
+// pingInterval is how often linked peers exchange PING/PONG to estimate
+// mesh time.
+const pingInterval = 15 * time.Second
+
+// Now is mesh time: the local clock corrected by the offset estimated from
+// peers. Message timestamps and anything that expires use it.
+func (g *GossipEngine) Now() time.Time {
+	return g.meshTime.Now()
+}
+
+// ClockOffset is how far mesh time is ahead of the local clock.
+func (g *GossipEngine) ClockOffset() time.Duration {
+	return g.meshTime.Offset()
+}
+
+// TimeStratum is 0 for a designated time source, one more than the best
+// peer's otherwise, or clock.StratumUnsynced with no path to a source.
+func (g *GossipEngine) TimeStratum() int {
+	return g.meshTime.Stratum()
+}
+
+func pingPacket() []byte {
+	payload, _ := json.Marshal(protocol.PingPayload{Sent: time.Now().UnixNano()})
+	data, _ := json.Marshal(protocol.Packet{Type: protocol.TypePing, Payload: payload})
+	return data
+}
+
+func (g *GossipEngine) startTimeSync(ctx context.Context) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.transport.BroadcastTo(pingPacket(), func(addr string) bool {
+				return g.session(addr) != nil
+			})
+		}
+	}
+}
+
+func (g *GossipEngine) handlePing(conn net.Conn, received time.Time, payload []byte) {
+	var ping protocol.PingPayload
+	if err := json.Unmarshal(payload, &ping); err != nil {
+		slog.Error("Failed to unmarshal PING payload", "error", err)
+		return
+	}
+	pong, _ := json.Marshal(protocol.PongPayload{
+		Sent:     ping.Sent,
+		Received: received.UnixNano(),
+		Replied:  g.Now().UnixNano(),
+		Stratum:  g.TimeStratum(),
+	})
+	data, _ := json.Marshal(protocol.Packet{Type: protocol.TypePong, Payload: pong})
+	if err := transport.WriteFrame(conn, data); err != nil {
+		slog.Debug("Failed to send PONG", "remote", conn.RemoteAddr(), "error", err)
+	}
+}
+
+func (g *GossipEngine) handlePong(sess *session, payload []byte) {
+	arrived := time.Now()
+	var pong protocol.PongPayload
+	if err := json.Unmarshal(payload, &pong); err != nil {
+		slog.Error("Failed to unmarshal PONG payload", "error", err)
+		return
+	}
+	// A claimed stratum only counts from a verified peer configured as a
+	// time source; anyone else is just one more clock in the median, since
+	// a single peer claiming a low stratum would otherwise set our offset.
+	stratum := pong.Stratum
+	if !sess.verified || !slices.Contains(g.TimeSources, sess.peerID) {
+		stratum = clock.StratumUnsynced
+	}
+	before := g.ClockOffset()
+	g.meshTime.Add(sess.limitKey(), time.Unix(0, pong.Sent), time.Unix(0, pong.Received), time.Unix(0, pong.Replied), arrived, stratum)
+	if after := g.ClockOffset(); after != before {
+		slog.Debug("Mesh time offset updated", "offset", after, "stratum", g.TimeStratum(), "peer", sess.peerID)
+	}
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/discovery"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// keyVerdict is what checkPeerKeys decided about an announcement's keys.
+type keyVerdict int
+
+const (
+	keyReject  keyVerdict = iota // drop the announcement
+	keyKnown                     // keys match what is pinned
+	keyPin                       // first keys seen for this node; pin them
+	keyRotate                    // proven rotation; pin the new keys
+	keyChanged                   // unproven change; hold for operator review
+)
+
+// checkPeerKeys decides whether a verified announcement may update what we
+// know about a peer. Discovery has already checked the signature against the
+// announced key; here we check that key is the one pinned for this node ID,
+// and that the heartbeat is not a replay. Only a strictly newer counter may
+// move a peer to a new address.
+func (g *GossipEngine) checkPeerKeys(info discovery.PeerInfo, known store.Peer, found bool) keyVerdict {
+	if !found || known.SignKey == "" {
+		// First contact (or a row from before signed heartbeats): trust
+		// the key we are shown.
+		return keyPin
+	}
+	if info.SignKey != known.SignKey || info.PubKey != known.PubKey {
+		rot := info.Rotation
+		if rot != nil && rot.OldSignPub == known.SignKey && rot.NewSignPub == info.SignKey &&
+			rot.NewPubKey == info.PubKey && rot.Verify(info.ID) {
+			slog.Info("Peer rotated its identity key", "peer", info.ID)
+			return keyRotate
+		}
+		return keyChanged
+	}
+	// Copies of one beat arrive over several paths with the same counter;
+	// only an older counter is a replay. mDNS carries no counter.
+	if info.Counter != 0 && info.Counter < known.LastCounter {
+		slog.Warn("Rejecting replayed heartbeat", "peer", info.ID, "counter", info.Counter, "last", known.LastCounter)
+		return keyReject
+	}
+	// A copy of a beat we have already seen, or an mDNS record, could have
+	// been replayed from anywhere, so it does not get to redirect the peer.
+	if info.Addr != known.Addr && info.Counter <= known.LastCounter {
+		slog.Debug("Ignoring announcement from a new address without a newer counter", "peer", info.ID, "addr", info.Addr, "known", known.Addr)
+		return keyReject
+	}
+	return keyKnown
+}
+
+// keyChangeCooldown is how long after a key change is flagged or rejected
+// further unproven changes for the same peer are ignored.
+const keyChangeCooldown = time.Hour
+
+// flagKeyChange holds an unproven key for review. Nothing else about the
+// peer is updated from such an announcement.
+func (g *GossipEngine) flagKeyChange(info discovery.PeerInfo) {
+	flagged, err := store.FlagPeerKeyChange(g.db, info.ID, info.PubKey, info.SignKey, info.Addr, time.Now().Add(-keyChangeCooldown))
+	if err != nil {
+		slog.Error("Failed to record key change", "peer", info.ID, "error", err)
+		return
+	}
+	if flagged {
+		slog.Warn("KEY CHANGED for peer; DMs blocked until reviewed",
+			"peer", info.ID, "nick", info.Nick, "addr", info.Addr, "source", info.Source)
+		g.pushPeers()
+	}
+}
+
+// AcceptPeerKey pins the key a peer changed to, after operator review.
+func (g *GossipEngine) AcceptPeerKey(peerID string) error {
+	if err := store.AcceptPeerKey(g.db, peerID); err != nil {
+		return fmt.Errorf("failed to accept key: %w", err)
+	}
+	slog.Info("Accepted new key for peer", "peer", peerID)
+	g.pushPeers()
+	return nil
+}
+
+// RejectPeerKey keeps the pinned key and ignores the one a peer changed to.
+func (g *GossipEngine) RejectPeerKey(peerID string) error {
+	if err := store.RejectPeerKey(g.db, peerID); err != nil {
+		return fmt.Errorf("failed to reject key: %w", err)
+	}
+	slog.Info("Rejected new key for peer", "peer", peerID)
+	g.pushPeers()
+	return nil
+}
+
+// Fingerprint is this node's key fingerprint, for reading out to peers.
+func (g *GossipEngine) Fingerprint() string {
+	return core.Fingerprint(g.identity.SignPub, g.identity.PubKey)
+}
+
+// FingerprintURI is what this node's fingerprint QR code encodes.
+func (g *GossipEngine) FingerprintURI() string {
+	return core.FingerprintURI(g.nodeID, g.identity.SignPub, g.identity.PubKey)
+}
+
+// VerifyPeer checks a fingerprint the operator typed or scanned against the
+// key pinned for peerID, and marks the peer verified if they match.
+func (g *GossipEngine) VerifyPeer(peerID, fingerprint string) error {
+	scannedID, fp, err := core.ParseFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	if scannedID != "" && scannedID != peerID {
+		return fmt.Errorf("code is for node %s, not %s", scannedID, peerID)
+	}
+	var peer store.Peer
+	if err := g.db.First(&peer, "id = ?", peerID).Error; err != nil {
+		return fmt.Errorf("failed to find peer: %w", err)
+	}
+	if peer.KeyStatus == store.KeyChanged {
+		return fmt.Errorf("key for %s has changed; review it before verifying", peer.Nick)
+	}
+	if fp != core.Fingerprint(peer.SignKey, peer.PubKey) {
+		slog.Warn("Fingerprint mismatch", "peer", peerID)
+		return fmt.Errorf("fingerprint does not match the key pinned for %s", peer.Nick)
+	}
+	return g.SetPeerVerified(peerID, true)
+}
+
+// SetPeerVerified records the operator's verdict after comparing
+// fingerprints by some other means, e.g. side by side on screen.
+func (g *GossipEngine) SetPeerVerified(peerID string, verified bool) error {
+	if err := store.SetPeerVerified(g.db, peerID, verified); err != nil {
+		return err
+	}
+	slog.Info("Peer verification changed", "peer", peerID, "verified", verified)
+	g.pushPeers()
+	return nil
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// A link that sends maxViolations invalid packets within violationWindow is
+// disconnected and its address quarantined like a flooding link.
+const (
+	maxViolations   = 5
+	violationWindow = 10 * time.Minute
+)
+
+// violation is a link's counters plus the current disconnect window.
+type violation struct {
+	protocol.ViolationStat
+	recent      int
+	windowStart time.Time
+}
+
+type violations struct {
+	mu     sync.Mutex
+	byLink map[string]*violation
+}
+
+// recordViolation logs a malformed packet from link and reports whether the
+// link has now offended often enough to be dropped. Out-of-bounds relayed
+// messages (protocol.ErrContent) are not violations.
+func (g *GossipEngine) recordViolation(link string, sess *session, err error) bool {
+	now := time.Now()
+	g.violations.mu.Lock()
+	v := g.violations.byLink[link]
+	if v == nil {
+		v = &violation{ViolationStat: protocol.ViolationStat{Link: link}}
+		g.violations.byLink[link] = v
+	}
+	if sess != nil {
+		v.PeerID = sess.peerID
+	}
+	if now.Sub(v.windowStart) > violationWindow {
+		v.windowStart, v.recent = now, 0
+	}
+	v.Total++
+	v.recent++
+	v.LastReason, v.Last = err.Error(), now
+	drop := v.recent >= maxViolations
+	if drop {
+		v.recent = 0
+	}
+	g.violations.mu.Unlock()
+
+	slog.Warn("Rejected invalid packet", "link", link, "error", err)
+	if sess != nil {
+		g.adjustReputation(sess.identity(), repInvalid, store.RepInvalid)
+	}
+	if drop {
+		g.limits.link.Block(link, linkLimit.Quarantine)
+	}
+	return drop
+}
+
+// Violations returns the per-link protocol violation counters, most first.
+func (g *GossipEngine) Violations() []protocol.ViolationStat {
+	g.violations.mu.Lock()
+	defer g.violations.mu.Unlock()
+	stats := make([]protocol.ViolationStat, 0, len(g.violations.byLink))
+	for _, v := range g.violations.byLink {
+		stats = append(stats, v.ViolationStat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Total > stats[j].Total })
+	return stats
+}
@@ -0,0 +1,127 @@
+// Package pfif encodes the person-finder registry as People Finder
+// Interchange Format 1.4 documents, which agencies' person-finder
+// repositories import.
+package pfif
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// Namespace is the PFIF 1.4 XML namespace.
+const Namespace = "http://zesty.ca/pfif/1.4"
+
+// Domain prefixes the mesh's record IDs, which PFIF wants as
+// "domain/unique-id".
+const Domain = "crisismesh.mesh"
+
+// SourceName is the repository records say they came from.
+const SourceName = "CrisisMesh"
+
+// TimeLayout is PFIF's date-time format, always UTC.
+const TimeLayout = "2006-01-02T15:04:05Z"
+
+// PFIF note statuses.
+const (
+	StatusAlive   = "believed_alive"
+	StatusMissing = "believed_missing"
+	StatusDead    = "believed_dead"
+)
+
+// Document is a <pfif:pfif> document.
+type Document struct {
+	XMLName xml.Name `xml:"http://zesty.ca/pfif/1.4 pfif"`
+	Persons []Person `xml:"person"`
+}
+
+// Person is a PFIF <person> with its notes nested. Only the elements the
+// registry has are modelled, in the order the schema requires.
+type Person struct {
+	PersonRecordID string `xml:"person_record_id"`
+	EntryDate      string `xml:"entry_date"`
+	AuthorName     string `xml:"author_name,omitempty"`
+	SourceName     string `xml:"source_name"`
+	SourceDate     string `xml:"source_date"`
+	FullName       string `xml:"full_name"`
+	Description    string `xml:"description,omitempty"`
+	Notes          []Note `xml:"note"`
+}
+
+// Note is a PFIF <note>: one report about the person.
+type Note struct {
+	NoteRecordID      string `xml:"note_record_id"`
+	PersonRecordID    string `xml:"person_record_id"`
+	EntryDate         string `xml:"entry_date"`
+	AuthorName        string `xml:"author_name"`
+	SourceDate        string `xml:"source_date"`
+	Status            string `xml:"status,omitempty"`
+	LastKnownLocation string `xml:"last_known_location,omitempty"`
+	Text              string `xml:"text"`
+}
+
+// Statuses maps registry statuses to PFIF note statuses; PFIF has no
+// injured, so the note text says so.
+var Statuses = map[string]string{
+	store.PersonSafe:     StatusAlive,
+	store.PersonInjured:  StatusAlive,
+	store.PersonMissing:  StatusMissing,
+	store.PersonDeceased: StatusDead,
+}
+
+// Encode renders a document with the XML declaration.
+func Encode(d *Document) ([]byte, error) {
+	data, err := xml.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PFIF: %w", err)
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// FromPeople builds a document from registry entries, each report becoming
+// a note. now is the export's entry date.
+func FromPeople(people []store.Person, now time.Time) *Document {
+	entry := now.UTC().Format(TimeLayout)
+	d := &Document{}
+	for _, p := range people {
+		id := RecordID(p.ID)
+		out := Person{PersonRecordID: id, EntryDate: entry, SourceName: SourceName,
+			SourceDate: p.Created.UTC().Format(TimeLayout), FullName: p.Name, Description: p.Description}
+		if len(p.Reports) > 0 {
+			out.AuthorName = p.Reports[0].Reporter
+		}
+		if p.Photo != "" {
+			out.Description = strings.TrimSpace(out.Description + "\nPhoto SHA-256: " + p.Photo)
+		}
+		for _, r := range p.Reports {
+			out.Notes = append(out.Notes, Note{NoteRecordID: RecordID(r.ID), PersonRecordID: id, EntryDate: entry,
+				AuthorName: r.Reporter, SourceDate: r.At.UTC().Format(TimeLayout), Status: Statuses[r.Status],
+				LastKnownLocation: location(r), Text: noteText(r)})
+		}
+		d.Persons = append(d.Persons, out)
+	}
+	return d
+}
+
+// RecordID is the PFIF record ID for a mesh ID.
+func RecordID(id string) string {
+	return Domain + "/" + id
+}
+
+func location(r store.PersonUpdate) string {
+	if r.Lat == 0 && r.Long == 0 {
+		return r.Location
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s (%.5f,%.5f)", r.Location, r.Lat, r.Long))
+}
+
+func noteText(r store.PersonUpdate) string {
+	text := "Reported " + r.Status
+	if r.Note != "" {
+		text += ": " + r.Note
+	}
+	return text
+}
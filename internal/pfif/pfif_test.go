@@ -0,0 +1,48 @@
+package pfif
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+func TestFromPeople(t *testing.T) {
+	seen := time.Date(2026, 3, 1, 14, 2, 0, 0, time.UTC)
+	people := []store.Person{{
+		ID: "p-1", Name: "Maria Lopez", Status: store.PersonInjured, Description: "Red jacket",
+		Photo: strings.Repeat("ab", 32), Created: seen,
+		Reports: []store.PersonUpdate{
+			{ID: "r-1", Reporter: "shelter-2", Status: store.PersonMissing, At: seen},
+			{ID: "r-2", Reporter: "medic", Status: store.PersonInjured, Location: "Field hospital",
+				Lat: 51.5, Long: -0.12, Note: "Broken arm", At: seen.Add(time.Hour)},
+		},
+	}}
+	data, err := Encode(FromPeople(people, seen.Add(2*time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Document
+	if err := xml.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Exported PFIF does not decode: %v\n%s", err, data)
+	}
+	p := out.Persons[0]
+	if p.PersonRecordID != "crisismesh.mesh/p-1" || p.FullName != "Maria Lopez" || p.AuthorName != "shelter-2" ||
+		p.SourceDate != "2026-03-01T14:02:00Z" || p.EntryDate != "2026-03-01T16:02:00Z" ||
+		!strings.Contains(p.Description, "Photo SHA-256: abab") {
+		t.Errorf("Unexpected person: %+v", p)
+	}
+	if len(p.Notes) != 2 {
+		t.Fatalf("Expected a note per report, got %+v", p.Notes)
+	}
+	n := p.Notes[1]
+	if n.Status != StatusAlive || n.PersonRecordID != p.PersonRecordID || n.Text != "Reported injured: Broken arm" ||
+		n.LastKnownLocation != "Field hospital (51.50000,-0.12000)" {
+		t.Errorf("Unexpected note: %+v", n)
+	}
+	if p.Notes[0].Status != StatusMissing {
+		t.Errorf("Expected missing to map to %s, got %s", StatusMissing, p.Notes[0].Status)
+	}
+}
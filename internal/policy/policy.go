@@ -0,0 +1,249 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// What a rule's Value is compared against.
+const (
+	MatchID   = "id"
+	MatchNick = "nick"
+	MatchAddr = "addr"
+	MatchCIDR = "cidr"
+)
+
+// Rule allows or denies links to peers matching Value. An "addr" value
+// without a port matches any port on that host.
+type Rule struct {
+	Action string `json:"action"`
+	Match  string `json:"match"`
+	Value  string `json:"value"`
+}
+
+// Peer is what a rule is evaluated against; any field may be empty when not
+// yet known (e.g. an inbound connection before it identifies itself).
+type Peer struct {
+	ID   string
+	Nick string
+	Addr string
+}
+
+type file struct {
+	Default string `json:"default"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Policy is an ordered allow/deny list deciding which peers this node links
+// with. The first matching rule wins; otherwise the default applies.
+type Policy struct {
+	mu       sync.RWMutex
+	def      string
+	rules    []Rule
+	path     string
+	onChange []func()
+}
+
+func New() *Policy {
+	return &Policy{def: ActionAllow}
+}
+
+// Load reads a policy file. A missing file yields an empty allow-all policy
+// that will be created on the first Save.
+func Load(path string) (*Policy, error) {
+	p := New()
+	p.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+	if f.Default != "" {
+		if err := validateAction(f.Default); err != nil {
+			return nil, err
+		}
+		p.def = f.Default
+	}
+	for _, r := range f.Rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	p.rules = f.Rules
+	return p, nil
+}
+
+// Save writes the policy back to the file it was loaded from, if any.
+func (p *Policy) Save() error {
+	p.mu.RLock()
+	path := p.path
+	data, err := json.MarshalIndent(file{Default: p.def, Rules: p.rules}, "", "  ")
+	p.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write policy file: %w", err)
+	}
+	return nil
+}
+
+// OnChange registers fn to run after every runtime modification, so callers
+// can drop links that are no longer allowed.
+func (p *Policy) OnChange(fn func()) {
+	p.mu.Lock()
+	p.onChange = append(p.onChange, fn)
+	p.mu.Unlock()
+}
+
+func (p *Policy) Allows(peer Peer) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, r := range p.rules {
+		if r.matches(peer) {
+			return r.Action == ActionAllow
+		}
+	}
+	return p.def == ActionAllow
+}
+
+func (p *Policy) Default() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.def
+}
+
+func (p *Policy) Rules() []Rule {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]Rule(nil), p.rules...)
+}
+
+func (p *Policy) SetDefault(action string) error {
+	if err := validateAction(action); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.def = action
+	p.mu.Unlock()
+	return p.changed()
+}
+
+// Add inserts r at index, or appends it when index is out of range.
+func (p *Policy) Add(r Rule, index int) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if index < 0 || index >= len(p.rules) {
+		p.rules = append(p.rules, r)
+	} else {
+		p.rules = append(p.rules[:index], append([]Rule{r}, p.rules[index:]...)...)
+	}
+	p.mu.Unlock()
+	return p.changed()
+}
+
+func (p *Policy) Remove(index int) error {
+	p.mu.Lock()
+	if index < 0 || index >= len(p.rules) {
+		p.mu.Unlock()
+		return fmt.Errorf("no rule at index %d", index)
+	}
+	p.rules = append(p.rules[:index], p.rules[index+1:]...)
+	p.mu.Unlock()
+	return p.changed()
+}
+
+func (p *Policy) changed() error {
+	err := p.Save()
+	p.mu.RLock()
+	hooks := append([]func(){}, p.onChange...)
+	p.mu.RUnlock()
+	for _, fn := range hooks {
+		fn()
+	}
+	return err
+}
+
+func (r Rule) Validate() error {
+	if err := validateAction(r.Action); err != nil {
+		return err
+	}
+	if r.Value == "" {
+		return fmt.Errorf("rule value is empty")
+	}
+	switch r.Match {
+	case MatchID, MatchNick, MatchAddr:
+	case MatchCIDR:
+		if _, _, err := net.ParseCIDR(r.Value); err != nil {
+			return fmt.Errorf("invalid CIDR %q: %w", r.Value, err)
+		}
+	default:
+		return fmt.Errorf("unknown match %q (want id, nick, addr or cidr)", r.Match)
+	}
+	return nil
+}
+
+func (r Rule) matches(peer Peer) bool {
+	switch r.Match {
+	case MatchID:
+		return peer.ID != "" && peer.ID == r.Value
+	case MatchNick:
+		return peer.Nick != "" && strings.EqualFold(peer.Nick, r.Value)
+	case MatchAddr:
+		if peer.Addr == "" {
+			return false
+		}
+		if peer.Addr == r.Value {
+			return true
+		}
+		host, _, err := net.SplitHostPort(peer.Addr)
+		return err == nil && host == r.Value
+	case MatchCIDR:
+		ip := hostIP(peer.Addr)
+		if ip == nil {
+			return false
+		}
+		_, ipnet, err := net.ParseCIDR(r.Value)
+		return err == nil && ipnet.Contains(ip)
+	}
+	return false
+}
+
+func hostIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+func validateAction(action string) error {
+	if action != ActionAllow && action != ActionDeny {
+		return fmt.Errorf("unknown action %q (want allow or deny)", action)
+	}
+	return nil
+}
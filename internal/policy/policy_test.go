@@ -0,0 +1,98 @@
+package policy
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFirstMatchWins(t *testing.T) {
+	p := New()
+	if err := p.Add(Rule{Action: ActionAllow, Match: MatchNick, Value: "medic"}, -1); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := p.Add(Rule{Action: ActionDeny, Match: MatchCIDR, Value: "10.0.0.0/8"}, -1); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	if !p.Allows(Peer{Nick: "MEDIC", Addr: "10.1.2.3:9000"}) {
+		t.Error("Expected allow rule for nick to win over later CIDR deny")
+	}
+	if p.Allows(Peer{Nick: "bob", Addr: "10.1.2.3:9000"}) {
+		t.Error("Expected CIDR deny for 10.1.2.3")
+	}
+	if !p.Allows(Peer{Nick: "bob", Addr: "192.168.1.5:9000"}) {
+		t.Error("Expected default allow outside the CIDR")
+	}
+
+	if err := p.SetDefault(ActionDeny); err != nil {
+		t.Fatalf("SetDefault failed: %v", err)
+	}
+	if p.Allows(Peer{Nick: "bob", Addr: "192.168.1.5:9000"}) {
+		t.Error("Expected default deny after SetDefault")
+	}
+}
+
+func TestAddrMatching(t *testing.T) {
+	p := New()
+	p.Add(Rule{Action: ActionDeny, Match: MatchAddr, Value: "127.0.0.1:9003"}, -1)
+	p.Add(Rule{Action: ActionDeny, Match: MatchCIDR, Value: "fe80::/10"}, -1)
+
+	if p.Allows(Peer{Addr: "127.0.0.1:9003"}) {
+		t.Error("Expected exact addr deny")
+	}
+	if !p.Allows(Peer{Addr: "127.0.0.1:9002"}) {
+		t.Error("Expected other port on same host to be allowed")
+	}
+	if p.Allows(Peer{Addr: "[fe80::1%wlan0]:9000"}) {
+		t.Error("Expected zoned link-local addr to match fe80::/10")
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	p := New()
+	bad := []Rule{
+		{Action: "drop", Match: MatchID, Value: "x"},
+		{Action: ActionDeny, Match: "port", Value: "9001"},
+		{Action: ActionDeny, Match: MatchCIDR, Value: "not-a-cidr"},
+		{Action: ActionDeny, Match: MatchID, Value: ""},
+	}
+	for _, r := range bad {
+		if err := p.Add(r, -1); err == nil {
+			t.Errorf("Expected %+v to be rejected", r)
+		}
+	}
+}
+
+func TestLoadSaveRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load of missing file failed: %v", err)
+	}
+	changed := 0
+	p.OnChange(func() { changed++ })
+	if err := p.Add(Rule{Action: ActionDeny, Match: MatchID, Value: "node-x"}, -1); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("Expected OnChange to fire once, fired %d times", changed)
+	}
+
+	p2, err := Load(path)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	rules := p2.Rules()
+	if len(rules) != 1 || rules[0].Value != "node-x" {
+		t.Fatalf("Expected persisted rule, got %+v", rules)
+	}
+	if p2.Allows(Peer{ID: "node-x"}) {
+		t.Error("Expected reloaded policy to deny node-x")
+	}
+	if err := p2.Remove(0); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := p2.Remove(0); err == nil {
+		t.Error("Expected Remove on empty policy to fail")
+	}
+}
@@ -3,16 +3,45 @@ package protocol
 import "github.com/bit2swaz/crisismesh/internal/store"
 
 const (
-	TypeSync = "SYNC"
-	TypeReq  = "REQ"
-	TypeMsg  = "MSG"
+	TypeHello = "HELLO"
+	TypeSync  = "SYNC"
+	TypeReq   = "REQ"
+	TypeMsg   = "MSG"
+	TypePing  = "PING"
+	TypePong  = "PONG"
+	TypeAuth  = "AUTH"
 )
 
 type Packet struct {
 	Type    string `json:"type"`
 	Payload []byte `json:"payload"`
 }
+
+// HelloPayload is the first frame each side sends on a connection. Nothing
+// else is exchanged until both sides know they share a network. NodeID is
+// only a claim until the other side has answered Nonce with an AUTH signed
+// by SignKey.
+type HelloPayload struct {
+	NodeID   string   `json:"node_id"`
+	Networks []string `json:"networks"`
+	SignKey  string   `json:"sign_key,omitempty"`
+	Nonce    string   `json:"nonce,omitempty"`
+}
+
+// AuthPayload answers the other side's HELLO nonce; Sig is over AuthBytes.
+type AuthPayload struct {
+	Sig string `json:"sig"`
+}
+
+// AuthBytes is what signer signs to prove its ID to verifier, who sent
+// nonce in its HELLO.
+func AuthBytes(signer, verifier, nonce string) []byte {
+	return []byte("crisismesh-auth:" + signer + ":" + verifier + ":" + nonce)
+}
+
 type SyncPayload struct {
+	// Network scopes the inventory; empty means the default network.
+	Network    string   `json:"network,omitempty"`
 	MessageIDs []string `json:"message_ids"`
 }
 type ReqPayload struct {
@@ -21,3 +50,19 @@ type ReqPayload struct {
 type MsgPayload struct {
 	Message store.Message `json:"message"`
 }
+
+// PingPayload starts a time exchange; Sent is the sender's local clock in
+// Unix nanoseconds.
+type PingPayload struct {
+	Sent int64 `json:"t1"`
+}
+
+// PongPayload answers a PING with the responder's mesh time when the PING
+// arrived and when the PONG left, and how far the responder is from a
+// trusted time source (see clock.MeshTime).
+type PongPayload struct {
+	Sent     int64 `json:"t1"`
+	Received int64 `json:"t2"`
+	Replied  int64 `json:"t3"`
+	Stratum  int   `json:"stratum"`
+}
@@ -0,0 +1,515 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/clock"
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// Bounds enforced on inbound packets.
+const (
+	// MaxIDs is the most message IDs a SYNC or REQ may carry.
+	MaxIDs = 100
+	// MaxContentLen bounds a message's content on the wire, after
+	// encryption and hex encoding.
+	MaxContentLen = 16 * 1024
+	// MaxTTL is the most hops a message may be given.
+	MaxTTL = 10
+	// MaxPayloadLen bounds a record's structured payload.
+	MaxPayloadLen = 4 * 1024
+	maxIDLen      = 64
+	maxAuthorLen  = 64
+	maxStratum    = 16
+	maxReasonLen  = 256
+	// Alert fields. Text imported from CAP is cut to fit.
+	MaxHeadlineLen     = 140
+	MaxAreaLen         = 200
+	MaxInstructionsLen = 1024
+	maxCapIDLen        = 256
+	// SOS fields.
+	maxPeople    = 10000
+	maxNotesLen  = 512
+	maxAccuracyM = 100000
+	// Incident fields.
+	maxTeamLen = 64
+	// maxRepeats bounds an SOS repeat's count: a week at the longest
+	// back-off is a little over a thousand.
+	maxRepeats = 10000
+	// Check-in intervals.
+	MinCheckIn = time.Minute
+	MaxCheckIn = 7 * 24 * time.Hour
+	// maxAnswers bounds the answers in one roll call reply; with the
+	// longest names that still fits MaxPayloadLen.
+	maxAnswers = 40
+	// Person-finder fields.
+	maxNameLen        = 100
+	maxDescriptionLen = 512
+	// maxFuture is how far ahead of our clock a timestamp may be. Smaller
+	// skews are accepted and flagged (see clock.MaxSkew).
+	maxFuture = 24 * time.Hour
+)
+
+// minTimestamp rejects timestamps from before the project existed, which only
+// come from unset clocks or forged packets.
+var minTimestamp = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ErrContent marks a MSG that is well formed but whose message is out of
+// bounds. Messages are relayed, so the link that delivered one is not the
+// one to blame: it is dropped without counting against the link.
+var ErrContent = errors.New("bad message")
+
+var (
+	idPattern  = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+	keyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	sigPattern = regexp.MustCompile(`^[0-9a-f]{128}$`)
+)
+
+// ViolationStat counts the invalid packets received over one link.
+type ViolationStat struct {
+	Link       string    `json:"link"`
+	PeerID     string    `json:"peer_id,omitempty"`
+	Total      int       `json:"total"`
+	LastReason string    `json:"last_reason"`
+	Last       time.Time `json:"last"`
+}
+
+// Validate decodes a frame strictly (no unknown fields, no trailing data) and
+// checks its payload against the schema for its type. now is used for the
+// timestamp window. A MSG whose message fails ValidateMessage is reported
+// as ErrContent.
+func Validate(data []byte, now time.Time) (Packet, error) {
+	var packet Packet
+	if err := decodeStrict(data, &packet); err != nil {
+		return packet, fmt.Errorf("bad packet: %w", err)
+	}
+	var err error
+	switch packet.Type {
+	case TypeHello:
+		var p HelloPayload
+		if err = decodeStrict(packet.Payload, &p); err == nil {
+			err = p.validate()
+		}
+	case TypeAuth:
+		var p AuthPayload
+		if err = decodeStrict(packet.Payload, &p); err == nil && !sigPattern.MatchString(p.Sig) {
+			err = errors.New("malformed signature")
+		}
+	case TypeSync:
+		var p SyncPayload
+		if err = decodeStrict(packet.Payload, &p); err == nil {
+			err = p.validate()
+		}
+	case TypeReq:
+		var p ReqPayload
+		if err = decodeStrict(packet.Payload, &p); err == nil {
+			err = validateIDs(p.MessageIDs)
+		}
+	case TypeMsg:
+		var p MsgPayload
+		if err = decodeStrict(packet.Payload, &p); err != nil {
+			break
+		}
+		if err := ValidateMessage(p.Message, now); err != nil {
+			return packet, fmt.Errorf("%w: %w", ErrContent, err)
+		}
+	case TypePing:
+		var p PingPayload
+		if err = decodeStrict(packet.Payload, &p); err == nil && p.Sent <= 0 {
+			err = errors.New("missing send time")
+		}
+	case TypePong:
+		var p PongPayload
+		if err = decodeStrict(packet.Payload, &p); err == nil {
+			err = p.validate()
+		}
+	default:
+		return packet, fmt.Errorf("unknown packet type %q", packet.Type)
+	}
+	if err != nil {
+		return packet, fmt.Errorf("bad %s: %w", packet.Type, err)
+	}
+	return packet, nil
+}
+
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("trailing data")
+	}
+	return nil
+}
+
+func (p HelloPayload) validate() error {
+	if err := validateID("node ID", p.NodeID); err != nil {
+		return err
+	}
+	if len(p.Networks) > store.MaxNetworks {
+		return fmt.Errorf("%d networks, at most %d allowed", len(p.Networks), store.MaxNetworks)
+	}
+	if p.SignKey != "" && !keyPattern.MatchString(p.SignKey) {
+		return errors.New("malformed signing key")
+	}
+	if p.Nonce != "" && !keyPattern.MatchString(p.Nonce) {
+		return errors.New("malformed nonce")
+	}
+	_, err := store.ParseNetworks(p.Networks)
+	return err
+}
+
+func (p SyncPayload) validate() error {
+	if p.Network != "" {
+		if _, err := store.ParseNetworks([]string{p.Network}); err != nil {
+			return err
+		}
+	}
+	return validateIDs(p.MessageIDs)
+}
+
+func (p PongPayload) validate() error {
+	if p.Sent <= 0 || p.Received <= 0 || p.Replied <= 0 {
+		return errors.New("missing timestamps")
+	}
+	if p.Stratum < 0 || p.Stratum > maxStratum {
+		return fmt.Errorf("stratum %d out of range", p.Stratum)
+	}
+	return nil
+}
+
+func validateIDs(ids []string) error {
+	if len(ids) > MaxIDs {
+		return fmt.Errorf("%d message IDs, at most %d allowed", len(ids), MaxIDs)
+	}
+	for _, id := range ids {
+		if err := validateID("message ID", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateID(what, id string) error {
+	if id == "" || len(id) > maxIDLen || !idPattern.MatchString(id) {
+		return fmt.Errorf("invalid %s %q", what, truncate(id))
+	}
+	return nil
+}
+
+// ValidateMessage checks the fields a sender controls. Fields the receiver
+// owns (Status, ClockSkew) are overwritten on receipt rather than checked.
+// A record's payload is checked by the Validate function for its kind, which
+// the engine runs both on records it receives and on those it sends.
+func ValidateMessage(m store.Message, now time.Time) error {
+	if err := validateID("message ID", m.ID); err != nil {
+		return err
+	}
+	if err := validateID("sender ID", m.SenderID); err != nil {
+		return err
+	}
+	if m.RecipientID != "" {
+		if err := validateID("recipient ID", m.RecipientID); err != nil {
+			return err
+		}
+	}
+	if len(m.Content) > MaxContentLen {
+		return fmt.Errorf("content of %d bytes, at most %d allowed", len(m.Content), MaxContentLen)
+	}
+	if len(m.Author) > maxAuthorLen {
+		return fmt.Errorf("author of %d bytes, at most %d allowed", len(m.Author), maxAuthorLen)
+	}
+	if m.Priority < 0 || m.Priority > 2 {
+		return fmt.Errorf("priority %d out of range", m.Priority)
+	}
+	if m.TTL < 0 || m.TTL > MaxTTL || m.HopCount < 0 || m.HopCount > MaxTTL {
+		return fmt.Errorf("ttl %d / hop count %d out of range", m.TTL, m.HopCount)
+	}
+	if m.Lat < -90 || m.Lat > 90 || m.Long < -180 || m.Long > 180 {
+		return fmt.Errorf("position %f,%f out of range", m.Lat, m.Long)
+	}
+	if m.Network != "" {
+		if _, err := store.ParseNetworks([]string{m.Network}); err != nil {
+			return err
+		}
+	}
+	if err := validateRecord(m); err != nil {
+		return err
+	}
+	if err := validateTime(time.Unix(m.Timestamp, 0), now); err != nil {
+		return err
+	}
+	if m.HLC != 0 {
+		return validateTime(clock.Physical(m.HLC), now)
+	}
+	return nil
+}
+
+// validateRecord checks the kind of a message and its signature fields:
+// records must be signed, chat may be. Signatures and certificates are
+// verified by the engine.
+func validateRecord(m store.Message) error {
+	if len(m.Payload) > MaxPayloadLen {
+		return fmt.Errorf("payload of %d bytes, at most %d allowed", len(m.Payload), MaxPayloadLen)
+	}
+	signed := m.SignerKey != "" || m.Signature != ""
+	if signed && (!keyPattern.MatchString(m.SignerKey) || !sigPattern.MatchString(m.Signature)) {
+		return errors.New("malformed signature")
+	}
+	if c := m.Cert; c != nil {
+		if !signed {
+			return errors.New("role certificate on an unsigned message")
+		}
+		if err := validateID("certificate node ID", c.NodeID); err != nil {
+			return err
+		}
+		if !keyPattern.MatchString(c.SignPub) || !sigPattern.MatchString(c.Sig) || !slices.Contains(core.Roles, c.Role) {
+			return errors.New("malformed role certificate")
+		}
+	}
+	switch m.Kind {
+	case "":
+		if m.Payload == "" {
+			return nil
+		}
+		// Only SOS details ride on chat messages.
+		var sos store.SOS
+		if err := decodeStrict([]byte(m.Payload), &sos); err != nil {
+			return fmt.Errorf("bad SOS payload: %w", err)
+		}
+		return ValidateSOS(sos)
+	case store.KindModeration, store.KindAlert, store.KindIncident, store.KindSOSRepeat, store.KindCheckIn,
+		store.KindRollCall, store.KindRollCallReply, store.KindPerson:
+	default:
+		return fmt.Errorf("unknown kind %q", truncate(m.Kind))
+	}
+	if m.Payload == "" {
+		return fmt.Errorf("%s record without payload", m.Kind)
+	}
+	if !signed {
+		return fmt.Errorf("unsigned %s record", m.Kind)
+	}
+	return nil
+}
+
+// ValidateModeration checks a moderation action names a known action and a
+// well-formed message or node ID.
+func ValidateModeration(a store.ModerationAction) error {
+	if len(a.Reason) > maxReasonLen {
+		return fmt.Errorf("reason of %d bytes, at most %d allowed", len(a.Reason), maxReasonLen)
+	}
+	switch a.Action {
+	case store.ModHide, store.ModUnhide:
+		return validateID("message ID", a.Target)
+	case store.ModBlock, store.ModUnblock:
+		return validateID("node ID", a.Target)
+	}
+	return fmt.Errorf("unknown moderation action %q", truncate(a.Action))
+}
+
+// ValidateAlert checks an alert or cancellation. A cancellation only needs
+// the alert it withdraws; an alert needs every field within bounds.
+func ValidateAlert(a store.Alert) error {
+	if a.Expires <= 0 {
+		return errors.New("alert without expiry")
+	}
+	if len(a.CapID) > maxCapIDLen {
+		return fmt.Errorf("CAP identifier of %d bytes, at most %d allowed", len(a.CapID), maxCapIDLen)
+	}
+	if a.Cancels != "" {
+		return validateID("cancelled alert ID", a.Cancels)
+	}
+	if a.Headline == "" || len(a.Headline) > MaxHeadlineLen {
+		return fmt.Errorf("headline must be 1-%d bytes", MaxHeadlineLen)
+	}
+	if !slices.Contains(store.Severities, a.Severity) {
+		return fmt.Errorf("severity must be one of %v", store.Severities)
+	}
+	if !slices.Contains(store.Urgencies, a.Urgency) {
+		return fmt.Errorf("urgency must be one of %v", store.Urgencies)
+	}
+	if a.Area == "" || len(a.Area) > MaxAreaLen {
+		return fmt.Errorf("area must be 1-%d bytes", MaxAreaLen)
+	}
+	if len(a.Instructions) > MaxInstructionsLen {
+		return fmt.Errorf("instructions of %d bytes, at most %d allowed", len(a.Instructions), MaxInstructionsLen)
+	}
+	return nil
+}
+
+// ValidateSOS checks the structured details carried in a distress
+// message's payload.
+func ValidateSOS(s store.SOS) error {
+	if !slices.Contains(store.EmergencyTypes, s.Type) {
+		return fmt.Errorf("emergency type must be one of %v", store.EmergencyTypes)
+	}
+	if s.People < 0 || s.People > maxPeople || s.Injured < 0 || s.Injured > maxPeople {
+		return fmt.Errorf("people and injured must be 0-%d", maxPeople)
+	}
+	if s.People > 0 && s.Injured > s.People {
+		return fmt.Errorf("%d injured of %d people", s.Injured, s.People)
+	}
+	if s.Mobility != "" && !slices.Contains(store.Mobilities, s.Mobility) {
+		return fmt.Errorf("mobility must be one of %v", store.Mobilities)
+	}
+	if len(s.Notes) > maxNotesLen {
+		return fmt.Errorf("notes of %d bytes, at most %d allowed", len(s.Notes), maxNotesLen)
+	}
+	if s.Accuracy < 0 || s.Accuracy > maxAccuracyM {
+		return fmt.Errorf("GPS accuracy must be 0-%d m", maxAccuracyM)
+	}
+	return nil
+}
+
+// ValidateIncident checks an incident update moves the incident to a later
+// state.
+func ValidateIncident(u store.IncidentUpdate) error {
+	if err := validateID("incident ID", u.Incident); err != nil {
+		return err
+	}
+	// Incidents open with the SOS; updates only move them on.
+	if u.State == store.IncidentOpen || !slices.Contains(store.IncidentStates, u.State) {
+		return fmt.Errorf("state must be one of %v", store.IncidentStates[1:])
+	}
+	if len(u.Team) > maxTeamLen {
+		return fmt.Errorf("team of %d bytes, at most %d allowed", len(u.Team), maxTeamLen)
+	}
+	if len(u.Note) > maxReasonLen {
+		return fmt.Errorf("note of %d bytes, at most %d allowed", len(u.Note), maxReasonLen)
+	}
+	return nil
+}
+
+// ValidateSOSRepeat checks an SOS repeat's count and the fix and battery
+// level it reports.
+func ValidateSOSRepeat(r store.SOSRepeat) error {
+	if err := validateID("SOS ID", r.SOS); err != nil {
+		return err
+	}
+	if r.Count < 1 || r.Count > maxRepeats {
+		return fmt.Errorf("repeat count %d out of range", r.Count)
+	}
+	if r.Lat < -90 || r.Lat > 90 || r.Long < -180 || r.Long > 180 {
+		return fmt.Errorf("position %f,%f out of range", r.Lat, r.Long)
+	}
+	if r.Accuracy < 0 || r.Accuracy > maxAccuracyM {
+		return fmt.Errorf("GPS accuracy must be 0-%d m", maxAccuracyM)
+	}
+	if r.Battery != nil && (*r.Battery < 0 || *r.Battery > 100) {
+		return fmt.Errorf("battery %d%% out of range", *r.Battery)
+	}
+	return nil
+}
+
+// ValidateCheckIn checks an armed check-in's interval is within
+// MinCheckIn and MaxCheckIn; a disarming one needs no interval.
+func ValidateCheckIn(c store.CheckIn) error {
+	if !c.Armed() {
+		if c.Interval < 0 {
+			return fmt.Errorf("check-in interval %ds out of range", c.Interval)
+		}
+		return nil
+	}
+	if c.Interval < int64(MinCheckIn/time.Second) || c.Interval > int64(MaxCheckIn/time.Second) {
+		return fmt.Errorf("check-in interval must be between %s and %s", MinCheckIn, MaxCheckIn)
+	}
+	if c.SOS != "" {
+		return validateID("SOS ID", c.SOS)
+	}
+	return nil
+}
+
+// ValidateRollCall checks a roll call's note.
+func ValidateRollCall(rc store.RollCall) error {
+	if len(rc.Note) > maxReasonLen {
+		return fmt.Errorf("note of %d bytes, at most %d allowed", len(rc.Note), maxReasonLen)
+	}
+	return nil
+}
+
+// ValidateRollCallReply checks a reply names a roll call and answers for
+// at most maxAnswers people.
+func ValidateRollCallReply(r store.RollCallReply) error {
+	if err := validateID("roll call ID", r.RollCall); err != nil {
+		return err
+	}
+	if len(r.Answers) == 0 || len(r.Answers) > maxAnswers {
+		return fmt.Errorf("%d answers, 1-%d allowed", len(r.Answers), maxAnswers)
+	}
+	for _, a := range r.Answers {
+		if len(a.Name) > maxAuthorLen {
+			return fmt.Errorf("name of %d bytes, at most %d allowed", len(a.Name), maxAuthorLen)
+		}
+		if !slices.Contains(store.RollCallStatuses, a.Status) {
+			return fmt.Errorf("answer must be one of %v", store.RollCallStatuses)
+		}
+	}
+	return nil
+}
+
+// ValidatePersonReport checks a person-finder report; Person is empty for
+// the report that opens an entry.
+func ValidatePersonReport(p store.PersonReport) error {
+	if p.Person != "" {
+		if err := validateID("person ID", p.Person); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(p.Name) == "" || len(p.Name) > maxNameLen {
+		return fmt.Errorf("name must be 1-%d bytes", maxNameLen)
+	}
+	if !slices.Contains(store.PersonStatuses, p.Status) {
+		return fmt.Errorf("status must be one of %v", store.PersonStatuses)
+	}
+	if len(p.Description) > maxDescriptionLen {
+		return fmt.Errorf("description of %d bytes, at most %d allowed", len(p.Description), maxDescriptionLen)
+	}
+	if len(p.Location) > MaxAreaLen {
+		return fmt.Errorf("location of %d bytes, at most %d allowed", len(p.Location), MaxAreaLen)
+	}
+	if p.Lat < -90 || p.Lat > 90 || p.Long < -180 || p.Long > 180 {
+		return fmt.Errorf("position %f,%f out of range", p.Lat, p.Long)
+	}
+	if p.Photo != "" && !keyPattern.MatchString(p.Photo) {
+		return errors.New("photo must be a lowercase hex SHA-256")
+	}
+	if len(p.Reporter) > maxAuthorLen {
+		return fmt.Errorf("reporter of %d bytes, at most %d allowed", len(p.Reporter), maxAuthorLen)
+	}
+	if len(p.Note) > maxNotesLen {
+		return fmt.Errorf("note of %d bytes, at most %d allowed", len(p.Note), maxNotesLen)
+	}
+	return nil
+}
+
+// ValidateWebUser checks a name a web client registers for roll calls.
+func ValidateWebUser(name string) error {
+	if strings.TrimSpace(name) == "" || len(name) > maxAuthorLen {
+		return fmt.Errorf("name must be 1-%d bytes", maxAuthorLen)
+	}
+	return nil
+}
+
+func validateTime(t, now time.Time) error {
+	if t.Before(minTimestamp) || t.After(now.Add(maxFuture)) {
+		return fmt.Errorf("timestamp %s outside accepted window", t.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func truncate(s string) string {
+	if len(s) > 16 {
+		return s[:16] + "..."
+	}
+	return s
+}
@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/bit2swaz/crisismesh/internal/transport"
+)
+
+func packet(t *testing.T, typ string, payload any) []byte {
+	t.Helper()
+	p, _ := json.Marshal(payload)
+	data, _ := json.Marshal(Packet{Type: typ, Payload: p})
+	return data
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	good := store.Message{ID: "a1b2c3d4e5f60718", SenderID: "node-1", Content: "hello", Timestamp: now.Unix(), TTL: 10}
+
+	sos := good
+	sos.Priority, sos.Payload = 2, `{"type":"trapped","people":3,"injured":1,"mobility":"immobile","accuracy":12.5}`
+	valid := map[string][]byte{
+		"hello": packet(t, TypeHello, HelloPayload{NodeID: "node-1", Networks: []string{"ops"}}),
+		"sync":  packet(t, TypeSync, SyncPayload{MessageIDs: []string{"a1", "b2"}}),
+		"msg":   packet(t, TypeMsg, MsgPayload{Message: good}),
+		"sos":   packet(t, TypeMsg, MsgPayload{Message: sos}),
+		"pong":  packet(t, TypePong, PongPayload{Sent: 1, Received: 2, Replied: 3, Stratum: 1}),
+		"auth":  packet(t, TypeAuth, AuthPayload{Sig: strings.Repeat("ab", 64)}),
+	}
+	for name, data := range valid {
+		if _, err := Validate(data, now); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+
+	msg := func(edit func(*store.Message)) []byte {
+		m := good
+		edit(&m)
+		return packet(t, TypeMsg, MsgPayload{Message: m})
+	}
+	invalid := map[string][]byte{
+		"not json":        []byte("{"),
+		"unknown type":    packet(t, "BOOM", struct{}{}),
+		"unknown field":   []byte(`{"type":"SYNC","payload":"e30=","extra":1}`),
+		"trailing data":   append(packet(t, TypeReq, ReqPayload{}), []byte(" {}")...),
+		"too many IDs":    packet(t, TypeReq, ReqPayload{MessageIDs: make([]string, MaxIDs+1)}),
+		"bad ID":          packet(t, TypeSync, SyncPayload{MessageIDs: []string{"'; DROP TABLE"}}),
+		"bad network":     packet(t, TypeHello, HelloPayload{NodeID: "node-1", Networks: []string{"Bad Net!"}}),
+		"huge content":    msg(func(m *store.Message) { m.Content = strings.Repeat("x", MaxContentLen+1) }),
+		"priority":        msg(func(m *store.Message) { m.Priority = 99 }),
+		"hop count":       msg(func(m *store.Message) { m.HopCount = -1 }),
+		"latitude":        msg(func(m *store.Message) { m.Lat = 200 }),
+		"far future":      msg(func(m *store.Message) { m.Timestamp = now.Add(48 * time.Hour).Unix() }),
+		"epoch":           msg(func(m *store.Message) { m.Timestamp = 0 }),
+		"missing sender":  msg(func(m *store.Message) { m.SenderID = "" }),
+		"unknown kind":    msg(func(m *store.Message) { m.Kind = "exploit"; m.Payload = "{}" }),
+		"unsigned record": msg(func(m *store.Message) { m.Kind = store.KindModeration; m.Payload = "{}" }),
+		"chat payload":    msg(func(m *store.Message) { m.Payload = "{}" }),
+		"sos field":       msg(func(m *store.Message) { m.Payload = `{"type":"fire","exploit":1}` }),
+		"sos injured":     msg(func(m *store.Message) { m.Payload = `{"type":"fire","people":1,"injured":2}` }),
+		"unsigned cert":   msg(func(m *store.Message) { m.Cert = &core.RoleCert{NodeID: "node-1", Role: core.RoleCommander} }),
+		"stratum":         packet(t, TypePong, PongPayload{Sent: 1, Received: 2, Replied: 3, Stratum: 99}),
+		"hello nonce":     packet(t, TypeHello, HelloPayload{NodeID: "node-1", Nonce: "short"}),
+		"auth signature":  packet(t, TypeAuth, AuthPayload{Sig: "zz"}),
+	}
+	for name, data := range invalid {
+		if _, err := Validate(data, now); err == nil {
+			t.Errorf("%s: expected packet to be rejected", name)
+		}
+	}
+	if _, err := Validate(invalid["priority"], now); !errors.Is(err, ErrContent) {
+		t.Errorf("Expected an out-of-bounds message to be a content error, got %v", err)
+	}
+	if _, err := Validate(invalid["unknown field"], now); errors.Is(err, ErrContent) {
+		t.Error("Expected a malformed packet not to be a content error")
+	}
+}
+
+// TestMaxFrameFitsMessage checks that the largest message ValidateMessage
+// accepts still fits in a frame, with every byte JSON-escaped.
+func TestMaxFrameFitsMessage(t *testing.T) {
+	wide := func(n int) string { return strings.Repeat("<", n) }
+	m := store.Message{
+		ID: wide(maxIDLen), SenderID: wide(maxIDLen), RecipientID: wide(maxIDLen),
+		Content: wide(MaxContentLen), Payload: wide(MaxPayloadLen), Author: wide(maxAuthorLen),
+		Priority: 2, Lat: -89.999999, Long: -179.999999, Timestamp: math.MaxInt64, HLC: math.MaxInt64,
+		TTL: MaxTTL, HopCount: MaxTTL, Status: wide(maxIDLen), Network: wide(maxIDLen), Kind: wide(maxIDLen), Role: wide(maxIDLen),
+		SignerKey: strings.Repeat("a", 64), Signature: strings.Repeat("a", 128),
+		Cert: &core.RoleCert{NodeID: wide(maxIDLen), SignPub: strings.Repeat("a", 64), Role: wide(maxIDLen), Issued: math.MaxInt64, Expires: math.MaxInt64, Sig: strings.Repeat("a", 128)},
+	}
+	if data := packet(t, TypeMsg, MsgPayload{Message: m}); len(data) > transport.MaxFrameSize {
+		t.Errorf("Largest MSG is %d bytes, over the %d byte frame limit", len(data), transport.MaxFrameSize)
+	}
+}
+
+func TestValidateAlert(t *testing.T) {
+	good := store.Alert{Headline: "Evacuate sector B now", Severity: "extreme", Urgency: "immediate", Area: "Sector B", Expires: 1}
+	if err := ValidateAlert(good); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := ValidateAlert(store.Alert{Cancels: "a1b2", Expires: 1}); err != nil {
+		t.Errorf("Unexpected error for cancellation %v", err)
+	}
+	bad := map[string]func(*store.Alert){
+		"no expiry": func(a *store.Alert) { a.Expires = 0 },
+		"severity":  func(a *store.Alert) { a.Severity = "apocalyptic" },
+		"urgency":   func(a *store.Alert) { a.Urgency = "" },
+		"no area":   func(a *store.Alert) { a.Area = "" },
+		"headline":  func(a *store.Alert) { a.Headline = strings.Repeat("x", 200) },
+	}
+	for name, edit := range bad {
+		a := good
+		edit(&a)
+		if err := ValidateAlert(a); err == nil {
+			t.Errorf("%s: expected alert to be rejected", name)
+		}
+	}
+}
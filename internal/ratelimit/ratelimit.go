@@ -0,0 +1,155 @@
+// Package ratelimit provides keyed token buckets that quarantine keys which
+// keep exceeding their limit.
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config describes one limiter. A key may make Burst requests at once and
+// then Rate per second. A key dropped Strikes times within Window is
+// quarantined, and every request from it refused, for Quarantine.
+type Config struct {
+	Rate       float64
+	Burst      int
+	Strikes    int
+	Window     time.Duration
+	Quarantine time.Duration
+}
+
+// maxIdle is how long a full, unquarantined bucket is kept after its last
+// request; older ones are pruned so the key space stays bounded.
+const maxIdle = 10 * time.Minute
+
+type bucket struct {
+	tokens      float64
+	last        time.Time
+	strikes     int
+	windowStart time.Time
+	until       time.Time
+	allowed     uint64
+	dropped     uint64
+}
+
+// Stat is one key's counters.
+type Stat struct {
+	Key              string    `json:"key"`
+	Allowed          uint64    `json:"allowed"`
+	Dropped          uint64    `json:"dropped"`
+	QuarantinedUntil time.Time `json:"quarantined_until,omitempty"`
+}
+
+type Limiter struct {
+	cfg     Config
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+	// OnQuarantine, if set, is called (without the lock held) when a key
+	// is quarantined.
+	OnQuarantine func(key string, until time.Time)
+}
+
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow takes a token for key, reporting whether the request may proceed.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	now := l.now()
+	b := l.buckets[key]
+	if b == nil {
+		l.prune(now)
+		b = &bucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[key] = b
+	}
+	if now.Before(b.until) {
+		b.dropped++
+		l.mu.Unlock()
+		return false
+	}
+	b.tokens = min(float64(l.cfg.Burst), b.tokens+now.Sub(b.last).Seconds()*l.cfg.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.allowed++
+		l.mu.Unlock()
+		return true
+	}
+	b.dropped++
+	if now.Sub(b.windowStart) > l.cfg.Window {
+		b.windowStart, b.strikes = now, 0
+	}
+	b.strikes++
+	var quarantined bool
+	if l.cfg.Strikes > 0 && b.strikes >= l.cfg.Strikes {
+		b.until, b.strikes = now.Add(l.cfg.Quarantine), 0
+		quarantined = true
+	}
+	until := b.until
+	l.mu.Unlock()
+	if quarantined && l.OnQuarantine != nil {
+		l.OnQuarantine(key, until)
+	}
+	return false
+}
+
+// Block quarantines key for d regardless of its bucket, for offences
+// detected elsewhere.
+func (l *Limiter) Block(key string, d time.Duration) {
+	l.mu.Lock()
+	now := l.now()
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.until = now.Add(d)
+	until := b.until
+	l.mu.Unlock()
+	if l.OnQuarantine != nil {
+		l.OnQuarantine(key, until)
+	}
+}
+
+// Quarantined reports whether key is currently refused outright.
+func (l *Limiter) Quarantined(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	return b != nil && l.now().Before(b.until)
+}
+
+// Stats returns every tracked key's counters, most dropped first.
+func (l *Limiter) Stats() []Stat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	stats := make([]Stat, 0, len(l.buckets))
+	for key, b := range l.buckets {
+		s := Stat{Key: key, Allowed: b.allowed, Dropped: b.dropped}
+		if now.Before(b.until) {
+			s.QuarantinedUntil = b.until
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Dropped != stats[j].Dropped {
+			return stats[i].Dropped > stats[j].Dropped
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	return stats
+}
+
+// prune drops buckets that have been idle long enough to have refilled and
+// are not quarantined. Called with the lock held.
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > maxIdle && !now.Before(b.until) {
+			delete(l.buckets, key)
+		}
+	}
+}
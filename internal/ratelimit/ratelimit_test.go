@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New(Config{Rate: 1, Burst: 3, Strikes: 3, Window: time.Minute, Quarantine: 5 * time.Minute})
+	l.now = func() time.Time { return now }
+	var quarantined string
+	l.OnQuarantine = func(key string, until time.Time) { quarantined = key }
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("Request %d within burst was refused", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Error("Expected request over burst to be refused")
+	}
+	if !l.Allow("b") {
+		t.Error("Keys must not share a bucket")
+	}
+	now = now.Add(time.Second)
+	if !l.Allow("a") {
+		t.Error("Expected a token after refill")
+	}
+
+	l.Allow("a")
+	l.Allow("a")
+	if quarantined != "a" || !l.Quarantined("a") {
+		t.Fatal("Expected repeat offender to be quarantined")
+	}
+	now = now.Add(time.Minute)
+	if l.Allow("a") {
+		t.Error("Expected quarantined key to be refused even with tokens")
+	}
+	now = now.Add(5 * time.Minute)
+	if !l.Allow("a") {
+		t.Error("Expected quarantine to expire")
+	}
+
+	stats := l.Stats()
+	if len(stats) != 2 || stats[0].Key != "a" || stats[0].Dropped != 4 || stats[0].Allowed != 5 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
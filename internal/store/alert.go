@@ -0,0 +1,77 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"gorm.io/gorm"
+)
+
+// KindAlert records are official alerts, signed by a node whose role may
+// issue them.
+const KindAlert = "alert"
+
+// Alert severities and urgencies, as in CAP 1.2, most pressing first.
+var (
+	Severities = []string{"extreme", "severe", "moderate", "minor"}
+	Urgencies  = []string{"immediate", "expected", "future"}
+)
+
+// Alert is the payload of a KindAlert record.
+type Alert struct {
+	Headline     string `json:"headline"`
+	Severity     string `json:"severity"`
+	Urgency      string `json:"urgency"`
+	Area         string `json:"area"`
+	Instructions string `json:"instructions,omitempty"`
+	// Expires is when the alert stops being shown, in Unix seconds.
+	Expires int64 `json:"expires"`
+	// Cancels is set on a cancellation, to the ID of the alert it withdraws.
+	Cancels string `json:"cancels,omitempty"`
+	// CapID is the identifier of the CAP document the alert was imported
+	// from, kept so exports and cancellations refer to the original.
+	CapID string `json:"cap_id,omitempty"`
+}
+
+// ActiveAlert is an alert in force, with who issued it.
+type ActiveAlert struct {
+	ID       string    `json:"id"`
+	IssuerID string    `json:"issuer_id"`
+	Issuer   string    `json:"issuer"`
+	Role     string    `json:"role"`
+	Sent     time.Time `json:"sent"`
+	Alert
+}
+
+// ActiveAlerts returns the alerts that have neither expired nor been
+// cancelled at now, most severe first. Only alerts whose sender's role may
+// issue them are considered.
+func ActiveAlerts(db *gorm.DB, now time.Time) ([]ActiveAlert, error) {
+	var msgs []Message
+	if err := db.Where("kind = ?", KindAlert).Order("hlc desc").Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	cancelled := make(map[string]bool)
+	var alerts []ActiveAlert
+	for _, m := range msgs {
+		var a Alert
+		if json.Unmarshal([]byte(m.Payload), &a) != nil || !core.RoleAllows(m.Role, core.PermAlert) {
+			continue
+		}
+		if a.Cancels != "" {
+			cancelled[a.Cancels] = true
+			continue
+		}
+		if now.Unix() >= a.Expires {
+			continue
+		}
+		alerts = append(alerts, ActiveAlert{ID: m.ID, IssuerID: m.SenderID, Issuer: m.Author, Role: m.Role, Sent: time.Unix(m.Timestamp, 0), Alert: a})
+	}
+	alerts = slices.DeleteFunc(alerts, func(a ActiveAlert) bool { return cancelled[a.ID] })
+	slices.SortStableFunc(alerts, func(a, b ActiveAlert) int {
+		return slices.Index(Severities, a.Severity) - slices.Index(Severities, b.Severity)
+	})
+	return alerts, nil
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KindCheckIn records a node's dead-man's switch: how often its operator
+// must check in and when the next check-in is due. Each check-in sends a
+// new one, so the latest record from a node is its switch's state.
+const KindCheckIn = "checkin"
+
+// CheckIn is the body of a check-in record. An Interval of zero means the
+// switch was turned off. Missed is set once the node gave up waiting and
+// sent SOS.
+type CheckIn struct {
+	// Interval is in seconds and Due a Unix time, as on the wire.
+	Interval int64  `json:"interval"`
+	Due      int64  `json:"due"`
+	Missed   bool   `json:"missed,omitempty"`
+	SOS      string `json:"sos,omitempty"`
+}
+
+// Armed reports whether the switch is on.
+func (c CheckIn) Armed() bool {
+	return c.Interval > 0
+}
+
+// CheckInStatus is where a node's switch stands, for the check-in board.
+type CheckInStatus struct {
+	NodeID   string    `json:"node_id"`
+	Nick     string    `json:"nick"`
+	Interval int64     `json:"interval"`
+	Last     time.Time `json:"last"`
+	Due      time.Time `json:"due"`
+	Overdue  bool      `json:"overdue"`
+	Missed   bool      `json:"missed"`
+	SOS      string    `json:"sos,omitempty"`
+	Lat      float64   `json:"lat"`
+	Long     float64   `json:"long"`
+}
+
+// LatestCheckIn returns the latest check-in record nodeID signed with
+// signKey, if any.
+func LatestCheckIn(db *gorm.DB, nodeID, signKey string) (CheckIn, Message, bool) {
+	var msg Message
+	err := db.Where("kind = ? AND sender_id = ? AND signer_key = ?", KindCheckIn, nodeID, signKey).Order("hlc desc").First(&msg).Error
+	if err != nil {
+		return CheckIn{}, msg, false
+	}
+	var c CheckIn
+	if json.Unmarshal([]byte(msg.Payload), &c) != nil {
+		return CheckIn{}, msg, false
+	}
+	return c, msg, true
+}
+
+// CheckIns returns every node whose switch is on as of now, overdue ones
+// first, then by when they are due. Only verified records signed with the
+// key bound to their SenderID count, so nobody can arm or disarm another
+// node's switch on the board. Positions are the last the node reported in
+// a heartbeat.
+func CheckIns(db *gorm.DB, now time.Time) ([]CheckInStatus, error) {
+	var records []Message
+	if err := db.Where("kind = ? AND verified = ?", KindCheckIn, true).Order("hlc").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	latest := make(map[string]Message)
+	for _, r := range boundRecords(db, records) {
+		latest[r.SenderID] = r
+	}
+	var out []CheckInStatus
+	for id, r := range latest {
+		var c CheckIn
+		if json.Unmarshal([]byte(r.Payload), &c) != nil || !c.Armed() {
+			continue
+		}
+		due := time.Unix(c.Due, 0)
+		s := CheckInStatus{NodeID: id, Nick: r.Author, Interval: c.Interval, Last: time.Unix(r.Timestamp, 0),
+			Due: due, Overdue: now.After(due), Missed: c.Missed, SOS: c.SOS}
+		var peer Peer
+		if db.First(&peer, "id = ?", id).Error == nil {
+			s.Lat, s.Long = peer.Lat, peer.Long
+		}
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b CheckInStatus) int {
+		if a.Overdue != b.Overdue {
+			return boolRank(b.Overdue) - boolRank(a.Overdue)
+		}
+		return a.Due.Compare(b.Due)
+	})
+	return out, nil
+}
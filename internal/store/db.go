@@ -1,6 +1,10 @@
 package store
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/clock"
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -26,31 +30,423 @@ func Init(path string) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	if err := db.AutoMigrate(&Peer{}, &Message{}); err != nil {
+	if err := db.AutoMigrate(&Peer{}, &Message{}, &PeerSighting{}, &PeerKeyHistory{}, &PeerReputation{}, &Moderation{}, &WebUser{}, &SignerPin{}); err != nil {
+		return nil, err
+	}
+	// Messages from before network IDs belong to the default mesh.
+	if err := db.Model(&Message{}).Where("network = '' OR network IS NULL").Update("network", DefaultNetwork).Error; err != nil {
+		return nil, err
+	}
+	// Messages from before records are chat and not hidden.
+	if err := db.Exec("UPDATE messages SET kind = '' WHERE kind IS NULL").Error; err != nil {
+		return nil, err
+	}
+	if err := db.Exec("UPDATE messages SET hidden = false WHERE hidden IS NULL").Error; err != nil {
+		return nil, err
+	}
+	// Messages from before signer binding are not known to be verified.
+	if err := db.Exec("UPDATE messages SET verified = false WHERE verified IS NULL").Error; err != nil {
+		return nil, err
+	}
+	// Peers from before the failure detector have no state; give them the
+	// one their IsActive implies so state filters do not drop them.
+	if err := db.Exec("UPDATE peers SET state = CASE WHEN is_active THEN 'alive' ELSE 'dead' END WHERE state IS NULL OR state = ''").Error; err != nil {
+		return nil, err
+	}
+	// Messages from before HLCs are ordered by their wall-clock time.
+	if err := db.Exec("UPDATE messages SET hlc = (timestamp * 1000) << 16 WHERE hlc = 0 OR hlc IS NULL").Error; err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
+// Open opens the database of a node that may be running, for the CLI. It
+// skips the VACUUM and migrations Init does, which would lock out or
+// rewrite the live node's database; with readOnly set SQLite refuses any
+// write.
+func Open(path string, readOnly bool) (*gorm.DB, error) {
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)"
+	if readOnly {
+		dsn += "&mode=ro"
+	}
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return db, nil
+}
+
 func Vacuum(db *gorm.DB) error {
 	return db.Exec("VACUUM").Error
 }
 func SaveMessage(db *gorm.DB, msg *Message) error {
+	if msg.Network == "" {
+		msg.Network = DefaultNetwork
+	}
+	if msg.HLC == 0 {
+		msg.HLC = clock.FromUnix(msg.Timestamp)
+	}
 	return db.Create(msg).Error
 }
+
+// HasMessage reports whether a message with id is already stored.
+func HasMessage(db *gorm.DB, id string) bool {
+	var n int64
+	db.Model(&Message{}).Where("id = ?", id).Count(&n)
+	return n > 0
+}
+
 func GetMessages(db *gorm.DB, limit int) ([]Message, error) {
 	var messages []Message
-	result := db.Order("timestamp desc").Limit(limit).Find(&messages)
+	result := db.Order("hlc desc").Limit(limit).Find(&messages)
 	return messages, result.Error
 }
+
+// MaxHLC is the highest HLC timestamp stored, or 0 if there are no messages.
+func MaxHLC(db *gorm.DB) (int64, error) {
+	var hlc int64
+	err := db.Model(&Message{}).Select("COALESCE(MAX(hlc), 0)").Scan(&hlc).Error
+	return hlc, err
+}
+
+// GetNetworkMessages is GetMessages restricted to one mesh, leaving out
+// hidden messages so they are not relayed.
+func GetNetworkMessages(db *gorm.DB, network string, limit int) ([]Message, error) {
+	var messages []Message
+	result := db.Where("network = ? AND hidden = ?", network, false).Order("hlc desc").Limit(limit).Find(&messages)
+	return messages, result.Error
+}
+
+// GetChatMessages is what the UIs show: chat messages, not records, that
+// moderation has not hidden.
+func GetChatMessages(db *gorm.DB, limit int) ([]Message, error) {
+	var messages []Message
+	result := db.Where("kind = '' AND hidden = ?", false).Order("hlc desc").Limit(limit).Find(&messages)
+	return messages, result.Error
+}
+
+// UpsertPeer records a sighting of peer. Keys are only written when the peer
+// is first inserted; after that they change through SetPeerKey and the
+// review functions below, never as a side effect of discovery.
 func UpsertPeer(db *gorm.DB, peer Peer) error {
 	return db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "id"}},
-		UpdateAll: true,
+		Columns: []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"nick", "addr", "last_seen", "is_active", "state", "last_counter",
+			"battery", "lat", "long", "role", "queue_depth", "networks"}),
 	}).Create(&peer).Error
 }
+
+// SetPeerKey pins a peer's keys, logging event (pinned or rotated) to the
+// key history.
+func SetPeerKey(db *gorm.DB, peerID, pubKey, signKey, addr, event string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Peer{}).Where("id = ?", peerID).Updates(map[string]interface{}{
+			"pub_key": pubKey, "sign_key": signKey, "key_status": KeyPinned,
+			"pending_pub_key": "", "pending_sign_key": "",
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&PeerKeyHistory{PeerID: peerID, Event: event, PubKey: pubKey, SignKey: signKey, Addr: addr, At: time.Now()}).Error
+	})
+}
+
+// BindSigner reports whether signKey is the key pinned for nodeID: the
+// heartbeat key if we have one, else the first key seen on its records,
+// which is pinned here if there is none yet.
+func BindSigner(db *gorm.DB, nodeID, signKey string, now time.Time) (bool, error) {
+	var peer Peer
+	if err := db.Select("sign_key").First(&peer, "id = ?", nodeID).Error; err == nil && peer.SignKey != "" {
+		return peer.SignKey == signKey, nil
+	}
+	pin := SignerPin{NodeID: nodeID, SignKey: signKey, At: now}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&pin).Error; err != nil {
+		return false, err
+	}
+	if err := db.First(&pin, "node_id = ?", nodeID).Error; err != nil {
+		return false, err
+	}
+	return pin.SignKey == signKey, nil
+}
+
+// boundSigner returns the key pinned for nodeID, as BindSigner would check
+// it, without pinning one; "" if none is.
+func boundSigner(db *gorm.DB, nodeID string) string {
+	var peer Peer
+	if db.Select("sign_key").First(&peer, "id = ?", nodeID).Error == nil && peer.SignKey != "" {
+		return peer.SignKey
+	}
+	var pin SignerPin
+	if db.First(&pin, "node_id = ?", nodeID).Error == nil {
+		return pin.SignKey
+	}
+	return ""
+}
+
+// boundRecords keeps the records signed with the key currently bound to
+// their SenderID, or any key if none is bound yet.
+func boundRecords(db *gorm.DB, records []Message) []Message {
+	bound := make(map[string]string)
+	var out []Message
+	for _, r := range records {
+		key, ok := bound[r.SenderID]
+		if !ok {
+			key = boundSigner(db, r.SenderID)
+			bound[r.SenderID] = key
+		}
+		if key == "" || r.SignerKey == key {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// FlagPeerKeyChange holds an unproven new key for operator review. It
+// reports false, leaving the peer as it is, when a change is already
+// pending, the key was rejected before, or another change was flagged or
+// rejected after since: anyone can announce a fresh key, so repeats must
+// not keep DMs blocked.
+func FlagPeerKeyChange(db *gorm.DB, peerID, pubKey, signKey, addr string, since time.Time) (bool, error) {
+	var peer Peer
+	if err := db.First(&peer, "id = ?", peerID).Error; err != nil {
+		return false, err
+	}
+	if peer.KeyStatus == KeyChanged {
+		return false, nil
+	}
+	var rejected, recent int64
+	db.Model(&PeerKeyHistory{}).Where("peer_id = ? AND event = ? AND pub_key = ? AND sign_key = ?",
+		peerID, KeyEventRejected, pubKey, signKey).Count(&rejected)
+	db.Model(&PeerKeyHistory{}).Where("peer_id = ? AND event IN ? AND at > ?",
+		peerID, []string{KeyEventChanged, KeyEventRejected}, since).Count(&recent)
+	if rejected > 0 || recent > 0 {
+		return false, nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Peer{}).Where("id = ?", peerID).Updates(map[string]interface{}{
+			"key_status": KeyChanged, "pending_pub_key": pubKey, "pending_sign_key": signKey,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&PeerKeyHistory{PeerID: peerID, Event: KeyEventChanged, PubKey: pubKey, SignKey: signKey, Addr: addr, At: time.Now()}).Error
+	})
+	return err == nil, err
+}
+
+// AcceptPeerKey pins a peer's pending key in place of the old one.
+func AcceptPeerKey(db *gorm.DB, peerID string) error {
+	var peer Peer
+	if err := db.First(&peer, "id = ?", peerID).Error; err != nil {
+		return fmt.Errorf("failed to find peer: %w", err)
+	}
+	if peer.KeyStatus != KeyChanged {
+		return fmt.Errorf("peer %s has no key change pending", peerID)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		// The new key starts its own heartbeat counter.
+		err := tx.Model(&Peer{}).Where("id = ?", peerID).Updates(map[string]interface{}{
+			"pub_key": peer.PendingPubKey, "sign_key": peer.PendingSignKey, "key_status": KeyPinned,
+			"pending_pub_key": "", "pending_sign_key": "", "last_counter": 0,
+			"verified": false, "verified_at": time.Time{},
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&PeerKeyHistory{PeerID: peerID, Event: KeyEventAccepted, PubKey: peer.PendingPubKey, SignKey: peer.PendingSignKey, At: time.Now()}).Error
+	})
+}
+
+// RejectPeerKey discards a peer's pending key and keeps the pinned one. The
+// rejected key is remembered and not raised again.
+func RejectPeerKey(db *gorm.DB, peerID string) error {
+	var peer Peer
+	if err := db.First(&peer, "id = ?", peerID).Error; err != nil {
+		return fmt.Errorf("failed to find peer: %w", err)
+	}
+	if peer.KeyStatus != KeyChanged {
+		return fmt.Errorf("peer %s has no key change pending", peerID)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Peer{}).Where("id = ?", peerID).Updates(map[string]interface{}{
+			"key_status": KeyPinned, "pending_pub_key": "", "pending_sign_key": "",
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&PeerKeyHistory{PeerID: peerID, Event: KeyEventRejected, PubKey: peer.PendingPubKey, SignKey: peer.PendingSignKey, At: time.Now()}).Error
+	})
+}
+
+// SetPeerVerified marks a peer's pinned key as verified (or not) and logs it
+// to the key history.
+func SetPeerVerified(db *gorm.DB, peerID string, verified bool) error {
+	var peer Peer
+	if err := db.First(&peer, "id = ?", peerID).Error; err != nil {
+		return fmt.Errorf("failed to find peer: %w", err)
+	}
+	at := time.Now()
+	event := KeyEventVerified
+	if !verified {
+		event, at = KeyEventUnverified, time.Time{}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Peer{}).Where("id = ?", peerID).Updates(map[string]interface{}{
+			"verified": verified, "verified_at": at,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&PeerKeyHistory{PeerID: peerID, Event: event, PubKey: peer.PubKey, SignKey: peer.SignKey, At: time.Now()}).Error
+	})
+}
+
+// VerifiedPeers returns the IDs of peers whose key has been verified.
+func VerifiedPeers(db *gorm.DB) map[string]bool {
+	var ids []string
+	db.Model(&Peer{}).Where("verified = ?", true).Pluck("id", &ids)
+	verified := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		verified[id] = true
+	}
+	return verified
+}
+
+// GetKeyHistory returns a peer's key history, oldest first.
+func GetKeyHistory(db *gorm.DB, peerID string) ([]PeerKeyHistory, error) {
+	history := []PeerKeyHistory{}
+	err := db.Where("peer_id = ?", peerID).Order("at, id").Find(&history).Error
+	return history, err
+}
 func GetActivePeers(db *gorm.DB) ([]Peer, error) {
 	var peers []Peer
 	result := db.Where("is_active = ?", true).Find(&peers)
 	return peers, result.Error
 }
+
+// ContactWindow is one continuous period a peer was reachable. End is the
+// last heartbeat before it dropped out, or now while Open.
+type ContactWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Addr  string    `json:"addr"`
+	Iface string    `json:"iface"`
+	Open  bool      `json:"open"`
+}
+
+type PeerStats struct {
+	PeerID        string          `json:"peer_id"`
+	Nick          string          `json:"nick"`
+	FirstSeen     time.Time       `json:"first_seen"`
+	UptimeSeconds float64         `json:"uptime_seconds"`
+	Windows       []ContactWindow `json:"windows"`
+}
+
+func RecordSighting(db *gorm.DB, s PeerSighting) error {
+	return db.Create(&s).Error
+}
+
+// CloseOpenSightings ends every contact window left open by a previous run at
+// the peer's last heartbeat and marks the peer dead, so the next heartbeat
+// opens a fresh window instead of counting our own downtime as uptime.
+func CloseOpenSightings(db *gorm.DB, deadState string) error {
+	var peers []Peer
+	if err := db.Find(&peers).Error; err != nil {
+		return err
+	}
+	for _, p := range peers {
+		var last PeerSighting
+		err := db.Where("peer_id = ?", p.ID).Order("at desc, id desc").First(&last).Error
+		if err != nil || last.Event != SightingAppear {
+			continue
+		}
+		if err := RecordSighting(db, PeerSighting{PeerID: p.ID, Event: SightingDisappear, Addr: last.Addr, Iface: last.Iface, At: p.LastSeen}); err != nil {
+			return err
+		}
+		db.Model(&Peer{}).Where("id = ?", p.ID).Updates(map[string]interface{}{"state": deadState, "is_active": false})
+	}
+	return nil
+}
+
+// GetPeerStats folds a peer's sighting log into contact windows.
+func GetPeerStats(db *gorm.DB, peerID string, now time.Time) (PeerStats, error) {
+	stats := PeerStats{PeerID: peerID, Windows: []ContactWindow{}}
+	var peer Peer
+	if err := db.First(&peer, "id = ?", peerID).Error; err == nil {
+		stats.Nick = peer.Nick
+	}
+	var log []PeerSighting
+	if err := db.Where("peer_id = ?", peerID).Order("at asc, id asc").Find(&log).Error; err != nil {
+		return stats, err
+	}
+	var open *ContactWindow
+	for _, s := range log {
+		switch s.Event {
+		case SightingAppear:
+			if open != nil {
+				continue
+			}
+			if stats.FirstSeen.IsZero() {
+				stats.FirstSeen = s.At
+			}
+			open = &ContactWindow{Start: s.At, Addr: s.Addr, Iface: s.Iface}
+		case SightingDisappear:
+			if open == nil {
+				continue
+			}
+			open.End = s.At
+			stats.Windows = append(stats.Windows, *open)
+			open = nil
+		}
+	}
+	if open != nil {
+		open.End = now
+		open.Open = true
+		stats.Windows = append(stats.Windows, *open)
+	}
+	var uptime time.Duration
+	for _, w := range stats.Windows {
+		if w.End.After(w.Start) {
+			uptime += w.End.Sub(w.Start)
+		}
+	}
+	stats.UptimeSeconds = uptime.Seconds()
+	return stats, nil
+}
+
+// GetAllPeerStats returns stats for every peer that has ever been sighted.
+func GetAllPeerStats(db *gorm.DB, now time.Time) ([]PeerStats, error) {
+	var ids []string
+	if err := db.Model(&PeerSighting{}).Distinct("peer_id").Pluck("peer_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	all := make([]PeerStats, 0, len(ids))
+	for _, id := range ids {
+		stats, err := GetPeerStats(db, id, now)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, stats)
+	}
+	return all, nil
+}
+
+// GetMessage returns the stored message with id.
+func GetMessage(db *gorm.DB, id string) (Message, error) {
+	var msg Message
+	if err := db.First(&msg, "id = ?", id).Error; err != nil {
+		return Message{}, fmt.Errorf("failed to find message %s: %w", id, err)
+	}
+	return msg, nil
+}
+
+// EmergencyMessages returns official alerts and SOS messages sent since
+// since, oldest first.
+func EmergencyMessages(db *gorm.DB, since time.Time) ([]Message, error) {
+	var messages []Message
+	err := db.Where("(kind = ? OR (kind = '' AND priority = 2)) AND timestamp >= ?", KindAlert, since.Unix()).
+		Order("hlc").Find(&messages).Error
+	return messages, err
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"gorm.io/gorm"
+)
+
+// KindIncident records a change in the state of the incident an SOS opened.
+const KindIncident = "incident"
+
+// Incident states, in the order an incident moves through them.
+const (
+	IncidentOpen         = "open"
+	IncidentAcknowledged = "acknowledged"
+	IncidentAssigned     = "assigned"
+	IncidentEnRoute      = "en_route"
+	IncidentResolved     = "resolved"
+)
+
+// IncidentStates lists the states in order. An incident never moves back;
+// updates to an earlier state are ignored, and at the same state the latest
+// wins, so a team can be reassigned until it is en route.
+var IncidentStates = []string{IncidentOpen, IncidentAcknowledged, IncidentAssigned, IncidentEnRoute, IncidentResolved}
+
+// IncidentUpdate is the body of an incident record. Incident is the ID of
+// the SOS message.
+type IncidentUpdate struct {
+	Incident string `json:"incident"`
+	State    string `json:"state"`
+	Team     string `json:"team,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// Incident is an SOS and where the response to it stands.
+type Incident struct {
+	ID         string    `json:"id"`
+	ReporterID string    `json:"reporter_id"`
+	Reporter   string    `json:"reporter"`
+	Summary    string    `json:"summary"`
+	SOS        *SOS      `json:"sos,omitempty"`
+	Lat        float64   `json:"lat"`
+	Long       float64   `json:"long"`
+	Network    string    `json:"network"`
+	Opened     time.Time `json:"opened"`
+	// Repeats counts the times the reporter re-announced the SOS while
+	// waiting; Lat, Long, SOS.Accuracy and Battery are from the latest.
+	Repeats   int       `json:"repeats,omitempty"`
+	LastHeard time.Time `json:"last_heard"`
+	Battery   *int      `json:"battery,omitempty"`
+	State     string    `json:"state"`
+	Team      string    `json:"team,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	// Cancelled is set when the reporter resolved the SOS themselves.
+	Cancelled bool `json:"cancelled,omitempty"`
+	// UpdatedBy and UpdatedRole are the author and role of the update the
+	// state comes from.
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	UpdatedRole string    `json:"updated_role,omitempty"`
+	Updated     time.Time `json:"updated,omitempty"`
+	// Status is StatusLine, for web clients.
+	Status string `json:"status"`
+}
+
+// IsSOS reports whether msg is a distress message, structured or not.
+// SAFE broadcasts share its priority but are not.
+func IsSOS(msg Message) bool {
+	if msg.Kind != "" || msg.Priority != 2 {
+		return false
+	}
+	_, ok := ParseSOS(msg)
+	return ok || strings.HasPrefix(msg.Content, "PRIORITY ALERT: SOS")
+}
+
+// StatusLine is what the person in distress is told about the response.
+func (i Incident) StatusLine() string {
+	who := i.Team
+	if who == "" {
+		who = i.UpdatedBy
+	}
+	switch i.State {
+	case IncidentAcknowledged:
+		return "Help acknowledged by " + who
+	case IncidentAssigned:
+		return who + " assigned"
+	case IncidentEnRoute:
+		return who + " en route"
+	case IncidentResolved:
+		if i.Cancelled {
+			return "Cancelled by the sender"
+		}
+		return "Resolved by " + who
+	}
+	return "Waiting for a response"
+}
+
+// MayUpdateIncident reports whether a sender with role may move an incident
+// reported by reporterID to state: responders and commanders may make any
+// change, and whoever sent the SOS may resolve it. Neither holds unless the
+// update is verified to come from senderID.
+func MayUpdateIncident(role, senderID, reporterID, state string, verified bool) bool {
+	if !verified {
+		return false
+	}
+	return core.RoleAllows(role, core.PermRespond) || (senderID == reporterID && state == IncidentResolved)
+}
+
+// Incidents returns the incidents opened by SOS messages since since, open
+// ones first, then newest first.
+func Incidents(db *gorm.DB, since time.Time) ([]Incident, error) {
+	var sosMsgs []Message
+	err := db.Where("kind = '' AND priority = 2 AND hidden = ? AND timestamp >= ?", false, since.Unix()).
+		Order("hlc").Find(&sosMsgs).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Incident)
+	var incidents []*Incident
+	for _, m := range sosMsgs {
+		if !IsSOS(m) {
+			continue
+		}
+		opened := time.Unix(m.Timestamp, 0)
+		inc := &Incident{ID: m.ID, ReporterID: m.SenderID, Reporter: m.Author, Summary: m.Content,
+			Lat: m.Lat, Long: m.Long, Network: m.Network, Opened: opened, LastHeard: opened, State: IncidentOpen}
+		if sos, ok := ParseSOS(m); ok {
+			inc.SOS = &sos
+		}
+		byID[m.ID] = inc
+		incidents = append(incidents, inc)
+	}
+	var records []Message
+	if err := db.Where("kind IN ?", []string{KindIncident, KindSOSRepeat}).Order("hlc").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r.Kind == KindSOSRepeat {
+			foldRepeat(byID, r)
+			continue
+		}
+		var u IncidentUpdate
+		if json.Unmarshal([]byte(r.Payload), &u) != nil {
+			continue
+		}
+		inc := byID[u.Incident]
+		if inc == nil || !MayUpdateIncident(r.Role, r.SenderID, inc.ReporterID, u.State, r.Verified) {
+			continue
+		}
+		// Records are in HLC order, so at the same state the latest wins.
+		if slices.Index(IncidentStates, u.State) < slices.Index(IncidentStates, inc.State) {
+			continue
+		}
+		inc.State, inc.Note = u.State, u.Note
+		if u.Team != "" {
+			inc.Team = u.Team
+		}
+		inc.UpdatedBy, inc.UpdatedRole, inc.Updated = r.Author, r.Role, time.Unix(r.Timestamp, 0)
+		inc.Cancelled = u.State == IncidentResolved && r.SenderID == inc.ReporterID
+	}
+	out := make([]Incident, len(incidents))
+	for i, inc := range incidents {
+		inc.Status = inc.StatusLine()
+		out[len(incidents)-1-i] = *inc
+	}
+	slices.SortStableFunc(out, func(a, b Incident) int {
+		return boolRank(a.State == IncidentResolved) - boolRank(b.State == IncidentResolved)
+	})
+	return out, nil
+}
+
+// foldRepeat applies a repeat record to the incident of the SOS it
+// repeats. Only the reporter may repeat an SOS, with a verified record.
+func foldRepeat(byID map[string]*Incident, r Message) {
+	var rep SOSRepeat
+	if json.Unmarshal([]byte(r.Payload), &rep) != nil {
+		return
+	}
+	inc := byID[rep.SOS]
+	if inc == nil || !r.Verified || r.SenderID != inc.ReporterID {
+		return
+	}
+	inc.Repeats = max(inc.Repeats, rep.Count)
+	if heard := time.Unix(r.Timestamp, 0); heard.After(inc.LastHeard) {
+		inc.LastHeard = heard
+	}
+	if rep.Lat != 0 || rep.Long != 0 {
+		inc.Lat, inc.Long = rep.Lat, rep.Long
+	}
+	if inc.SOS != nil && rep.Accuracy > 0 {
+		sos := *inc.SOS
+		sos.Accuracy = rep.Accuracy
+		inc.SOS = &sos
+	}
+	if rep.Battery != nil {
+		inc.Battery = rep.Battery
+	}
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,7 +1,10 @@
 package store
 
 import (
+	"encoding/json"
 	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
 )
 
 type Peer struct {
@@ -11,6 +14,34 @@ type Peer struct {
 	PubKey   string
 	LastSeen time.Time
 	IsActive bool
+	// State is the failure detector's verdict: alive, suspect, dead or
+	// forgotten. IsActive is true while alive or suspect.
+	State string
+	// SignKey is the peer's Ed25519 identity key. Once known it only changes
+	// with a rotation proof signed by the previous key.
+	SignKey string
+	// LastCounter is the highest heartbeat counter accepted, for replay
+	// protection.
+	LastCounter uint64
+	// KeyStatus is "pinned" once the first key seen for this node is stored,
+	// or "changed" while a different key awaits operator review. The
+	// announced keys are held in PendingPubKey/PendingSignKey meanwhile.
+	KeyStatus      string
+	PendingPubKey  string
+	PendingSignKey string
+	// Verified is set when an operator has compared the pinned key's
+	// fingerprint out of band. Accepting an unproven key change clears it.
+	Verified   bool
+	VerifiedAt time.Time
+	// Status from the peer's last heartbeat. Battery is nil when the peer
+	// has none or does not say; Lat/Long are 0 when unknown.
+	Battery    *int
+	Lat        float64
+	Long       float64
+	Role       string
+	QueueDepth int
+	// Networks is the comma-separated list of meshes the peer announced.
+	Networks string
 }
 type Message struct {
 	ID          string `gorm:"primaryKey"`
@@ -26,4 +57,109 @@ type Message struct {
 	HopCount    int
 	Status      string
 	IsEncrypted bool
+	// Network is the mesh this message belongs to; it is only synced with
+	// peers that share it.
+	Network string `gorm:"index" json:"network"`
+	// HLC is the hybrid logical clock timestamp (see internal/clock) that
+	// messages are ordered by; Timestamp stays the sender's wall clock for
+	// display.
+	HLC int64 `gorm:"index" json:"hlc"`
+	// ClockSkew is set by the receiver when the sender's clock looked badly
+	// wrong, so its Timestamp should not be trusted.
+	ClockSkew bool `json:"clock_skew"`
+	// Kind is empty for chat; other kinds are records (see KindModeration)
+	// whose body is in Payload and which gossip like messages but are not
+	// shown as chat. A chat message's Payload can only hold SOS details.
+	Kind    string `gorm:"index" json:"kind,omitempty"`
+	Payload string `json:"payload,omitempty"`
+	// SignerKey and Signature are the Ed25519 key and signature over
+	// SigningBytes, required for records. Cert, if present, is the signer's
+	// role certificate.
+	SignerKey string         `json:"signer_key,omitempty"`
+	Signature string         `json:"sig,omitempty"`
+	Cert      *core.RoleCert `gorm:"serializer:json" json:"cert,omitempty"`
+	// Role is set by the receiver from Cert once the signature and
+	// certificate check out; empty means a civilian or unsigned message.
+	Role string `json:"role,omitempty"`
+	// Verified is set locally when the message was signed by the key bound
+	// to its SenderID (always, for our own signed messages), so stored
+	// records can be trusted to come from who they name.
+	Verified bool `json:"-"`
+	// Hidden is set locally when moderation hides the message or blocks its
+	// sender. Hidden messages are kept, so they are not fetched again, but
+	// are neither shown nor relayed.
+	Hidden bool `gorm:"index" json:"-"`
+}
+
+// Record kinds.
+const (
+	KindModeration = "moderation"
+)
+
+// SigningBytes is what a signed message's signature covers. The position is
+// included: it is where responders go for an SOS.
+func (m Message) SigningBytes() []byte {
+	b, _ := json.Marshal(struct {
+		ID, SenderID, RecipientID, Author, Content string
+		Priority                                   int
+		Lat, Long                                  float64
+		Kind, Payload, Network                     string
+		Timestamp                                  int64
+	}{m.ID, m.SenderID, m.RecipientID, m.Author, m.Content, m.Priority, m.Lat, m.Long, m.Kind, m.Payload, m.Network, m.Timestamp})
+	return b
+}
+
+// Sighting events.
+const (
+	SightingAppear    = "appear"
+	SightingDisappear = "disappear"
+)
+
+// PeerSighting is one entry in the append-only log of when a peer came into
+// and dropped out of contact, and over which address and interface.
+type PeerSighting struct {
+	ID     uint   `gorm:"primaryKey"`
+	PeerID string `gorm:"index"`
+	Event  string
+	Addr   string
+	Iface  string
+	At     time.Time
+}
+
+// Key statuses.
+const (
+	KeyPinned  = "pinned"
+	KeyChanged = "changed"
+)
+
+// Key history events.
+const (
+	KeyEventPinned     = "pinned"
+	KeyEventRotated    = "rotated"
+	KeyEventChanged    = "changed"
+	KeyEventAccepted   = "accepted"
+	KeyEventRejected   = "rejected"
+	KeyEventVerified   = "verified"
+	KeyEventUnverified = "unverified"
+)
+
+// SignerPin is the signing key first seen on records from a node we have
+// no heartbeat key for, e.g. one several hops away. Records claiming that
+// node are only accepted with the pinned key.
+type SignerPin struct {
+	NodeID  string `gorm:"primaryKey"`
+	SignKey string
+	At      time.Time
+}
+
+// PeerKeyHistory is the audit trail of every key a peer has been pinned to or
+// presented, and what the operator decided about it.
+type PeerKeyHistory struct {
+	ID      uint      `gorm:"primaryKey" json:"id"`
+	PeerID  string    `gorm:"index" json:"peer_id"`
+	Event   string    `json:"event"`
+	PubKey  string    `json:"pub_key"`
+	SignKey string    `json:"sign_key"`
+	Addr    string    `json:"addr"`
+	At      time.Time `json:"at"`
 }
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Moderation actions.
+const (
+	ModHide    = "hide"
+	ModUnhide  = "unhide"
+	ModBlock   = "block"
+	ModUnblock = "unblock"
+)
+
+// ModerationAction is the payload of a KindModeration record.
+type ModerationAction struct {
+	Action string `json:"action"`
+	// Target is a message ID for hide/unhide and a node ID for
+	// block/unblock.
+	Target string `json:"target"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Moderation is the audit trail of every moderation action this node has
+// issued or received, whether or not it was applied.
+type Moderation struct {
+	ID string `gorm:"primaryKey" json:"id"`
+	ModerationAction
+	Issuer    string `json:"issuer"`
+	IssuerKey string `json:"issuer_key,omitempty"`
+	// Local actions were taken on this node only and never gossiped.
+	Local bool `json:"local"`
+	// Applied is false for records from signers this node does not trust;
+	// Note says why.
+	Applied bool      `json:"applied"`
+	Note    string    `json:"note,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// AddModeration records an action (ignoring repeats) and reapplies
+// moderation to the stored messages.
+func AddModeration(db *gorm.DB, m Moderation) error {
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&m).Error; err != nil {
+		return err
+	}
+	return RebuildModeration(db)
+}
+
+// AddLocalModeration records an action taken by this node's operator for
+// this node only.
+func AddLocalModeration(db *gorm.DB, act ModerationAction, issuer string, at time.Time) error {
+	return AddModeration(db, Moderation{
+		ID:               fmt.Sprintf("local-%d", at.UnixNano()),
+		ModerationAction: act,
+		Issuer:           issuer,
+		Local:            true,
+		Applied:          true,
+		At:               at,
+	})
+}
+
+// SetModerationApplied changes whether a record is in force, e.g. after the
+// issuer's trust changed.
+func SetModerationApplied(db *gorm.DB, id string, applied bool, note string) error {
+	return db.Model(&Moderation{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"applied": applied, "note": note}).Error
+}
+
+// GetModerations returns the audit trail, newest first.
+func GetModerations(db *gorm.DB) ([]Moderation, error) {
+	var mods []Moderation
+	err := db.Order("at desc").Find(&mods).Error
+	return mods, err
+}
+
+// ModerationState replays the applied actions in order, returning the
+// messages hidden and the nodes blocked.
+func ModerationState(db *gorm.DB) (hidden, blocked map[string]bool) {
+	var mods []Moderation
+	db.Where("applied = ?", true).Order("at asc").Find(&mods)
+	hidden, blocked = make(map[string]bool), make(map[string]bool)
+	for _, m := range mods {
+		switch m.Action {
+		case ModHide:
+			hidden[m.Target] = true
+		case ModUnhide:
+			delete(hidden, m.Target)
+		case ModBlock:
+			blocked[m.Target] = true
+		case ModUnblock:
+			delete(blocked, m.Target)
+		}
+	}
+	return hidden, blocked
+}
+
+// Silenced reports whether moderation hides msg.
+func Silenced(db *gorm.DB, msg Message) bool {
+	hidden, blocked := ModerationState(db)
+	return hidden[msg.ID] || blocked[msg.SenderID]
+}
+
+// RebuildModeration recomputes every message's Hidden flag from the applied
+// actions. Records themselves are never hidden, so moderation can always be
+// undone.
+func RebuildModeration(db *gorm.DB) error {
+	hidden, blocked := ModerationState(db)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Message{}).Where("hidden = ?", true).Update("hidden", false).Error; err != nil {
+			return err
+		}
+		q := tx.Model(&Message{}).Where("kind = ''")
+		ids, senders := keys(hidden), keys(blocked)
+		switch {
+		case len(ids) > 0 && len(senders) > 0:
+			q = q.Where("id IN ? OR sender_id IN ?", ids, senders)
+		case len(ids) > 0:
+			q = q.Where("id IN ?", ids)
+		case len(senders) > 0:
+			q = q.Where("sender_id IN ?", senders)
+		default:
+			return nil
+		}
+		return q.Update("hidden", true).Error
+	})
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultNetwork is the mesh a node joins when none is configured, and the
+// one assumed for peers and messages that predate network IDs.
+const DefaultNetwork = "default"
+
+// MaxNetworks bounds how many meshes a node may join, which keeps the list
+// small enough for heartbeats.
+const MaxNetworks = 8
+
+var networkName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// ParseNetworks validates and de-duplicates network IDs, returning
+// DefaultNetwork alone if none are given. Names are lower-cased.
+func ParseNetworks(names []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n == "" || seen[n] {
+			continue
+		}
+		if !networkName.MatchString(n) {
+			return nil, fmt.Errorf("invalid network ID %q: use up to 32 letters, digits, '.', '_' or '-'", n)
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	if len(out) == 0 {
+		return []string{DefaultNetwork}, nil
+	}
+	if len(out) > MaxNetworks {
+		return nil, fmt.Errorf("at most %d networks may be joined, got %d", MaxNetworks, len(out))
+	}
+	return out, nil
+}
+
+// SharedNetworks returns the networks in both lists, in the order of ours.
+// A peer that names none is taken to be on DefaultNetwork.
+func SharedNetworks(ours, theirs []string) []string {
+	if len(theirs) == 0 {
+		theirs = []string{DefaultNetwork}
+	}
+	var shared []string
+	for _, n := range ours {
+		for _, t := range theirs {
+			if n == t {
+				shared = append(shared, n)
+				break
+			}
+		}
+	}
+	return shared
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KindPerson is a report about someone in the person-finder registry. The
+// first report about a person opens an entry; later reports name it in
+// Person and update it.
+const KindPerson = "person"
+
+// Person statuses.
+const (
+	PersonSafe     = "safe"
+	PersonInjured  = "injured"
+	PersonMissing  = "missing"
+	PersonDeceased = "deceased"
+)
+
+// PersonStatuses lists the statuses a report may give.
+var PersonStatuses = []string{PersonSafe, PersonInjured, PersonMissing, PersonDeceased}
+
+// PersonReport is the body of a person record. Name and Status are always
+// given; other fields are left empty when the report does not change them.
+// Photo is the hex SHA-256 of a photo, so agencies holding the photo can
+// match it without it crossing the mesh. Reporter is who made the report
+// when that is not the node's operator, e.g. a web user.
+type PersonReport struct {
+	Person      string  `json:"person,omitempty"`
+	Name        string  `json:"name"`
+	Status      string  `json:"status"`
+	Description string  `json:"description,omitempty"`
+	Location    string  `json:"location,omitempty"`
+	Lat         float64 `json:"lat,omitempty"`
+	Long        float64 `json:"long,omitempty"`
+	Photo       string  `json:"photo,omitempty"`
+	Reporter    string  `json:"reporter,omitempty"`
+	Note        string  `json:"note,omitempty"`
+}
+
+// Person is a registry entry: every report about one person merged, each
+// field taking the value from the latest report that set it. Reports are
+// ordered by timestamp and then ID, both covered by the signature, so every
+// node merges them the same way whatever order they arrived in. The HLC is
+// not used: it is unsigned and rewritten by receivers whose clocks disagree.
+type Person struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Status      string         `json:"status"`
+	Description string         `json:"description,omitempty"`
+	Location    string         `json:"location,omitempty"`
+	Lat         float64        `json:"lat,omitempty"`
+	Long        float64        `json:"long,omitempty"`
+	Photo       string         `json:"photo,omitempty"`
+	Reporter    string         `json:"reporter"`
+	Created     time.Time      `json:"created"`
+	Updated     time.Time      `json:"updated"`
+	Reports     []PersonUpdate `json:"reports"`
+}
+
+// PersonUpdate is one report in an entry's history.
+type PersonUpdate struct {
+	ID       string    `json:"id"`
+	NodeID   string    `json:"node_id"`
+	Reporter string    `json:"reporter"`
+	Status   string    `json:"status"`
+	Location string    `json:"location,omitempty"`
+	Lat      float64   `json:"lat,omitempty"`
+	Long     float64   `json:"long,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Matches reports whether query appears in the person's name, description
+// or last seen location, ignoring case. An empty query matches everyone.
+func (p Person) Matches(query string) bool {
+	query = strings.ToLower(strings.TrimSpace(query))
+	for _, s := range []string{p.Name, p.Description, p.Location} {
+		if strings.Contains(strings.ToLower(s), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// People merges the person records into registry entries and returns those
+// matching query, most recently updated first. Reports from hidden senders
+// are left out.
+func People(db *gorm.DB, query string) ([]Person, error) {
+	var records []Message
+	if err := db.Where("kind = ? AND hidden = ?", KindPerson, false).Order("timestamp, id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Person)
+	var order []string
+	for _, r := range records {
+		var rep PersonReport
+		if json.Unmarshal([]byte(r.Payload), &rep) != nil {
+			continue
+		}
+		id := rep.Person
+		if id == "" {
+			id = r.ID
+		}
+		p := byID[id]
+		if p == nil {
+			p = &Person{ID: id, Created: time.Unix(r.Timestamp, 0)}
+			byID[id] = p
+			order = append(order, id)
+		}
+		p.merge(r, rep)
+	}
+	var out []Person
+	for _, id := range order {
+		if p := byID[id]; p.Matches(query) {
+			out = append(out, *p)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b Person) int {
+		return b.Updated.Compare(a.Updated)
+	})
+	return out, nil
+}
+
+// GetPerson returns the registry entry id.
+func GetPerson(db *gorm.DB, id string) (Person, bool, error) {
+	people, err := People(db, "")
+	if err != nil {
+		return Person{}, false, err
+	}
+	for _, p := range people {
+		if p.ID == id {
+			return p, true, nil
+		}
+	}
+	return Person{}, false, nil
+}
+
+func (p *Person) merge(r Message, rep PersonReport) {
+	reporter := rep.Reporter
+	if reporter == "" {
+		reporter = r.Author
+	}
+	at := time.Unix(r.Timestamp, 0)
+	p.Name, p.Status, p.Reporter = rep.Name, rep.Status, reporter
+	if rep.Description != "" {
+		p.Description = rep.Description
+	}
+	if rep.Location != "" {
+		p.Location = rep.Location
+	}
+	if rep.Lat != 0 || rep.Long != 0 {
+		p.Lat, p.Long = rep.Lat, rep.Long
+	}
+	if rep.Photo != "" {
+		p.Photo = rep.Photo
+	}
+	if at.After(p.Updated) {
+		p.Updated = at
+	}
+	p.Reports = append(p.Reports, PersonUpdate{ID: r.ID, NodeID: r.SenderID, Reporter: reporter, Status: rep.Status,
+		Location: rep.Location, Lat: rep.Lat, Long: rep.Long, Note: rep.Note, At: at})
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reputation scores run from MinScore to MaxScore; new peers start at
+// NeutralScore. Below LowScore a peer is deprioritised, and below
+// QuarantineScore it is refused.
+const (
+	MinScore        = 0.0
+	QuarantineScore = 10.0
+	LowScore        = 30.0
+	NeutralScore    = 50.0
+	MaxScore        = 100.0
+)
+
+// Reputation events, which pick the counter an adjustment is tallied under.
+const (
+	RepInvalid = "invalid"
+	RepBadSig  = "bad_sig"
+	RepFlood   = "flood"
+	RepRelay   = "relay"
+	RepUptime  = "uptime"
+)
+
+// PeerReputation is what this node has observed of a peer's behaviour.
+type PeerReputation struct {
+	PeerID string  `gorm:"primaryKey" json:"peer_id"`
+	Score  float64 `json:"score"`
+	// Override, when set by an operator, replaces Score until cleared.
+	Override *float64 `json:"override,omitempty"`
+	Invalid  int      `json:"invalid"`
+	BadSigs  int      `json:"bad_sigs"`
+	Floods   int      `json:"floods"`
+	Relays   int      `json:"relays"`
+	// QuarantinedUntil is set when the score falls too low; the peer is
+	// refused until then.
+	QuarantinedUntil time.Time `json:"quarantined_until"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Effective is the score the engine acts on: the operator's override if
+// there is one, otherwise the observed score.
+func (r PeerReputation) Effective() float64 {
+	if r.Override != nil {
+		return *r.Override
+	}
+	return r.Score
+}
+
+// AdjustReputation adds delta to a peer's score, clamped to the valid range,
+// and counts event against it. Unknown peers start at NeutralScore.
+func AdjustReputation(db *gorm.DB, peerID string, delta float64, event string) (PeerReputation, error) {
+	var rep PeerReputation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&PeerReputation{PeerID: peerID, Score: NeutralScore}).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&rep, "peer_id = ?", peerID).Error; err != nil {
+			return err
+		}
+		rep.Score = min(MaxScore, max(MinScore, rep.Score+delta))
+		switch event {
+		case RepInvalid:
+			rep.Invalid++
+		case RepBadSig:
+			rep.BadSigs++
+		case RepFlood:
+			rep.Floods++
+		case RepRelay:
+			rep.Relays++
+		}
+		return tx.Save(&rep).Error
+	})
+	return rep, err
+}
+
+// GetReputation returns a peer's reputation, or a neutral one if nothing has
+// been observed yet.
+func GetReputation(db *gorm.DB, peerID string) PeerReputation {
+	rep := PeerReputation{PeerID: peerID, Score: NeutralScore}
+	db.Limit(1).Find(&rep, "peer_id = ?", peerID)
+	return rep
+}
+
+// GetReputations returns every scored peer, lowest score first.
+func GetReputations(db *gorm.DB) ([]PeerReputation, error) {
+	var reps []PeerReputation
+	err := db.Order("score asc").Find(&reps).Error
+	return reps, err
+}
+
+// SetReputationOverride pins a peer's effective score, or with nil returns
+// it to the observed score. Either way any quarantine is lifted, so the
+// engine re-evaluates the peer from the new score.
+func SetReputationOverride(db *gorm.DB, peerID string, score *float64) error {
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&PeerReputation{PeerID: peerID, Score: NeutralScore}).Error; err != nil {
+		return err
+	}
+	return db.Model(&PeerReputation{}).Where("peer_id = ?", peerID).
+		Updates(map[string]interface{}{"override": score, "quarantined_until": time.Time{}}).Error
+}
+
+// QuarantinePeer records that a peer is refused until the given time.
+func QuarantinePeer(db *gorm.DB, peerID string, until time.Time) error {
+	return db.Model(&PeerReputation{}).Where("peer_id = ?", peerID).Update("quarantined_until", until).Error
+}
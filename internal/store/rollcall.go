@@ -0,0 +1,216 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KindRollCall asks everyone on the mesh to report whether they are safe.
+// KindRollCallReply answers one for the people at a node: its operator and
+// the web users registered with it.
+const (
+	KindRollCall      = "rollcall"
+	KindRollCallReply = "rollcall_reply"
+)
+
+// Roll call answers. Pending means the node got the roll call but the
+// person has not answered.
+const (
+	RollCallSafe    = "safe"
+	RollCallHelp    = "help"
+	RollCallPending = "pending"
+)
+
+// RollCallStatuses lists the answers a reply may carry.
+var RollCallStatuses = []string{RollCallSafe, RollCallHelp, RollCallPending}
+
+// RollCallWindow is how long after a roll call nodes answer it and prompt
+// their users. RollCallRoster is how recently a peer must have been seen
+// to be expected to answer.
+const (
+	RollCallWindow = time.Hour
+	RollCallRoster = 24 * time.Hour
+)
+
+// RollCall is the body of a roll call record.
+type RollCall struct {
+	Note string `json:"note,omitempty"`
+}
+
+// RollCallReply is the body of a reply record.
+type RollCallReply struct {
+	RollCall string           `json:"rollcall"`
+	Answers  []RollCallAnswer `json:"answers"`
+}
+
+// RollCallAnswer is one person's answer. An empty Name is the node's
+// operator. Auto is set when the node answered by itself, e.g. help for an
+// open SOS.
+type RollCallAnswer struct {
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	Auto   bool   `json:"auto,omitempty"`
+}
+
+// WebUser is a name a web client registered with this node, so the node
+// can answer roll calls for them. Token is the secret the client got when
+// it registered; only that client may answer for the name.
+type WebUser struct {
+	Name     string `gorm:"primaryKey"`
+	Token    string
+	LastSeen time.Time
+}
+
+// RegisterWebUser records that the web client holding token goes by name.
+func RegisterWebUser(db *gorm.DB, name, token string, now time.Time) error {
+	return db.Save(&WebUser{Name: name, Token: token, LastSeen: now}).Error
+}
+
+// GetWebUser returns name's registration if it was seen since since.
+func GetWebUser(db *gorm.DB, name string, since time.Time) (WebUser, bool) {
+	var u WebUser
+	err := db.Where("name = ? AND last_seen >= ?", name, since).First(&u).Error
+	return u, err == nil
+}
+
+// WebUsers returns the names registered since since.
+func WebUsers(db *gorm.DB, since time.Time) ([]string, error) {
+	var names []string
+	err := db.Model(&WebUser{}).Where("last_seen >= ?", since).Order("name").Pluck("name", &names).Error
+	return names, err
+}
+
+// RollCallEntry is where one person stands in a roll call. Status is
+// empty for a node that has not replied at all. Operator marks the node's
+// own operator, as opposed to a web user registered with it. HelpEarlier
+// is set when the person answered help before a later safe, so the change
+// stays visible.
+type RollCallEntry struct {
+	NodeID      string    `json:"node_id"`
+	Node        string    `json:"node"`
+	Name        string    `json:"name"`
+	Operator    bool      `json:"operator"`
+	Status      string    `json:"status"`
+	Auto        bool      `json:"auto,omitempty"`
+	Answered    time.Time `json:"answered,omitempty"`
+	HelpEarlier bool      `json:"help_earlier,omitempty"`
+}
+
+// Accounted reports whether the person has answered.
+func (e RollCallEntry) Accounted() bool {
+	return e.Status == RollCallSafe || e.Status == RollCallHelp
+}
+
+// RollCallStatus is the accountability board for one roll call.
+type RollCallStatus struct {
+	ID      string          `json:"id"`
+	Caller  string          `json:"caller"`
+	Role    string          `json:"role"`
+	Note    string          `json:"note,omitempty"`
+	Started time.Time       `json:"started"`
+	Entries []RollCallEntry `json:"entries"`
+	Safe    int             `json:"safe"`
+	Help    int             `json:"help"`
+	Missing int             `json:"missing"`
+}
+
+// LatestRollCall returns the most recent roll call, if any.
+func LatestRollCall(db *gorm.DB) (Message, bool) {
+	var msg Message
+	if err := db.Where("kind = ?", KindRollCall).Order("hlc desc").First(&msg).Error; err != nil {
+		return msg, false
+	}
+	return msg, true
+}
+
+// RollCallBoard works out who is accounted for in roll call id: answers
+// from verified replies signed by the key bound to their SenderID, in HLC
+// order, the latest for each person winning except
+// that pending never overrides an answer, then every peer seen within
+// RollCallRoster of the call that has not replied. Those needing help come
+// first, then those who needed help earlier, then those missing, then the
+// safe.
+func RollCallBoard(db *gorm.DB, id string) (RollCallStatus, error) {
+	var call Message
+	if err := db.First(&call, "id = ? AND kind = ?", id, KindRollCall).Error; err != nil {
+		return RollCallStatus{}, err
+	}
+	var rc RollCall
+	json.Unmarshal([]byte(call.Payload), &rc)
+	status := RollCallStatus{ID: call.ID, Caller: call.Author, Role: call.Role, Note: rc.Note, Started: time.Unix(call.Timestamp, 0)}
+
+	var replies []Message
+	if err := db.Where("kind = ? AND verified = ?", KindRollCallReply, true).Order("hlc").Find(&replies).Error; err != nil {
+		return status, err
+	}
+	type person struct{ node, name string }
+	byPerson := make(map[person]*RollCallEntry)
+	var entries []*RollCallEntry
+	replied := make(map[string]bool)
+	for _, r := range boundRecords(db, replies) {
+		var reply RollCallReply
+		if json.Unmarshal([]byte(r.Payload), &reply) != nil || reply.RollCall != id {
+			continue
+		}
+		replied[r.SenderID] = true
+		for _, a := range reply.Answers {
+			key := person{r.SenderID, a.Name}
+			e := byPerson[key]
+			if e == nil {
+				e = &RollCallEntry{NodeID: r.SenderID, Name: a.Name, Operator: a.Name == ""}
+				byPerson[key] = e
+				entries = append(entries, e)
+			} else if a.Status == RollCallPending {
+				continue
+			}
+			if e.Status == RollCallHelp && a.Status != RollCallHelp {
+				e.HelpEarlier = true
+			}
+			e.Node, e.Status, e.Auto, e.Answered = r.Author, a.Status, a.Auto, time.Unix(r.Timestamp, 0)
+		}
+	}
+	var peers []Peer
+	db.Where("last_seen >= ?", status.Started.Add(-RollCallRoster)).Find(&peers)
+	for _, p := range peers {
+		if !replied[p.ID] {
+			entries = append(entries, &RollCallEntry{NodeID: p.ID, Node: p.Nick, Operator: true})
+		}
+	}
+
+	for _, e := range entries {
+		if e.Name == "" {
+			e.Name = e.Node
+		}
+		switch e.Status {
+		case RollCallSafe:
+			status.Safe++
+		case RollCallHelp:
+			status.Help++
+		default:
+			status.Missing++
+		}
+		status.Entries = append(status.Entries, *e)
+	}
+	rank := func(e RollCallEntry) int {
+		switch {
+		case e.Status == RollCallHelp:
+			return 0
+		case e.HelpEarlier:
+			return 1
+		case e.Status == RollCallSafe:
+			return 3
+		}
+		return 2
+	}
+	slices.SortStableFunc(status.Entries, func(a, b RollCallEntry) int {
+		if d := rank(a) - rank(b); d != 0 {
+			return d
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return status, nil
+}
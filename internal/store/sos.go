@@ -0,0 +1,81 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Emergency types an SOS can report.
+const (
+	EmergencyMedical = "medical"
+	EmergencyFire    = "fire"
+	EmergencyTrapped = "trapped"
+	EmergencyFlood   = "flood"
+	EmergencyOther   = "other"
+)
+
+// EmergencyTypes lists the SOS types, in the order forms offer them.
+var EmergencyTypes = []string{EmergencyMedical, EmergencyFire, EmergencyTrapped, EmergencyFlood, EmergencyOther}
+
+// Mobilities describe whether the people in an SOS can move themselves.
+var Mobilities = []string{"mobile", "limited", "immobile"}
+
+// SOS is the structured body of a distress message, carried in the Payload
+// of a priority 2 chat message whose Content is its Summary. Zero counts
+// and empty fields mean unknown.
+type SOS struct {
+	Type     string `json:"type"`
+	People   int    `json:"people,omitempty"`
+	Injured  int    `json:"injured,omitempty"`
+	Mobility string `json:"mobility,omitempty"`
+	Notes    string `json:"notes,omitempty"`
+	// Accuracy is the GPS fix's radius in metres.
+	Accuracy float64 `json:"accuracy,omitempty"`
+}
+
+// Summary is the one-line text sent as the message content, for clients
+// that do not read the payload.
+func (s SOS) Summary() string {
+	parts := []string{"PRIORITY ALERT: SOS " + strings.ToUpper(s.Type)}
+	if s.People > 0 {
+		parts = append(parts, fmt.Sprintf("%d people", s.People))
+	}
+	if s.Injured > 0 {
+		parts = append(parts, fmt.Sprintf("%d injured", s.Injured))
+	}
+	if s.Mobility != "" {
+		parts = append(parts, s.Mobility)
+	}
+	line := strings.Join(parts, ", ")
+	if s.Notes != "" {
+		line += ": " + s.Notes
+	}
+	return line
+}
+
+// ParseSOS returns the structured SOS a message carries, if any.
+func ParseSOS(msg Message) (SOS, bool) {
+	var s SOS
+	if msg.Kind != "" || msg.Payload == "" || json.Unmarshal([]byte(msg.Payload), &s) != nil {
+		return SOS{}, false
+	}
+	return s, true
+}
+
+// KindSOSRepeat re-announces an SOS that has had no response, with the
+// sender's latest position and battery. Receivers fold repeats into the
+// incident the SOS opened instead of showing them in the stream.
+const KindSOSRepeat = "sos_repeat"
+
+// SOSRepeat is the body of a repeat record. SOS is the ID of the original
+// message and Count how many times it has been repeated.
+type SOSRepeat struct {
+	SOS      string  `json:"sos"`
+	Count    int     `json:"count"`
+	Lat      float64 `json:"lat,omitempty"`
+	Long     float64 `json:"long,omitempty"`
+	Accuracy float64 `json:"accuracy,omitempty"`
+	// Battery is a percentage; nil means unknown.
+	Battery *int `json:"battery,omitempty"`
+}
@@ -1,12 +1,15 @@
 package store
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/core"
 )
 
 func TestWALMode(t *testing.T) {
@@ -144,4 +147,266 @@ func TestMessagePersistence(t *testing.T) {
 			t.Errorf("Expected LastSeen to be updated to %v, got %v (diff: %v)", newTime, retrievedPeer.LastSeen, diff)
 		}
 	}
+
+	// Peers stored before liveness states get one on the next start.
+	db2.Create(&Peer{ID: "peer2", Nick: "Bob"})
+	db2.Exec("UPDATE peers SET state = NULL")
+	sqlDB, _ = db2.DB()
+	sqlDB.Close()
+	db3, err := Init(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to re-open db: %v", err)
+	}
+	var states []string
+	db3.Model(&Peer{}).Where("state <> ?", "forgotten").Order("id").Pluck("state", &states)
+	if len(states) != 2 || states[0] != "alive" || states[1] != "dead" {
+		t.Errorf("Expected pre-upgrade peers back-filled alive and dead, got %v", states)
+	}
+
+	// The CLI reads a running node's database without writing to it.
+	ro, err := Open(dbPath, true)
+	if err != nil {
+		t.Fatalf("Failed to open db read-only: %v", err)
+	}
+	var n int64
+	if err := ro.Model(&Peer{}).Count(&n).Error; err != nil || n != 2 {
+		t.Errorf("Expected to read 2 peers read-only, got %d, %v", n, err)
+	}
+	if err := UpsertPeer(ro, Peer{ID: "peer3"}); err == nil {
+		t.Error("Expected a write through a read-only handle to fail")
+	}
+}
+
+func TestPeerStats(t *testing.T) {
+	db, err := Init(filepath.Join(t.TempDir(), "sightings.db"))
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	t0 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	log := []PeerSighting{
+		{PeerID: "p1", Event: SightingAppear, Addr: "10.0.0.2:9000", Iface: "wlan0", At: t0},
+		{PeerID: "p1", Event: SightingDisappear, At: t0.Add(10 * time.Minute)},
+		{PeerID: "p1", Event: SightingAppear, Addr: "[fe80::2%eth0]:9000", Iface: "eth0", At: t0.Add(time.Hour)},
+	}
+	for _, s := range log {
+		if err := RecordSighting(db, s); err != nil {
+			t.Fatalf("Failed to record sighting: %v", err)
+		}
+	}
+	now := t0.Add(time.Hour + 5*time.Minute)
+	stats, err := GetPeerStats(db, "p1", now)
+	if err != nil {
+		t.Fatalf("GetPeerStats failed: %v", err)
+	}
+	if !stats.FirstSeen.Equal(t0) {
+		t.Errorf("Expected first seen %v, got %v", t0, stats.FirstSeen)
+	}
+	if len(stats.Windows) != 2 {
+		t.Fatalf("Expected 2 contact windows, got %d", len(stats.Windows))
+	}
+	if stats.Windows[0].Iface != "wlan0" || stats.Windows[0].Open {
+		t.Errorf("Unexpected first window: %+v", stats.Windows[0])
+	}
+	if !stats.Windows[1].Open || stats.Windows[1].Addr != "[fe80::2%eth0]:9000" {
+		t.Errorf("Expected second window open over IPv6, got %+v", stats.Windows[1])
+	}
+	if want := (15 * time.Minute).Seconds(); stats.UptimeSeconds != want {
+		t.Errorf("Expected uptime %.0fs, got %.0fs", want, stats.UptimeSeconds)
+	}
+
+	// A restart closes the open window at the peer's last heartbeat.
+	lastSeen := t0.Add(time.Hour + 2*time.Minute)
+	if err := UpsertPeer(db, Peer{ID: "p1", LastSeen: lastSeen, IsActive: true, State: "alive"}); err != nil {
+		t.Fatalf("Failed to upsert peer: %v", err)
+	}
+	if err := CloseOpenSightings(db, "dead"); err != nil {
+		t.Fatalf("CloseOpenSightings failed: %v", err)
+	}
+	stats, _ = GetPeerStats(db, "p1", now)
+	if stats.Windows[1].Open || !stats.Windows[1].End.Equal(lastSeen) {
+		t.Errorf("Expected window closed at last heartbeat, got %+v", stats.Windows[1])
+	}
+	var peer Peer
+	db.First(&peer, "id = ?", "p1")
+	if peer.IsActive || peer.State != "dead" {
+		t.Errorf("Expected peer marked dead after restart, got %+v", peer)
+	}
+}
+
+func TestUpsertPeerKeepsPinnedKey(t *testing.T) {
+	db, err := Init(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	if err := UpsertPeer(db, Peer{ID: "p1", Nick: "alpha", PubKey: "good", SignKey: "good-sk"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpsertPeer(db, Peer{ID: "p1", Nick: "alpha2", PubKey: "evil", SignKey: "evil-sk"}); err != nil {
+		t.Fatal(err)
+	}
+	var p Peer
+	db.First(&p, "id = ?", "p1")
+	if p.PubKey != "good" || p.SignKey != "good-sk" {
+		t.Errorf("Upsert overwrote pinned keys: %+v", p)
+	}
+	if p.Nick != "alpha2" {
+		t.Errorf("Expected nick to update, got %q", p.Nick)
+	}
+
+	hourAgo := time.Now().Add(-time.Hour)
+	if flagged, _ := FlagPeerKeyChange(db, "p1", "new", "new-sk", "", hourAgo); !flagged {
+		t.Fatal("Expected key change to be flagged")
+	}
+	if flagged, _ := FlagPeerKeyChange(db, "p1", "new", "new-sk", "", hourAgo); flagged {
+		t.Error("Expected repeat of a pending key not to be flagged again")
+	}
+	if flagged, _ := FlagPeerKeyChange(db, "p1", "other", "other-sk", "", hourAgo); flagged {
+		t.Error("Expected another key not to replace the pending one")
+	}
+	if err := AcceptPeerKey(db, "p1"); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&p, "id = ?", "p1")
+	if p.PubKey != "new" || p.SignKey != "new-sk" || p.KeyStatus != KeyPinned || p.PendingPubKey != "" {
+		t.Errorf("Expected accepted key to be pinned, got %+v", p)
+	}
+	if err := AcceptPeerKey(db, "p1"); err == nil {
+		t.Error("Expected accept with nothing pending to fail")
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	nets, err := ParseNetworks([]string{" Rescue-A ", "rescue-a", "ops"})
+	if err != nil || fmt.Sprint(nets) != "[rescue-a ops]" {
+		t.Errorf("Unexpected parse result %v %v", nets, err)
+	}
+	if nets, _ := ParseNetworks(nil); fmt.Sprint(nets) != "[default]" {
+		t.Errorf("Expected default network, got %v", nets)
+	}
+	if _, err := ParseNetworks([]string{"bad name"}); err == nil {
+		t.Error("Expected invalid name to be rejected")
+	}
+	if got := SharedNetworks([]string{"ops", "default"}, nil); fmt.Sprint(got) != "[default]" {
+		t.Errorf("Expected legacy peer on default network, got %v", got)
+	}
+	if got := SharedNetworks([]string{"ops"}, []string{"rescue-a"}); len(got) != 0 {
+		t.Errorf("Expected no shared network, got %v", got)
+	}
+
+	db, err := Init(filepath.Join(t.TempDir(), "nets.db"))
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	SaveMessage(db, &Message{ID: "m1", Content: "legacy"})
+	SaveMessage(db, &Message{ID: "m2", Content: "ops only", Network: "ops"})
+	msgs, _ := GetNetworkMessages(db, DefaultNetwork, 10)
+	if len(msgs) != 1 || msgs[0].ID != "m1" {
+		t.Errorf("Expected only the default network's message, got %+v", msgs)
+	}
+}
+
+func TestReputation(t *testing.T) {
+	db, err := Init(filepath.Join(t.TempDir(), "rep.db"))
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	if r := GetReputation(db, "p1"); r.Score != NeutralScore {
+		t.Errorf("Expected unknown peer to be neutral, got %v", r.Score)
+	}
+	AdjustReputation(db, "p1", 10, RepRelay)
+	r, _ := AdjustReputation(db, "p1", -500, RepInvalid)
+	if r.Score != MinScore || r.Relays != 1 || r.Invalid != 1 {
+		t.Errorf("Expected clamped score and counters, got %+v", r)
+	}
+
+	high := 90.0
+	SetReputationOverride(db, "p1", &high)
+	if r := GetReputation(db, "p1"); r.Effective() != high || r.Score != MinScore {
+		t.Errorf("Expected override to mask observed score, got %+v", r)
+	}
+	SetReputationOverride(db, "p1", nil)
+	if r := GetReputation(db, "p1"); r.Effective() != MinScore {
+		t.Errorf("Expected cleared override to restore observed score, got %+v", r)
+	}
+}
+
+func TestModerationState(t *testing.T) {
+	db, err := Init(filepath.Join(t.TempDir(), "mod.db"))
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	for _, m := range []Message{{ID: "m1", SenderID: "a"}, {ID: "m2", SenderID: "b"}, {ID: "r1", SenderID: "b", Kind: KindModeration}} {
+		SaveMessage(db, &m)
+	}
+	at := time.Now()
+	add := func(id, action, target string, applied bool) {
+		t.Helper()
+		err := AddModeration(db, Moderation{ID: id, ModerationAction: ModerationAction{Action: action, Target: target}, Applied: applied, At: at})
+		if err != nil {
+			t.Fatal(err)
+		}
+		at = at.Add(time.Second)
+	}
+	hiddenIDs := func() []string {
+		var ids []string
+		db.Model(&Message{}).Where("hidden = ?", true).Order("id").Pluck("id", &ids)
+		return ids
+	}
+
+	add("x1", ModHide, "m1", true)
+	add("x2", ModBlock, "b", true)
+	if got := hiddenIDs(); len(got) != 2 || got[0] != "m1" || got[1] != "m2" {
+		t.Errorf("Expected m1 and m2 hidden and the record left alone, got %v", got)
+	}
+	add("x3", ModUnhide, "m1", true)
+	add("x4", ModUnblock, "b", false)
+	if got := hiddenIDs(); len(got) != 1 || got[0] != "m2" {
+		t.Errorf("Expected only m2 hidden after unhide and an unapplied unblock, got %v", got)
+	}
+	if !Silenced(db, Message{ID: "new", SenderID: "b"}) {
+		t.Error("Expected new message from blocked sender to be silenced")
+	}
+}
+
+func TestIncidents(t *testing.T) {
+	db, err := Init(filepath.Join(t.TempDir(), "incidents.db"))
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	now := time.Now().Unix()
+	record := func(id, sender, role string, hlc int64, u IncidentUpdate) Message {
+		payload, _ := json.Marshal(u)
+		return Message{ID: id, SenderID: sender, Role: role, Kind: KindIncident, Payload: string(payload), Timestamp: now, HLC: hlc, Verified: true}
+	}
+	// An update that only claims to come from the reporter carries no weight.
+	forged := record("r5", "other", "", 8, IncidentUpdate{Incident: "sos-b", State: IncidentResolved})
+	forged.Verified = false
+	repeat, _ := json.Marshal(SOSRepeat{SOS: "sos-a", Count: 3, Lat: 50, Long: 60})
+	forgedRepeat := Message{ID: "r6", SenderID: "victim", Kind: KindSOSRepeat, Payload: string(repeat), Timestamp: now, HLC: 9}
+	for _, m := range []Message{
+		{ID: "sos-a", SenderID: "victim", Priority: 2, Content: "PRIORITY ALERT: SOS", Timestamp: now, HLC: 1},
+		{ID: "sos-b", SenderID: "other", Priority: 2, Payload: `{"type":"fire"}`, Timestamp: now, HLC: 2},
+		{ID: "safe", SenderID: "victim", Priority: 2, Content: "SAFE ALERT: I am safe!", Timestamp: now, HLC: 3},
+		record("r1", "stranger", "", 4, IncidentUpdate{Incident: "sos-a", State: IncidentAcknowledged}),
+		record("r2", "victim", "", 5, IncidentUpdate{Incident: "sos-a", State: IncidentResolved}),
+		record("r3", "medic", core.RoleResponder, 6, IncidentUpdate{Incident: "sos-b", State: IncidentAssigned, Team: "Team 1"}),
+		record("r4", "medic", core.RoleResponder, 7, IncidentUpdate{Incident: "sos-b", State: IncidentAssigned, Team: "Team 2"}),
+		forged,
+		forgedRepeat,
+	} {
+		SaveMessage(db, &m)
+	}
+	incidents, err := Incidents(db, time.Unix(now, 0).Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incidents) != 2 {
+		t.Fatalf("Expected the two SOS as incidents, got %+v", incidents)
+	}
+	if b := incidents[0]; b.ID != "sos-b" || b.Status != "Team 2 assigned" {
+		t.Errorf("Expected the open incident first, reassigned to Team 2, got %+v", b)
+	}
+	if a := incidents[1]; a.ID != "sos-a" || a.State != IncidentResolved || a.Repeats != 0 || a.Lat != 0 {
+		t.Errorf("Expected the reporter to resolve its own SOS, got %+v", a)
+	}
 }
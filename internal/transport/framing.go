@@ -5,7 +5,18 @@ import (
 	"io"
 	"net"
 )
+
+// MaxFrameSize bounds a frame's payload. The largest legitimate frame is a
+// MSG with protocol.MaxContentLen of content and protocol.MaxPayloadLen of
+// payload: JSON may escape each byte as \u00XX (6x), and the MSG payload is
+// base64-encoded inside the packet (4/3x), so about 160 KiB plus the other
+// fields.
+const MaxFrameSize = 256 * 1024
+
 func WriteFrame(conn net.Conn, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("frame too large: %d bytes", len(data))
+	}
 	length := uint32(len(data))
 	header := make([]byte, 4)
 	binary.BigEndian.PutUint32(header, length)
@@ -23,7 +34,7 @@ func ReadFrame(conn net.Conn) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read header: %w", err)
 	}
 	length := binary.BigEndian.Uint32(header)
-	if length > 10*1024*1024 {
+	if length > MaxFrameSize {
 		return nil, fmt.Errorf("frame too large: %d bytes", length)
 	}
 	payload := make([]byte, length)
@@ -2,10 +2,14 @@ package transport
 
 import (
 	"fmt"
+	"log/slog"
 	"net"
 	"sync"
+	"time"
 )
 
+const dialTimeout = 5 * time.Second
+
 type Manager struct {
 	conns sync.Map
 }
@@ -14,7 +18,7 @@ func NewManager() *Manager {
 	return &Manager{}
 }
 func (m *Manager) Listen(port string, handler func(net.Conn)) error {
-	listener, err := net.Listen("tcp", ":"+port)
+	listener, err := net.Listen("tcp", net.JoinHostPort("", port))
 	if err != nil {
 		return fmt.Errorf("failed to listen on port %s: %w", port, err)
 	}
@@ -37,13 +41,61 @@ func (m *Manager) Listen(port string, handler func(net.Conn)) error {
 	return nil
 }
 func (m *Manager) Dial(addr string) (net.Conn, error) {
-	conn, err := net.Dial("tcp", addr)
+	conn, err := dialZoned(addr)
 	if err != nil {
 		return nil, err
 	}
 	m.registerConn(conn)
 	return conn, nil
 }
+
+// dialZoned dials addr, and for an IPv6 link-local address given without a
+// zone (e.g. typed in by hand) tries each usable interface in turn, since
+// fe80::/10 is ambiguous until it is scoped to a link. The connection then
+// still reports addr as its remote address, so it is registered under the
+// address the caller dialed and will look up.
+func dialZoned(addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.To4() != nil || !ip.IsLinkLocalUnicast() {
+		return net.DialTimeout("tcp", addr, dialTimeout)
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	lastErr := fmt.Errorf("no interface to reach %s", addr)
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host+"%"+ifi.Name, port), dialTimeout)
+		if err == nil {
+			return dialedConn{Conn: conn, addr: dialedAddr(addr)}, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+// dialedConn is a connection whose RemoteAddr is the address it was dialed
+// as rather than the one it reached.
+type dialedConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c dialedConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+type dialedAddr string
+
+func (a dialedAddr) Network() string { return "tcp" }
+func (a dialedAddr) String() string  { return string(a) }
+
 func (m *Manager) registerConn(conn net.Conn) {
 	m.conns.Store(conn.RemoteAddr().String(), conn)
 }
@@ -61,11 +113,31 @@ func (m *Manager) CloseAll() {
 func (m *Manager) BroadcastPacket(data []byte) {
 	m.conns.Range(func(key, value interface{}) bool {
 		if conn, ok := value.(net.Conn); ok {
-			_ = WriteFrame(conn, data)
+			if err := WriteFrame(conn, data); err != nil {
+				slog.Debug("Failed to broadcast frame", "peer", key, "error", err)
+			}
+		}
+		return true
+	})
+}
+// BroadcastTo sends data to every connection whose remote address allow
+// accepts.
+func (m *Manager) BroadcastTo(data []byte, allow func(addr string) bool) {
+	m.conns.Range(func(key, value interface{}) bool {
+		if conn, ok := value.(net.Conn); ok && allow(key.(string)) {
+			if err := WriteFrame(conn, data); err != nil {
+				slog.Debug("Failed to broadcast frame", "peer", key, "error", err)
+			}
 		}
 		return true
 	})
 }
+// Disconnect closes the connection to addr, if any.
+func (m *Manager) Disconnect(addr string) {
+	if val, ok := m.conns.LoadAndDelete(addr); ok {
+		val.(net.Conn).Close()
+	}
+}
 func (m *Manager) HasConnection(addr string) bool {
 	_, ok := m.conns.Load(addr)
 	return ok
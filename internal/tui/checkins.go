@@ -0,0 +1,144 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// checkInWarning is how long before a check-in is due the sidebar starts
+// shouting about it.
+const checkInWarning = 5 * time.Minute
+
+func (m *model) loadCheckIns() {
+	m.checkIns, _ = store.CheckIns(m.db, time.Now())
+	m.ownCheckIn = m.publisher.OwnCheckIn()
+}
+
+func newIntervalInput() textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = "e.g. 30m, 2h; 0 turns off"
+	ti.CharLimit = 16
+	ti.Width = 30
+	return ti
+}
+
+// checkInLine is the sidebar's countdown to this node's next check-in,
+// or "" with the switch off.
+func (m model) checkInLine() string {
+	c := m.ownCheckIn
+	if !c.Armed() {
+		return ""
+	}
+	if c.Missed {
+		return alertStyle.Render("CHECK-IN MISSED: SOS SENT (c)")
+	}
+	left := time.Until(time.Unix(c.Due, 0))
+	line := fmt.Sprintf("CHECK-IN IN %s (c)", left.Round(time.Minute))
+	if left < checkInWarning {
+		return alertStyle.Render(line)
+	}
+	return line
+}
+
+func (m *model) checkIn() {
+	if err := m.publisher.CheckIn(); err != nil {
+		m.checkInMessage = err.Error()
+	} else {
+		m.checkInMessage = "Checked in"
+	}
+	m.loadCheckIns()
+}
+
+// updateCheckInBoard handles keys while the check-in board is open: c
+// check in, s set this node's interval, x turn it off.
+func (m model) updateCheckInBoard(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.intervalInput.Focused() {
+		switch msg.String() {
+		case "esc":
+			m.intervalInput.Blur()
+		case "enter":
+			m.intervalInput.Blur()
+			m.setCheckIn(strings.TrimSpace(m.intervalInput.Value()))
+		default:
+			var cmd tea.Cmd
+			m.intervalInput, cmd = m.intervalInput.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+	}
+	switch msg.String() {
+	case "esc", "w":
+		m.showCheckIns = false
+	case "c":
+		m.checkIn()
+	case "s":
+		m.intervalInput.SetValue("")
+		m.intervalInput.Focus()
+	case "x":
+		m.setCheckIn("0")
+	}
+	return m, nil
+}
+
+func (m *model) setCheckIn(value string) {
+	interval, err := time.ParseDuration(value)
+	if err == nil {
+		err = m.publisher.SetCheckIn(interval)
+	}
+	if err != nil {
+		m.checkInMessage = err.Error()
+	} else if interval == 0 {
+		m.checkInMessage = "Check-ins turned off"
+	} else {
+		m.checkInMessage = fmt.Sprintf("Check in every %s", interval)
+	}
+	m.loadCheckIns()
+}
+
+func (m model) renderCheckInBoard() string {
+	var sb strings.Builder
+	sb.WriteString("CHECK-IN BOARD\n\n")
+	if len(m.checkIns) == 0 {
+		sb.WriteString("No node has check-ins on.\n")
+	}
+	now := time.Now()
+	for _, c := range m.checkIns {
+		name := c.Nick
+		if c.NodeID == m.nodeID {
+			name += " (you)"
+		}
+		due := fmt.Sprintf("due %s, in %s", c.Due.Format("15:04"), c.Due.Sub(now).Round(time.Minute))
+		style := lipgloss.NewStyle()
+		if c.Overdue {
+			due = fmt.Sprintf("OVERDUE %s since %s", now.Sub(c.Due).Round(time.Minute), c.Due.Format("15:04"))
+			style = alertStyle
+		}
+		if c.Missed {
+			due += ", SOS sent"
+		}
+		pos := "-"
+		if c.Lat != 0 || c.Long != 0 {
+			pos = fmt.Sprintf("%.4f,%.4f", c.Lat, c.Long)
+		}
+		every := time.Duration(c.Interval) * time.Second
+		sb.WriteString(style.Render(fmt.Sprintf("%-16s every %-8s last %s  %s  %s", name, every, c.Last.Format("15:04"), due, pos)) + "\n")
+	}
+	if m.intervalInput.Focused() {
+		sb.WriteString("\nCheck in every: " + m.intervalInput.View() + "\n")
+	}
+	if m.checkInMessage != "" {
+		sb.WriteString("\n" + m.checkInMessage + "\n")
+	}
+	sb.WriteString("\nc check in · s set interval · x turn off · w close")
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(colorRed).
+		Padding(1).
+		Render(sb.String())
+}
@@ -0,0 +1,177 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"gorm.io/gorm"
+)
+
+// incidentWindow matches the engine's: SOS of the last week are tracked.
+const incidentWindow = 7 * 24 * time.Hour
+
+func loadIncidents(db *gorm.DB) []store.Incident {
+	incidents, _ := store.Incidents(db, time.Now().Add(-incidentWindow))
+	return incidents
+}
+
+// openIncidents counts the incidents not yet resolved.
+func (m model) openIncidents() int {
+	n := 0
+	for _, inc := range m.incidents {
+		if inc.State != store.IncidentResolved {
+			n++
+		}
+	}
+	return n
+}
+
+func newTeamInput() textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = "team to assign"
+	ti.CharLimit = 64
+	ti.Width = 30
+	return ti
+}
+
+// ownSOS is the latest unresolved SOS this node sent, if any.
+func (m model) ownSOS() (store.Incident, bool) {
+	for _, inc := range m.incidents {
+		if inc.ReporterID == m.nodeID && inc.State != store.IncidentResolved {
+			return inc, true
+		}
+	}
+	return store.Incident{}, false
+}
+
+// updateIncidentBoard handles keys while the incident board is open: a
+// acknowledge, g assign (asks for a team), e en route, r resolve, c cancel
+// an SOS this node sent.
+func (m model) updateIncidentBoard(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.teamInput.Focused() {
+		switch msg.String() {
+		case "esc":
+			m.teamInput.Blur()
+		case "enter":
+			m.teamInput.Blur()
+			m.moveIncident(store.IncidentAssigned, strings.TrimSpace(m.teamInput.Value()))
+		default:
+			var cmd tea.Cmd
+			m.teamInput, cmd = m.teamInput.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+	}
+	switch msg.String() {
+	case "esc", "i":
+		m.showIncidents = false
+	case "up":
+		if m.incidentCursor > 0 {
+			m.incidentCursor--
+		}
+	case "down":
+		if m.incidentCursor < len(m.incidents)-1 {
+			m.incidentCursor++
+		}
+	case "a":
+		m.moveIncident(store.IncidentAcknowledged, "")
+	case "g":
+		if m.incidentCursor < len(m.incidents) {
+			m.teamInput.SetValue("")
+			m.teamInput.Focus()
+		}
+	case "e":
+		m.moveIncident(store.IncidentEnRoute, "")
+	case "r":
+		m.moveIncident(store.IncidentResolved, "")
+	case "c":
+		m.cancelIncident()
+	}
+	return m, nil
+}
+
+func (m *model) cancelIncident() {
+	if m.incidentCursor >= len(m.incidents) {
+		return
+	}
+	inc := m.incidents[m.incidentCursor]
+	if inc.ReporterID != m.nodeID {
+		m.incidentMessage = "Only the sender of an SOS can cancel it"
+		return
+	}
+	if err := m.publisher.CancelSOS(inc.ID); err != nil {
+		m.incidentMessage = err.Error()
+		return
+	}
+	m.incidentMessage = ""
+	m.incidents = loadIncidents(m.db)
+}
+
+func (m *model) moveIncident(state, team string) {
+	if m.incidentCursor >= len(m.incidents) {
+		return
+	}
+	inc := m.incidents[m.incidentCursor]
+	if err := m.publisher.UpdateIncident(inc.ID, state, team, ""); err != nil {
+		m.incidentMessage = err.Error()
+		return
+	}
+	m.incidentMessage = ""
+	m.incidents = loadIncidents(m.db)
+}
+
+func (m model) renderIncidentBoard() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("INCIDENT BOARD (%d open)\n\n", m.openIncidents()))
+	if len(m.incidents) == 0 {
+		sb.WriteString("No SOS in the last week.\n")
+	}
+	for i, inc := range m.incidents {
+		cursor := "  "
+		if i == m.incidentCursor {
+			cursor = "> "
+		}
+		what := inc.Summary
+		if inc.SOS != nil {
+			what = sosTags(*inc.SOS)
+		}
+		state := incidentStyle(inc.State).Render(fmt.Sprintf("%-12s", strings.ToUpper(inc.State)))
+		sb.WriteString(fmt.Sprintf("%s%s %s %-10s %s\n", cursor, inc.Opened.Format("15:04"), state, inc.Reporter, what))
+		if inc.State != store.IncidentOpen {
+			sb.WriteString(fmt.Sprintf("      %s (%s)\n", inc.StatusLine(), inc.Updated.Format("15:04")))
+		} else if inc.Repeats > 0 {
+			heard := fmt.Sprintf("      re-sent %d×, last heard %s", inc.Repeats, inc.LastHeard.Format("15:04"))
+			if inc.Battery != nil {
+				heard += ", battery " + battery(inc.Battery)
+			}
+			sb.WriteString(heard + "\n")
+		}
+	}
+	if m.teamInput.Focused() {
+		sb.WriteString("\nAssign to: " + m.teamInput.View() + "\n")
+	}
+	if m.incidentMessage != "" {
+		sb.WriteString("\n" + m.incidentMessage + "\n")
+	}
+	sb.WriteString("\n↑/↓ select · a acknowledge · g assign · e en route · r resolve · c cancel own · i close")
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(colorRed).
+		Padding(1).
+		Render(sb.String())
+}
+
+func incidentStyle(state string) lipgloss.Style {
+	switch state {
+	case store.IncidentOpen:
+		return lipgloss.NewStyle().Foreground(colorRed).Bold(true)
+	case store.IncidentResolved:
+		return lipgloss.NewStyle().Foreground(colorGray)
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+}
@@ -1,13 +1,16 @@
 package tui
 
 import (
+	"fmt"
 	"sort"
 	"time"
 
+	"github.com/bit2swaz/crisismesh/internal/discovery"
 	"github.com/bit2swaz/crisismesh/internal/store"
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -21,38 +24,68 @@ const (
 	TabComms = iota
 	TabNetwork
 	TabGuide
+	TabReputation
 )
 
 type Publisher interface {
 	PublishText(content string, author string, lat float64, long float64) error
 	ManualConnect(addr string) error
 	BroadcastSafe() error
+	AcceptPeerKey(peerID string) error
+	RejectPeerKey(peerID string) error
+	SetPeerVerified(peerID string, verified bool) error
+	Fingerprint() string
+	FingerprintURI() string
+	ClockOffset() time.Duration
+	TimeStratum() int
+	CertifiedRole() string
+	PublishSOS(sos store.SOS, author string, lat, long float64) (string, error)
+	UpdateIncident(id, state, team, note string) error
+	CancelSOS(id string) error
+	CheckIn() error
+	SetCheckIn(interval time.Duration) error
+	OwnCheckIn() store.CheckIn
+	StartRollCall(note string) (string, error)
+	AnswerRollCall(id, name, status string) error
+	ReportPerson(rep store.PersonReport) (string, error)
 }
 
 type keyMap struct {
-	Tab     key.Binding
-	Quit    key.Binding
-	Help    key.Binding
-	Safe    key.Binding
-	Monitor key.Binding
-	QR      key.Binding
+	Tab       key.Binding
+	Quit      key.Binding
+	Help      key.Binding
+	Safe      key.Binding
+	Monitor   key.Binding
+	QR        key.Binding
+	Keys      key.Binding
+	Verify    key.Binding
+	Ack       key.Binding
+	SOS       key.Binding
+	Filter    key.Binding
+	Incidents key.Binding
+	CheckIn   key.Binding
+	CheckIns  key.Binding
+	RollCall  key.Binding
+	RollSafe  key.Binding
+	NeedHelp  key.Binding
+	People    key.Binding
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Help, k.Quit, k.Monitor, k.QR}
+	return []key.Binding{k.Help, k.Quit, k.Monitor, k.QR, k.Keys, k.Verify}
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.Tab, k.Safe, k.Monitor, k.QR},
+		{k.Tab, k.Safe, k.SOS, k.Filter, k.Incidents, k.CheckIn, k.CheckIns, k.RollCall, k.RollSafe, k.NeedHelp, k.People, k.Monitor, k.QR, k.Keys, k.Verify, k.Ack},
 		{k.Help, k.Quit},
 	}
 }
 
 var keys = keyMap{
 	Tab: key.NewBinding(
-		key.WithKeys("f1", "f2", "f3"),
-		key.WithHelp("F1-F3", "switch tabs"),
+		key.WithKeys("f1", "f2", "f3", "f4"),
+		key.WithHelp("F1-F4", "switch tabs"),
 	),
 	Quit: key.NewBinding(
 		key.WithKeys("ctrl+c", "esc"),
@@ -74,6 +107,54 @@ var keys = keyMap{
 		key.WithKeys("q"),
 		key.WithHelp("q", "toggle QR"),
 	),
+	Keys: key.NewBinding(
+		key.WithKeys("k"),
+		key.WithHelp("k", "review key changes"),
+	),
+	Verify: key.NewBinding(
+		key.WithKeys("f"),
+		key.WithHelp("f", "fingerprints"),
+	),
+	Ack: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "acknowledge alert"),
+	),
+	SOS: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "send SOS"),
+	),
+	Filter: key.NewBinding(
+		key.WithKeys("t"),
+		key.WithHelp("t", "filter SOS by type"),
+	),
+	Incidents: key.NewBinding(
+		key.WithKeys("i"),
+		key.WithHelp("i", "incident board"),
+	),
+	CheckIn: key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "check in"),
+	),
+	CheckIns: key.NewBinding(
+		key.WithKeys("w"),
+		key.WithHelp("w", "check-in board"),
+	),
+	RollCall: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "roll call board"),
+	),
+	RollSafe: key.NewBinding(
+		key.WithKeys("y"),
+		key.WithHelp("y", "roll call: safe"),
+	),
+	NeedHelp: key.NewBinding(
+		key.WithKeys("n"),
+		key.WithHelp("n", "roll call: need help"),
+	),
+	People: key.NewBinding(
+		key.WithKeys("p"),
+		key.WithHelp("p", "person finder"),
+	),
 }
 
 type model struct {
@@ -86,6 +167,7 @@ type model struct {
 	err         error
 	msgSub      <-chan store.Message
 	peerSub     <-chan []store.Peer
+	eventSub    <-chan discovery.PeerEvent
 	publisher   Publisher
 
 	// New fields
@@ -99,14 +181,66 @@ type model struct {
 	qrCode          string
 	showQR          bool
 	lastMsgPriority int
+	peerEvents      []discovery.PeerEvent
+
+	// Key change review overlay: a/x accept or reject the selected peer.
+	showKeys   bool
+	keyCursor  int
+	keyMessage string
+
+	// Fingerprint overlay: v toggles the selected peer's verified mark.
+	showFingerprints bool
+	fpCursor         int
+
+	// Official alerts in force. Each takes over the screen until
+	// acknowledged with Enter, then stays pinned until it ends.
+	alerts      []store.ActiveAlert
+	ackedAlerts map[string]bool
+
+	// SOS form overlay, and the chat filter (see sosFilters).
+	showSOS    bool
+	sosForm    sosForm
+	chatFilter string
+
+	// Incident board: the SOS of the last week and the response to each.
+	incidents       []store.Incident
+	showIncidents   bool
+	incidentCursor  int
+	incidentMessage string
+	teamInput       textinput.Model
+
+	// Dead-man's switch: this node's, and the check-in board of every
+	// node with one on.
+	ownCheckIn     store.CheckIn
+	checkIns       []store.CheckInStatus
+	showCheckIns   bool
+	checkInMessage string
+	intervalInput  textinput.Model
+
+	// Roll call: the latest one's accountability board.
+	rollCall        store.RollCallStatus
+	hasRollCall     bool
+	showRollCall    bool
+	rollCallMessage string
+	noteInput       textinput.Model
+
+	// Person finder: the registry entries matching the search.
+	people        []store.Person
+	showPeople    bool
+	peopleCursor  int
+	peopleMessage string
+	peopleQuery   textinput.Model
+	personInput   textinput.Model
 }
 
-func initialModel(db *gorm.DB, nodeID string, msgSub <-chan store.Message, peerSub <-chan []store.Peer, pub Publisher, qrCode string) model {
-	var peers []store.Peer
-	db.Find(&peers)
+// maxSidebarEvents is how many liveness transitions the sidebar shows.
+const maxSidebarEvents = 5
+
+func initialModel(db *gorm.DB, nodeID string, msgSub <-chan store.Message, peerSub <-chan []store.Peer, eventSub <-chan discovery.PeerEvent, pub Publisher, qrCode string) model {
+	peers := loadPeers(db)
 	sortPeers(peers)
 
-	history, prio, _ := buildChatHistory(db, nodeID, false)
+	history, prio, _ := buildChatHistory(db, nodeID, false, "")
 	if history == "" {
 		history = "Welcome to CrisisMesh Node Dashboard\nPacket stream initialized...\n"
 	}
@@ -115,13 +249,14 @@ func initialModel(db *gorm.DB, nodeID string, msgSub <-chan store.Message, peerS
 	s.Spinner = spinner.Pulse
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
 
-	return model{
+	m := model{
 		db:              db,
 		nodeID:          nodeID,
 		peers:           peers,
 		chatHistory:     history,
 		msgSub:          msgSub,
 		peerSub:         peerSub,
+		eventSub:        eventSub,
 		publisher:       pub,
 		activeTab:       TabComms,
 		startTime:       time.Now(),
@@ -132,7 +267,34 @@ func initialModel(db *gorm.DB, nodeID string, msgSub <-chan store.Message, peerS
 		qrCode:          qrCode,
 		showQR:          false,
 		lastMsgPriority: prio,
+		alerts:          loadAlerts(db),
+		ackedAlerts:     make(map[string]bool),
+		sosForm:         newSOSForm(),
+		incidents:       loadIncidents(db),
+		teamInput:       newTeamInput(),
+		intervalInput:   newIntervalInput(),
+		noteInput:       newNoteInput(),
+		peopleQuery:     newPeopleQuery(),
+		personInput:     newPersonInput(),
+	}
+	m.loadCheckIns()
+	m.loadRollCall()
+	return m
+}
+
+func loadAlerts(db *gorm.DB) []store.ActiveAlert {
+	alerts, _ := store.ActiveAlerts(db, time.Now())
+	return alerts
+}
+
+// pendingAlert is the most severe alert not yet acknowledged, or nil.
+func (m model) pendingAlert() *store.ActiveAlert {
+	for i, a := range m.alerts {
+		if !m.ackedAlerts[a.ID] {
+			return &m.alerts[i]
+		}
 	}
+	return nil
 }
 
 func (m model) Init() tea.Cmd {
@@ -141,6 +303,7 @@ func (m model) Init() tea.Cmd {
 		m.spinner.Tick,
 		WaitForUpdates(m.msgSub),
 		WaitForPeerUpdates(m.peerSub),
+		WaitForPeerEvents(m.eventSub),
 	)
 }
 
@@ -156,6 +319,12 @@ func WaitForPeerUpdates(sub <-chan []store.Peer) tea.Cmd {
 	}
 }
 
+func WaitForPeerEvents(sub <-chan discovery.PeerEvent) tea.Cmd {
+	return func() tea.Msg {
+		return <-sub
+	}
+}
+
 func tick() tea.Cmd {
 	return tea.Tick(1*time.Second, func(t time.Time) tea.Msg {
 		return tickMsg(t)
@@ -175,7 +344,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	)
 	switch msg := msg.(type) {
 	case store.Message:
-		newHistory, prio, err := buildChatHistory(m.db, m.nodeID, m.monitorMode)
+		m.alerts = loadAlerts(m.db)
+		m.incidents = loadIncidents(m.db)
+		m.loadCheckIns()
+		m.loadRollCall()
+		if m.showPeople {
+			m.loadPeople()
+		}
+		newHistory, prio, err := buildChatHistory(m.db, m.nodeID, m.monitorMode, m.chatFilter)
 		if err == nil {
 			m.chatHistory = newHistory
 			m.lastMsgPriority = prio
@@ -199,12 +375,22 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.peers = msg
 		sortPeers(m.peers)
 		return m, WaitForPeerUpdates(m.peerSub)
+	case discovery.PeerEvent:
+		m.peerEvents = append(m.peerEvents, msg)
+		if len(m.peerEvents) > maxSidebarEvents {
+			m.peerEvents = m.peerEvents[len(m.peerEvents)-maxSidebarEvents:]
+		}
+		return m, WaitForPeerEvents(m.eventSub)
 	case tickMsg:
-		var peers []store.Peer
-		m.db.Find(&peers)
-		sortPeers(peers)
-		m.peers = peers
-		newHistory, prio, err := buildChatHistory(m.db, m.nodeID, m.monitorMode)
+		m.peers = loadPeers(m.db)
+		m.alerts = loadAlerts(m.db)
+		m.incidents = loadIncidents(m.db)
+		m.loadCheckIns()
+		m.loadRollCall()
+		if m.showPeople {
+			m.loadPeople()
+		}
+		newHistory, prio, err := buildChatHistory(m.db, m.nodeID, m.monitorMode, m.chatFilter)
 		if err == nil && newHistory != m.chatHistory {
 			m.chatHistory = newHistory
 			m.lastMsgPriority = prio
@@ -217,6 +403,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.spinner, cmd = m.spinner.Update(msg)
 		return m, cmd
 	case tea.KeyMsg:
+		if a := m.pendingAlert(); a != nil && key.Matches(msg, m.keys.Ack) {
+			m.ackedAlerts[a.ID] = true
+			return m, nil
+		}
+		if m.showSOS && msg.Type != tea.KeyCtrlC {
+			return m.updateSOSForm(msg)
+		}
+		if m.showIncidents && msg.Type != tea.KeyCtrlC {
+			return m.updateIncidentBoard(msg)
+		}
+		if m.showCheckIns && msg.Type != tea.KeyCtrlC {
+			return m.updateCheckInBoard(msg)
+		}
+		if m.showRollCall && msg.Type != tea.KeyCtrlC {
+			return m.updateRollCallBoard(msg)
+		}
+		if m.showPeople && msg.Type != tea.KeyCtrlC {
+			return m.updatePeople(msg)
+		}
 		switch {
 		case key.Matches(msg, m.keys.Quit):
 			return m, tea.Quit
@@ -224,12 +429,53 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.help.ShowAll = !m.help.ShowAll
 		case key.Matches(msg, m.keys.Monitor):
 			m.monitorMode = !m.monitorMode
-			newHistory, prio, _ := buildChatHistory(m.db, m.nodeID, m.monitorMode)
+			newHistory, prio, _ := buildChatHistory(m.db, m.nodeID, m.monitorMode, m.chatFilter)
 			m.chatHistory = newHistory
 			m.lastMsgPriority = prio
 			m.viewport.SetContent(m.chatHistory)
+		case key.Matches(msg, m.keys.SOS):
+			m.showSOS = true
+			m.sosForm.message = ""
+		case key.Matches(msg, m.keys.Incidents):
+			m.showIncidents = true
+			m.incidentCursor, m.incidentMessage = 0, ""
+		case key.Matches(msg, m.keys.CheckIns):
+			m.showCheckIns = true
+			m.checkInMessage = ""
+		case key.Matches(msg, m.keys.CheckIn) && m.ownCheckIn.Armed():
+			m.checkIn()
+		case key.Matches(msg, m.keys.RollCall):
+			m.showRollCall = true
+			m.rollCallMessage = ""
+		case key.Matches(msg, m.keys.People):
+			m.showPeople = true
+			m.peopleMessage = ""
+			m.loadPeople()
+		case key.Matches(msg, m.keys.RollSafe) && m.rollCallPending():
+			m.answerRollCall(store.RollCallSafe)
+		case key.Matches(msg, m.keys.NeedHelp) && m.rollCallPending():
+			m.answerRollCall(store.RollCallHelp)
+		case key.Matches(msg, m.keys.Filter):
+			m.chatFilter = cycle(sosFilters, m.chatFilter, 1)
+			newHistory, prio, _ := buildChatHistory(m.db, m.nodeID, m.monitorMode, m.chatFilter)
+			m.chatHistory = newHistory
+			m.lastMsgPriority = prio
+			m.viewport.SetContent(m.chatHistory)
+			m.viewport.GotoBottom()
 		case key.Matches(msg, m.keys.QR):
 			m.showQR = !m.showQR
+		case key.Matches(msg, m.keys.Keys):
+			m.showKeys = !m.showKeys
+			m.keyCursor, m.keyMessage = 0, ""
+		case key.Matches(msg, m.keys.Verify):
+			m.showFingerprints = !m.showFingerprints
+			m.fpCursor, m.keyMessage = 0, ""
+		case m.showKeys:
+			m.updateKeyReview(msg.String())
+			return m, nil
+		case m.showFingerprints:
+			m.updateFingerprints(msg.String())
+			return m, nil
 		case key.Matches(msg, m.keys.Tab):
 			// Cycle tabs for simplicity or keep F-keys if preferred, but prompt said "Bind ? key".
 			// The existing code used F1, F2, F3. I'll keep F-keys logic but maybe map them in keyMap.
@@ -246,6 +492,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.activeTab = TabNetwork
 		case tea.KeyF3:
 			m.activeTab = TabGuide
+		case tea.KeyF4:
+			m.activeTab = TabReputation
 		case tea.KeyCtrlS:
 			if err := m.publisher.BroadcastSafe(); err != nil {
 			}
@@ -268,6 +516,91 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(tiCmd, vpCmd)
 }
 
+// keyChanges returns the peers whose key changed and awaits review.
+func (m model) keyChanges() []store.Peer {
+	var changed []store.Peer
+	for _, p := range m.peers {
+		if p.KeyStatus == store.KeyChanged {
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+func (m *model) updateKeyReview(k string) {
+	changed := m.keyChanges()
+	switch k {
+	case "up":
+		if m.keyCursor > 0 {
+			m.keyCursor--
+		}
+	case "down":
+		if m.keyCursor < len(changed)-1 {
+			m.keyCursor++
+		}
+	case "a", "x":
+		if m.keyCursor >= len(changed) {
+			return
+		}
+		p := changed[m.keyCursor]
+		review, verb := m.publisher.AcceptPeerKey, "Accepted"
+		if k == "x" {
+			review, verb = m.publisher.RejectPeerKey, "Rejected"
+		}
+		if err := review(p.ID); err != nil {
+			m.keyMessage = err.Error()
+			return
+		}
+		m.keyMessage = fmt.Sprintf("%s new key for %s", verb, p.Nick)
+		m.peers = loadPeers(m.db)
+		m.keyCursor = 0
+	}
+}
+
+// verifiablePeers are the peers with a pinned signing key to compare.
+func (m model) verifiablePeers() []store.Peer {
+	var out []store.Peer
+	for _, p := range m.peers {
+		if p.SignKey != "" && p.KeyStatus != store.KeyChanged {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (m *model) updateFingerprints(k string) {
+	peers := m.verifiablePeers()
+	switch k {
+	case "up":
+		if m.fpCursor > 0 {
+			m.fpCursor--
+		}
+	case "down":
+		if m.fpCursor < len(peers)-1 {
+			m.fpCursor++
+		}
+	case "v":
+		if m.fpCursor >= len(peers) {
+			return
+		}
+		p := peers[m.fpCursor]
+		if err := m.publisher.SetPeerVerified(p.ID, !p.Verified); err != nil {
+			m.keyMessage = err.Error()
+			return
+		}
+		m.keyMessage = ""
+		m.peers = loadPeers(m.db)
+	}
+}
+
+// loadPeers returns every peer the failure detector has not yet forgotten.
+func loadPeers(db *gorm.DB) []store.Peer {
+	var peers []store.Peer
+	db.Where("state <> ?", discovery.StateForgotten).Find(&peers)
+	sortPeers(peers)
+	return peers
+}
+
 func sortPeers(peers []store.Peer) {
 	sort.Slice(peers, func(i, j int) bool {
 		if peers[i].IsActive && !peers[j].IsActive {
@@ -280,8 +613,8 @@ func sortPeers(peers []store.Peer) {
 	})
 }
 
-func StartTUI(db *gorm.DB, nodeID string, msgSub <-chan store.Message, peerSub <-chan []store.Peer, pub Publisher, qrCode string) error {
-	p := tea.NewProgram(initialModel(db, nodeID, msgSub, peerSub, pub, qrCode), tea.WithAltScreen())
+func StartTUI(db *gorm.DB, nodeID string, msgSub <-chan store.Message, peerSub <-chan []store.Peer, eventSub <-chan discovery.PeerEvent, pub Publisher, qrCode string) error {
+	p := tea.NewProgram(initialModel(db, nodeID, msgSub, peerSub, eventSub, pub, qrCode), tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		return err
 	}
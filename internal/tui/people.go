@@ -0,0 +1,189 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxPeopleShown bounds the person finder's list; search narrows it.
+const maxPeopleShown = 15
+
+// personKeys are the keys that set the selected person's status.
+var personKeys = map[string]string{
+	"1": store.PersonSafe,
+	"2": store.PersonInjured,
+	"3": store.PersonMissing,
+	"4": store.PersonDeceased,
+}
+
+func (m *model) loadPeople() {
+	m.people, _ = store.People(m.db, m.peopleQuery.Value())
+	if m.peopleCursor >= len(m.people) {
+		m.peopleCursor = max(0, len(m.people)-1)
+	}
+}
+
+func newPeopleQuery() textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = "name, description or place"
+	ti.CharLimit = 100
+	ti.Width = 40
+	return ti
+}
+
+func newPersonInput() textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = "name; safe|injured|missing|deceased; last seen at"
+	ti.CharLimit = 320
+	ti.Width = 50
+	return ti
+}
+
+// updatePeople handles keys while the person finder is open: / search, a
+// add a person ("name; status; last seen at"), 1-4 mark the selected
+// person safe, injured, missing or deceased.
+func (m model) updatePeople(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.peopleQuery.Focused() {
+		switch msg.String() {
+		case "esc", "enter":
+			m.peopleQuery.Blur()
+		default:
+			var cmd tea.Cmd
+			m.peopleQuery, cmd = m.peopleQuery.Update(msg)
+			m.loadPeople()
+			return m, cmd
+		}
+		return m, nil
+	}
+	if m.personInput.Focused() {
+		switch msg.String() {
+		case "esc":
+			m.personInput.Blur()
+		case "enter":
+			m.personInput.Blur()
+			m.addPerson(m.personInput.Value())
+		default:
+			var cmd tea.Cmd
+			m.personInput, cmd = m.personInput.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+	}
+	switch msg.String() {
+	case "esc", "p":
+		m.showPeople = false
+	case "up":
+		if m.peopleCursor > 0 {
+			m.peopleCursor--
+		}
+	case "down":
+		if m.peopleCursor < min(len(m.people), maxPeopleShown)-1 {
+			m.peopleCursor++
+		}
+	case "/":
+		m.peopleQuery.Focus()
+	case "a":
+		m.personInput.SetValue("")
+		m.personInput.Focus()
+	default:
+		if status, ok := personKeys[msg.String()]; ok && m.peopleCursor < len(m.people) {
+			p := m.people[m.peopleCursor]
+			m.reportPerson(store.PersonReport{Person: p.ID, Name: p.Name, Status: status})
+		}
+	}
+	return m, nil
+}
+
+// addPerson reports a new person from "name; status; last seen at".
+func (m *model) addPerson(value string) {
+	parts := strings.SplitN(value, ";", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	rep := store.PersonReport{Name: strings.TrimSpace(parts[0]), Status: strings.ToLower(strings.TrimSpace(parts[1])),
+		Location: strings.TrimSpace(parts[2])}
+	if rep.Status == "" {
+		rep.Status = store.PersonMissing
+	}
+	m.reportPerson(rep)
+}
+
+func (m *model) reportPerson(rep store.PersonReport) {
+	if _, err := m.publisher.ReportPerson(rep); err != nil {
+		m.peopleMessage = err.Error()
+	} else {
+		m.peopleMessage = fmt.Sprintf("Reported %s %s", rep.Name, rep.Status)
+	}
+	m.loadPeople()
+}
+
+func personStyle(status string) lipgloss.Style {
+	switch status {
+	case store.PersonMissing:
+		return alertStyle
+	case store.PersonInjured:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	case store.PersonDeceased:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	}
+	return lipgloss.NewStyle().Foreground(colorGreen)
+}
+
+func (m model) renderPeople() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("PERSON FINDER (%d found)\n\n", len(m.people)))
+	sb.WriteString("Search: " + m.peopleQuery.View() + "\n\n")
+	if len(m.people) == 0 {
+		sb.WriteString("Nobody found.\n")
+	}
+	for i, p := range m.people[:min(len(m.people), maxPeopleShown)] {
+		cursor := "  "
+		if i == m.peopleCursor {
+			cursor = "> "
+		}
+		where := p.Location
+		if where == "" {
+			where = "-"
+		}
+		sb.WriteString(personStyle(p.Status).Render(fmt.Sprintf("%s%-24s %-9s %s", cursor, p.Name, strings.ToUpper(p.Status), where)) + "\n")
+	}
+	if m.peopleCursor < len(m.people) {
+		p := m.people[m.peopleCursor]
+		sb.WriteString("\n")
+		if p.Description != "" {
+			sb.WriteString(p.Description + "\n")
+		}
+		if p.Photo != "" {
+			sb.WriteString("Photo SHA-256: " + p.Photo[:16] + "...\n")
+		}
+		for _, r := range p.Reports[max(0, len(p.Reports)-4):] {
+			line := fmt.Sprintf("%s %s: %s", r.At.Format("Jan 2 15:04"), r.Reporter, strings.ToUpper(r.Status))
+			if r.Location != "" {
+				line += " at " + r.Location
+			}
+			if r.Note != "" {
+				line += " - " + r.Note
+			}
+			sb.WriteString(line + "\n")
+		}
+		sb.WriteString(fmt.Sprintf("Updated %s ago\n", time.Since(p.Updated).Round(time.Minute)))
+	}
+	if m.personInput.Focused() {
+		sb.WriteString("\nNew person: " + m.personInput.View() + "\n")
+	}
+	if m.peopleMessage != "" {
+		sb.WriteString("\n" + m.peopleMessage + "\n")
+	}
+	sb.WriteString("\n/ search · a add · 1 safe · 2 injured · 3 missing · 4 deceased · p close")
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(colorRed).
+		Padding(1).
+		Render(sb.String())
+}
@@ -0,0 +1,137 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+func (m *model) loadRollCall() {
+	m.rollCall, m.hasRollCall = store.RollCallStatus{}, false
+	if call, ok := store.LatestRollCall(m.db); ok {
+		board, err := store.RollCallBoard(m.db, call.ID)
+		m.rollCall, m.hasRollCall = board, err == nil
+	}
+}
+
+func newNoteInput() textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = "note, e.g. aftershock 14:02 (optional)"
+	ti.CharLimit = 256
+	ti.Width = 40
+	return ti
+}
+
+// rollCallPending reports whether the current roll call still waits on
+// this node's operator.
+func (m model) rollCallPending() bool {
+	if !m.hasRollCall || time.Since(m.rollCall.Started) > store.RollCallWindow {
+		return false
+	}
+	for _, e := range m.rollCall.Entries {
+		if e.NodeID == m.nodeID && e.Operator {
+			return !e.Accounted()
+		}
+	}
+	return true
+}
+
+func (m *model) answerRollCall(status string) {
+	if err := m.publisher.AnswerRollCall(m.rollCall.ID, "", status); err != nil {
+		m.rollCallMessage = err.Error()
+	}
+	m.loadRollCall()
+}
+
+// updateRollCallBoard handles keys while the accountability board is open:
+// n starts a new roll call (asks for a note).
+func (m model) updateRollCallBoard(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.noteInput.Focused() {
+		switch msg.String() {
+		case "esc":
+			m.noteInput.Blur()
+		case "enter":
+			m.noteInput.Blur()
+			if _, err := m.publisher.StartRollCall(strings.TrimSpace(m.noteInput.Value())); err != nil {
+				m.rollCallMessage = err.Error()
+			} else {
+				m.rollCallMessage = "Roll call sent"
+			}
+			m.loadRollCall()
+		default:
+			var cmd tea.Cmd
+			m.noteInput, cmd = m.noteInput.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+	}
+	switch msg.String() {
+	case "esc", "r":
+		m.showRollCall = false
+	case "n":
+		m.noteInput.SetValue("")
+		m.noteInput.Focus()
+	}
+	return m, nil
+}
+
+func (m model) renderRollCallBoard() string {
+	var sb strings.Builder
+	rc := m.rollCall
+	if !m.hasRollCall {
+		sb.WriteString("ROLL CALL\n\nNo roll call yet.\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("ROLL CALL by %s at %s (%s ago)\n", rc.Caller, rc.Started.Format("15:04"), time.Since(rc.Started).Round(time.Second)))
+		if rc.Note != "" {
+			sb.WriteString(rc.Note + "\n")
+		}
+		sb.WriteString("\n" + alertStyle.Render(fmt.Sprintf("NEED HELP %d", rc.Help)) + "  " +
+			lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render(fmt.Sprintf("NO RESPONSE %d", rc.Missing)) + "  " +
+			lipgloss.NewStyle().Foreground(colorGreen).Render(fmt.Sprintf("SAFE %d", rc.Safe)) + "\n\n")
+		for _, e := range rc.Entries {
+			status := strings.ToUpper(e.Status)
+			style := lipgloss.NewStyle().Foreground(colorGreen)
+			switch {
+			case e.Status == store.RollCallHelp:
+				status, style = "NEED HELP", alertStyle
+			case !e.Accounted():
+				status, style = "NO RESPONSE", lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+			}
+			if e.Auto {
+				status += " (auto)"
+			}
+			if e.HelpEarlier {
+				status += " (needed help earlier)"
+				style = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+			}
+			where := "via " + e.Node
+			if e.Node == "" {
+				where = "via " + shortID(e.NodeID)
+			}
+			if e.Operator {
+				where = "node " + shortID(e.NodeID)
+			}
+			if e.NodeID == m.nodeID && e.Operator {
+				where += " (you)"
+			}
+			sb.WriteString(style.Render(fmt.Sprintf("%-20s %-20s %s", e.Name, status, where)) + "\n")
+		}
+	}
+	if m.noteInput.Focused() {
+		sb.WriteString("\nNew roll call: " + m.noteInput.View() + "\n")
+	}
+	if m.rollCallMessage != "" {
+		sb.WriteString("\n" + m.rollCallMessage + "\n")
+	}
+	sb.WriteString("\nn new roll call · r close")
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(colorRed).
+		Padding(1).
+		Render(sb.String())
+}
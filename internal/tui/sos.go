@@ -0,0 +1,172 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Fields of the SOS form, in order.
+const (
+	sosFieldType = iota
+	sosFieldPeople
+	sosFieldInjured
+	sosFieldMobility
+	sosFieldNotes
+	sosFieldCount
+)
+
+// sosForm is the overlay for sending a structured SOS from the TUI.
+type sosForm struct {
+	field   int
+	sos     store.SOS
+	notes   textinput.Model
+	message string
+}
+
+func newSOSForm() sosForm {
+	notes := textinput.New()
+	notes.Placeholder = "what, where exactly, hazards"
+	notes.CharLimit = 512
+	notes.Width = 40
+	return sosForm{sos: store.SOS{Type: store.EmergencyMedical}, notes: notes}
+}
+
+// sosFilters are what the chat filter cycles through: everything, every
+// SOS, then each emergency type.
+var sosFilters = append([]string{"", "sos"}, store.EmergencyTypes...)
+
+// matchesFilter reports whether msg is shown under the chat filter f.
+func matchesFilter(msg store.Message, f string) bool {
+	if f == "" {
+		return true
+	}
+	sos, ok := store.ParseSOS(msg)
+	if !ok {
+		return f == "sos" && msg.Priority == 2
+	}
+	return f == "sos" || sos.Type == f
+}
+
+// cycle steps v through values by delta, wrapping around.
+func cycle(values []string, v string, delta int) string {
+	i := slices.Index(values, v) + delta
+	n := len(values)
+	return values[((i%n)+n)%n]
+}
+
+// updateSOSForm handles keys while the SOS form is open.
+func (m model) updateSOSForm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	f := &m.sosForm
+	switch msg.String() {
+	case "esc":
+		m.showSOS = false
+		return m, nil
+	case "up", "shift+tab":
+		f.field = (f.field + sosFieldCount - 1) % sosFieldCount
+	case "down", "tab":
+		f.field = (f.field + 1) % sosFieldCount
+	case "left", "right":
+		delta := 1
+		if msg.String() == "left" {
+			delta = -1
+		}
+		switch f.field {
+		case sosFieldType:
+			f.sos.Type = cycle(store.EmergencyTypes, f.sos.Type, delta)
+		case sosFieldPeople:
+			f.sos.People = max(0, f.sos.People+delta)
+		case sosFieldInjured:
+			f.sos.Injured = max(0, f.sos.Injured+delta)
+		case sosFieldMobility:
+			f.sos.Mobility = cycle(append([]string{""}, store.Mobilities...), f.sos.Mobility, delta)
+		case sosFieldNotes:
+			var cmd tea.Cmd
+			f.notes, cmd = f.notes.Update(msg)
+			return m, cmd
+		}
+	case "enter":
+		sos := f.sos
+		sos.Notes = strings.TrimSpace(f.notes.Value())
+		if _, err := m.publisher.PublishSOS(sos, "", 0, 0); err != nil {
+			f.message = err.Error()
+			return m, nil
+		}
+		m.showSOS = false
+		m.sosForm = newSOSForm()
+	default:
+		if f.field == sosFieldNotes {
+			var cmd tea.Cmd
+			f.notes, cmd = f.notes.Update(msg)
+			return m, cmd
+		}
+	}
+	if f.field == sosFieldNotes {
+		f.notes.Focus()
+	} else {
+		f.notes.Blur()
+	}
+	return m, nil
+}
+
+func (m model) renderSOSForm() string {
+	f := m.sosForm
+	count := func(n int) string {
+		if n == 0 {
+			return "?"
+		}
+		return fmt.Sprint(n)
+	}
+	mobility := f.sos.Mobility
+	if mobility == "" {
+		mobility = "unknown"
+	}
+	rows := []string{
+		"TYPE      ◂ " + strings.ToUpper(f.sos.Type) + " ▸",
+		"PEOPLE    ◂ " + count(f.sos.People) + " ▸",
+		"INJURED   ◂ " + count(f.sos.Injured) + " ▸",
+		"MOBILITY  ◂ " + strings.ToUpper(mobility) + " ▸",
+		"NOTES     " + f.notes.View(),
+	}
+	var sb strings.Builder
+	sb.WriteString("SEND SOS\n\n")
+	for i, r := range rows {
+		cursor := "  "
+		if i == f.field {
+			cursor = "> "
+		}
+		sb.WriteString(cursor + r + "\n")
+	}
+	if f.message != "" {
+		sb.WriteString("\n" + f.message + "\n")
+	}
+	sb.WriteString("\n↑/↓ field · ←/→ change · enter send · esc close")
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(colorRed).
+		Padding(1).
+		Render(sb.String())
+}
+
+// sosTags renders an SOS's details for the chat stream.
+func sosTags(sos store.SOS) string {
+	tags := []string{"SOS: " + strings.ToUpper(sos.Type)}
+	if sos.People > 0 {
+		tags = append(tags, fmt.Sprintf("PEOPLE: %d", sos.People))
+	}
+	if sos.Injured > 0 {
+		tags = append(tags, fmt.Sprintf("INJURED: %d", sos.Injured))
+	}
+	if sos.Mobility != "" {
+		tags = append(tags, strings.ToUpper(sos.Mobility))
+	}
+	if sos.Accuracy > 0 {
+		tags = append(tags, fmt.Sprintf("±%.0fm", sos.Accuracy))
+	}
+	return "[" + strings.Join(tags, "] [") + "]"
+}
@@ -44,3 +44,27 @@ func TestPeerSorting(t *testing.T) {
 		t.Errorf("Expected third peer to be Alpha, got %s", peers[2].Nick)
 	}
 }
+
+func TestMatchesFilter(t *testing.T) {
+	chat := store.Message{Content: "hello"}
+	bare := store.Message{Content: "PRIORITY ALERT: SOS", Priority: 2}
+	fire := store.Message{Priority: 2, Payload: `{"type":"fire"}`}
+	cases := []struct {
+		filter string
+		msg    store.Message
+		want   bool
+	}{
+		{"", chat, true},
+		{"sos", chat, false},
+		{"sos", bare, true},
+		{"sos", fire, true},
+		{"fire", fire, true},
+		{"medical", fire, false},
+		{"fire", bare, false},
+	}
+	for _, c := range cases {
+		if got := matchesFilter(c.msg, c.filter); got != c.want {
+			t.Errorf("matchesFilter(%q, %q) = %v, want %v", c.msg.Content+c.msg.Payload, c.filter, got, c.want)
+		}
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bit2swaz/crisismesh/internal/clock"
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/discovery"
 	"github.com/bit2swaz/crisismesh/internal/store"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/lipgloss/table"
+	"github.com/skip2/go-qrcode"
 	"gorm.io/gorm"
 )
 
@@ -70,6 +74,18 @@ var (
 			BorderForeground(colorGreen).
 			Padding(0, 1)
 
+	verifiedStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("10")).
+			Bold(true)
+
+	roleStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("13")).
+			Bold(true)
+
+	statedRoleStyle = lipgloss.NewStyle().
+			Faint(true).
+			Italic(true)
+
 	streamStyle = lipgloss.NewStyle().
 			Border(lipgloss.NormalBorder()).
 			BorderForeground(colorGreen).
@@ -101,6 +117,23 @@ func (m model) View() string {
 	totalWidth := m.viewport.Width
 	totalHeight := m.viewport.Height
 
+	if a := m.pendingAlert(); a != nil {
+		return lipgloss.Place(totalWidth, totalHeight, lipgloss.Center, lipgloss.Center, renderAlert(*a, totalWidth))
+	}
+
+	banner := m.renderKeyBanner(totalWidth)
+	if banner != "" {
+		totalHeight--
+	}
+	if pins := m.renderAlertPins(totalWidth); pins != "" {
+		totalHeight -= lipgloss.Height(pins)
+		if banner == "" {
+			banner = pins
+		} else {
+			banner = lipgloss.JoinVertical(lipgloss.Left, pins, banner)
+		}
+	}
+
 	streamWidth := int(float64(totalWidth) * 0.7)
 	sidebarWidth := totalWidth - streamWidth - 4 // Adjust for borders
 
@@ -109,10 +142,42 @@ func (m model) View() string {
 	vp.Width = streamWidth
 	vp.Height = totalHeight
 
-	streamView := streamStyle.Width(streamWidth).Height(totalHeight).Render(vp.View())
+	streamContent := vp.View()
+	switch m.activeTab {
+	case TabNetwork:
+		streamContent = m.renderNetwork(streamWidth)
+	case TabReputation:
+		streamContent = m.renderReputation(streamWidth)
+	}
+	streamView := streamStyle.Width(streamWidth).Height(totalHeight).Render(streamContent)
 	sidebarView := m.renderSidebar(sidebarWidth, totalHeight)
 
 	body := lipgloss.JoinHorizontal(lipgloss.Top, streamView, sidebarView)
+	if banner != "" {
+		body = lipgloss.JoinVertical(lipgloss.Left, banner, body)
+	}
+
+	if m.showCheckIns {
+		return lipgloss.Place(totalWidth, totalHeight, lipgloss.Center, lipgloss.Center, m.renderCheckInBoard())
+	}
+	if m.showRollCall {
+		return lipgloss.Place(totalWidth, totalHeight, lipgloss.Center, lipgloss.Center, m.renderRollCallBoard())
+	}
+	if m.showPeople {
+		return lipgloss.Place(totalWidth, totalHeight, lipgloss.Center, lipgloss.Center, m.renderPeople())
+	}
+	if m.showIncidents {
+		return lipgloss.Place(totalWidth, totalHeight, lipgloss.Center, lipgloss.Center, m.renderIncidentBoard())
+	}
+	if m.showSOS {
+		return lipgloss.Place(totalWidth, totalHeight, lipgloss.Center, lipgloss.Center, m.renderSOSForm())
+	}
+	if m.showKeys {
+		return lipgloss.Place(totalWidth, totalHeight, lipgloss.Center, lipgloss.Center, m.renderKeyReview())
+	}
+	if m.showFingerprints {
+		return lipgloss.Place(totalWidth, totalHeight, lipgloss.Center, lipgloss.Center, m.renderFingerprints())
+	}
 
 	if m.showQR {
 		qrView := lipgloss.NewStyle().
@@ -145,15 +210,53 @@ func (m model) renderSidebar(width, height int) string {
  CRISISMESH
  v0.1.1
 `
-	identity := fmt.Sprintf("ID: %s\nKEY: %s...", m.nodeID[:8], "Curve25519")
+	identity := fmt.Sprintf("ID: %s\nROLE: %s\nFP: %s", m.nodeID[:8], strings.ToUpper(m.publisher.CertifiedRole()), splitFingerprint(m.publisher.Fingerprint()))
+	if n := m.openIncidents(); n > 0 {
+		identity += "\n" + alertStyle.Render(fmt.Sprintf("OPEN SOS: %d (i)", n))
+	}
+	if inc, ok := m.ownSOS(); ok {
+		identity += "\n" + alertStyle.Render("YOUR SOS: "+inc.StatusLine())
+	}
+	if line := m.checkInLine(); line != "" {
+		identity += "\n" + line
+	}
+	if m.rollCallPending() {
+		identity += "\n" + alertStyle.Render("ROLL CALL: y safe · n need help")
+	} else if m.hasRollCall && time.Since(m.rollCall.Started) < store.RollCallWindow {
+		identity += "\n" + fmt.Sprintf("ROLL CALL: %d/%d accounted (r)", m.rollCall.Safe+m.rollCall.Help, len(m.rollCall.Entries))
+	}
+	if m.chatFilter != "" {
+		identity += "\n" + alertStyle.Render("FILTER: "+strings.ToUpper(m.chatFilter))
+	}
 
 	t := table.New().
 		Border(lipgloss.HiddenBorder()).
-		Headers("ID", "RTT", "SEEN").
-		Width(width)
+		Headers("ID", "STATE", "SEEN", "BAT").
+		Width(width).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row >= 0 && row < len(m.peers) && col == 1 {
+				if m.peers[row].KeyStatus == store.KeyChanged {
+					return lipgloss.NewStyle().Foreground(colorRed).Bold(true)
+				}
+				return stateStyle(m.peers[row].State)
+			}
+			if row >= 0 && row < len(m.peers) && col == 3 {
+				return batteryStyle(m.peers[row].Battery)
+			}
+			return lipgloss.NewStyle()
+		})
 
 	for _, p := range m.peers {
-		t.Row(p.ID[:4], "12ms", "Now")
+		state := strings.ToUpper(p.State)
+		if p.KeyStatus == store.KeyChanged {
+			state = "KEY!"
+		}
+		t.Row(shortID(p.ID)+verifiedMark(p.Verified), state, seenAgo(p.LastSeen), battery(p.Battery))
+	}
+
+	var events strings.Builder
+	for _, ev := range m.peerEvents {
+		events.WriteString(fmt.Sprintf("%s %s %s\n", ev.At.Format("15:04:05"), shortID(ev.PeerID), stateStyle(ev.To).Render(strings.ToUpper(ev.To))))
 	}
 
 	encStatus := "ENCRYPTION: ACTIVE\nCurve25519 + XSalsa20"
@@ -165,20 +268,290 @@ func (m model) renderSidebar(width, height int) string {
 		"\n",
 		"NETWORK HEALTH:",
 		t.Render(),
-		"\n",
+		"LINK EVENTS:",
+		events.String(),
 		encStatus,
 	)
 
 	return sidebarStyle.Width(width).Height(height).Render(content)
 }
 
-func buildChatHistory(db *gorm.DB, nodeID string, monitorMode bool) (string, int, error) {
+// renderKeyBanner warns about peers whose key changed, or returns "".
+func (m model) renderKeyBanner(width int) string {
+	changed := m.keyChanges()
+	if len(changed) == 0 {
+		return ""
+	}
+	var names []string
+	for _, p := range changed {
+		names = append(names, p.Nick)
+	}
+	text := fmt.Sprintf(" ⚠ KEY CHANGED: %s — DMs blocked, press k to review", strings.Join(names, ", "))
+	return alertStyle.Width(width).Render(text)
+}
+
+// renderAlert is the full-screen interruption for an unacknowledged alert.
+func renderAlert(a store.ActiveAlert, width int) string {
+	issuer := a.Issuer
+	if issuer == "" {
+		issuer = a.IssuerID[:min(8, len(a.IssuerID))]
+	}
+	var sb strings.Builder
+	sb.WriteString(alertStyle.Render(" OFFICIAL ALERT ") + "\n\n")
+	sb.WriteString(lipgloss.NewStyle().Bold(true).Render(strings.ToUpper(a.Headline)) + "\n\n")
+	sb.WriteString(fmt.Sprintf("%s / %s — %s\n", strings.ToUpper(a.Severity), strings.ToUpper(a.Urgency), a.Area))
+	sb.WriteString(fmt.Sprintf("From %s (%s) at %s, until %s\n", issuer, a.Role,
+		a.Sent.Format("15:04"), time.Unix(a.Expires, 0).Format("15:04 Jan 2")))
+	if a.Instructions != "" {
+		sb.WriteString("\n" + a.Instructions + "\n")
+	}
+	sb.WriteString("\nPress ENTER to acknowledge")
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(lipgloss.Color("#FF0000")).
+		Padding(1, 3).
+		Width(min(width-4, 72)).
+		Align(lipgloss.Center).
+		Render(sb.String())
+}
+
+// renderAlertPins lists acknowledged alerts still in force, one per line.
+func (m model) renderAlertPins(width int) string {
+	var lines []string
+	for _, a := range m.alerts {
+		if m.ackedAlerts[a.ID] {
+			text := fmt.Sprintf(" ⚠ %s — %s — until %s", a.Headline, a.Area, time.Unix(a.Expires, 0).Format("15:04"))
+			lines = append(lines, alertStyle.Width(width).MaxHeight(1).Render(text))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m model) renderKeyReview() string {
+	var sb strings.Builder
+	sb.WriteString("KEY CHANGE REVIEW\n\n")
+	changed := m.keyChanges()
+	if len(changed) == 0 {
+		sb.WriteString("No key changes pending.\n")
+	}
+	for i, p := range changed {
+		cursor := "  "
+		if i == m.keyCursor {
+			cursor = "> "
+		}
+		sb.WriteString(fmt.Sprintf("%s%s (%s)\n    pinned:  %s\n    offered: %s\n",
+			cursor, p.Nick, shortID(p.ID), shortKey(p.SignKey), shortKey(p.PendingSignKey)))
+	}
+	if m.keyMessage != "" {
+		sb.WriteString("\n" + m.keyMessage + "\n")
+	}
+	sb.WriteString("\n↑/↓ select · a accept · x reject · k close")
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(colorRed).
+		Padding(1).
+		Render(sb.String())
+}
+
+func (m model) renderFingerprints() string {
+	var sb strings.Builder
+	sb.WriteString("KEY FINGERPRINTS\n\n")
+	sb.WriteString("This node: " + m.publisher.Fingerprint() + "\n")
+	if qr, err := qrcode.New(m.publisher.FingerprintURI(), qrcode.Low); err == nil {
+		sb.WriteString(qr.ToSmallString(false))
+	}
+	sb.WriteString("\nCompare with what each peer reads out or shows:\n\n")
+	peers := m.verifiablePeers()
+	if len(peers) == 0 {
+		sb.WriteString("No peers with keys yet.\n")
+	}
+	for i, p := range peers {
+		cursor := "  "
+		if i == m.fpCursor {
+			cursor = "> "
+		}
+		mark := "  "
+		if p.Verified {
+			mark = verifiedStyle.Render("✓ ")
+		}
+		sb.WriteString(fmt.Sprintf("%s%s%-12s %s\n", cursor, mark, p.Nick, core.Fingerprint(p.SignKey, p.PubKey)))
+	}
+	if m.keyMessage != "" {
+		sb.WriteString("\n" + m.keyMessage + "\n")
+	}
+	sb.WriteString("\n↑/↓ select · v mark verified/unverified · f close")
+	return lipgloss.NewStyle().
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(colorGreen).
+		Padding(1).
+		Render(sb.String())
+}
+
+// splitFingerprint breaks a fingerprint over two lines to fit the sidebar.
+func splitFingerprint(fp string) string {
+	groups := strings.Fields(fp)
+	half := (len(groups) + 1) / 2
+	return strings.Join(groups[:half], " ") + "\n    " + strings.Join(groups[half:], " ")
+}
+
+func verifiedMark(verified bool) string {
+	if verified {
+		return " ✓"
+	}
+	return ""
+}
+
+func shortKey(k string) string {
+	if len(k) > 16 {
+		return k[:16] + "…"
+	}
+	return k
+}
+
+// renderNetwork is the F2 tab: every peer's heartbeat status in full.
+func (m model) renderNetwork(width int) string {
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(colorGreen)).
+		Headers("NICK", "ID", "STATE", "STATED ROLE", "BAT", "QUEUE", "POSITION", "SEEN").
+		Width(width - 4).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row >= 0 && row < len(m.peers) {
+				switch col {
+				case 2:
+					return stateStyle(m.peers[row].State)
+				case 3:
+					// Self-declared in heartbeats; certified roles are shown
+					// on messages in roleStyle.
+					return statedRoleStyle
+				case 4:
+					return batteryStyle(m.peers[row].Battery)
+				}
+			}
+			return lipgloss.NewStyle()
+		})
+	for _, p := range m.peers {
+		role, queue, pos := "-", "-", "-"
+		if p.Role != "" {
+			role = p.Role
+		}
+		if p.QueueDepth > 0 {
+			queue = fmt.Sprintf("%d", p.QueueDepth)
+		}
+		if p.Lat != 0 || p.Long != 0 {
+			pos = fmt.Sprintf("%.4f, %.4f", p.Lat, p.Long)
+		}
+		t.Row(p.Nick+verifiedMark(p.Verified), shortID(p.ID), strings.ToUpper(p.State), role, battery(p.Battery), queue, pos, seenAgo(p.LastSeen))
+	}
+	return "FIELD DEVICES (F1 to return)\n" + t.Render() + "\n" + meshTime(m.publisher.ClockOffset(), m.publisher.TimeStratum())
+}
+
+// meshTime summarises how this node's clock is being corrected.
+func meshTime(offset time.Duration, stratum int) string {
+	switch {
+	case stratum == clock.StratumSource:
+		return "MESH TIME: this node is the time source"
+	case stratum >= clock.StratumUnsynced:
+		return fmt.Sprintf("MESH TIME: offset %+.3fs, no time source (peer median)", offset.Seconds())
+	}
+	return fmt.Sprintf("MESH TIME: offset %+.3fs, stratum %d", offset.Seconds(), stratum)
+}
+
+// renderReputation is the F4 tab: what this node has observed of each peer,
+// worst first. Scores are overridden with `crisis reputation set`.
+func (m model) renderReputation(width int) string {
+	reps, err := store.GetReputations(m.db)
+	if err != nil {
+		return "Error loading reputation: " + err.Error()
+	}
+	nicks := make(map[string]string, len(m.peers))
+	for _, p := range m.peers {
+		nicks[p.ID] = p.Nick
+	}
+	now := time.Now()
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(colorGreen)).
+		Headers("NICK", "ID", "SCORE", "RELAYS", "INVALID", "BAD SIG", "FLOODS", "STATUS").
+		Width(width - 4).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row >= 0 && row < len(reps) && col == 2 && reps[row].Effective() < store.LowScore {
+				return lipgloss.NewStyle().Foreground(colorRed).Bold(true)
+			}
+			return lipgloss.NewStyle()
+		})
+	for _, r := range reps {
+		status := "ok"
+		switch {
+		case now.Before(r.QuarantinedUntil):
+			status = "quarantined " + r.QuarantinedUntil.Sub(now).Round(time.Minute).String()
+		case r.Override != nil:
+			status = "override"
+		}
+		nick := nicks[r.PeerID]
+		if nick == "" {
+			nick = "-"
+		}
+		t.Row(nick, shortID(r.PeerID), fmt.Sprintf("%.1f", r.Effective()), fmt.Sprint(r.Relays),
+			fmt.Sprint(r.Invalid), fmt.Sprint(r.BadSigs), fmt.Sprint(r.Floods), status)
+	}
+	return "PEER REPUTATION (F1 to return; override with `crisis reputation set`)\n" + t.Render()
+}
+
+// lowBattery is the charge below which a peer's battery is shown in red.
+const lowBattery = 20
+
+func battery(level *int) string {
+	if level == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", *level)
+}
+
+func batteryStyle(level *int) lipgloss.Style {
+	if level != nil && *level < lowBattery {
+		return lipgloss.NewStyle().Foreground(colorRed).Bold(true)
+	}
+	return lipgloss.NewStyle()
+}
+
+func stateStyle(state string) lipgloss.Style {
+	switch state {
+	case discovery.StateSuspect:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	case discovery.StateDead, discovery.StateForgotten:
+		return lipgloss.NewStyle().Foreground(colorGray)
+	}
+	return lipgloss.NewStyle().Foreground(colorGreen)
+}
+
+func shortID(id string) string {
+	if len(id) > 4 {
+		return id[:4]
+	}
+	return id
+}
+
+func seenAgo(t time.Time) string {
+	d := time.Since(t)
+	if d < 2*time.Second {
+		return "Now"
+	}
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
+
+func buildChatHistory(db *gorm.DB, nodeID string, monitorMode bool, filter string) (string, int, error) {
 	var sb strings.Builder
-	msgs, err := store.GetMessages(db, 50)
+	msgs, err := store.GetChatMessages(db, 50)
 	if err != nil {
 		return "", 0, err
 	}
 
+	verified := store.VerifiedPeers(db)
+
 	latestPriority := 0
 	if len(msgs) > 0 {
 		latestPriority = msgs[0].Priority
@@ -186,6 +559,9 @@ func buildChatHistory(db *gorm.DB, nodeID string, monitorMode bool) (string, int
 
 	for i := len(msgs) - 1; i >= 0; i-- {
 		msg := msgs[i]
+		if !matchesFilter(msg, filter) {
+			continue
+		}
 
 		var line string
 		if monitorMode {
@@ -203,8 +579,29 @@ func buildChatHistory(db *gorm.DB, nodeID string, monitorMode bool) (string, int
 				author = "Unknown"
 			}
 			authorTag := authorStyle.Render(fmt.Sprintf("[USER: %s]", author))
+			if verified[msg.SenderID] {
+				authorTag += verifiedStyle.Render("✓")
+			}
+			if msg.Role != "" {
+				authorTag += roleStyle.Render(" [" + strings.ToUpper(msg.Role) + "]")
+			}
 
-			line = fmt.Sprintf("[%s] [ENC:%s] [HOP:%d] %s -> %s", ts, enc, hops, authorTag, msg.Content)
+			content := msg.Content
+			if sos, ok := store.ParseSOS(msg); ok {
+				content = sosTags(sos)
+				if sos.Notes != "" {
+					content += " " + sos.Notes
+				}
+			}
+			line = fmt.Sprintf("[%s] [ENC:%s] [HOP:%d] %s -> %s", ts, enc, hops, authorTag, content)
+
+			if msg.ClockSkew {
+				// The sender's clock is off, so its time of day is not to be trusted.
+				line += lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render(" [CLOCK?]")
+			}
+			if msg.Network != "" && msg.Network != store.DefaultNetwork {
+				line = authorStyle.Render(fmt.Sprintf("[NET: %s] ", msg.Network)) + line
+			}
 
 			if msg.Lat != 0 && msg.Long != 0 {
 				gpsTag := lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render(fmt.Sprintf(" [GPS: %.4f, %.4f]", msg.Lat, msg.Long))
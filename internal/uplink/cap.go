@@ -0,0 +1,54 @@
+package uplink
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bit2swaz/crisismesh/internal/cap"
+	"github.com/bit2swaz/crisismesh/internal/store"
+	"gorm.io/gorm"
+)
+
+// CAPService posts alerts and SOS messages as CAP 1.2 XML to an alerting
+// aggregator.
+type CAPService struct {
+	URL    string
+	db     *gorm.DB
+	client *http.Client
+}
+
+func NewCAPService(url string, db *gorm.DB) *CAPService {
+	return &CAPService{
+		URL: url,
+		db:  db,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (s *CAPService) Start(msgChan <-chan store.Message) {
+	go func() {
+		for msg := range msgChan {
+			if !cap.Exportable(msg) {
+				continue
+			}
+			data, err := cap.ExportMessage(s.db, msg.ID)
+			if err != nil {
+				slog.Error("Failed to encode CAP uplink", "id", msg.ID, "error", err)
+				continue
+			}
+			resp, err := s.client.Post(s.URL, "application/xml", bytes.NewReader(data))
+			if err != nil {
+				slog.Error("Failed to send CAP uplink", "error", err)
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				slog.Error("CAP uplink returned non-200 status", "status", resp.Status)
+			}
+		}
+	}()
+}
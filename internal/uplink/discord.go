@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -45,9 +46,13 @@ func (s *Service) Start(msgChan <-chan store.Message) {
 			}
 
 			// Format Location
+			sos, isStructured := store.ParseSOS(msg)
 			locationStr := "Unknown"
 			if msg.Lat != 0 && msg.Long != 0 {
 				locationStr = fmt.Sprintf("%.4f, %.4f\n[Open in Maps](https://maps.google.com/?q=%f,%f)", msg.Lat, msg.Long, msg.Lat, msg.Long)
+				if isStructured && sos.Accuracy > 0 {
+					locationStr = fmt.Sprintf("%.4f, %.4f (±%.0f m)\n[Open in Maps](https://maps.google.com/?q=%f,%f)", msg.Lat, msg.Long, sos.Accuracy, msg.Lat, msg.Long)
+				}
 			}
 
 			// Construct Discord Payload
@@ -56,6 +61,9 @@ func (s *Service) Start(msgChan <-chan store.Message) {
 				content,
 				locationStr,
 			)
+			if isStructured {
+				discordMsg += "\n" + sosFields(sos)
+			}
 
 			payload := map[string]string{
 				"content": discordMsg,
@@ -84,3 +92,23 @@ func (s *Service) Start(msgChan <-chan store.Message) {
 		}
 	}()
 }
+
+// sosFields renders an SOS's details as Discord markdown, "?" for unknown.
+func sosFields(sos store.SOS) string {
+	count := func(n int) string {
+		if n == 0 {
+			return "?"
+		}
+		return strconv.Itoa(n)
+	}
+	mobility := sos.Mobility
+	if mobility == "" {
+		mobility = "?"
+	}
+	line := fmt.Sprintf("**Emergency:** %s | **People:** %s | **Injured:** %s | **Mobility:** %s",
+		strings.ToUpper(sos.Type), count(sos.People), count(sos.Injured), mobility)
+	if sos.Notes != "" {
+		line += "\n**Notes:** " + sos.Notes
+	}
+	return line
+}
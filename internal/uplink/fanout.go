@@ -0,0 +1,54 @@
+package uplink
+
+import (
+	"slices"
+
+	"github.com/bit2swaz/crisismesh/internal/store"
+)
+
+// Fanout copies every message from in to n channels, so several uplinks
+// can share the engine's uplink channel. Slow consumers miss messages
+// rather than block the others.
+func Fanout(in <-chan store.Message, n int) []chan store.Message {
+	outs := make([]chan store.Message, n)
+	for i := range outs {
+		outs[i] = make(chan store.Message, cap(in))
+	}
+	go func() {
+		for msg := range in {
+			for _, out := range outs {
+				select {
+				case out <- msg:
+				default:
+				}
+			}
+		}
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+	return outs
+}
+
+// Filter passes on the messages keep accepts.
+func Filter(in <-chan store.Message, keep func(store.Message) bool) <-chan store.Message {
+	out := make(chan store.Message, cap(in))
+	go func() {
+		for msg := range in {
+			if keep(msg) {
+				out <- msg
+			}
+		}
+		close(out)
+	}()
+	return out
+}
+
+// SOSTypes keeps structured SOS messages of the given emergency types and
+// everything that is not a structured SOS.
+func SOSTypes(types []string) func(store.Message) bool {
+	return func(msg store.Message) bool {
+		sos, ok := store.ParseSOS(msg)
+		return !ok || slices.Contains(types, sos.Type)
+	}
+}
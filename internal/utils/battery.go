@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// powerSupplyDir is where Linux exposes batteries and chargers.
+const powerSupplyDir = "/sys/class/power_supply"
+
+// BatteryLevel returns the charge of the first battery in sysfs, in percent.
+// ok is false on machines without one (or outside Linux).
+func BatteryLevel() (level int, ok bool) {
+	matches, _ := filepath.Glob(filepath.Join(powerSupplyDir, "*", "capacity"))
+	for _, path := range matches {
+		dir := filepath.Dir(path)
+		// Skip capacity reported by mice, headsets and the like.
+		if t, err := os.ReadFile(filepath.Join(dir, "type")); err == nil && strings.TrimSpace(string(t)) != "Battery" {
+			continue
+		}
+		if scope, err := os.ReadFile(filepath.Join(dir, "scope")); err == nil && strings.TrimSpace(string(scope)) == "Device" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || n < 0 || n > 100 {
+			continue
+		}
+		return n, true
+	}
+	return 0, false
+}
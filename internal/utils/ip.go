@@ -2,13 +2,18 @@ package utils
 
 import (
 	"net"
+	"strconv"
 )
 
 // GetOutboundIP prefers the outbound IP of this machine
 func GetOutboundIP() (string, error) {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
-		return "127.0.0.1", err
+		// No IPv4 route; an IPv6-only hotspot may still have one
+		conn, err = net.Dial("udp", "[2001:4860:4860::8888]:80")
+		if err != nil {
+			return "127.0.0.1", err
+		}
 	}
 	defer conn.Close()
 
@@ -16,3 +21,55 @@ func GetOutboundIP() (string, error) {
 
 	return localAddr.IP.String(), nil
 }
+
+// HostPort formats an IP (with optional IPv6 zone) and port as a dialable
+// address, bracketing IPv6 literals: "10.0.0.2:9000", "[fe80::1%wlan0]:9000".
+func HostPort(ip net.IP, zone string, port int) string {
+	host := ip.String()
+	if zone != "" && ip.To4() == nil {
+		host += "%" + zone
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// MulticastInterfaces returns the up, multicast-capable, non-loopback
+// interfaces that IPv6 link-local heartbeats can be sent on.
+func MulticastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []net.Interface
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		out = append(out, ifi)
+	}
+	return out
+}
+
+// InterfaceFor names the local interface a packet from ip arrived on: the
+// zone for link-local IPv6, otherwise the interface whose subnet contains
+// ip. Returns "" when no interface matches.
+func InterfaceFor(ip net.IP, zone string) string {
+	if zone != "" {
+		return zone
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.Contains(ip) {
+				return ifi.Name
+			}
+		}
+	}
+	return ""
+}
@@ -2,18 +2,34 @@ package web
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"html"
 	"html/template"
+	"io"
 	"io/fs"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bit2swaz/crisismesh/internal/cap"
+	"github.com/bit2swaz/crisismesh/internal/core"
+	"github.com/bit2swaz/crisismesh/internal/discovery"
+	"github.com/bit2swaz/crisismesh/internal/policy"
+	"github.com/bit2swaz/crisismesh/internal/protocol"
+	"github.com/bit2swaz/crisismesh/internal/ratelimit"
 	"github.com/bit2swaz/crisismesh/internal/store"
+	"github.com/skip2/go-qrcode"
 	"gorm.io/gorm"
 )
 
@@ -23,12 +39,59 @@ var staticFiles embed.FS
 type Engine interface {
 	GetNodeID() string
 	PublishText(content string, author string, lat float64, long float64) error
+	PublishTextTo(network, content, author string, lat, long float64) error
+	PublishSOSTo(network string, sos store.SOS, author string, lat, long float64) (string, error)
+	JoinedNetworks() []string
+	ClockOffset() time.Duration
+	TimeStratum() int
+	LinkPolicy() *policy.Policy
+	RecentPeerEvents() []discovery.PeerEvent
+	AcceptPeerKey(peerID string) error
+	RejectPeerKey(peerID string) error
+	Fingerprint() string
+	FingerprintURI() string
+	VerifyPeer(peerID, fingerprint string) error
+	SetPeerVerified(peerID string, verified bool) error
+	RateLimits() map[string][]ratelimit.Stat
+	Violations() []protocol.ViolationStat
+	Moderate(action, target, reason string, local bool) error
+	ModerationLog() ([]store.Moderation, error)
+	CertifiedRole() string
+	IssueAlert(a store.Alert) (string, error)
+	CancelAlert(id string) error
+	ActiveAlerts() ([]store.ActiveAlert, error)
+	ImportCAP(data []byte) (string, error)
+	ExportCAP(id string) ([]byte, error)
+	UpdateIncident(id, state, team, note string) error
+	Incidents() ([]store.Incident, error)
+	SOSToken(id string) string
+	UpdateSOSFix(id string, lat, long, accuracy float64, battery *int) error
+	CancelSOS(id string) error
+	SetCheckIn(interval time.Duration) error
+	CheckIn() error
+	CheckIns() ([]store.CheckInStatus, error)
+	StartRollCall(note string) (string, error)
+	AnswerRollCall(id, name, status string) error
+	RollCall() (store.RollCallStatus, bool, error)
+	RegisterWebUser(name, token string) (string, error)
+	AuthWebUser(name, token string) bool
+	ReportPerson(rep store.PersonReport) (string, error)
+	People(query string) ([]store.Person, error)
+	ExportPFIF(query string) ([]byte, error)
 }
 
+// maxPhotoSize bounds a photo posted to the person finder; only its hash
+// is kept.
+const maxPhotoSize = 8 << 20
+
+// postLimit bounds how fast each web client may post messages.
+var postLimit = ratelimit.Config{Rate: 1, Burst: 5, Strikes: 20, Window: time.Minute, Quarantine: 5 * time.Minute}
+
 type Server struct {
 	db     *gorm.DB
 	engine Engine
 	port   int
+	posts  *ratelimit.Limiter
 }
 
 func NewServer(db *gorm.DB, eng Engine, port int) *Server {
@@ -36,6 +99,7 @@ func NewServer(db *gorm.DB, eng Engine, port int) *Server {
 		db:     db,
 		engine: eng,
 		port:   port,
+		posts:  ratelimit.New(postLimit),
 	}
 }
 
@@ -63,9 +127,33 @@ func (s *Server) Start(ctx context.Context) error {
 
 	mux.HandleFunc("/", s.handleIndex)
 	mux.HandleFunc("/map", s.handleMap)
+	mux.HandleFunc("/verify", s.handleVerifyPage)
+	mux.HandleFunc("/checkins", s.handleCheckInPage)
+	mux.HandleFunc("/people", s.handlePeoplePage)
 	mux.HandleFunc("/api/messages", s.handleMessages)
+	mux.HandleFunc("/api/sos", s.handleSOS)
+	mux.HandleFunc("/api/sos/fix", s.handleSOSFix)
+	mux.HandleFunc("/api/sos/cancel", s.handleSOSCancel)
+	mux.HandleFunc("/api/incidents", s.handleIncidents)
+	mux.HandleFunc("/api/checkins", s.handleCheckIns)
+	mux.HandleFunc("/api/rollcall", s.handleRollCall)
+	mux.HandleFunc("/api/rollcall/answer", s.handleRollCallAnswer)
+	mux.HandleFunc("/api/rollcall/register", s.handleRollCallRegister)
+	mux.HandleFunc("/api/people", s.handlePeople)
+	mux.HandleFunc("/api/people/pfif", s.handlePFIF)
 	mux.HandleFunc("/api/status", s.handleStatus)
 	mux.HandleFunc("/api/graph", s.handleGraph)
+	mux.HandleFunc("/api/policy", s.handlePolicy)
+	mux.HandleFunc("/api/peers/events", s.handlePeerEvents)
+	mux.HandleFunc("/api/peers/history", s.handlePeerHistory)
+	mux.HandleFunc("/api/peers/keys", s.handlePeerKeys)
+	mux.HandleFunc("/api/peers/verify", s.handlePeerVerify)
+	mux.HandleFunc("/api/fingerprint", s.handleFingerprint)
+	mux.HandleFunc("/api/fingerprint/qr", s.handleFingerprintQR)
+	mux.HandleFunc("/api/limits", s.handleLimits)
+	mux.HandleFunc("/api/moderation", s.handleModeration)
+	mux.HandleFunc("/api/alerts", s.handleAlerts)
+	mux.HandleFunc("/api/cap", s.handleCAP)
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
@@ -99,13 +187,17 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var messages []store.Message
-	if err := s.db.Order("timestamp desc").Limit(50).Find(&messages).Error; err != nil {
+	query := s.db.Where("kind = '' AND hidden = ?", false).Order("hlc desc").Limit(50)
+	if network := r.URL.Query().Get("network"); network != "" {
+		query = query.Where("network = ?", network)
+	}
+	if err := query.Find(&messages).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	sort.Slice(messages, func(i, j int) bool {
-		return messages[i].Timestamp < messages[j].Timestamp
+		return messages[i].HLC < messages[j].HLC
 	})
 
 	if r.Header.Get("HX-Request") == "true" {
@@ -117,14 +209,35 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		verified := store.VerifiedPeers(s.db)
+		incidents := make(map[string]store.Incident)
+		if list, err := s.engine.Incidents(); err == nil {
+			for _, inc := range list {
+				incidents[inc.ID] = inc
+			}
+		}
 		for _, msg := range messages {
 			ts := time.Unix(msg.Timestamp, 0).Format("15:04")
+			if msg.ClockSkew {
+				ts += ` <span class="clock-skew" title="Sender clock looks wrong; time may be inaccurate">?</span>`
+			}
 			isMe := msg.SenderID == s.engine.GetNodeID()
 
 			bubbleClass := "msg-bubble"
 			if len(msg.Content) >= 9 && msg.Content[:9] == "PRIORITY:" {
 				bubbleClass += " msg-safe"
 			}
+			if sos, ok := store.ParseSOS(msg); ok {
+				bubbleClass += " msg-sos"
+				ts = sosTags(sos) + " " + ts
+			}
+			if inc, ok := incidents[msg.ID]; ok {
+				status := inc.StatusLine()
+				if inc.State == store.IncidentOpen && inc.Repeats > 0 {
+					status += fmt.Sprintf(" (re-sent %d×, last %s)", inc.Repeats, inc.LastHeard.Format("15:04"))
+				}
+				ts += fmt.Sprintf(` <div class="sos-status state-%s">%s</div>`, inc.State, html.EscapeString(status))
+			}
 
 			if isMe {
 				fmt.Fprintf(w, `
@@ -133,12 +246,19 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 						<div class="msg-text">%s</div>
 						<div class="msg-meta">%s</div>
 					</div>
-				</div>`, bubbleClass, msg.Content, ts)
+				</div>`, bubbleClass, html.EscapeString(msg.Content), ts)
 			} else {
 				senderDisplay := msg.SenderID
 				if len(senderDisplay) > 8 {
 					senderDisplay = senderDisplay[:8]
 				}
+				senderDisplay = html.EscapeString(senderDisplay)
+				if verified[msg.SenderID] {
+					senderDisplay += ` <span class="verified" title="Key verified">&#10003;</span>`
+				}
+				if msg.Role != "" {
+					senderDisplay += fmt.Sprintf(` <span class="role-badge role-%s" title="Certified by incident command">%s</span>`, msg.Role, strings.ToUpper(msg.Role))
+				}
 				fmt.Fprintf(w, `
 				<div class="msg-row msg-peer">
 					<div class="msg-sender">%s</div>
@@ -146,7 +266,7 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 						<div class="msg-text">%s</div>
 						<div class="msg-meta">%s</div>
 					</div>
-				</div>`, senderDisplay, bubbleClass, msg.Content, ts)
+				</div>`, senderDisplay, bubbleClass, html.EscapeString(msg.Content), ts)
 			}
 		}
 		return
@@ -157,8 +277,13 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	if !s.posts.Allow(clientIP(r)) {
+		http.Error(w, "Too many messages; slow down", http.StatusTooManyRequests)
+		return
+	}
 	var content string
 	var author string
+	var network string
 	var lat, long float64
 
 	// Check Content-Type more robustly
@@ -169,6 +294,7 @@ func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request) {
 			Author  string  `json:"author"`
 			Lat     float64 `json:"lat"`
 			Long    float64 `json:"long"`
+			Network string  `json:"network"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			slog.Error("Failed to decode JSON", "error", err)
@@ -179,10 +305,12 @@ func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request) {
 		author = req.Author
 		lat = req.Lat
 		long = req.Long
+		network = req.Network
 		slog.Info("Received JSON message", "content", content, "author", author, "lat", lat, "long", long)
 	} else {
 		content = r.FormValue("content")
 		author = r.FormValue("author")
+		network = r.FormValue("network")
 		slog.Info("Received Form message", "content", content, "author", author)
 	}
 
@@ -191,7 +319,10 @@ func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.engine.PublishText(content, author, lat, long); err != nil {
+	if network == "" {
+		network = s.engine.JoinedNetworks()[0]
+	}
+	if err := s.engine.PublishTextTo(network, content, author, lat, long); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -205,10 +336,155 @@ func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "sent"})
 }
 
+// sosTags renders an SOS's type and position accuracy for the feed.
+func sosTags(sos store.SOS) string {
+	tags := fmt.Sprintf(`<span class="sos-type sos-%s">%s</span>`, sos.Type, strings.ToUpper(sos.Type))
+	if sos.Accuracy > 0 {
+		tags += fmt.Sprintf(` <span class="sos-accuracy" title="GPS accuracy">&plusmn;%.0f m</span>`, sos.Accuracy)
+	}
+	return tags
+}
+
+// handleSOS sends a distress message with the details from the web SOS form.
+// The reply carries the SOS ID and the token the client needs to update or
+// cancel it while it repeats.
+func (s *Server) handleSOS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.posts.Allow(clientIP(r)) {
+		http.Error(w, "Too many messages; slow down", http.StatusTooManyRequests)
+		return
+	}
+	var req struct {
+		store.SOS
+		Author  string  `json:"author"`
+		Lat     float64 `json:"lat"`
+		Long    float64 `json:"long"`
+		Network string  `json:"network"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Network == "" {
+		req.Network = s.engine.JoinedNetworks()[0]
+	}
+	id, err := s.engine.PublishSOSTo(req.Network, req.SOS, req.Author, req.Lat, req.Long)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": id, "token": s.engine.SOSToken(id)})
+}
+
+// sosRequest is a client's update to an SOS it sent, proven by the token
+// handleSOS gave it.
+type sosRequest struct {
+	ID       string  `json:"id"`
+	Token    string  `json:"token"`
+	Lat      float64 `json:"lat"`
+	Long     float64 `json:"long"`
+	Accuracy float64 `json:"accuracy"`
+	Battery  *int    `json:"battery"`
+}
+
+// decodeSOSRequest reads a sosRequest and checks its token, writing the
+// error response if it fails.
+func (s *Server) decodeSOSRequest(w http.ResponseWriter, r *http.Request) (sosRequest, bool) {
+	var req sosRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(s.engine.SOSToken(req.ID))) != 1 {
+		http.Error(w, "not your SOS", http.StatusForbidden)
+		return req, false
+	}
+	return req, true
+}
+
+// handleSOSFix takes the latest position and battery of a client whose SOS
+// is repeating: {"id", "token", "lat", "long", "accuracy", "battery"}.
+func (s *Server) handleSOSFix(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeSOSRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.engine.UpdateSOSFix(req.ID, req.Lat, req.Long, req.Accuracy, req.Battery); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSOSCancel calls off a client's SOS: {"id", "token"}.
+func (s *Server) handleSOSCancel(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeSOSRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.engine.CancelSOS(req.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleIncidents lists the SOS incidents and where the response stands.
+// From this node only, POST moves one on: {"id", "state", "team", "note"}.
+func (s *Server) handleIncidents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		incidents, err := s.engine.Incidents()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if incidents == nil {
+			incidents = []store.Incident{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(incidents)
+	case http.MethodPost:
+		if !operatorOnly(w, r) {
+			return
+		}
+		var req struct {
+			ID    string `json:"id"`
+			State string `json:"state"`
+			Team  string `json:"team"`
+			Note  string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.engine.UpdateIncident(req.ID, req.State, req.Team, req.Note); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	status := map[string]interface{}{
-		"node_id": s.engine.GetNodeID(),
-		"peers":   0, // TODO: Expose peer count from engine
+		"node_id":  s.engine.GetNodeID(),
+		"networks": s.engine.JoinedNetworks(),
+		// Mesh time; see engine.Now.
+		"clock_offset_ms": s.engine.ClockOffset().Milliseconds(),
+		"time_stratum":    s.engine.TimeStratum(),
+		"role":            s.engine.CertifiedRole(),
+		"peers":           0, // TODO: Expose peer count from engine
 	}
 	json.NewEncoder(w).Encode(status)
 }
@@ -222,16 +498,260 @@ func (s *Server) handleMap(w http.ResponseWriter, r *http.Request) {
 	tmpl.Execute(w, nil)
 }
 
+func (s *Server) handleVerifyPage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFS(staticFiles, "static/verify.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, nil)
+}
+
+func (s *Server) handleCheckInPage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFS(staticFiles, "static/checkins.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, nil)
+}
+
+// handleCheckIns lists the dead-man's switches on the mesh. From this node
+// only, POST checks in, PUT {"interval": "30m"} turns this node's switch
+// on and DELETE turns it off.
+func (s *Server) handleCheckIns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !operatorOnly(w, r) {
+		return
+	}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		list, err := s.engine.CheckIns()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []store.CheckInStatus{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"node": s.engine.GetNodeID(), "checkins": list})
+		return
+	case http.MethodPost:
+		err = s.engine.CheckIn()
+	case http.MethodPut:
+		var req struct {
+			Interval string `json:"interval"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval, perr := time.ParseDuration(req.Interval)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		err = s.engine.SetCheckIn(interval)
+	case http.MethodDelete:
+		err = s.engine.SetCheckIn(0)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRollCall returns the board for the latest roll call, or null.
+// From this node only, POST {"note"} starts a new one.
+func (s *Server) handleRollCall(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		board, ok, err := s.engine.RollCall()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			fmt.Fprint(w, "null")
+			return
+		}
+		json.NewEncoder(w).Encode(board)
+	case http.MethodPost:
+		if !operatorOnly(w, r) {
+			return
+		}
+		var req struct {
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := s.engine.StartRollCall(req.Note)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePeoplePage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFS(staticFiles, "static/people.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, nil)
+}
+
+// handlePeople searches the person-finder registry (GET ?q=) or takes a
+// report (POST). A posted photo is hashed and dropped; only the hash is
+// gossiped.
+func (s *Server) handlePeople(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		people, err := s.engine.People(r.URL.Query().Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(people)
+	case http.MethodPost:
+		if !s.posts.Allow(clientIP(r)) {
+			http.Error(w, "Too many messages; slow down", http.StatusTooManyRequests)
+			return
+		}
+		var req struct {
+			store.PersonReport
+			PhotoData []byte `json:"photo_data"`
+		}
+		// Base64 makes the photo a third bigger.
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPhotoSize*4/3+4096)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.PhotoData) > 0 {
+			sum := sha256.Sum256(req.PhotoData)
+			req.Photo = hex.EncodeToString(sum[:])
+		}
+		id, err := s.engine.ReportPerson(req.PersonReport)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePFIF exports the registry entries matching ?q= as PFIF 1.4.
+func (s *Server) handlePFIF(w http.ResponseWriter, r *http.Request) {
+	data, err := s.engine.ExportPFIF(r.URL.Query().Get("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="people.pfif.xml"`)
+	w.Write(data)
+}
+
+// handleRollCallAnswer answers a roll call for a web user:
+// {"id", "name", "token", "status"}, with the token the client got when it
+// registered the name. Answering for the operator (no name) only works
+// from this node.
+func (s *Server) handleRollCallAnswer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.posts.Allow(clientIP(r)) {
+		http.Error(w, "Too many messages; slow down", http.StatusTooManyRequests)
+		return
+	}
+	var req struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Token  string `json:"token"`
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" && !operatorOnly(w, r) {
+		return
+	}
+	if req.Name != "" && !s.engine.AuthWebUser(req.Name, req.Token) {
+		http.Error(w, "not registered as "+req.Name+" on this node", http.StatusForbidden)
+		return
+	}
+	if err := s.engine.AnswerRollCall(req.ID, req.Name, req.Status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRollCallRegister records the name a web client goes by, so this
+// node answers roll calls for them: {"name", "token"}. It returns the
+// token, new for a client that had none.
+func (s *Server) handleRollCallRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.posts.Allow(clientIP(r)) {
+		http.Error(w, "Too many messages; slow down", http.StatusTooManyRequests)
+		return
+	}
+	var req struct {
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token, err := s.engine.RegisterWebUser(req.Name, req.Token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
 func (s *Server) handleGraph(w http.ResponseWriter, r *http.Request) {
 	var peers []store.Peer
 	// We ignore error here because if DB is empty, we still want to show "Me"
-	s.db.Find(&peers)
+	s.db.Where("state <> ?", discovery.StateForgotten).Find(&peers)
 
 	type Node struct {
 		ID    string `json:"id"`
 		Label string `json:"label"`
 		Color string `json:"color"`
 		Shape string `json:"shape"`
+		Title string `json:"title,omitempty"`
+		// Heartbeat status, for the map's device panel.
+		Battery *int    `json:"battery,omitempty"`
+		Role    string  `json:"role,omitempty"`
+		Queue   int     `json:"queue,omitempty"`
+		Lat     float64 `json:"lat,omitempty"`
+		Long    float64 `json:"long,omitempty"`
 	}
 	type Link struct {
 		From string `json:"from"`
@@ -258,7 +778,10 @@ func (s *Server) handleGraph(w http.ResponseWriter, r *http.Request) {
 		}
 
 		color := "#008800" // Dark Green
-		if !p.IsActive {
+		switch {
+		case p.State == discovery.StateSuspect:
+			color = "#CCAA00" // Amber while the detector is unsure
+		case !p.IsActive:
 			color = "#555555" // Grey for offline
 		}
 
@@ -266,12 +789,41 @@ func (s *Server) handleGraph(w http.ResponseWriter, r *http.Request) {
 		if label == "" {
 			label = p.ID[:8]
 		}
+		if p.Verified {
+			label += " ✓"
+		}
+
+		title := []string{strings.ToUpper(p.State)}
+		// The heartbeat role is whatever the peer says it is, so it is not
+		// shown like a certified role.
+		if p.Role != "" {
+			title = append(title, "Stated role (not certified): "+p.Role)
+		}
+		if p.Battery != nil {
+			title = append(title, fmt.Sprintf("Battery: %d%%", *p.Battery))
+			if *p.Battery < 20 {
+				label += fmt.Sprintf(" %d%%", *p.Battery)
+				color = "#CC0000" // Red when about to drop off the mesh
+			}
+		}
+		if p.QueueDepth > 0 {
+			title = append(title, fmt.Sprintf("Queue: %d", p.QueueDepth))
+		}
+		if p.Lat != 0 || p.Long != 0 {
+			title = append(title, fmt.Sprintf("Position: %.5f, %.5f", p.Lat, p.Long))
+		}
 
 		nodes = append(nodes, Node{
-			ID:    p.ID,
-			Label: label,
-			Color: color,
-			Shape: "dot",
+			ID:      p.ID,
+			Label:   label,
+			Color:   color,
+			Shape:   "dot",
+			Title:   strings.Join(title, "\n"),
+			Battery: p.Battery,
+			Role:    p.Role,
+			Queue:   p.QueueDepth,
+			Lat:     p.Lat,
+			Long:    p.Long,
 		})
 
 		// Link everyone to me (Star topology visualization for now)
@@ -289,3 +841,404 @@ func (s *Server) handleGraph(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// handlePolicy exposes the link policy: GET lists it, POST adds a rule (or
+// sets the default with {"default": "deny"}), DELETE ?index=N removes one.
+func (s *Server) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	pol := s.engine.LinkPolicy()
+	if r.Method != http.MethodGet && !operatorOnly(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			policy.Rule
+			Default string `json:"default"`
+			Index   *int   `json:"index"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if req.Default != "" {
+			err = pol.SetDefault(req.Default)
+		} else {
+			index := -1
+			if req.Index != nil {
+				index = *req.Index
+			}
+			err = pol.Add(req.Rule, index)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		index, err := strconv.Atoi(r.URL.Query().Get("index"))
+		if err != nil {
+			http.Error(w, "index required", http.StatusBadRequest)
+			return
+		}
+		if err := pol.Remove(index); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"default": pol.Default(),
+		"rules":   pol.Rules(),
+	})
+}
+
+// handleLimits returns the flood-protection counters: the engine's per
+// origin, SOS and link limits, per web client posts, and protocol
+// violations per link.
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{"web": s.posts.Stats(), "violations": s.engine.Violations()}
+	for name, stats := range s.engine.RateLimits() {
+		resp[name] = stats
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleModeration returns the moderation audit trail, or issues an action:
+// {"action": "hide", "target": "<message id>", "reason": "...", "local": true}.
+func (s *Server) handleModeration(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		mods, err := s.engine.ModerationLog()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mods)
+	case http.MethodPost:
+		if !operatorOnly(w, r) {
+			return
+		}
+		var req struct {
+			store.ModerationAction
+			Local bool `json:"local"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.engine.Moderate(req.Action, req.Target, req.Reason, req.Local); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAlerts returns the active official alerts. From this node only,
+// POST issues one (a store.Alert as JSON) and DELETE ?id= cancels one.
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		alerts, err := s.engine.ActiveAlerts()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if alerts == nil {
+			alerts = []store.ActiveAlert{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alerts)
+	case http.MethodPost:
+		if !operatorOnly(w, r) {
+			return
+		}
+		var a store.Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := s.engine.IssueAlert(a)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	case http.MethodDelete:
+		if !operatorOnly(w, r) {
+			return
+		}
+		if err := s.engine.CancelAlert(r.URL.Query().Get("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCAP exports an alert or SOS message (?id=) as CAP 1.2 XML. From
+// this node only, POST imports a CAP document as an official alert.
+func (s *Server) handleCAP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		data, err := s.engine.ExportCAP(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(data)
+	case http.MethodPost:
+		if !operatorOnly(w, r) {
+			return
+		}
+		// A form can post text/plain across sites without asking; XML
+		// content types need a preflight a foreign page cannot pass.
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/xml" && ct != "text/xml" {
+			http.Error(w, "CAP documents must be sent as application/xml", http.StatusUnsupportedMediaType)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, cap.MaxDocumentSize+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := s.engine.ImportCAP(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// operatorOnly refuses requests from anywhere but this machine, so web
+// clients on the hotspot cannot act with this node's role, and requests
+// another site open in the operator's browser makes on its behalf.
+func operatorOnly(w http.ResponseWriter, r *http.Request) bool {
+	if ip := net.ParseIP(clientIP(r)); ip == nil || !ip.IsLoopback() {
+		http.Error(w, "only available on this node", http.StatusForbidden)
+		return false
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-site request refused", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// sameOrigin reports whether a request came from this node's own pages, or
+// from a client that is not a browser (the crisis CLI, curl), which sends
+// neither Sec-Fetch-Site nor Origin. The Host must name this machine, so a
+// site that rebinds its DNS to 127.0.0.1 is not taken for us.
+func sameOrigin(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return false
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	return true
+}
+
+// clientIP is the web client's address without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handlePeerEvents returns recent peer liveness transitions, oldest first.
+func (s *Server) handlePeerEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.engine.RecentPeerEvents())
+}
+
+// handlePeerHistory returns first-seen, uptime and contact windows for the
+// peer given by ?id=, or for every sighted peer.
+func (s *Server) handlePeerHistory(w http.ResponseWriter, r *http.Request) {
+	var resp interface{}
+	var err error
+	if id := r.URL.Query().Get("id"); id != "" {
+		resp, err = store.GetPeerStats(s.db, id, time.Now())
+	} else {
+		resp, err = store.GetAllPeerStats(s.db, time.Now())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handlePeerKeys supports key-change review. GET lists peers whose key has
+// changed (or, with ?id=, that peer's key history); POST
+// {"id": "...", "action": "accept"|"reject"} resolves a change.
+func (s *Server) handlePeerKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var resp interface{}
+		if id := r.URL.Query().Get("id"); id != "" {
+			history, err := store.GetKeyHistory(s.db, id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp = history
+		} else {
+			type change struct {
+				ID             string `json:"id"`
+				Nick           string `json:"nick"`
+				PubKey         string `json:"pub_key"`
+				SignKey        string `json:"sign_key"`
+				PendingPubKey  string `json:"pending_pub_key"`
+				PendingSignKey string `json:"pending_sign_key"`
+			}
+			var peers []store.Peer
+			s.db.Where("key_status = ?", store.KeyChanged).Find(&peers)
+			changes := []change{}
+			for _, p := range peers {
+				changes = append(changes, change{p.ID, p.Nick, p.PubKey, p.SignKey, p.PendingPubKey, p.PendingSignKey})
+			}
+			resp = changes
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	case http.MethodPost:
+		if !operatorOnly(w, r) {
+			return
+		}
+		var req struct {
+			ID     string `json:"id"`
+			Action string `json:"action"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		switch req.Action {
+		case "accept":
+			err = s.engine.AcceptPeerKey(req.ID)
+		case "reject":
+			err = s.engine.RejectPeerKey(req.ID)
+		default:
+			http.Error(w, "action must be accept or reject", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": req.Action + "ed"})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleFingerprint returns this node's fingerprint and the text its QR code
+// encodes.
+func (s *Server) handleFingerprint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"node_id":     s.engine.GetNodeID(),
+		"fingerprint": s.engine.Fingerprint(),
+		"uri":         s.engine.FingerprintURI(),
+	})
+}
+
+// handleFingerprintQR serves this node's fingerprint as a PNG QR code.
+func (s *Server) handleFingerprintQR(w http.ResponseWriter, r *http.Request) {
+	png, err := qrcode.Encode(s.engine.FingerprintURI(), qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// handlePeerVerify lists peers with their key fingerprints (GET), or records
+// a verification (POST). POST {"id", "fingerprint"} checks a typed or
+// scanned fingerprint; POST {"id", "verified": bool} sets the mark after a
+// side-by-side comparison.
+func (s *Server) handlePeerVerify(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		type entry struct {
+			ID          string    `json:"id"`
+			Nick        string    `json:"nick"`
+			Fingerprint string    `json:"fingerprint"`
+			Verified    bool      `json:"verified"`
+			VerifiedAt  time.Time `json:"verified_at"`
+		}
+		var peers []store.Peer
+		s.db.Where("state <> ? AND sign_key <> '' AND key_status <> ?", discovery.StateForgotten, store.KeyChanged).
+			Order("nick").Find(&peers)
+		entries := []entry{}
+		for _, p := range peers {
+			entries = append(entries, entry{p.ID, p.Nick, core.Fingerprint(p.SignKey, p.PubKey), p.Verified, p.VerifiedAt})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	case http.MethodPost:
+		// The fingerprints are listed above, so matching one proves nothing
+		// about who is asking; only the operator may mark peers.
+		if !operatorOnly(w, r) {
+			return
+		}
+		var req struct {
+			ID          string `json:"id"`
+			Fingerprint string `json:"fingerprint"`
+			Verified    *bool  `json:"verified"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		switch {
+		case req.Fingerprint != "":
+			err = s.engine.VerifyPeer(req.ID, req.Fingerprint)
+		case req.Verified != nil:
+			err = s.engine.SetPeerVerified(req.ID, *req.Verified)
+		default:
+			http.Error(w, "fingerprint or verified required", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}